@@ -0,0 +1,624 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/claudiocandio/gemini-api"
+	"github.com/claudiocandio/gemini-api/logger"
+	"github.com/urfave/cli/v2"
+)
+
+// the average price of the execution report is rounded to 8 decimals
+var reportIncrement = decimal{big.NewRat(1, 100000000)}
+
+// duration of the /v2/candles time frames, the vwap volume profile uses the
+// longest that is not longer than a slice
+var candleDurations = map[string]time.Duration{
+	"1m":   time.Minute,
+	"5m":   5 * time.Minute,
+	"15m":  15 * time.Minute,
+	"30m":  30 * time.Minute,
+	"1hr":  time.Hour,
+	"6hr":  6 * time.Hour,
+	"1day": 24 * time.Hour,
+}
+
+// algo_params are the flags of order algo twap and vwap
+type algo_params struct {
+	algo     string
+	symbol   string
+	side     string
+	amount   decimal
+	duration time.Duration
+	slices   int
+	// worst price: the highest for a buy, the lowest for a sell
+	limit_price decimal
+	// bps through the best price the child orders are priced
+	cross_bps decimal
+	// percent of the market volume of the last slice, 0 no cap
+	max_participation decimal
+	client_order_id   string
+}
+
+// algo_slice is one child order of an execution algorithm
+type algo_slice struct {
+	Time          time.Time `json:"time"`
+	Slice         int       `json:"slice"`
+	OrderId       string    `json:"order_id"`
+	ClientOrderId string    `json:"client_order_id"`
+	// what the schedule asked for and what was sent after the caps
+	Target   decimal `json:"target"`
+	Amount   decimal `json:"amount"`
+	Price    decimal `json:"price"`
+	Executed decimal `json:"executed_amount"`
+	AvgPrice decimal `json:"avg_price"`
+	Fees     decimal `json:"fees"`
+	// sent, or skipped with the reason in detail
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+}
+
+// algo_report is the execution report printed when the algorithm ends
+type algo_report struct {
+	Algo      string  `json:"algo"`
+	Symbol    string  `json:"symbol"`
+	Side      string  `json:"side"`
+	Amount    decimal `json:"amount"`
+	Executed  decimal `json:"executed_amount"`
+	Remaining decimal `json:"remaining_amount"`
+	AvgPrice  decimal `json:"avg_price"`
+	Notional  decimal `json:"notional"`
+	// mid price when the algorithm started and the cost of the average
+	// price compared to it, positive when worse
+	ArrivalMid  decimal   `json:"arrival_mid"`
+	SlippageBps decimal   `json:"slippage_bps"`
+	Fees        decimal   `json:"fees"`
+	FeeCurrency string    `json:"fee_currency"`
+	Slices      int       `json:"slices"`
+	ChildOrders int       `json:"child_orders"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	// completed, incomplete when the schedule ended before the amount was
+	// executed, or stopped by Ctrl-C
+	Status string `json:"status"`
+}
+
+// algo_flags are the flags of order algo twap and vwap
+func algo_flags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "ticker",
+			Aliases: []string{"t"},
+			Usage:   "e.g. --ticker btcusd",
+		},
+		&cli.StringFlag{
+			Name:     "side",
+			Aliases:  []string{"s"},
+			Usage:    "--side buy|sell",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:     "amount",
+			Aliases:  []string{"a"},
+			Usage:    "e.g. --amount 5 - Total amount to execute",
+			Required: true,
+		},
+		&cli.DurationFlag{
+			Name:     "duration",
+			Usage:    "e.g. --duration 4h - Time over which the amount is executed",
+			Required: true,
+		},
+		&cli.IntFlag{
+			Name:  "slices",
+			Usage: "e.g. --slices 48 - Number of child orders, one every duration/slices",
+			Value: 10,
+		},
+		&cli.StringFlag{
+			Name:  "limit-price",
+			Usage: "e.g. --limit-price 31000 - Worst price: no child order buys above it or sells below it",
+		},
+		&cli.StringFlag{
+			Name:  "cross-bps",
+			Usage: "e.g. --cross-bps 5 - Price the child orders 5 bps through the best ask (buy) or bid (sell)",
+			Value: "0",
+		},
+		&cli.StringFlag{
+			Name:  "max-participation",
+			Usage: "e.g. --max-participation 10 - A child order is at most 10% of the market volume traded during the last slice",
+		},
+		&cli.StringFlag{
+			Name:    "client-order-id",
+			Aliases: []string{"client_order_id", "i"},
+			Usage:   "e.g. --client-order-id treasury-w12 - The child orders are treasury-w12-1, treasury-w12-2...",
+		},
+		&cli.StringFlag{
+			Name:  "log",
+			Usage: "--log slices.jsonl - Append every slice to this file as json lines",
+		},
+	}
+}
+
+func get_algo_params(c *cli.Context, algo string) (algo_params, error) {
+	p := algo_params{algo: algo, side: c.String("side"), duration: c.Duration("duration"), slices: c.Int("slices"),
+		client_order_id: c.String("client-order-id")}
+	symbol, err := get_ticker_flag(c)
+	if err != nil {
+		return p, err
+	}
+	p.symbol = strings.ToLower(symbol)
+	if p.side != "buy" && p.side != "sell" {
+		return p, fmt.Errorf("Error invalid side: %s\nValid sides: buy, sell", p.side)
+	}
+	if p.amount, err = decimal_flag(c, "amount"); err != nil {
+		return p, err
+	}
+	if p.amount.sign() <= 0 {
+		return p, fmt.Errorf("Error invalid amount: %s, it must be greater than 0", p.amount)
+	}
+	if p.duration <= 0 {
+		return p, fmt.Errorf("Error invalid --duration: %s, it must be greater than 0", p.duration)
+	}
+	if p.slices < 1 {
+		return p, fmt.Errorf("Error invalid --slices: %d, it must be at least 1", p.slices)
+	}
+	if p.limit_price, err = decimal_flag(c, "limit-price"); err != nil {
+		return p, err
+	}
+	if p.limit_price.sign() < 0 {
+		return p, fmt.Errorf("Error invalid --limit-price: %s, it must be greater than 0", p.limit_price)
+	}
+	if p.cross_bps, err = decimal_flag(c, "cross-bps"); err != nil {
+		return p, err
+	}
+	if p.cross_bps.sign() < 0 {
+		return p, fmt.Errorf("Error invalid --cross-bps: %s, it must not be negative", p.cross_bps)
+	}
+	if p.max_participation, err = decimal_flag(c, "max-participation"); err != nil {
+		return p, err
+	}
+	if p.max_participation.sign() < 0 || p.max_participation.cmp(decimal_int(100)) > 0 {
+		return p, fmt.Errorf("Error invalid --max-participation: %s, it must be a percent from 0 to 100", p.max_participation)
+	}
+	if p.client_order_id == "" {
+		p.client_order_id = fmt.Sprintf("%s-%d", algo, time.Now().UnixNano()/1e6)
+	}
+	return p, nil
+}
+
+// twap_weights gives every slice the same share of the amount
+func twap_weights(slices int) []decimal {
+	weights := make([]decimal, slices)
+	for i := range weights {
+		weights[i] = decimal_int(1).quo(decimal_int(int64(slices)))
+	}
+	return weights
+}
+
+// vwap_weights gives every slice the share of the volume traded at the same
+// time of day in the candles, equal shares if the candles have no volume
+func vwap_weights(candles []candle, start time.Time, interval time.Duration, slices int) []decimal {
+	day := 24 * time.Hour
+	volumes := make([]decimal, slices)
+	total := decimal{}
+	for _, k := range candles {
+		volume, err := parse_decimal(k.Volume.String())
+		if err != nil || volume.sign() <= 0 {
+			continue
+		}
+		// where the candle falls in the schedule, by time of day
+		offset := (k.Time.Sub(start)%day + day) % day
+		if i := int(offset / interval); i < slices && offset < time.Duration(slices)*interval {
+			volumes[i] = volumes[i].add(volume)
+			total = total.add(volume)
+		}
+	}
+	if total.sign() == 0 {
+		return twap_weights(slices)
+	}
+	weights := make([]decimal, slices)
+	for i, v := range volumes {
+		weights[i] = v.quo(total)
+	}
+	return weights
+}
+
+// algo_weights returns the share of the amount of every slice
+func algo_weights(api gemini_client, p algo_params, start time.Time) ([]decimal, error) {
+	interval := p.duration / time.Duration(p.slices)
+	if p.algo != "vwap" {
+		return twap_weights(p.slices), nil
+	}
+	time_frame := candleTimeFrames[0]
+	for _, tf := range candleTimeFrames {
+		if candleDurations[tf] <= interval {
+			time_frame = tf
+		}
+	}
+	if candleDurations[time_frame] > interval || p.duration > 24*time.Hour {
+		return nil, fmt.Errorf("Error vwap slices must be from 1m to 24h and the duration at most 24h, " +
+			"the volume profile is by time of day, use twap")
+	}
+	// /v2/candles/:symbol/:time_frame
+	candles, err := api.Candles(p.symbol, time_frame)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("func algo_weights: volume profile",
+		fmt.Sprintf("time_frame:%s", time_frame),
+		fmt.Sprintf("candles:%d", len(candles)),
+	)
+	return vwap_weights(candles, start, interval, p.slices), nil
+}
+
+// pauser pauses an algorithm between two slices, kill -USR1 pauses it and
+// kill -USR2 resumes it
+type pauser struct {
+	mu      sync.Mutex
+	paused  bool
+	changed chan struct{}
+}
+
+func new_pauser(ctx context.Context) *pauser {
+	p := &pauser{changed: make(chan struct{})}
+	if pause_signal == nil {
+		return p
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, pause_signal, resume_signal)
+	go func() {
+		defer signal.Stop(signals)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case s := <-signals:
+				p.set(s == pause_signal)
+			}
+		}
+	}()
+	return p
+}
+
+func (p *pauser) set(paused bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.paused == paused {
+		return
+	}
+	p.paused = paused
+	close(p.changed)
+	p.changed = make(chan struct{})
+	if paused {
+		errlog.Printf("paused, kill -USR2 %d to resume", os.Getpid())
+	} else {
+		errlog.Printf("resumed")
+	}
+}
+
+// wait returns when the algorithm is not paused, false if ctx is done
+func (p *pauser) wait(ctx context.Context) bool {
+	for {
+		p.mu.Lock()
+		paused, changed := p.paused, p.changed
+		p.mu.Unlock()
+		if !paused {
+			return ctx.Err() == nil
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-changed:
+		}
+	}
+}
+
+// algo_run is the state of a running algorithm
+type algo_run struct {
+	c       *cli.Context
+	api     gemini_client
+	p       algo_params
+	details symbol_details
+	log     *os.File
+	report  algo_report
+}
+
+// touch returns the best bid and ask of the symbol
+func (a *algo_run) touch() (decimal, decimal, error) {
+	// /v1/book/:symbol
+	book, err := a.api.OrderBook(a.p.symbol, gemini.Args{"limit_bids": 1, "limit_asks": 1})
+	if err != nil {
+		return decimal{}, decimal{}, err
+	}
+	if len(book.Bids) == 0 || len(book.Asks) == 0 {
+		return decimal{}, decimal{}, fmt.Errorf("Error %s order book is empty", a.p.symbol)
+	}
+	return decimal_from_float(book.Bids[0].Price), decimal_from_float(book.Asks[0].Price), nil
+}
+
+// slice_price prices a child order through the best price by --cross-bps,
+// it returns false if the best price is already beyond --limit-price
+func (a *algo_run) slice_price(bid, ask decimal) (decimal, bool) {
+	cross := a.p.cross_bps.quo(decimal_int(10000))
+	inc := a.details.QuoteIncrement
+	if a.p.side == "buy" {
+		if !a.p.limit_price.is_zero() && ask.cmp(a.p.limit_price) > 0 {
+			return decimal{}, false
+		}
+		price := ask.mul(decimal_int(1).add(cross))
+		if !a.p.limit_price.is_zero() && price.cmp(a.p.limit_price) > 0 {
+			price = a.p.limit_price
+		}
+		if inc.sign() > 0 {
+			price = price.round_down(inc)
+		}
+		return price, true
+	}
+	if !a.p.limit_price.is_zero() && bid.cmp(a.p.limit_price) < 0 {
+		return decimal{}, false
+	}
+	price := bid.mul(decimal_int(1).sub(cross))
+	if !a.p.limit_price.is_zero() && price.cmp(a.p.limit_price) < 0 {
+		price = a.p.limit_price
+	}
+	if inc.sign() > 0 {
+		price = price.round_up(inc)
+	}
+	return price, true
+}
+
+// market_volume is the volume traded on the symbol since since
+func (a *algo_run) market_volume(since time.Time) (decimal, error) {
+	// /v1/trades/:symbol
+	trades, err := a.api.Trades(a.p.symbol, gemini.Args{"timestamp": since, "limit_trades": 500})
+	if err != nil {
+		return decimal{}, err
+	}
+	volume := decimal{}
+	for _, t := range trades {
+		if !msToTime(t.Timestampms).Before(since) {
+			volume = volume.add(decimal_from_float(t.Amount))
+		}
+	}
+	return volume, nil
+}
+
+// send_slice sends the child order of slice i for up to target
+func (a *algo_run) send_slice(i int, target decimal, interval time.Duration) algo_slice {
+	s := algo_slice{Time: time.Now().UTC(), Slice: i + 1, Target: target, Status: "skipped"}
+	amount := target
+	if a.p.max_participation.sign() > 0 {
+		volume, err := a.market_volume(time.Now().Add(-interval))
+		if err != nil {
+			s.Detail = one_line(err)
+			return s
+		}
+		if limit := volume.mul(a.p.max_participation).quo(decimal_int(100)); limit.cmp(amount) < 0 {
+			amount = limit
+			s.Detail = fmt.Sprintf("participation capped at %s of the %s traded", limit, volume)
+		}
+	}
+	if tick := a.details.TickSize; tick.sign() > 0 {
+		amount = amount.round_down(tick)
+	}
+	if amount.sign() <= 0 || amount.cmp(a.details.MinOrderSize) < 0 {
+		if s.Detail != "" {
+			s.Detail += ", "
+		}
+		s.Detail += fmt.Sprintf("amount %s is below the minimum order size %s", amount, a.details.MinOrderSize)
+		return s
+	}
+
+	bid, ask, err := a.touch()
+	if err != nil {
+		s.Detail = one_line(err)
+		return s
+	}
+	price, ok := a.slice_price(bid, ask)
+	if !ok {
+		best := ask
+		if a.p.side == "sell" {
+			best = bid
+		}
+		s.Detail = fmt.Sprintf("best price %s is beyond the limit price %s", best, a.p.limit_price)
+		return s
+	}
+
+	o := orderRequest{
+		Symbol:        a.p.symbol,
+		ClientOrderId: fmt.Sprintf("%s-%d", a.p.client_order_id, i+1),
+		Side:          a.p.side,
+		Type:          orderTypeLimit,
+		Amount:        amount,
+		Price:         price,
+		Options:       []string{optionImmediateOrCancel},
+	}
+	s.Amount, s.Price, s.ClientOrderId = amount, price, o.ClientOrderId
+	details := map[string]symbol_details{a.p.symbol: a.details}
+	if err := prepare_order(a.c, a.api, &o, details); err != nil {
+		s.Detail = one_line(err)
+		return s
+	}
+	// /v1/order/new
+	placed, err := place_order(a.c, a.api, o)
+	if err != nil {
+		s.Detail = one_line(err)
+		return s
+	}
+	s.OrderId, s.Status = placed.OrderId, "sent"
+	a.report.ChildOrders++
+
+	// /v1/order/status include_trades, for the fills and the fees
+	status, err := a.api.OrderStatusTrades(placed.OrderId)
+	if err != nil {
+		// the immediate-or-cancel order is done, its executed amount is known
+		s.Executed, s.AvgPrice = decimal_from_float(placed.ExecutedAmount), decimal_from_float(placed.AvgExecutionPrice)
+		s.Detail = "fees unknown: " + one_line(err)
+		a.add_fill(s.Executed, s.Executed.mul(s.AvgPrice), decimal{}, "")
+		return s
+	}
+	notional := decimal{}
+	for _, t := range status.Trades {
+		fill := decimal_from_float(t.Amount)
+		s.Executed = s.Executed.add(fill)
+		notional = notional.add(fill.mul(decimal_from_float(t.Price)))
+		s.Fees = s.Fees.add(decimal_from_float(t.FeeAmount))
+		a.add_fill(fill, fill.mul(decimal_from_float(t.Price)), decimal_from_float(t.FeeAmount), t.FeeCurrency)
+	}
+	if s.Executed.sign() > 0 {
+		s.AvgPrice = notional.quo(s.Executed).round(reportIncrement)
+	}
+	return s
+}
+
+func (a *algo_run) add_fill(amount, notional, fee decimal, fee_currency string) {
+	r := &a.report
+	r.Executed = r.Executed.add(amount)
+	r.Notional = r.Notional.add(notional)
+	r.Fees = r.Fees.add(fee)
+	if fee_currency != "" {
+		r.FeeCurrency = fee_currency
+	}
+}
+
+func (a *algo_run) write_slice(s algo_slice) error {
+	errlog.Printf("%s slice %d/%d: %s %s @ %s executed %s %s", a.p.algo, s.Slice, a.p.slices, s.Status, s.Amount, s.Price,
+		s.Executed, s.Detail)
+	if a.log == nil {
+		return nil
+	}
+	j, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	_, err = a.log.Write(append(j, '\n'))
+	return err
+}
+
+// finish completes the report
+func (a *algo_run) finish(status string) algo_report {
+	r := &a.report
+	r.End, r.Status = time.Now().UTC(), status
+	r.Remaining = a.p.amount.sub(r.Executed)
+	if r.Executed.sign() > 0 {
+		r.AvgPrice = r.Notional.quo(r.Executed).round(reportIncrement)
+		if r.ArrivalMid.sign() > 0 {
+			slippage := r.AvgPrice.sub(r.ArrivalMid)
+			if a.p.side == "sell" {
+				slippage = r.ArrivalMid.sub(r.AvgPrice)
+			}
+			r.SlippageBps = round_price(slippage.quo(r.ArrivalMid).mul(decimal_int(10000)))
+		}
+	}
+	return *r
+}
+
+// run_algo executes --amount in --slices immediate-or-cancel child orders
+// over --duration. Every slice sends what the schedule asks for by then
+// minus what was executed, so what a slice misses is sent by the next
+// ones. It prints the execution report when the schedule ends or on Ctrl-C
+func run_algo(c *cli.Context, api gemini_client, algo string) error {
+	p, err := get_algo_params(c, algo)
+	if err != nil {
+		return err
+	}
+	// /v1/symbols/details/:symbol
+	details, err := api.SymbolDetails(p.symbol)
+	if err != nil {
+		return err
+	}
+	if p.amount.decimals() > details.TickSize.decimals() {
+		return fmt.Errorf("Error amount %s has more precision than %s supports, the tick size is %s", p.amount, p.symbol, details.TickSize)
+	}
+	start := time.Now()
+	weights, err := algo_weights(api, p, start)
+	if err != nil {
+		return err
+	}
+	a := &algo_run{c: c, api: api, p: p, details: details,
+		report: algo_report{Algo: algo, Symbol: p.symbol, Side: p.side, Amount: p.amount, Slices: p.slices, Start: start.UTC()}}
+	bid, ask, err := a.touch()
+	if err != nil {
+		return err
+	}
+	a.report.ArrivalMid = bid.add(ask).quo(decimal_int(2))
+	interval := p.duration / time.Duration(p.slices)
+
+	if c.Bool("dry-run") {
+		price, ok := a.slice_price(bid, ask)
+		if !ok {
+			return fmt.Errorf("Error bid %s / ask %s is beyond --limit-price %s, the first slice would be skipped", bid, ask, p.limit_price)
+		}
+		first := orderRequest{Symbol: p.symbol, ClientOrderId: p.client_order_id + "-1", Side: p.side, Type: orderTypeLimit,
+			Amount: p.amount.mul(weights[0]).round_down(details.TickSize), Price: price, Options: []string{optionImmediateOrCancel}}
+		return dry_run(c, api, new_order_URI, new_order_params(first))
+	}
+	summary := append(environment_summary(c),
+		summary_field{"algo", algo},
+		summary_field{"symbol", p.symbol},
+		summary_field{"side", p.side},
+		summary_field{"amount", p.amount.String()},
+		summary_field{"schedule", fmt.Sprintf("%d slices, one every %s, for %s", p.slices, interval, p.duration)},
+		summary_field{"arrival mid", a.report.ArrivalMid.String()},
+	)
+	if !p.limit_price.is_zero() {
+		summary = append(summary, summary_field{"limit price", p.limit_price.String()})
+	}
+	if p.max_participation.sign() > 0 {
+		summary = append(summary, summary_field{"max participation", p.max_participation.String() + "%"})
+	}
+	if err := confirm(c, "Start an execution algorithm", summary); err != nil {
+		return err
+	}
+	if path := c.String("log"); path != "" {
+		if a.log, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600); err != nil {
+			return err
+		}
+		defer a.log.Close()
+	}
+
+	ctx, cancel := interrupt_context(context.Background())
+	defer cancel()
+	pause := new_pauser(ctx)
+	if pause_signal != nil {
+		errlog.Printf("%s: kill -USR1 %d to pause, kill -USR2 %d to resume", algo, os.Getpid(), os.Getpid())
+	}
+
+	next := start
+	target := decimal{}
+	for i := 0; i < p.slices; i++ {
+		target = target.add(p.amount.mul(weights[i]))
+		if i == p.slices-1 {
+			target = p.amount
+		}
+		if d := time.Until(next); d > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(d):
+			}
+		}
+		// a pause delays the rest of the schedule
+		paused_at := time.Now()
+		if !pause.wait(ctx) {
+			return print_output(c, a.finish("stopped"))
+		}
+		next = next.Add(interval + time.Since(paused_at))
+
+		if left := target.sub(a.report.Executed); left.sign() > 0 {
+			if err := a.write_slice(a.send_slice(i, left, interval)); err != nil {
+				errlog.Printf("Warning %s: %s", algo, err)
+			}
+		}
+		if a.report.Executed.cmp(p.amount) >= 0 {
+			return print_output(c, a.finish("completed"))
+		}
+	}
+	return print_output(c, a.finish("incomplete"))
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// the signals pausing and resuming order algo
+var (
+	pause_signal  os.Signal = syscall.SIGUSR1
+	resume_signal os.Signal = syscall.SIGUSR2
+)
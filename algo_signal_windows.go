@@ -0,0 +1,12 @@
+//go:build windows
+// +build windows
+
+package main
+
+import "os"
+
+// windows has no user signals, order algo cannot be paused
+var (
+	pause_signal  os.Signal
+	resume_signal os.Signal
+)
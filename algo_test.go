@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/claudiocandio/gemini-api"
+)
+
+func run_algo_report(t *testing.T, args ...string) algo_report {
+	t.Helper()
+	out, err := run_app(t, nil, append([]string{"-y", "-o", "json", "order", "algo"}, args...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r algo_report
+	if err := json.Unmarshal([]byte(out), &r); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	return r
+}
+
+func TestOrderAlgoTwap(t *testing.T) {
+	server := use_fake_gemini(t)
+	// 100 bps through the best ask 101 is 102.01, the child orders fill at 101
+	r := run_algo_report(t, "twap", "-t", "btcusd", "-s", "buy", "-a", "1.5", "--duration", "40ms", "--slices", "2",
+		"--cross-bps", "100", "-i", "twap1")
+	if r.Status != "completed" || r.ChildOrders != 2 || r.Executed.String() != "1.5" || r.Remaining.String() != "0" {
+		t.Errorf("report: %+v", r)
+	}
+	if r.AvgPrice.String() != "101" || r.ArrivalMid.String() != "100" || r.SlippageBps.String() != "100" {
+		t.Errorf("avg price %s arrival mid %s slippage %s bps", r.AvgPrice, r.ArrivalMid, r.SlippageBps)
+	}
+	if r.Fees.String() != "0.1515" || r.FeeCurrency != "USD" {
+		t.Errorf("fees %s %s", r.Fees, r.FeeCurrency)
+	}
+	var ids []string
+	for _, o := range server.orders {
+		if !contains(o.Options, optionImmediateOrCancel) || o.Price != 102.01 {
+			t.Errorf("child order: %+v", o)
+		}
+		ids = append(ids, o.ClientOrderId+" "+formatFloat(o.OriginalAmount))
+	}
+	if strings.Join(ids, ",") != "twap1-1 0.75,twap1-2 0.75" {
+		t.Errorf("child orders: %v", ids)
+	}
+}
+
+func TestOrderAlgoLimitPrice(t *testing.T) {
+	server := use_fake_gemini(t)
+	// the best bid 99 is below the limit price, no slice is sent
+	r := run_algo_report(t, "twap", "-t", "btcusd", "-s", "sell", "-a", "1", "--duration", "20ms", "--slices", "2",
+		"--limit-price", "100")
+	if r.Status != "incomplete" || r.ChildOrders != 0 || !r.Executed.is_zero() || r.Remaining.String() != "1" {
+		t.Errorf("report: %+v", r)
+	}
+	if len(server.orders) != 0 {
+		t.Errorf("%d orders placed", len(server.orders))
+	}
+}
+
+func TestOrderAlgoDryRunLimitPrice(t *testing.T) {
+	use_fake_gemini(t)
+	// the first slice would be skipped, there is no order to sign
+	_, err := run_app(t, nil, "--dry-run", "order", "algo", "twap", "-t", "btcusd", "-s", "sell", "-a", "1",
+		"--duration", "20ms", "--slices", "2", "--limit-price", "100")
+	if err == nil || !strings.Contains(err.Error(), "first slice would be skipped") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestOrderAlgoParticipation(t *testing.T) {
+	server := use_fake_gemini(t)
+	server.trades = []gemini.Trade{{Timestampms: time.Now().Add(time.Hour).UnixNano() / 1e6, TradeId: 2, Price: 99, Amount: 1,
+		Exchange: "gemini", Type: "sell"}}
+	// every slice is capped at 10% of the 1 traded
+	r := run_algo_report(t, "twap", "-t", "btcusd", "-s", "sell", "-a", "1", "--duration", "20ms", "--slices", "2",
+		"--max-participation", "10")
+	if r.Status != "incomplete" || r.ChildOrders != 2 || r.Executed.String() != "0.2" || r.AvgPrice.String() != "99" {
+		t.Errorf("report: %+v", r)
+	}
+	if r.SlippageBps.String() != "100" {
+		t.Errorf("slippage %s bps", r.SlippageBps)
+	}
+}
+
+func TestVwapWeights(t *testing.T) {
+	start := time.Date(2021, 2, 4, 10, 0, 0, 0, time.UTC)
+	candles := []candle{
+		// the day before at 10:00 and 11:00, and 12:00 outside of the schedule
+		{Time: start.Add(-24 * time.Hour), Volume: "3"},
+		{Time: start.Add(-23 * time.Hour), Volume: "1"},
+		{Time: start.Add(-22 * time.Hour), Volume: "6"},
+	}
+	var got []string
+	for _, w := range vwap_weights(candles, start, time.Hour, 2) {
+		got = append(got, w.String())
+	}
+	if strings.Join(got, ",") != "0.75,0.25" {
+		t.Errorf("weights: %v", got)
+	}
+	got = nil
+	for _, w := range vwap_weights(nil, start, time.Hour, 4) {
+		got = append(got, w.String())
+	}
+	if strings.Join(got, ",") != "0.25,0.25,0.25,0.25" {
+		t.Errorf("weights without volume: %v", got)
+	}
+}
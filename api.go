@@ -3,28 +3,64 @@ package main
 import (
 	"fmt"
 	"os"
+	"os/exec"
+	"runtime"
+	"sort"
 	"strconv"
+	"strings"
 
-	"github.com/claudiocandio/gemini-api"
 	"github.com/claudiocandio/gemini-api/logger"
 	"gopkg.in/yaml.v2"
 )
 
+// gemini_profile is one Gemini account configuration
+type gemini_profile struct {
+	Gemini_api_key        string `yaml:"gemini_api_key"`
+	Gemini_api_secret     string `yaml:"gemini_api_secret"`
+	Gemini_api_production string `yaml:"gemini_api_production"`
+	Gemini_api_url        string `yaml:"gemini_api_url"`
+	// used by the commands when --ticker is not given
+	Default_symbol string `yaml:"default_symbol"`
+	// sub-account name, only for master API keys
+	Account string `yaml:"account"`
+	// command printing the API secret, e.g. "pass show gemini/primary"
+	Secret_command string `yaml:"secret_command"`
+	// pre-trade checks, see risk_limits
+	Risk *risk_limits `yaml:"risk"`
+
+	// profile name, empty for gemini_api_credentials and environment variables
+	name string
+	// where the API secret was read from, shown by auth status
+	secret_source string
+}
+
+// profile_source tells load_profile where to read the profile from
+type profile_source struct {
+	config    string // --config
+	profile   string // --profile
+	keystore  string // --keystore
+	secret_fd int    // --secret-fd, -1 if not given
+}
+
+// gemini_yml is either a single gemini_api_credentials block, or named
+// profiles selected with --profile/GEMINI_PROFILE or default_profile
 type gemini_yml struct {
-	Gemini_api_credentials struct {
-		Gemini_api_key        string `yaml:"gemini_api_key"`
-		Gemini_api_secret     string `yaml:"gemini_api_secret"`
-		Gemini_api_production string `yaml:"gemini_api_production"`
-	} `yaml:"gemini_api_credentials"`
+	Gemini_api_credentials gemini_profile            `yaml:"gemini_api_credentials"`
+	Default_profile        string                    `yaml:"default_profile"`
+	Profiles               map[string]gemini_profile `yaml:"profiles"`
 }
 
-func start_api(gemini_config_yml string) (*gemini.Api, error) {
+// read_profile reads the profile selected with --profile, GEMINI_PROFILE or
+// default_profile from the yml configuration file, it resolves no secret
+func read_profile(src profile_source) (*gemini_profile, error) {
 
 	var gc gemini_yml
-	var gemini_api_production bool
-	var err error
+	gemini_config_yml, profile := src.config, src.profile
 
 	if gemini_config_yml != "" {
+		if err := check_config_permissions(gemini_config_yml); err != nil {
+			return nil, err
+		}
 		fp, err := os.Open(gemini_config_yml)
 		if err != nil {
 			logger.Debug("Open configuration file error",
@@ -32,66 +68,283 @@ func start_api(gemini_config_yml string) (*gemini.Api, error) {
 				fmt.Sprintf("error:%s", err))
 			return nil, fmt.Errorf("Cannot open configuration file: %s", gemini_config_yml)
 		}
+		defer fp.Close()
 		decoder := yaml.NewDecoder(fp)
 		err = decoder.Decode(&gc)
 		if err != nil {
 			return nil, err
 		}
-		fp.Close()
 	}
 
-	if gc.Gemini_api_credentials.Gemini_api_key == "" {
-		gc.Gemini_api_credentials.Gemini_api_key = os.Getenv("GEMINI_API_KEY")
-		if gc.Gemini_api_credentials.Gemini_api_key == "" {
-			errlog.Println("Missing GEMINI_API_KEY")
+	p := gc.Gemini_api_credentials
+	if profile == "" {
+		profile = gc.Default_profile
+	}
+	if profile != "" {
+		var ok bool
+		if p, ok = gc.Profiles[profile]; !ok && gemini_config_yml != "" {
+			return nil, fmt.Errorf("Error profile %s not found in %s\nValid profiles: %s",
+				profile, gemini_config_yml, strings.Join(profile_names(gc), ", "))
 		}
+		p.name = profile
+	} else if len(gc.Profiles) > 0 && p.Gemini_api_key == "" {
+		return nil, fmt.Errorf("Error select a profile with --profile, GEMINI_PROFILE or default_profile\nValid profiles: %s",
+			strings.Join(profile_names(gc), ", "))
+	}
+	return &p, nil
+}
+
+// load_profile reads the profile from the yml configuration file, the
+// credentials missing in the file are read from secret_command, --secret-fd,
+// the environment variables and the keystore, in this order; the environment
+// variables are used only when no named profile is selected
+func load_profile(src profile_source) (*gemini_profile, error) {
+	p, err := read_profile(src)
+	if err != nil {
+		return nil, err
 	}
 
-	if gc.Gemini_api_credentials.Gemini_api_secret == "" {
-		gc.Gemini_api_credentials.Gemini_api_secret = os.Getenv("GEMINI_API_SECRET")
-		if gc.Gemini_api_credentials.Gemini_api_secret == "" {
-			errlog.Println("Missing GEMINI_API_SECRET")
+	if p.Gemini_api_secret != "" {
+		p.secret_source = "config"
+	}
+	if p.Gemini_api_secret == "" && p.Secret_command != "" {
+		secret, err := run_secret_command(p.Secret_command)
+		if err != nil {
+			return nil, err
 		}
+		p.Gemini_api_secret, p.secret_source = secret, "secret_command"
+	}
+	if p.Gemini_api_secret == "" && src.secret_fd >= 0 {
+		secret, err := read_secret_fd(src.secret_fd)
+		if err != nil {
+			return nil, err
+		}
+		p.Gemini_api_secret, p.secret_source = secret, "secret-fd"
 	}
 
-	if gc.Gemini_api_credentials.Gemini_api_production != "" {
-		gemini_api_production, err = strconv.ParseBool(gc.Gemini_api_credentials.Gemini_api_production)
-		if gc.Gemini_api_credentials.Gemini_api_production != "" && err != nil {
-			errlog.Println("GEMINI_API_PRODUCTION environment variable must be set as true or false")
-			gc.Gemini_api_credentials.Gemini_api_production = ""
+	// a named profile never inherits the environment variables, e.g. a
+	// sandbox profile run in a shell with GEMINI_API_PRODUCTION=true
+	if p.name == "" {
+		if p.Gemini_api_key == "" {
+			p.Gemini_api_key = os.Getenv("GEMINI_API_KEY")
 		}
-	} else {
-		gc.Gemini_api_credentials.Gemini_api_production = os.Getenv("GEMINI_API_PRODUCTION")
-		if gc.Gemini_api_credentials.Gemini_api_production == "" {
-			errlog.Println("Missing GEMINI_API_PRODUCTION")
+		if p.Gemini_api_secret == "" {
+			if p.Gemini_api_secret = os.Getenv("GEMINI_API_SECRET"); p.Gemini_api_secret != "" {
+				p.secret_source = "environment"
+			}
 		}
-		gemini_api_production, err = strconv.ParseBool(gc.Gemini_api_credentials.Gemini_api_production)
-		if gc.Gemini_api_credentials.Gemini_api_production != "" && err != nil {
+		if p.Gemini_api_production == "" {
+			p.Gemini_api_production = os.Getenv("GEMINI_API_PRODUCTION")
+		}
+		// optional, e.g. a local test server
+		if p.Gemini_api_url == "" {
+			p.Gemini_api_url = os.Getenv("GEMINI_API_URL")
+		}
+	}
+
+	if p.Gemini_api_key == "" || p.Gemini_api_secret == "" {
+		if err := keystore_profile(src.keystore, p); err != nil {
+			return nil, err
+		}
+	}
+
+	redact.add(p.Gemini_api_key, p.Gemini_api_secret)
+
+	return p, nil
+}
+
+// secret_origin tells where load_profile would read the secret of p from,
+// without running secret_command, reading --secret-fd or unlocking the
+// keystore; empty when there is no secret
+func secret_origin(src profile_source, p *gemini_profile) (string, error) {
+	switch {
+	case p.Gemini_api_secret != "":
+		return "config", nil
+	case p.Secret_command != "":
+		return "secret_command", nil
+	case src.secret_fd >= 0:
+		return "secret-fd", nil
+	case p.name == "" && os.Getenv("GEMINI_API_SECRET") != "":
+		return "environment", nil
+	case src.keystore == "":
+		return "", nil
+	}
+	ks, err := open_keystore(src.keystore)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := ks.Entries[keystore_entry_name(p.name)]; ok {
+		return "keystore", nil
+	}
+	return "", nil
+}
+
+// keystore_profile fills the credentials missing in p from the keystore
+// entry of the profile, if there is one
+func keystore_profile(path string, p *gemini_profile) error {
+	if path == "" {
+		return nil
+	}
+	ks, err := open_keystore(path)
+	if err != nil {
+		return err
+	}
+	name := keystore_entry_name(p.name)
+	if _, ok := ks.Entries[name]; !ok {
+		return nil
+	}
+	passphrase, err := read_passphrase(fmt.Sprintf("Keystore passphrase (%s): ", name), false)
+	if err != nil {
+		return err
+	}
+	if err := ks.unlock(passphrase); err != nil {
+		return err
+	}
+	cred, err := ks.get(name)
+	if err != nil {
+		return err
+	}
+	if p.Gemini_api_key == "" {
+		p.Gemini_api_key = cred.Gemini_api_key
+	}
+	if p.Gemini_api_secret == "" {
+		p.Gemini_api_secret, p.secret_source = cred.Gemini_api_secret, "keystore"
+	}
+	if p.Gemini_api_production == "" {
+		p.Gemini_api_production = cred.Gemini_api_production
+	}
+	return nil
+}
+
+// check_config_permissions refuses configuration files other users can read
+func check_config_permissions(gemini_config_yml string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	fi, err := os.Stat(gemini_config_yml)
+	if err != nil {
+		return fmt.Errorf("Cannot open configuration file: %s", gemini_config_yml)
+	}
+	if fi.Mode().Perm()&0004 != 0 {
+		return fmt.Errorf("Error configuration file %s is world-readable (%s), run: chmod 600 %s",
+			gemini_config_yml, fi.Mode().Perm(), gemini_config_yml)
+	}
+	return nil
+}
+
+// run_secret_command returns the first line printed by the secret_command
+func run_secret_command(command string) (string, error) {
+	logger.Debug("Running secret_command", fmt.Sprintf("secret_command:%s", command))
+
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("Error secret_command failed: %s", err)
+	}
+	secret := strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0])
+	if secret == "" {
+		return "", fmt.Errorf("Error secret_command printed no secret")
+	}
+	return secret, nil
+}
+
+func profile_names(gc gemini_yml) []string {
+	var names []string
+	for name := range gc.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// profile_url is the REST base url the profile connects to
+func profile_url(p *gemini_profile) (string, error) {
+	if p.Gemini_api_url != "" {
+		if err := base_url_valid(p.Gemini_api_url); err != nil {
+			return "", err
+		}
+		return strings.TrimSuffix(p.Gemini_api_url, "/"), nil
+	}
+	production, err := strconv.ParseBool(p.Gemini_api_production)
+	if err != nil {
+		return "", fmt.Errorf("Error GEMINI_API_PRODUCTION must be set as true or false")
+	}
+	if production {
+		return base_URL, nil
+	}
+	return sandbox_URL, nil
+}
+
+func start_api(p *gemini_profile) (*gemini_api, error) {
+
+	var gemini_api_production bool
+	var err error
+
+	// a named profile does not read the environment variables
+	missing := func(env, field string) {
+		if p.name != "" {
+			errlog.Printf("Profile %s has no %s", p.name, field)
+		} else {
+			errlog.Println("Missing " + env)
+		}
+	}
+
+	if p.Gemini_api_key == "" {
+		missing("GEMINI_API_KEY", "gemini_api_key")
+	}
+
+	if p.Gemini_api_secret == "" {
+		missing("GEMINI_API_SECRET", "gemini_api_secret")
+	}
+
+	if p.Gemini_api_production == "" {
+		missing("GEMINI_API_PRODUCTION", "gemini_api_production")
+	} else {
+		gemini_api_production, err = strconv.ParseBool(p.Gemini_api_production)
+		if err != nil {
 			errlog.Println("GEMINI_API_PRODUCTION environment variable must be set as true or false")
-			gc.Gemini_api_credentials.Gemini_api_production = ""
+			p.Gemini_api_production = ""
 		}
 	}
 
-	if gc.Gemini_api_credentials.Gemini_api_key == "" ||
-		gc.Gemini_api_credentials.Gemini_api_secret == "" ||
-		gc.Gemini_api_credentials.Gemini_api_production == "" {
+	if p.Gemini_api_key == "" ||
+		p.Gemini_api_secret == "" ||
+		p.Gemini_api_production == "" {
+		if p.name != "" {
+			return nil, fmt.Errorf("Set Gemini API credentials of profile %s.", p.name)
+		}
 		return nil, fmt.Errorf("Set Gemini API credentials.")
 	}
 
-	if gemini_api_production {
+	if p.Gemini_api_url != "" {
+		if err := base_url_valid(p.Gemini_api_url); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.name != "" {
+		logger.Debug("Using Gemini profile.", fmt.Sprintf("profile:%s", p.name))
+	}
+	if p.Gemini_api_url != "" {
+		logger.Debug("Connecting to Gemini API url.",
+			fmt.Sprintf("url:%s", p.Gemini_api_url))
+	} else if gemini_api_production {
 		logger.Debug("Connecting to Gemini Production site.")
 	} else {
 		logger.Debug("Connecting to Gemini Sandbox site.")
 	}
 
-	api := gemini.New(gemini_api_production,
-		gc.Gemini_api_credentials.Gemini_api_key,
-		gc.Gemini_api_credentials.Gemini_api_secret)
+	api := new_gemini_api(gemini_api_production,
+		p.Gemini_api_key,
+		p.Gemini_api_secret,
+		p.Gemini_api_url)
+	api.account = p.Account
 
-	//will show gemini api key & secret !!!
+	// gemini api key & secret are redacted unless --unsafe-show-secrets
 	logger.Trace("Gemini",
-		fmt.Sprintf("api:%v", api),
-		fmt.Sprintf("gc:%v", gc))
+		fmt.Sprintf("api:%+v", *api),
+		fmt.Sprintf("profile:%+v", *p))
 
 	return api, nil
 }
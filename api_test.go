@@ -0,0 +1,311 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const profiles_yml = `
+default_profile: primary
+profiles:
+  primary:
+    gemini_api_key: "primary-key"
+    gemini_api_secret: "primary-secret"
+    gemini_api_production: "true"
+  strategy1:
+    gemini_api_key: "master-key"
+    gemini_api_secret: "master-secret"
+    gemini_api_production: "true"
+    account: "strategy1"
+    default_symbol: "ethusd"
+`
+
+const legacy_yml = `
+gemini_api_credentials:
+  gemini_api_key: "legacy-key"
+  gemini_api_secret: "legacy-secret"
+  gemini_api_production: "false"
+`
+
+func write_config(t *testing.T, yml string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "gemini.yml")
+	if err := ioutil.WriteFile(path, []byte(yml), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func source(config, profile string) profile_source {
+	return profile_source{config: config, profile: profile, secret_fd: -1}
+}
+
+func clear_gemini_env(t *testing.T) {
+	for _, key := range []string{"GEMINI_API_KEY", "GEMINI_API_SECRET", "GEMINI_API_PRODUCTION", "GEMINI_API_URL", "GEMINI_PROFILE", "GEMINI_KEYSTORE_PASSPHRASE"} {
+		setenv(t, key, "")
+	}
+}
+
+func TestLoadProfile(t *testing.T) {
+	clear_gemini_env(t)
+	profiles := write_config(t, profiles_yml)
+	legacy := write_config(t, legacy_yml)
+
+	tests := []struct {
+		config, profile string
+		key, account    string
+		symbol          string
+	}{
+		{legacy, "", "legacy-key", "", ""},
+		{profiles, "", "primary-key", "", ""},
+		{profiles, "primary", "primary-key", "", ""},
+		{profiles, "strategy1", "master-key", "strategy1", "ethusd"},
+	}
+	for _, tt := range tests {
+		p, err := load_profile(source(tt.config, tt.profile))
+		if err != nil {
+			t.Errorf("%s: %v", tt.profile, err)
+			continue
+		}
+		if p.Gemini_api_key != tt.key || p.Account != tt.account || p.Default_symbol != tt.symbol {
+			t.Errorf("%s: unexpected profile %+v", tt.profile, p)
+		}
+	}
+
+	if _, err := load_profile(source(profiles, "nope")); err == nil || !strings.Contains(err.Error(), "primary, strategy1") {
+		t.Errorf("unknown profile err = %v", err)
+	}
+	if _, err := load_profile(source(legacy, "primary")); err == nil {
+		t.Error("expected an error for a profile in a file without profiles")
+	}
+}
+
+func TestLoadProfileEnvironment(t *testing.T) {
+	clear_gemini_env(t)
+	setenv(t, "GEMINI_API_KEY", "env-key")
+	setenv(t, "GEMINI_API_SECRET", "env-secret")
+	setenv(t, "GEMINI_API_PRODUCTION", "false")
+
+	p, err := load_profile(source("", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Gemini_api_key != "env-key" || p.Gemini_api_secret != "env-secret" {
+		t.Errorf("unexpected profile %+v", p)
+	}
+	// a named profile does not read the environment variables
+	if p, err := load_profile(source("", "primary")); err != nil || p.Gemini_api_key != "" {
+		t.Errorf("profile without configuration file: %+v %v", p, err)
+	}
+
+	setenv(t, "GEMINI_API_PRODUCTION", "true")
+	setenv(t, "GEMINI_API_URL", "https://api.gemini.com")
+	config := write_config(t, `
+profiles:
+  sandbox:
+    gemini_api_key: "sandbox-key"
+    gemini_api_secret: "sandbox-secret"
+`)
+	p, err = load_profile(source(config, "sandbox"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Gemini_api_production != "" || p.Gemini_api_url != "" {
+		t.Errorf("the profile inherited the environment %+v", p)
+	}
+	if _, err := start_api(p); err == nil || !strings.Contains(err.Error(), "profile sandbox") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestProfileAccountAndDefaultSymbol(t *testing.T) {
+	server := use_fake_gemini(t)
+	config := write_config(t, `
+profiles:
+  strategy1:
+    gemini_api_key: "`+fake_key+`"
+    gemini_api_secret: "`+fake_secret+`"
+    gemini_api_production: "false"
+    gemini_api_url: "`+server.URL+`"
+    account: "strategy1"
+    default_symbol: "ethusd"
+`)
+	setenv(t, "GEMINI_PROFILE", "strategy1")
+
+	if _, err := run_app(t, nil, "-c", config, "get", "balances"); err != nil {
+		t.Fatal(err)
+	}
+	if p := server.last_payload(); p["account"] != "strategy1" {
+		t.Errorf("payload account = %v, want strategy1", p["account"])
+	}
+
+	if _, err := run_app(t, nil, "-c", config, "order", "past_trades"); err != nil {
+		t.Fatal(err)
+	}
+	if p := server.last_payload(); p["symbol"] != "ethusd" {
+		t.Errorf("payload symbol = %v, want ethusd", p["symbol"])
+	}
+}
+
+func TestConfigWorldReadable(t *testing.T) {
+	clear_gemini_env(t)
+	config := write_config(t, legacy_yml)
+	if err := os.Chmod(config, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := load_profile(source(config, "")); err == nil || !strings.Contains(err.Error(), "world-readable") {
+		t.Errorf("err = %v, want world-readable error", err)
+	}
+}
+
+func TestSecretCommand(t *testing.T) {
+	clear_gemini_env(t)
+	config := write_config(t, `
+gemini_api_credentials:
+  gemini_api_key: "command-key"
+  secret_command: "echo command-secret"
+  gemini_api_production: "false"
+`)
+	p, err := load_profile(source(config, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Gemini_api_secret != "command-secret" || p.secret_source != "secret_command" {
+		t.Errorf("unexpected profile %+v", p)
+	}
+}
+
+// secret_pipe returns a file descriptor to read secret from
+func secret_pipe(t *testing.T, secret string) int {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteString(secret + "\n")
+	w.Close()
+	return int(r.Fd())
+}
+
+func TestKeystoreLogin(t *testing.T) {
+	server := use_fake_gemini(t)
+	setenv(t, "GEMINI_API_KEY", "")
+	setenv(t, "GEMINI_API_SECRET", "")
+	setenv(t, "GEMINI_KEYSTORE_PASSPHRASE", "correct horse")
+	keystore := filepath.Join(t.TempDir(), "gemini_cli", "keystore.json")
+	// the profile does not read GEMINI_API_URL, it has the url of the fake server
+	config := write_config(t, `
+profiles:
+  sandbox:
+    gemini_api_url: "`+server.URL+`"
+`)
+
+	fd := secret_pipe(t, fake_secret)
+	_, err := run_app(t, nil, "-c", config, "--keystore", keystore, "--secret-fd", strconv.Itoa(fd), "--profile", "sandbox",
+		"auth", "login", "--api_key", fake_key, "--production", "false")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(keystore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), fake_secret) || strings.Contains(string(data), fake_key) {
+		t.Error("keystore contains the credentials in clear")
+	}
+	if fi, _ := os.Stat(keystore); fi.Mode().Perm() != 0600 {
+		t.Errorf("keystore permissions %s, want 0600", fi.Mode().Perm())
+	}
+
+	// the keystore credentials are used by the commands
+	if _, err := run_app(t, nil, "-c", config, "--keystore", keystore, "--profile", "sandbox", "get", "balances"); err != nil {
+		t.Fatal(err)
+	}
+	if p := server.last_payload(); p["request"] != "/v1/balances" {
+		t.Errorf("unexpected payload %v", p)
+	}
+
+	out, err := run_app(t, nil, "-c", config, "--keystore", keystore, "--profile", "sandbox", "-o", "jsonl", "auth", "status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, `"keystore_entries":["sandbox"]`) || !strings.Contains(out, `"secret_source":"keystore"`) ||
+		strings.Contains(out, fake_key) {
+		t.Errorf("unexpected status %s", out)
+	}
+
+	setenv(t, "GEMINI_KEYSTORE_PASSPHRASE", "wrong")
+	if _, err := run_app(t, nil, "-c", config, "--keystore", keystore, "--profile", "sandbox", "get", "balances"); err == nil ||
+		!strings.Contains(err.Error(), "wrong passphrase") {
+		t.Errorf("err = %v, want wrong passphrase", err)
+	}
+
+	if _, err := run_app(t, nil, "-c", config, "--keystore", keystore, "--profile", "sandbox", "auth", "logout"); err != nil {
+		t.Fatal(err)
+	}
+	ks, err := open_keystore(keystore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ks.Entries) != 0 {
+		t.Errorf("entries after logout %v", ks.names())
+	}
+}
+
+func TestAuthDefaultProfile(t *testing.T) {
+	server := use_fake_gemini(t)
+	clear_gemini_env(t)
+	setenv(t, "GEMINI_KEYSTORE_PASSPHRASE", "correct horse")
+	dir := t.TempDir()
+	keystore := filepath.Join(dir, "keystore.json")
+	marker := filepath.Join(dir, "secret_command_ran")
+	config := write_config(t, `
+default_profile: primary
+profiles:
+  primary:
+    gemini_api_url: "`+server.URL+`"
+  other:
+    gemini_api_key: "other-key"
+    secret_command: "touch `+marker+`; echo other-secret"
+`)
+
+	// without --profile auth login saves the entry of default_profile
+	fd := secret_pipe(t, fake_secret)
+	out, err := run_app(t, nil, "-c", config, "--keystore", keystore, "--secret-fd", strconv.Itoa(fd), "-o", "jsonl",
+		"auth", "login", "--api_key", fake_key, "--production", "false")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, `"entry":"primary"`) {
+		t.Errorf("login %s", out)
+	}
+	if _, err := run_app(t, nil, "-c", config, "--keystore", keystore, "get", "balances"); err != nil {
+		t.Fatal(err)
+	}
+
+	// auth status neither unlocks the keystore nor runs secret_command
+	setenv(t, "GEMINI_KEYSTORE_PASSPHRASE", "")
+	out, err = run_app(t, nil, "-c", config, "--keystore", keystore, "-o", "jsonl", "auth", "status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, `"profile":"primary"`) || !strings.Contains(out, `"secret_source":"keystore"`) ||
+		strings.Contains(out, `"error"`) {
+		t.Errorf("status %s", out)
+	}
+	out, err = run_app(t, nil, "-c", config, "--keystore", keystore, "--profile", "other", "-o", "jsonl", "auth", "status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, `"secret_source":"secret_command"`) || !strings.Contains(out, `"api_key":"****-key"`) {
+		t.Errorf("status %s", out)
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Error("auth status ran secret_command")
+	}
+}
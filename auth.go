@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+type auth_result struct {
+	Keystore string `json:"keystore"`
+	Entry    string `json:"entry"`
+	Result   string `json:"result"`
+}
+
+type auth_status struct {
+	Keystore           string   `json:"keystore"`
+	Keystore_entries   []string `json:"keystore_entries"`
+	Config             string   `json:"config,omitempty"`
+	Config_permissions string   `json:"config_permissions,omitempty"`
+	Profile            string   `json:"profile"`
+	Api_key            string   `json:"api_key"`
+	Secret_source      string   `json:"secret_source"`
+	Production         string   `json:"production"`
+	Error              string   `json:"error,omitempty"`
+}
+
+// auth_login stores the API key and secret of the profile in the keystore,
+// the secret is read from --secret-fd or from the terminal
+func auth_login(src profile_source, api_key, production string) (interface{}, error) {
+	if src.keystore == "" {
+		return nil, fmt.Errorf("Error set the keystore path with --keystore")
+	}
+	if production != "" {
+		if _, err := strconv.ParseBool(production); err != nil {
+			return nil, fmt.Errorf("Error --production must be true or false")
+		}
+	}
+
+	// the entry load_profile reads, default_profile without --profile
+	p, err := read_profile(src)
+	if err != nil {
+		return nil, err
+	}
+	name := keystore_entry_name(p.name)
+
+	ks, err := open_keystore(src.keystore)
+	if err != nil {
+		return nil, err
+	}
+
+	var secret string
+	if src.secret_fd >= 0 {
+		secret, err = read_secret_fd(src.secret_fd)
+	} else {
+		secret, err = read_password("Gemini API secret: ")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error reading the Gemini API secret: %s\nUse --secret-fd when not using a terminal", err)
+	}
+	if secret == "" {
+		return nil, fmt.Errorf("Error empty Gemini API secret")
+	}
+
+	passphrase, err := read_passphrase("Keystore passphrase: ", !ks.exists())
+	if err != nil {
+		return nil, err
+	}
+	if err := ks.unlock(passphrase); err != nil {
+		return nil, err
+	}
+
+	err = ks.put(name, keystore_credentials{
+		Gemini_api_key:        api_key,
+		Gemini_api_secret:     secret,
+		Gemini_api_production: production,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := ks.save(); err != nil {
+		return nil, err
+	}
+
+	return auth_result{Keystore: src.keystore, Entry: name, Result: "saved"}, nil
+}
+
+// auth_logout removes the profile from the keystore, or the whole keystore
+func auth_logout(src profile_source, all bool) (interface{}, error) {
+	if all {
+		if err := os.Remove(src.keystore); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		return auth_result{Keystore: src.keystore, Result: "removed"}, nil
+	}
+
+	p, err := read_profile(src)
+	if err != nil {
+		return nil, err
+	}
+	ks, err := open_keystore(src.keystore)
+	if err != nil {
+		return nil, err
+	}
+	name := keystore_entry_name(p.name)
+	if _, ok := ks.Entries[name]; !ok {
+		return nil, fmt.Errorf("Error no credentials for %s in keystore %s", name, src.keystore)
+	}
+	delete(ks.Entries, name)
+	if err := ks.save(); err != nil {
+		return nil, err
+	}
+	return auth_result{Keystore: src.keystore, Entry: name, Result: "removed"}, nil
+}
+
+// auth_status_of shows where the credentials of the profile come from, it
+// runs no secret_command and needs no keystore passphrase
+func auth_status_of(s *session) (interface{}, error) {
+	src := s.source
+	status := auth_status{
+		Keystore:         src.keystore,
+		Keystore_entries: []string{},
+		Config:           src.config,
+		Profile:          keystore_entry_name(src.profile),
+	}
+
+	if src.keystore != "" {
+		ks, err := open_keystore(src.keystore)
+		if err != nil {
+			return nil, err
+		}
+		status.Keystore_entries = append(status.Keystore_entries, ks.names()...)
+	}
+
+	if src.config != "" {
+		if fi, err := os.Stat(src.config); err == nil {
+			status.Config_permissions = fi.Mode().Perm().String()
+		}
+	}
+
+	p, err := read_profile(src)
+	if err != nil {
+		status.Error = err.Error()
+		return status, nil
+	}
+	status.Profile = keystore_entry_name(p.name)
+	if status.Secret_source, err = secret_origin(src, p); err != nil {
+		status.Error = err.Error()
+	}
+	// the key and production of a keystore entry stay encrypted
+	if p.name == "" {
+		if p.Gemini_api_key == "" {
+			p.Gemini_api_key = os.Getenv("GEMINI_API_KEY")
+		}
+		if p.Gemini_api_production == "" {
+			p.Gemini_api_production = os.Getenv("GEMINI_API_PRODUCTION")
+		}
+	}
+	status.Api_key = mask_key(p.Gemini_api_key)
+	status.Production = p.Gemini_api_production
+	return status, nil
+}
+
+// mask_key shows only the last 4 characters of a key
+func mask_key(key string) string {
+	if len(key) <= 4 {
+		return ""
+	}
+	return "****" + key[len(key)-4:]
+}
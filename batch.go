@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/claudiocandio/gemini-api"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v2"
+)
+
+// batch_order is one row of the order batch file
+type batch_order struct {
+	Symbol        string   `json:"symbol" yaml:"symbol"`
+	Side          string   `json:"side" yaml:"side"`
+	Type          string   `json:"type" yaml:"type"`
+	Amount        decimal  `json:"amount" yaml:"amount"`
+	Price         decimal  `json:"price" yaml:"price"`
+	StopPrice     decimal  `json:"stop_price" yaml:"stop_price"`
+	ClientOrderId string   `json:"client_order_id" yaml:"client_order_id"`
+	Options       []string `json:"options" yaml:"options"`
+}
+
+// batch_result is what order batch prints for every row of the file
+type batch_result struct {
+	Row           int     `json:"row"`
+	Symbol        string  `json:"symbol"`
+	Side          string  `json:"side"`
+	Amount        decimal `json:"amount"`
+	Price         decimal `json:"price"`
+	ClientOrderId string  `json:"client_order_id"`
+	// invalid, valid, placed, failed or skipped
+	Status  string `json:"status"`
+	OrderId string `json:"order_id"`
+	Error   string `json:"error"`
+}
+
+// read_batch reads the orders of a csv, json or yaml file
+func read_batch(path string) ([]batch_order, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var orders []batch_order
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		orders, err = read_batch_csv(data)
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&orders)
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(data, &orders)
+	default:
+		return nil, fmt.Errorf("Error invalid batch file %s: the extension must be .csv, .json, .yaml or .yml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error invalid batch file %s: %s", path, err)
+	}
+	if len(orders) == 0 {
+		return nil, fmt.Errorf("Error batch file %s has no orders", path)
+	}
+	return orders, nil
+}
+
+// read_batch_csv reads a csv file with a header, the columns are the json
+// names of batch_order and the options are separated by spaces or ;
+func read_batch_csv(data []byte) ([]batch_order, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.TrimLeadingSpace = true
+	header, err := r.Read()
+	if err != nil {
+		return nil, err
+	}
+	valid := []string{"symbol", "side", "type", "amount", "price", "stop_price", "client_order_id", "options"}
+	for i, h := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(h))
+		if !contains(valid, header[i]) {
+			return nil, fmt.Errorf("invalid column %q, valid columns: %s", h, strings.Join(valid, ", "))
+		}
+	}
+
+	var orders []batch_order
+	for line := 2; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			return orders, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var o batch_order
+		for i, value := range record {
+			value = strings.TrimSpace(value)
+			var err error
+			switch header[i] {
+			case "symbol":
+				o.Symbol = value
+			case "side":
+				o.Side = value
+			case "type":
+				o.Type = value
+			case "client_order_id":
+				o.ClientOrderId = value
+			case "options":
+				o.Options = strings.FieldsFunc(value, func(r rune) bool { return r == ';' || r == ' ' })
+			case "amount":
+				o.Amount, err = parse_decimal_cell(value)
+			case "price":
+				o.Price, err = parse_decimal_cell(value)
+			case "stop_price":
+				o.StopPrice, err = parse_decimal_cell(value)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("line %d column %s: %s", line, header[i], err)
+			}
+		}
+		orders = append(orders, o)
+	}
+}
+
+func parse_decimal_cell(value string) (decimal, error) {
+	if value == "" {
+		return decimal{}, nil
+	}
+	return parse_decimal(value)
+}
+
+// validate_batch checks every row like order new does, the order requests
+// are returned only if all the rows are valid
+func validate_batch(c *cli.Context, api gemini_client, rows []batch_order) ([]orderRequest, []batch_result) {
+	orders := make([]orderRequest, len(rows))
+	results := make([]batch_result, len(rows))
+	details := map[string]symbol_details{}
+	client_order_ids := map[string]int{}
+	invalid := false
+
+	for i, row := range rows {
+		results[i] = batch_result{Row: i + 1, Symbol: strings.ToLower(row.Symbol), Side: row.Side,
+			Amount: row.Amount, Price: row.Price, ClientOrderId: row.ClientOrderId, Status: "valid"}
+		err := func() error {
+			if row.Symbol == "" {
+				return fmt.Errorf("Error symbol is required")
+			}
+			if first, ok := client_order_ids[row.ClientOrderId]; ok && row.ClientOrderId != "" {
+				return fmt.Errorf("Error client_order_id %s is also used by row %d", row.ClientOrderId, first)
+			}
+			client_order_ids[row.ClientOrderId] = i + 1
+			if row.Type == "" {
+				row.Type = "limit"
+			}
+			orderType, err := parseOrderType(row.Type)
+			if err != nil {
+				return err
+			}
+			orders[i] = orderRequest{
+				Symbol:        strings.ToLower(row.Symbol),
+				ClientOrderId: row.ClientOrderId,
+				Side:          row.Side,
+				Type:          orderType,
+				Amount:        row.Amount,
+				Price:         row.Price,
+				StopPrice:     row.StopPrice,
+				Options:       row.Options,
+			}
+			return prepare_order(c, api, &orders[i], details)
+		}()
+		if err != nil {
+			invalid = true
+			results[i].Status, results[i].Error = "invalid", one_line(err)
+			continue
+		}
+		// --round can change them
+		results[i].Amount, results[i].Price = orders[i].Amount, orders[i].Price
+	}
+	if invalid {
+		return nil, results
+	}
+	return orders, results
+}
+
+func one_line(err error) string {
+	return strings.ReplaceAll(err.Error(), "\n", " ")
+}
+
+// batch_summary is the confirmation summary: orders by symbol and side and
+// the total notional by quote currency
+func batch_summary(c *cli.Context, orders []orderRequest) []summary_field {
+	counts := map[string]int{}
+	notional := map[string]decimal{}
+	for _, o := range orders {
+		counts[o.Symbol+" "+o.Side]++
+		quote := quote_currency(o.Symbol)
+		notional[quote] = notional[quote].add(o.Amount.mul(o.Price))
+	}
+	fields := append(environment_summary(c), summary_field{"orders", fmt.Sprint(len(orders))})
+	var keys []string
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fields = append(fields, summary_field{k, fmt.Sprint(counts[k])})
+	}
+	var quotes []string
+	for q := range notional {
+		quotes = append(quotes, q)
+	}
+	sort.Strings(quotes)
+	for _, q := range quotes {
+		fields = append(fields, summary_field{"notional", strings.TrimSpace(notional[q].String() + " " + q)})
+	}
+	return fields
+}
+
+// submit_batch places the orders with at most --concurrency requests in
+// flight and at most --rate orders per second. After a failure with
+// --stop-on-error, or after Ctrl-C, the orders not yet sent are skipped
+func submit_batch(c *cli.Context, api gemini_client, orders []orderRequest, results []batch_result) {
+	ctx, cancel := interrupt_context(context.Background())
+	defer cancel()
+	t, stop := new_throttle(ctx, c.Float64("rate"))
+	defer stop()
+
+	run_parallel(ctx, c.Int("concurrency"), len(orders), func(i int) {
+		r := &results[i]
+		var placed gemini.Order
+		sent, err := t.send(fmt.Sprintf("order batch: row %d", r.Row), func() (err error) {
+			placed, err = place_order(c, api, orders[i])
+			return err
+		})
+		switch {
+		case !sent:
+			r.Status = "skipped"
+		case err != nil:
+			r.Status, r.Error = "failed", one_line(err)
+			if c.Bool("stop-on-error") {
+				cancel()
+			}
+		default:
+			r.Status, r.OrderId = "placed", placed.OrderId
+		}
+	}, func(i int) {
+		results[i].Status = "skipped"
+	})
+}
+
+// run_batch validates all the orders of --file, then places them and
+// prints the result of every row. It fails if any order is not placed
+func run_batch(c *cli.Context, api gemini_client) error {
+	if err := check_parallel_flags(c); err != nil {
+		return err
+	}
+	rows, err := read_batch(c.String("file"))
+	if err != nil {
+		return err
+	}
+
+	orders, results := validate_batch(c, api, rows)
+	if orders == nil {
+		if err := print_output(c, results); err != nil {
+			return err
+		}
+		invalid := 0
+		for _, r := range results {
+			if r.Status == "invalid" {
+				invalid++
+			}
+		}
+		return fmt.Errorf("Error %d of %d orders are invalid, no order was sent", invalid, len(results))
+	}
+
+	if c.Bool("dry-run") {
+		var requests []signed_request
+		for _, o := range orders {
+			req, err := api.SignRequest(new_order_URI, new_order_params(o))
+			if err != nil {
+				return err
+			}
+			requests = append(requests, req)
+		}
+		return print_output(c, requests)
+	}
+	if err := confirm(c, "Place a batch of orders", batch_summary(c, orders)); err != nil {
+		return err
+	}
+
+	submit_batch(c, api, orders, results)
+	if err := print_output(c, results); err != nil {
+		return err
+	}
+
+	counts := map[string]int{}
+	for _, r := range results {
+		counts[r.Status]++
+	}
+	errlog.Printf("order batch: %d placed, %d failed, %d skipped", counts["placed"], counts["failed"], counts["skipped"])
+	if counts["placed"] < len(results) {
+		return fmt.Errorf("Error %d of %d orders were not placed", len(results)-counts["placed"], len(results))
+	}
+	return nil
+}
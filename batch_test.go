@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func write_batch(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func batch_results(t *testing.T, out string) []batch_result {
+	t.Helper()
+	var results []batch_result
+	if err := json.Unmarshal([]byte(out), &results); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	return results
+}
+
+func TestOrderBatch(t *testing.T) {
+	server := use_fake_gemini(t)
+	saved := send_backoff
+	defer func() { send_backoff = saved }()
+	send_backoff = 10 * time.Millisecond
+
+	csv := "symbol,side,amount,price,client_order_id,options\n"
+	for i := 0; i < 8; i++ {
+		csv += "btcusd,buy,0.1," + []string{"90", "91", "92", "93", "94", "95", "96", "97"}[i] + ",ladder-" + string(rune('a'+i)) + ",maker-or-cancel\n"
+	}
+	// two orders refused by the rate limit are sent again
+	server.rateLimited = 2
+	out, err := run_app(t, nil, "-y", "order", "batch", "-f", write_batch(t, "orders.csv", csv), "--concurrency", "4", "--rate", "100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := batch_results(t, out)
+	ids := map[string]bool{}
+	for i, r := range results {
+		if r.Row != i+1 || r.Status != "placed" || r.OrderId == "" || r.Error != "" {
+			t.Errorf("row %+v", r)
+		}
+		ids[r.OrderId] = true
+	}
+	if len(ids) != 8 || len(server.orders) != 8 {
+		t.Errorf("%d order ids, %d orders placed", len(ids), len(server.orders))
+	}
+	for _, o := range server.orders {
+		if len(o.Options) != 1 || o.Options[0] != optionMakerOrCancel || !strings.HasPrefix(o.ClientOrderId, "ladder-") {
+			t.Errorf("order %+v", o)
+		}
+	}
+}
+
+func TestOrderBatchInvalid(t *testing.T) {
+	server := use_fake_gemini(t)
+
+	yml := `
+- {symbol: btcusd, side: buy, amount: 0.1, price: 90, client_order_id: a}
+- {symbol: btcusd, side: hold, amount: 0.1, price: 90}
+- {symbol: btcusd, side: sell, amount: 0.1, price: 110.001}
+- {symbol: ethusd, side: sell, amount: 1, price: 110, client_order_id: a}
+`
+	out, err := run_app(t, nil, "-y", "order", "batch", "-f", write_batch(t, "orders.yaml", yml))
+	if err == nil || !strings.Contains(err.Error(), "3 of 4 orders are invalid, no order was sent") {
+		t.Errorf("err = %v", err)
+	}
+	results := batch_results(t, out)
+	want := []string{"valid", "invalid", "invalid", "invalid"}
+	for i, r := range results {
+		if r.Status != want[i] {
+			t.Errorf("row %d status %s, want %s: %s", r.Row, r.Status, want[i], r.Error)
+		}
+	}
+	if !strings.Contains(results[3].Error, "also used by row 1") {
+		t.Errorf("row 4 error %s", results[3].Error)
+	}
+	if len(server.orders) != 0 {
+		t.Errorf("%d orders placed", len(server.orders))
+	}
+
+	for _, test := range []struct{ name, content, err string }{
+		{"orders.csv", "symbol,side,amount,prize\n", `invalid column "prize"`},
+		{"orders.json", `[{"symbol":"btcusd","sid":"buy"}]`, `unknown field "sid"`},
+		{"orders.txt", "", "extension must be"},
+		{"orders.json", `[]`, "has no orders"},
+	} {
+		_, err := run_app(t, nil, "-y", "order", "batch", "-f", write_batch(t, test.name, test.content))
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: err = %v, want %s", test.content, err, test.err)
+		}
+	}
+}
+
+func TestOrderBatchFailure(t *testing.T) {
+	server := use_fake_gemini(t)
+	saved_backoff, saved_retries := send_backoff, send_retries
+	defer func() { send_backoff, send_retries = saved_backoff, saved_retries }()
+	send_backoff, send_retries = time.Millisecond, 1
+
+	json := `[{"symbol":"btcusd","side":"buy","amount":"0.1","price":"90"},
+		{"symbol":"btcusd","side":"buy","amount":"0.1","price":"91"},
+		{"symbol":"btcusd","side":"buy","amount":"0.1","price":"92"}]`
+	path := write_batch(t, "orders.json", json)
+
+	// the first order is refused twice: once and once more after the retry
+	server.rateLimited = 2
+	out, err := run_app(t, nil, "-y", "order", "batch", "-f", path, "--concurrency", "1", "--stop-on-error")
+	if err == nil || !strings.Contains(err.Error(), "3 of 3 orders were not placed") {
+		t.Errorf("err = %v", err)
+	}
+	results := batch_results(t, out)
+	if results[0].Status != "failed" || !strings.Contains(results[0].Error, "429") ||
+		results[1].Status != "skipped" || results[2].Status != "skipped" {
+		t.Errorf("results %+v", results)
+	}
+
+	server.rateLimited = 2
+	out, err = run_app(t, nil, "-y", "order", "batch", "-f", path, "--concurrency", "1")
+	if err == nil || !strings.Contains(err.Error(), "1 of 3 orders were not placed") {
+		t.Errorf("err = %v", err)
+	}
+	results = batch_results(t, out)
+	if results[0].Status != "failed" || results[1].Status != "placed" || results[2].Status != "placed" {
+		t.Errorf("results %+v", results)
+	}
+}
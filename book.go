@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/claudiocandio/gemini-api"
+	"github.com/urfave/cli/v2"
+)
+
+// order_book is a local order book of one symbol, seeded from the REST
+// snapshot and kept up to date with the l2 changes of the market data feed
+type order_book struct {
+	mu     sync.Mutex
+	symbol string
+	// price -> amount
+	bids, asks map[float64]float64
+	updated    time.Time
+}
+
+type book_level struct {
+	Price  float64
+	Amount float64
+}
+
+func new_order_book(symbol string) *order_book {
+	return &order_book{symbol: strings.ToLower(symbol), bids: map[float64]float64{}, asks: map[float64]float64{}}
+}
+
+// seed replaces the book with a REST snapshot
+func (b *order_book) seed(snapshot gemini.Book) {
+	b.bids, b.asks = map[float64]float64{}, map[float64]float64{}
+	for _, e := range snapshot.Bids {
+		b.bids[e.Price] = e.Amount
+	}
+	for _, e := range snapshot.Asks {
+		b.asks[e.Price] = e.Amount
+	}
+	b.updated = time.Now().UTC()
+}
+
+// apply applies a change of the market data feed, the amount is the new
+// amount at the price level and 0 removes it
+func (b *order_book) apply(e market_event) error {
+	if e.Type != "change" {
+		return nil
+	}
+	price, err := strconv.ParseFloat(e.Price, 64)
+	if err != nil {
+		return fmt.Errorf("Error invalid book change price: %s", e.Price)
+	}
+	amount, err := strconv.ParseFloat(e.Amount, 64)
+	if err != nil {
+		return fmt.Errorf("Error invalid book change amount: %s", e.Amount)
+	}
+	side := b.bids
+	if e.Side == "ask" {
+		side = b.asks
+	}
+	if amount == 0 {
+		delete(side, price)
+	} else {
+		side[price] = amount
+	}
+	b.updated = e.Time
+	return nil
+}
+
+// levels returns the bids from the highest price, or the asks from the lowest
+func (b *order_book) levels(side string) []book_level {
+	m := b.bids
+	if side == "ask" {
+		m = b.asks
+	}
+	levels := make([]book_level, 0, len(m))
+	for price, amount := range m {
+		levels = append(levels, book_level{price, amount})
+	}
+	sort.Slice(levels, func(i, j int) bool {
+		if side == "ask" {
+			return levels[i].Price < levels[j].Price
+		}
+		return levels[i].Price > levels[j].Price
+	})
+	return levels
+}
+
+// book_stats is what book stats prints, depth is the cumulative amount
+// within each distance from the mid price
+type book_stats struct {
+	Time      time.Time    `json:"time"`
+	Symbol    string       `json:"symbol"`
+	BestBid   float64      `json:"best_bid"`
+	BestAsk   float64      `json:"best_ask"`
+	Mid       float64      `json:"mid"`
+	Spread    float64      `json:"spread"`
+	SpreadBps float64      `json:"spread_bps"`
+	Imbalance float64      `json:"imbalance"`
+	Depth     []book_depth `json:"depth"`
+}
+
+type book_depth struct {
+	Bps         float64 `json:"bps"`
+	BidAmount   float64 `json:"bid_amount"`
+	AskAmount   float64 `json:"ask_amount"`
+	BidNotional float64 `json:"bid_notional"`
+	AskNotional float64 `json:"ask_notional"`
+	Imbalance   float64 `json:"imbalance"`
+}
+
+// book_depth_row is one depth of book_stats with the top of the book, for
+// the jsonl, csv and table output
+type book_depth_row struct {
+	Time        time.Time `json:"time"`
+	Symbol      string    `json:"symbol"`
+	BestBid     float64   `json:"best_bid"`
+	BestAsk     float64   `json:"best_ask"`
+	SpreadBps   float64   `json:"spread_bps"`
+	Bps         float64   `json:"bps"`
+	BidAmount   float64   `json:"bid_amount"`
+	AskAmount   float64   `json:"ask_amount"`
+	BidNotional float64   `json:"bid_notional"`
+	AskNotional float64   `json:"ask_notional"`
+	Imbalance   float64   `json:"imbalance"`
+}
+
+// imbalance is (bid - ask) / (bid + ask): 1 only bids, -1 only asks
+func imbalance(bid, ask float64) float64 {
+	if bid+ask == 0 {
+		return 0
+	}
+	return round_decimals((bid-ask)/(bid+ask), 4)
+}
+
+func round_decimals(x float64, decimals int) float64 {
+	p := math.Pow(10, float64(decimals))
+	return math.Round(x*p) / p
+}
+
+// stats computes the spread, the top of book imbalance and the depth
+// within each of depth_bps from the mid price
+func (b *order_book) stats(depth_bps []float64) (book_stats, error) {
+	bids, asks := b.levels("bid"), b.levels("ask")
+	if len(bids) == 0 || len(asks) == 0 {
+		return book_stats{}, fmt.Errorf("Error the %s order book has no bids or no asks", b.symbol)
+	}
+	bid, ask := bids[0], asks[0]
+	mid := (bid.Price + ask.Price) / 2
+	s := book_stats{
+		Time:      b.updated,
+		Symbol:    b.symbol,
+		BestBid:   bid.Price,
+		BestAsk:   ask.Price,
+		Mid:       round_decimals(mid, 8),
+		Spread:    round_decimals(ask.Price-bid.Price, 8),
+		SpreadBps: round_decimals((ask.Price-bid.Price)/mid*1e4, 2),
+		Imbalance: imbalance(bid.Amount, ask.Amount),
+		Depth:     []book_depth{},
+	}
+	for _, bps := range depth_bps {
+		d := book_depth{Bps: bps}
+		for _, l := range bids {
+			if l.Price < mid*(1-bps/1e4) {
+				break
+			}
+			d.BidAmount += l.Amount
+			d.BidNotional += l.Amount * l.Price
+		}
+		for _, l := range asks {
+			if l.Price > mid*(1+bps/1e4) {
+				break
+			}
+			d.AskAmount += l.Amount
+			d.AskNotional += l.Amount * l.Price
+		}
+		d.Imbalance = imbalance(d.BidAmount, d.AskAmount)
+		d.BidAmount, d.AskAmount = round_decimals(d.BidAmount, 8), round_decimals(d.AskAmount, 8)
+		d.BidNotional, d.AskNotional = round_decimals(d.BidNotional, 2), round_decimals(d.AskNotional, 2)
+		s.Depth = append(s.Depth, d)
+	}
+	return s, nil
+}
+
+// book_impact is the result of walking the book with a market order of amount
+type book_impact struct {
+	Time   time.Time `json:"time"`
+	Symbol string    `json:"symbol"`
+	Side   string    `json:"side"`
+	Amount decimal   `json:"amount"`
+	// less than amount when the book is not deep enough
+	Filled     decimal `json:"filled"`
+	Complete   bool    `json:"complete"`
+	Notional   decimal `json:"notional"`
+	Vwap       decimal `json:"vwap"`
+	BestPrice  decimal `json:"best_price"`
+	WorstPrice decimal `json:"worst_price"`
+	Levels     int     `json:"levels"`
+	// cost of the vwap compared to the best price and to the mid price
+	SlippageBps    decimal `json:"slippage_bps"`
+	MidSlippageBps decimal `json:"mid_slippage_bps"`
+}
+
+// impact walks the asks for a buy, the bids for a sell, in exact decimals
+func (b *order_book) impact(side string, amount decimal) (book_impact, error) {
+	bids, asks := b.levels("bid"), b.levels("ask")
+	if len(bids) == 0 || len(asks) == 0 {
+		return book_impact{}, fmt.Errorf("Error the %s order book has no bids or no asks", b.symbol)
+	}
+	mid := decimal_from_float(bids[0].Price).add(decimal_from_float(asks[0].Price)).quo(decimal_int(2))
+	levels := asks
+	if side == "sell" {
+		levels = bids
+	}
+
+	r := book_impact{Time: b.updated, Symbol: b.symbol, Side: side, Amount: amount, BestPrice: decimal_from_float(levels[0].Price)}
+	remaining := amount
+	for _, l := range levels {
+		if remaining.sign() <= 0 {
+			break
+		}
+		price, fill := decimal_from_float(l.Price), decimal_from_float(l.Amount)
+		if remaining.cmp(fill) < 0 {
+			fill = remaining
+		}
+		r.Filled = r.Filled.add(fill)
+		r.Notional = r.Notional.add(fill.mul(price))
+		r.WorstPrice = price
+		r.Levels++
+		remaining = remaining.sub(fill)
+	}
+	r.Complete = remaining.sign() <= 0
+	r.Vwap = r.Notional.quo(r.Filled)
+
+	one, bps := decimal_int(1), decimal_int(10000)
+	slippage, mid_slippage := r.Vwap.quo(r.BestPrice).sub(one), r.Vwap.quo(mid).sub(one)
+	if side == "sell" {
+		slippage, mid_slippage = one.sub(r.Vwap.quo(r.BestPrice)), one.sub(r.Vwap.quo(mid))
+	}
+	r.SlippageBps = round_price(slippage.mul(bps))
+	r.MidSlippageBps = round_price(mid_slippage.mul(bps))
+	r.Notional = r.Notional.round(reportIncrement)
+	r.Vwap = r.Vwap.round(reportIncrement)
+	return r, nil
+}
+
+// book_feed keeps book up to date with the v2 l2 feed, the first
+// l2_updates of every connection is the full book and replaces it
+func book_feed(c *cli.Context, book *order_book) (*ws_feed, func([]byte) error, error) {
+	u, err := ws_url(c, marketdata_v2_URI)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	snapshot := true
+	feed := &ws_feed{
+		name: "marketdata l2 " + book.symbol,
+		url:  u,
+		subscribe: map[string]interface{}{
+			"type":          "subscribe",
+			"subscriptions": []map[string]interface{}{{"name": "l2", "symbols": []string{strings.ToUpper(book.symbol)}}},
+		},
+		heartbeat:   c.Duration("heartbeat"),
+		max_retries: c.Int("max-retries"),
+		connected: func(bool) error {
+			book.mu.Lock()
+			snapshot = true
+			book.mu.Unlock()
+			return nil
+		},
+	}
+	handle := func(msg []byte) error {
+		var m v2_message
+		if err := json.Unmarshal(msg, &m); err != nil {
+			return fmt.Errorf("Error %s: invalid message: %s", feed.name, err)
+		}
+		if m.Type != "l2_updates" {
+			return nil
+		}
+		events, err := v2_events(m)
+		if err != nil {
+			return err
+		}
+
+		book.mu.Lock()
+		defer book.mu.Unlock()
+		if snapshot {
+			book.seed(gemini.Book{})
+			snapshot = false
+		}
+		for _, e := range events {
+			if err := book.apply(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return feed, handle, nil
+}
+
+// run_book seeds the book of --ticker from the REST snapshot and prints
+// report once, or with --live every --every while the l2 feed updates it
+func run_book(c *cli.Context, api gemini_client, report func(b *order_book) (interface{}, error)) error {
+	symbol, err := get_ticker_flag(c)
+	if err != nil {
+		return err
+	}
+	if every := c.Duration("every"); c.Bool("live") && every <= 0 {
+		return fmt.Errorf("Error invalid --every: %s, it must be greater than 0", every)
+	}
+	book := new_order_book(symbol)
+	snapshot, err := api.OrderBook(book.symbol, gemini.Args{"limit_bids": "0", "limit_asks": "0"})
+	if err != nil {
+		return err
+	}
+	book.seed(snapshot)
+
+	if !c.Bool("live") {
+		v, err := report(book)
+		if err != nil {
+			return err
+		}
+		return print_output(c, v)
+	}
+
+	w := new_stream_writer(c)
+	feed, handle, err := book_feed(c, book)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		ticker := time.NewTicker(c.Duration("every"))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			book.mu.Lock()
+			v, err := report(book)
+			book.mu.Unlock()
+			if err != nil {
+				errlog.Println(err)
+				continue
+			}
+			w.write(v)
+		}
+	}()
+	return stream_feeds(ctx, w, []*ws_feed{feed}, []func([]byte) error{handle})
+}
+
+// book_flags are the flags of book stats and book impact
+func book_flags(flags ...cli.Flag) []cli.Flag {
+	flags = append([]cli.Flag{
+		&cli.StringFlag{
+			Name:    "ticker",
+			Aliases: []string{"t"},
+			Usage:   "e.g. --ticker btcusd (required unless the profile has a default_symbol)",
+		},
+	}, flags...)
+	return append(flags, append([]cli.Flag{
+		&cli.BoolFlag{
+			Name:  "live",
+			Usage: "Keep the book up to date with the market data feed and print every --every until Ctrl-C",
+		},
+		&cli.DurationFlag{
+			Name:  "every",
+			Usage: "e.g. --every 5s - How often --live prints",
+			Value: time.Second,
+		},
+	}, feed_flags()...)...)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/claudiocandio/gemini-api"
+	"github.com/gorilla/websocket"
+)
+
+var test_book = gemini.Book{
+	Bids: gemini.BookEntries{{Price: 99.9, Amount: 1}, {Price: 99.5, Amount: 2}, {Price: 98, Amount: 5}},
+	Asks: gemini.BookEntries{{Price: 100.1, Amount: 0.5}, {Price: 100.4, Amount: 1.5}, {Price: 102, Amount: 10}},
+}
+
+func TestBookStats(t *testing.T) {
+	b := new_order_book("BTCUSD")
+	b.seed(test_book)
+
+	s, err := b.stats([]float64{10, 50, 250})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.BestBid != 99.9 || s.BestAsk != 100.1 || s.Mid != 100 || s.Spread != 0.2 || s.SpreadBps != 20 ||
+		s.Imbalance != 0.3333 {
+		t.Errorf("stats %+v", s)
+	}
+	want := []book_depth{
+		{Bps: 10, BidAmount: 1, AskAmount: 0.5, BidNotional: 99.9, AskNotional: 50.05, Imbalance: 0.3333},
+		{Bps: 50, BidAmount: 3, AskAmount: 2, BidNotional: 298.9, AskNotional: 200.65, Imbalance: 0.2},
+		{Bps: 250, BidAmount: 8, AskAmount: 12, BidNotional: 788.9, AskNotional: 1220.65, Imbalance: -0.2},
+	}
+	for i, d := range s.Depth {
+		if d != want[i] {
+			t.Errorf("depth %+v, want %+v", d, want[i])
+		}
+	}
+
+	// a change sets the amount of a level, 0 removes it
+	b.apply(market_event{Type: "change", Side: "ask", Price: "100.1", Amount: "0"})
+	b.apply(market_event{Type: "change", Side: "bid", Price: "100", Amount: "4"})
+	if s, _ := b.stats(nil); s.BestBid != 100 || s.BestAsk != 100.4 {
+		t.Errorf("after changes %+v", s)
+	}
+}
+
+func TestBookImpact(t *testing.T) {
+	b := new_order_book("btcusd")
+	b.seed(test_book)
+
+	r, err := b.impact("buy", dec("1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 0.5 at 100.1 and 0.5 at 100.4
+	if !r.Complete || r.Vwap.String() != "100.25" || r.WorstPrice.String() != "100.4" || r.Levels != 2 ||
+		r.SlippageBps.String() != "14.99" || r.MidSlippageBps.String() != "25" {
+		t.Errorf("buy impact %+v", r)
+	}
+
+	r, _ = b.impact("sell", dec("3"))
+	if !r.Complete || r.Notional.String() != "298.9" || r.Vwap.String() != "99.63333333" || r.SlippageBps.String() != "26.69" {
+		t.Errorf("sell impact %+v", r)
+	}
+
+	r, _ = b.impact("sell", dec("10"))
+	if r.Complete || r.Filled.String() != "8" || r.Levels != 3 {
+		t.Errorf("impact larger than the book %+v", r)
+	}
+}
+
+func TestBookCommands(t *testing.T) {
+	fake := &fake_client{book: test_book}
+	out, err := run_app(t, fake, "-o", "csv", "book", "stats", "-t", "btcusd", "--depth-bps", "10", "--depth-bps", "50")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(out, "\n")
+	if lines[0] != "time,symbol,best_bid,best_ask,spread_bps,bps,bid_amount,ask_amount,bid_notional,ask_notional,imbalance" ||
+		!strings.HasSuffix(lines[2], ",btcusd,99.9,100.1,20,50,3,2,298.9,200.65,0.2") {
+		t.Errorf("output:\n%s", out)
+	}
+
+	out, err = run_app(t, fake, "book", "impact", "-t", "btcusd", "-s", "buy", "-a", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r book_impact
+	if err := json.Unmarshal([]byte(out), &r); err != nil || r.Vwap.String() != "100.25" {
+		t.Errorf("output %s: %v", out, err)
+	}
+
+	// the amount and the notional are exact decimals, printed as strings
+	out, err = run_app(t, fake, "-o", "jsonl", "book", "impact", "-t", "btcusd", "-s", "buy", "-a", "0.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, `"amount":"0.3","filled":"0.3","complete":true,"notional":"30.03"`) {
+		t.Errorf("output %s", out)
+	}
+	if _, err := run_app(t, fake, "book", "impact", "-t", "btcusd", "-s", "buy", "-a", "1e"); err == nil {
+		t.Error("invalid amount accepted")
+	}
+	if _, err := run_app(t, fake, "book", "impact", "-t", "btcusd", "-s", "hold", "-a", "1"); err == nil {
+		t.Error("invalid side accepted")
+	}
+	if _, err := run_app(t, fake, "book", "stats", "-t", "btcusd", "--live", "--every", "0"); err == nil ||
+		!strings.Contains(err.Error(), "invalid --every") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestBookLive(t *testing.T) {
+	new_fake_ws(t, true, func(conn *websocket.Conn, n int) {
+		// the first l2_updates is the full book and replaces the REST snapshot
+		send(conn,
+			`{"type":"l2_updates","symbol":"BTCUSD","changes":[["buy","99","1"],["sell","101","1"]]}`,
+			`{"type":"l2_updates","symbol":"BTCUSD","changes":[["buy","100","3"]]}`,
+		)
+	})
+	fake := &fake_client{book: test_book}
+
+	out, err := run_app(t, fake, "-o", "jsonl", "book", "impact", "-t", "btcusd", "-s", "sell", "-a", "4",
+		"--live", "--every", "200ms", "--count", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r book_impact
+	if err := json.Unmarshal([]byte(out), &r); err != nil {
+		t.Fatal(err)
+	}
+	if !r.Complete || r.BestPrice.String() != "100" || r.Vwap.String() != "99.75" || r.Levels != 2 {
+		t.Errorf("impact %+v", r)
+	}
+}
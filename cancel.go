@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/claudiocandio/gemini-api"
+	"github.com/claudiocandio/gemini-api/logger"
+	"github.com/urfave/cli/v2"
+)
+
+// the matching orders listed in the confirmation, the others are counted
+const cancel_preview_orders = 20
+
+// cancel_filter selects the active orders cancelled by order cancel, an
+// order must match all the filters that are set
+type cancel_filter struct {
+	symbols []string
+	side    string
+	// Gemini order type e.g. exchange limit
+	order_type string
+	min_price  decimal
+	max_price  decimal
+	// bps between the order price and the mid price of its symbol
+	min_distance_bps       decimal
+	older_than             time.Duration
+	client_order_id_prefix string
+}
+
+// cancel_filter_flags are the flags of order cancel selecting many orders
+func cancel_filter_flags() []cli.Flag {
+	return append([]cli.Flag{
+		&cli.StringSliceFlag{
+			Name:    "ticker",
+			Aliases: []string{"t"},
+			Usage:   "e.g. --ticker btcusd - Cancel the orders of this symbol, can be repeated",
+		},
+		&cli.StringFlag{
+			Name:    "side",
+			Aliases: []string{"s"},
+			Usage:   "--side buy|sell - Cancel the orders of this side",
+		},
+		&cli.StringFlag{
+			Name:  "type",
+			Usage: "--type limit|stop-limit - Cancel the orders of this type",
+		},
+		&cli.StringFlag{
+			Name:  "min-price",
+			Usage: "e.g. --min-price 30000 - Cancel the orders with a price of at least 30000",
+		},
+		&cli.StringFlag{
+			Name:  "max-price",
+			Usage: "e.g. --max-price 31000 - Cancel the orders with a price of at most 31000",
+		},
+		&cli.StringFlag{
+			Name:  "min-distance-bps",
+			Usage: "e.g. --min-distance-bps 50 - Cancel the orders at least 50 bps away from the mid price of their symbol",
+		},
+		&cli.DurationFlag{
+			Name:  "older-than",
+			Usage: "e.g. --older-than 1h - Cancel the orders placed more than 1h ago",
+		},
+		&cli.StringFlag{
+			Name:  "client-order-id-prefix",
+			Usage: "e.g. --client-order-id-prefix mybot- - Cancel the orders with a client_order_id starting with mybot-",
+		},
+	}, parallel_flags("Orders cancelled")...)
+}
+
+// get_cancel_filter returns the filter of the flags, nil if none is set
+func get_cancel_filter(c *cli.Context) (*cancel_filter, error) {
+	set := false
+	for _, name := range []string{"ticker", "side", "type", "min-price", "max-price", "min-distance-bps",
+		"older-than", "client-order-id-prefix"} {
+		set = set || c.IsSet(name)
+	}
+	if !set {
+		return nil, nil
+	}
+	// an empty filter would match every active order, like cancel_all
+	for _, name := range []string{"side", "type", "min-price", "max-price", "min-distance-bps", "client-order-id-prefix"} {
+		if c.IsSet(name) && strings.TrimSpace(c.String(name)) == "" {
+			return nil, fmt.Errorf("Error invalid --%s: it must not be empty", name)
+		}
+	}
+	for _, symbol := range c.StringSlice("ticker") {
+		if strings.TrimSpace(symbol) == "" {
+			return nil, fmt.Errorf("Error invalid --ticker: it must not be empty")
+		}
+	}
+
+	f := &cancel_filter{
+		side:                   c.String("side"),
+		older_than:             c.Duration("older-than"),
+		client_order_id_prefix: c.String("client-order-id-prefix"),
+	}
+	for _, symbol := range c.StringSlice("ticker") {
+		f.symbols = append(f.symbols, strings.ToLower(symbol))
+	}
+	if f.side != "" && f.side != "buy" && f.side != "sell" {
+		return nil, fmt.Errorf("Error invalid side: %s\nValid sides: buy, sell", f.side)
+	}
+	if c.IsSet("type") {
+		t, err := parseOrderType(c.String("type"))
+		if err != nil {
+			return nil, err
+		}
+		f.order_type = t
+	}
+	var err error
+	if f.min_price, err = decimal_flag(c, "min-price"); err != nil {
+		return nil, err
+	}
+	if f.max_price, err = decimal_flag(c, "max-price"); err != nil {
+		return nil, err
+	}
+	if c.IsSet("min-price") && c.IsSet("max-price") && f.min_price.cmp(f.max_price) > 0 {
+		return nil, fmt.Errorf("Error --min-price %s is greater than --max-price %s", f.min_price, f.max_price)
+	}
+	if f.min_distance_bps, err = decimal_flag(c, "min-distance-bps"); err != nil {
+		return nil, err
+	}
+	if f.min_distance_bps.sign() < 0 {
+		return nil, fmt.Errorf("Error invalid --min-distance-bps: %s, it must not be negative", f.min_distance_bps)
+	}
+	if f.older_than < 0 {
+		return nil, fmt.Errorf("Error invalid --older-than: %s, it must not be negative", f.older_than)
+	}
+	return f, nil
+}
+
+// match tells if the order passes all the filters but --min-distance-bps,
+// which needs the mid price of the order symbol
+func (f *cancel_filter) match(o gemini.Order, now time.Time) bool {
+	price := decimal_from_float(o.Price)
+	switch {
+	case len(f.symbols) > 0 && !contains(f.symbols, strings.ToLower(o.Symbol)):
+		return false
+	case f.side != "" && o.Side != f.side:
+		return false
+	case f.order_type != "" && o.Type != f.order_type:
+		return false
+	case !f.min_price.is_zero() && price.cmp(f.min_price) < 0:
+		return false
+	case !f.max_price.is_zero() && price.cmp(f.max_price) > 0:
+		return false
+	case f.older_than > 0 && now.Sub(msToTime(o.Timestampms)) < f.older_than:
+		return false
+	}
+	return strings.HasPrefix(o.ClientOrderId, f.client_order_id_prefix)
+}
+
+// distance_bps is the distance of price from mid in bps
+func distance_bps(price, mid decimal) decimal {
+	d := price.sub(mid).quo(mid).mul(decimal_int(10000))
+	if d.sign() < 0 {
+		return decimal_int(0).sub(d)
+	}
+	return d
+}
+
+// cancel_row is what order cancel prints for every matching order
+type cancel_row struct {
+	OrderId       string    `json:"order_id"`
+	ClientOrderId string    `json:"client_order_id"`
+	Symbol        string    `json:"symbol"`
+	Side          string    `json:"side"`
+	Type          string    `json:"type"`
+	Price         decimal   `json:"price"`
+	Remaining     decimal   `json:"remaining_amount"`
+	Placed        time.Time `json:"placed"`
+	// cancelled, failed or skipped
+	Status string `json:"status"`
+	Error  string `json:"error"`
+}
+
+// match_orders returns the active orders matching f, oldest first. The mid
+// prices are read only for --min-distance-bps and the symbols with orders
+func match_orders(api gemini_client, f *cancel_filter) ([]gemini.Order, error) {
+	// /v1/orders
+	orders, err := api.ActiveOrders()
+	if err != nil {
+		return nil, err
+	}
+	mids := map[string]decimal{}
+	now := time.Now()
+	var matched []gemini.Order
+	for _, o := range orders {
+		if !f.match(o, now) {
+			continue
+		}
+		if f.min_distance_bps.sign() > 0 {
+			symbol := strings.ToLower(o.Symbol)
+			if _, ok := mids[symbol]; !ok {
+				// /v2/ticker/:symbol
+				ticker, err := api.TickerV2(symbol)
+				if err != nil {
+					return nil, err
+				}
+				if ticker.Bid <= 0 || ticker.Ask <= 0 {
+					return nil, fmt.Errorf("Error %s has no bid/ask to compute the distance from the mid price", symbol)
+				}
+				mids[symbol] = decimal_from_float(ticker.Bid).add(decimal_from_float(ticker.Ask)).quo(decimal_int(2))
+			}
+			if distance_bps(decimal_from_float(o.Price), mids[symbol]).cmp(f.min_distance_bps) < 0 {
+				continue
+			}
+		}
+		matched = append(matched, o)
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i].Timestampms < matched[j].Timestampms })
+	logger.Debug("func match_orders",
+		fmt.Sprintf("active:%d", len(orders)),
+		fmt.Sprintf("matched:%d", len(matched)),
+	)
+	return matched, nil
+}
+
+// cancel_summary is the confirmation summary: the matching orders by symbol
+// and side and the first cancel_preview_orders orders
+func cancel_summary(c *cli.Context, orders []gemini.Order) []summary_field {
+	counts := map[string]int{}
+	for _, o := range orders {
+		counts[strings.ToLower(o.Symbol)+" "+o.Side]++
+	}
+	fields := append(environment_summary(c), summary_field{"orders", fmt.Sprint(len(orders))})
+	var keys []string
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fields = append(fields, summary_field{k, fmt.Sprint(counts[k])})
+	}
+	for i, o := range orders {
+		if i == cancel_preview_orders {
+			fields = append(fields, summary_field{"...", fmt.Sprintf("and %d more orders", len(orders)-i)})
+			break
+		}
+		fields = append(fields, summary_field{"order " + o.OrderId,
+			strings.TrimSpace(fmt.Sprintf("%s %s %s @ %s %s", strings.ToLower(o.Symbol), o.Side,
+				formatFloat(o.RemainingAmount), formatFloat(o.Price), o.ClientOrderId))})
+	}
+	return fields
+}
+
+// run_cancel_where cancels the active orders matching f with at most
+// --concurrency requests in flight, after Ctrl-C the orders not yet
+// cancelled are skipped. It fails if any matching order is not cancelled
+func run_cancel_where(c *cli.Context, api gemini_client, f *cancel_filter) error {
+	if err := check_parallel_flags(c); err != nil {
+		return err
+	}
+	orders, err := match_orders(api, f)
+	if err != nil {
+		return err
+	}
+
+	rows := make([]cancel_row, len(orders))
+	for i, o := range orders {
+		rows[i] = cancel_row{OrderId: o.OrderId, ClientOrderId: o.ClientOrderId, Symbol: strings.ToLower(o.Symbol),
+			Side: o.Side, Type: o.Type, Price: decimal_from_float(o.Price), Remaining: decimal_from_float(o.RemainingAmount),
+			Placed: msToTime(o.Timestampms).UTC()}
+	}
+	if len(orders) == 0 {
+		errlog.Printf("order cancel: no active order matches the filters")
+		return print_output(c, rows)
+	}
+
+	if c.Bool("dry-run") {
+		var requests []signed_request
+		for _, o := range orders {
+			req, err := api.SignRequest(cancel_order_URI, map[string]interface{}{"order_id": o.OrderId})
+			if err != nil {
+				return err
+			}
+			requests = append(requests, req)
+		}
+		return print_output(c, requests)
+	}
+	if err := confirm(c, "Cancel the matching orders", cancel_summary(c, orders)); err != nil {
+		return err
+	}
+
+	ctx, cancel := interrupt_context(context.Background())
+	defer cancel()
+	t, stop := new_throttle(ctx, c.Float64("rate"))
+	defer stop()
+	run_parallel(ctx, c.Int("concurrency"), len(rows), func(i int) {
+		r := &rows[i]
+		// /v1/order/cancel
+		sent, err := t.send("order cancel: order "+r.OrderId, func() error {
+			_, err := api.CancelOrder(r.OrderId)
+			return err
+		})
+		switch {
+		case !sent:
+			r.Status = "skipped"
+		case err != nil:
+			r.Status, r.Error = "failed", one_line(err)
+		default:
+			r.Status = "cancelled"
+		}
+	}, func(i int) {
+		rows[i].Status = "skipped"
+	})
+	if err := print_output(c, rows); err != nil {
+		return err
+	}
+
+	cancelled := 0
+	for _, r := range rows {
+		if r.Status == "cancelled" {
+			cancelled++
+		}
+	}
+	errlog.Printf("order cancel: %d of %d matching orders cancelled", cancelled, len(rows))
+	if cancelled < len(rows) {
+		return fmt.Errorf("Error %d of %d matching orders were not cancelled", len(rows)-cancelled, len(rows))
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/claudiocandio/gemini-api"
+)
+
+func TestOrderCancelWhere(t *testing.T) {
+	server := use_fake_gemini(t)
+	old := time.Now().Add(-2*time.Hour).UnixNano() / 1e6
+	now := time.Now().UnixNano() / 1e6
+	for _, o := range []gemini.Order{
+		{OrderId: "1", ClientOrderId: "mybot-1", Symbol: "btcusd", Side: "buy", Type: orderTypeLimit, Price: 90, Timestampms: old},
+		{OrderId: "2", ClientOrderId: "mybot-2", Symbol: "btcusd", Side: "buy", Type: orderTypeLimit, Price: 99.5, Timestampms: old},
+		{OrderId: "3", ClientOrderId: "mybot-3", Symbol: "btcusd", Side: "sell", Type: orderTypeLimit, Price: 110, Timestampms: old},
+		{OrderId: "4", ClientOrderId: "mybot-4", Symbol: "btcusd", Side: "buy", Type: orderTypeLimit, Price: 80, Timestampms: now},
+		{OrderId: "5", ClientOrderId: "manual", Symbol: "btcusd", Side: "buy", Type: orderTypeLimit, Price: 85, Timestampms: old},
+		{OrderId: "6", ClientOrderId: "mybot-6", Symbol: "ethusd", Side: "buy", Type: orderTypeLimit, Price: 90, Timestampms: old},
+	} {
+		o := o
+		o.IsLive = true
+		o.RemainingAmount = 1
+		server.orders = append(server.orders, &o)
+	}
+	live := func() []string {
+		var ids []string
+		for _, o := range server.orders {
+			if o.IsLive {
+				ids = append(ids, o.OrderId)
+			}
+		}
+		return ids
+	}
+
+	// stale bids of btcusd: mid is 100, 99.5 is only 50 bps away
+	where := []string{"order", "cancel", "-t", "btcusd", "--side", "buy", "--older-than", "1h",
+		"--client-order-id-prefix", "mybot-", "--min-distance-bps", "100"}
+
+	var prompt bytes.Buffer
+	saved_output := confirm_output
+	defer func() { confirm_input, confirm_output = os.Stdin, saved_output }()
+	confirm_input, confirm_output = strings.NewReader("no\n"), &prompt
+	if _, err := run_app(t, nil, where...); err == nil || !strings.Contains(err.Error(), "Aborted") {
+		t.Errorf("err = %v", err)
+	}
+	for _, want := range []string{"orders:", "btcusd buy:", "order 1:", "btcusd buy 1 @ 90 mybot-1"} {
+		if !strings.Contains(prompt.String(), want) {
+			t.Errorf("missing %q in the preview\n%s", want, prompt.String())
+		}
+	}
+	if strings.Contains(prompt.String(), "order 2:") {
+		t.Errorf("order 2 in the preview\n%s", prompt.String())
+	}
+
+	out, err := run_app(t, nil, append([]string{"--dry-run"}, where...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, `"order_id": "1"`) {
+		t.Errorf("dry run\n%s", out)
+	}
+	if ids := live(); len(ids) != 6 {
+		t.Fatalf("orders cancelled by the dry run or the aborted cancel: live %v", ids)
+	}
+
+	out, err = run_app(t, nil, append([]string{"-y", "-o", "csv"}, where...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "1,mybot-1,btcusd,buy") || !strings.Contains(out, "cancelled") {
+		t.Errorf("output\n%s", out)
+	}
+	if ids := strings.Join(live(), ","); ids != "2,3,4,5,6" {
+		t.Errorf("live orders %s", ids)
+	}
+
+	// nothing left to match
+	out, err = run_app(t, nil, append([]string{"-y"}, where...)...)
+	if err != nil || strings.TrimSpace(out) != "[]" {
+		t.Errorf("out %s, err = %v", out, err)
+	}
+
+	if _, err := run_app(t, nil, "-y", "order", "cancel", "--max-price", "100", "--concurrency", "2"); err != nil {
+		t.Fatal(err)
+	}
+	if ids := strings.Join(live(), ","); ids != "3" {
+		t.Errorf("live orders %s", ids)
+	}
+
+	for _, test := range []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"--side", "hold"}, "invalid side: hold"},
+		{[]string{"--min-price", "100", "--max-price", "90"}, "greater than --max-price"},
+		{[]string{"--orderid", "3", "-t", "btcusd"}, "not both"},
+		{[]string{"-t", "btcusd", "--concurrency", "20"}, "invalid --concurrency"},
+		{nil, "a filter (--ticker"},
+		{[]string{"--client-order-id-prefix", ""}, "invalid --client-order-id-prefix: it must not be empty"},
+		{[]string{"--side", " "}, "invalid --side: it must not be empty"},
+		{[]string{"-t", ""}, "invalid --ticker: it must not be empty"},
+	} {
+		_, err := run_app(t, nil, append([]string{"-y", "order", "cancel"}, test.args...)...)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%v: err = %v, want %s", test.args, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// time frames of /v2/candles
+var candleTimeFrames = []string{"1m", "5m", "15m", "30m", "1hr", "6hr", "1day"}
+
+// candle_options are the indicators and the time range of get candles
+type candle_options struct {
+	sma, ema    []int
+	rsi, atr    int
+	bollinger   int
+	bollinger_k float64
+	// only the candles from start to end, and then the last limit, if set
+	start, end *time.Time
+	limit      int
+}
+
+func (o candle_options) validate() error {
+	for _, n := range append(append([]int{}, o.sma...), o.ema...) {
+		if n <= 0 {
+			return fmt.Errorf("Error invalid period: %d, it must be greater than 0", n)
+		}
+	}
+	for _, n := range []int{o.rsi, o.atr, o.bollinger} {
+		if n < 0 {
+			return fmt.Errorf("Error invalid period: %d, it must be greater than 0", n)
+		}
+	}
+	if o.bollinger > 0 && o.bollinger_k <= 0 {
+		return fmt.Errorf("Error invalid --bollinger-k: %s, it must be greater than 0", formatFloat(o.bollinger_k))
+	}
+	if o.start != nil && o.end != nil && o.end.Before(*o.start) {
+		return fmt.Errorf("Error --end is before --start")
+	}
+	return nil
+}
+
+// indicator_value is a computed column of a candle, NaN until there are
+// enough candles to compute it
+type indicator_value struct {
+	name  string
+	value float64
+}
+
+// candle_row is a candle followed by the indicator columns
+type candle_row struct {
+	candle
+	indicators []indicator_value
+}
+
+// MarshalJSON adds the indicators after the candle fields, null when not yet computed
+func (r candle_row) MarshalJSON() ([]byte, error) {
+	j, err := json.Marshal(r.candle)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.Write(j[:len(j)-1])
+	for _, i := range r.indicators {
+		value := "null"
+		if !math.IsNaN(i.value) {
+			value = strconv.FormatFloat(i.value, 'f', -1, 64)
+		}
+		fmt.Fprintf(&buf, ",%q:%s", i.name, value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// flat gives the csv and table columns, the indicators are empty when not yet computed
+func (r candle_row) flat() ([]string, []string) {
+	var cols, values []string
+	flatten(reflect.ValueOf(r.candle), "", &cols, &values)
+	for _, i := range r.indicators {
+		cols = append(cols, i.name)
+		if math.IsNaN(i.value) {
+			values = append(values, "")
+		} else {
+			values = append(values, strconv.FormatFloat(i.value, 'f', -1, 64))
+		}
+	}
+	return cols, values
+}
+
+// candle_rows sorts the candles from the oldest, computes the indicators
+// on all of them and then trims the time range
+func candle_rows(candles []candle, o candle_options) ([]candle_row, error) {
+	sort.Slice(candles, func(i, j int) bool { return candles[i].Time.Before(candles[j].Time) })
+
+	var high, low, close []float64
+	for _, c := range candles {
+		var values [3]float64
+		for i, n := range []json.Number{c.High, c.Low, c.Close} {
+			v, err := n.Float64()
+			if err != nil {
+				return nil, fmt.Errorf("Error invalid candle value: %s", n)
+			}
+			values[i] = v
+		}
+		high, low, close = append(high, values[0]), append(low, values[1]), append(close, values[2])
+	}
+
+	var columns []indicator
+	for _, n := range o.sma {
+		columns = append(columns, indicator{fmt.Sprintf("sma_%d", n), sma(close, n)})
+	}
+	for _, n := range o.ema {
+		columns = append(columns, indicator{fmt.Sprintf("ema_%d", n), ema(close, n)})
+	}
+	if o.rsi > 0 {
+		columns = append(columns, indicator{fmt.Sprintf("rsi_%d", o.rsi), rsi(close, o.rsi)})
+	}
+	if o.atr > 0 {
+		columns = append(columns, indicator{fmt.Sprintf("atr_%d", o.atr), atr(high, low, close, o.atr)})
+	}
+	if o.bollinger > 0 {
+		mid, upper, lower := bollinger(close, o.bollinger, o.bollinger_k)
+		columns = append(columns,
+			indicator{fmt.Sprintf("bb_mid_%d", o.bollinger), mid},
+			indicator{fmt.Sprintf("bb_upper_%d", o.bollinger), upper},
+			indicator{fmt.Sprintf("bb_lower_%d", o.bollinger), lower})
+	}
+
+	rows := []candle_row{}
+	for i, c := range candles {
+		if (o.start != nil && c.Time.Before(*o.start)) || (o.end != nil && c.Time.After(*o.end)) {
+			continue
+		}
+		row := candle_row{candle: c}
+		for _, col := range columns {
+			row.indicators = append(row.indicators, indicator_value{col.name, round_decimals(col.values[i], 8)})
+		}
+		rows = append(rows, row)
+	}
+	if o.limit > 0 && len(rows) > o.limit {
+		rows = rows[len(rows)-o.limit:]
+	}
+	return rows, nil
+}
+
+type indicator struct {
+	name   string
+	values []float64
+}
+
+func nan_series(n int) []float64 {
+	s := make([]float64, n)
+	for i := range s {
+		s[i] = math.NaN()
+	}
+	return s
+}
+
+// sma is the simple moving average of the last n values
+func sma(values []float64, n int) []float64 {
+	out := nan_series(len(values))
+	sum := 0.0
+	for i, v := range values {
+		sum += v
+		if i >= n {
+			sum -= values[i-n]
+		}
+		if i >= n-1 {
+			out[i] = sum / float64(n)
+		}
+	}
+	return out
+}
+
+// ema is the exponential moving average with alpha 2/(n+1), starting
+// from the sma of the first n values
+func ema(values []float64, n int) []float64 {
+	out := nan_series(len(values))
+	if len(values) < n {
+		return out
+	}
+	alpha := 2 / float64(n+1)
+	out[n-1] = sma(values[:n], n)[n-1]
+	for i := n; i < len(values); i++ {
+		out[i] = alpha*values[i] + (1-alpha)*out[i-1]
+	}
+	return out
+}
+
+// wilder smooths the values with Wilder's moving average, starting from
+// the mean of values[first:first+n]
+func wilder(values []float64, first, n int) []float64 {
+	out := nan_series(len(values))
+	if len(values) < first+n {
+		return out
+	}
+	sum := 0.0
+	for _, v := range values[first : first+n] {
+		sum += v
+	}
+	out[first+n-1] = sum / float64(n)
+	for i := first + n; i < len(values); i++ {
+		out[i] = (out[i-1]*float64(n-1) + values[i]) / float64(n)
+	}
+	return out
+}
+
+// rsi is Wilder's relative strength index of the closes
+func rsi(close []float64, n int) []float64 {
+	gains, losses := make([]float64, len(close)), make([]float64, len(close))
+	for i := 1; i < len(close); i++ {
+		if change := close[i] - close[i-1]; change > 0 {
+			gains[i] = change
+		} else {
+			losses[i] = -change
+		}
+	}
+	// the first change is at index 1
+	avg_gain, avg_loss := wilder(gains, 1, n), wilder(losses, 1, n)
+	out := nan_series(len(close))
+	for i := range close {
+		switch {
+		case math.IsNaN(avg_gain[i]):
+		case avg_loss[i] == 0:
+			out[i] = 100
+		default:
+			out[i] = 100 - 100/(1+avg_gain[i]/avg_loss[i])
+		}
+	}
+	return out
+}
+
+// atr is Wilder's average true range
+func atr(high, low, close []float64, n int) []float64 {
+	tr := make([]float64, len(close))
+	for i := range close {
+		tr[i] = high[i] - low[i]
+		if i > 0 {
+			tr[i] = math.Max(tr[i], math.Max(math.Abs(high[i]-close[i-1]), math.Abs(low[i]-close[i-1])))
+		}
+	}
+	return wilder(tr, 0, n)
+}
+
+// bollinger returns the sma of n closes and the bands k standard deviations away
+func bollinger(close []float64, n int, k float64) ([]float64, []float64, []float64) {
+	mid := sma(close, n)
+	upper, lower := nan_series(len(close)), nan_series(len(close))
+	for i := n - 1; i < len(close); i++ {
+		variance := 0.0
+		for _, v := range close[i-n+1 : i+1] {
+			variance += (v - mid[i]) * (v - mid[i])
+		}
+		sd := math.Sqrt(variance / float64(n))
+		upper[i], lower[i] = mid[i]+k*sd, mid[i]-k*sd
+	}
+	return mid, upper, lower
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6 || (math.IsNaN(a) && math.IsNaN(b))
+}
+
+func TestIndicators(t *testing.T) {
+	nan := math.NaN()
+	close := []float64{1, 2, 3, 4, 5, 4, 3}
+	tests := []struct {
+		name      string
+		got, want []float64
+	}{
+		{"sma", sma(close, 3), []float64{nan, nan, 2, 3, 4, 13.0 / 3, 4}},
+		{"ema", ema(close, 3), []float64{nan, nan, 2, 3, 4, 4, 3.5}},
+		// average gain 1 and loss 0, then (1*2+0)/3 and (0*2+1)/3
+		{"rsi", rsi(close, 3), []float64{nan, nan, nan, 100, 100, 100 - 100/(1+(2.0/3)/(1.0/3)), 100 - 100/(1+(4.0/9)/(5.0/9))}},
+	}
+	for _, tt := range tests {
+		for i := range tt.want {
+			if !near(tt.got[i], tt.want[i]) {
+				t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+				break
+			}
+		}
+	}
+
+	// true ranges 2, 2 and 3 (the gap from the close 1)
+	high, low := []float64{3, 4, 4}, []float64{1, 2, 3}
+	if got := atr(high, low, []float64{2, 1, 3}, 2); !math.IsNaN(got[0]) || got[1] != 2 || got[2] != 2.5 {
+		t.Errorf("atr = %v", got)
+	}
+
+	mid, upper, lower := bollinger([]float64{1, 3, 1, 3}, 2, 2)
+	if mid[1] != 2 || upper[1] != 4 || lower[1] != 0 || !math.IsNaN(upper[0]) {
+		t.Errorf("bollinger = %v %v %v", mid, upper, lower)
+	}
+}
+
+func TestGetCandles(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2021, 2, d, 0, 0, 0, 0, time.UTC) }
+	fake := &fake_client{}
+	// newest first as sent by Gemini
+	for d := 5; d >= 1; d-- {
+		close := json.Number(formatFloat(float64(100 + d)))
+		fake.candles = append(fake.candles, candle{Time: day(d), Symbol: "btcusd", Open: "100", High: "110", Low: "90",
+			Close: close, Volume: "1.5"})
+	}
+
+	out, err := run_app(t, fake, "-o", "csv", "get", "candles", "-t", "btcusd", "-i", "1day", "--sma", "2", "--rsi", "2", "--limit", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `time,symbol,open,high,low,close,volume,sma_2,rsi_2
+2021-02-03T00:00:00Z,btcusd,100,110,90,103,1.5,102.5,100
+2021-02-04T00:00:00Z,btcusd,100,110,90,104,1.5,103.5,100
+2021-02-05T00:00:00Z,btcusd,100,110,90,105,1.5,104.5,100
+`
+	if out != want {
+		t.Errorf("output:\n%s\nwant:\n%s", out, want)
+	}
+	if fake.calls[0] != "Candles btcusd 1day" {
+		t.Errorf("calls %v", fake.calls)
+	}
+
+	// the indicators are null until there are enough candles
+	out, err = run_app(t, fake, "-o", "jsonl", "get", "candles", "-t", "btcusd", "--ema", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(out, "\n")
+	if !strings.HasSuffix(lines[1], `"volume":1.5,"ema_3":null}`) || !strings.HasSuffix(lines[2], `"ema_3":102}`) {
+		t.Errorf("output:\n%s", out)
+	}
+
+	if _, err := run_app(t, fake, "get", "candles", "-t", "btcusd", "-i", "1h"); err == nil {
+		t.Error("invalid interval accepted")
+	}
+}
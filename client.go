@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/claudiocandio/gemini-api"
+	"github.com/urfave/cli/v2"
+)
+
+// gemini_client is the part of the Gemini API used by the commands,
+// *gemini_api implements it and tests replace it with a fake
+type gemini_client interface {
+	// public
+	Symbols() ([]string, error)
+	TickerV2(symbol string) (gemini.TickerV2, error)
+	SymbolDetails(symbol string) (symbol_details, error)
+	Candles(symbol, time_frame string) ([]candle, error)
+	OrderBook(symbol string, args gemini.Args) (gemini.Book, error)
+	Trades(symbol string, args gemini.Args) ([]gemini.Trade, error)
+	CurrentAuction(symbol string) (gemini.CurrentAuction, error)
+	AuctionHistory(symbol string, args gemini.Args) ([]gemini.Auction, error)
+
+	// authenticated
+	AccountDetail() (gemini.AccountDetail, error)
+	Balances() ([]gemini.FundBalance, error)
+	Transfers(args gemini.Args) ([]gemini.Transfer, error)
+	TradeVolume() ([][]gemini.TradeVolume, error)
+	DepositAddresses(currency string) ([]gemini.DepositAddresses, error)
+	NewDepositAddress(currency, label string) (gemini.NewDepositAddress, error)
+	ActiveOrders() ([]gemini.Order, error)
+	PastTrades(symbol string, args gemini.Args) ([]gemini.PastTrade, error)
+	OrderStatus(orderId string) (gemini.Order, error)
+	OrderStatusTrades(orderId string) (order_trades, error)
+	NewOrderType(o orderRequest) (gemini.Order, error)
+	CancelOrder(orderId string) (gemini.Order, error)
+	CancelAll() (gemini.CancelResult, error)
+	CancelSession() (gemini.CancelResult, error)
+	Heartbeat() (gemini.GenericResponse, error)
+	WithdrawFunds(currency, address string, amount decimal) (gemini.WithdrawFundsResult, error)
+
+	// SignRequest signs a private request without sending it, for --dry-run
+	SignRequest(uri string, params map[string]interface{}) (signed_request, error)
+	// SignHeader signs a private request, for the order events WebSocket
+	SignHeader(uri string, params map[string]interface{}) (http.Header, error)
+}
+
+// start_client builds the client used by the commands, tests replace it
+var start_client = func(p *gemini_profile) (gemini_client, error) {
+	api, err := start_api(p)
+	if err != nil {
+		return nil, err
+	}
+	return api, nil
+}
+
+// session holds what is shared by all the commands of one invocation,
+// the profile and the client are loaded the first time a command asks
+// for them so that --help and input errors do not need credentials
+type session struct {
+	source profile_source
+
+	profileOnce sync.Once
+	profile     *gemini_profile
+	profileErr  error
+
+	clientOnce sync.Once
+	client     gemini_client
+	clientErr  error
+}
+
+func new_session(source profile_source) *session {
+	return &session{source: source}
+}
+
+func (s *session) Profile() (*gemini_profile, error) {
+	s.profileOnce.Do(func() {
+		s.profile, s.profileErr = load_profile(s.source)
+	})
+	return s.profile, s.profileErr
+}
+
+func (s *session) Client() (gemini_client, error) {
+	s.clientOnce.Do(func() {
+		var p *gemini_profile
+		if p, s.clientErr = s.Profile(); s.clientErr == nil {
+			s.client, s.clientErr = start_client(p)
+		}
+	})
+	return s.client, s.clientErr
+}
+
+func get_session(c *cli.Context) (*session, error) {
+	s, ok := c.App.Metadata["session"].(*session)
+	if !ok {
+		return nil, fmt.Errorf("Error gemini_cli session not initialized")
+	}
+	return s, nil
+}
+
+// get_client returns the client of the session created in the app Before hook
+func get_client(c *cli.Context) (gemini_client, error) {
+	s, err := get_session(c)
+	if err != nil {
+		return nil, err
+	}
+	return s.Client()
+}
+
+// get_ticker_flag returns --ticker or the default_symbol of the profile
+func get_ticker_flag(c *cli.Context) (string, error) {
+	if ticker := c.String("ticker"); ticker != "" {
+		return ticker, nil
+	}
+	return default_symbol(c)
+}
+
+// get_tickers_flag returns the symbols of a --ticker flag that can be repeated
+func get_tickers_flag(c *cli.Context) ([]string, error) {
+	if tickers := c.StringSlice("ticker"); len(tickers) > 0 {
+		return tickers, nil
+	}
+	ticker, err := default_symbol(c)
+	if err != nil {
+		return nil, err
+	}
+	return []string{ticker}, nil
+}
+
+func default_symbol(c *cli.Context) (string, error) {
+	s, err := get_session(c)
+	if err != nil {
+		return "", err
+	}
+	p, err := s.Profile()
+	if err != nil {
+		return "", err
+	}
+	if p.Default_symbol == "" {
+		return "", fmt.Errorf("Error --ticker is required, or set default_symbol in the profile")
+	}
+	return p.Default_symbol, nil
+}
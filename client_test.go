@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/claudiocandio/gemini-api"
+)
+
+// fake_client is a gemini_client returning canned data, calls records
+// the methods called with their arguments
+type fake_client struct {
+	calls []string
+
+	orders    []gemini.Order
+	balances  []gemini.FundBalance
+	book      gemini.Book
+	candles   []candle
+	details   map[string]symbol_details
+	newOrders []orderRequest
+	err       error
+}
+
+func (f *fake_client) call(name string) error {
+	f.calls = append(f.calls, name)
+	return f.err
+}
+
+func (f *fake_client) Symbols() ([]string, error) {
+	return []string{"btcusd", "ethusd"}, f.call("Symbols")
+}
+func (f *fake_client) TickerV2(symbol string) (gemini.TickerV2, error) {
+	return gemini.TickerV2{Symbol: symbol, Bid: 100, Ask: 101}, f.call("TickerV2 " + symbol)
+}
+func (f *fake_client) SymbolDetails(symbol string) (symbol_details, error) {
+	if d, ok := f.details[symbol]; ok {
+		return d, f.call("SymbolDetails " + symbol)
+	}
+	return symbol_details{Symbol: strings.ToUpper(symbol), Status: "open"}, f.call("SymbolDetails " + symbol)
+}
+func (f *fake_client) Candles(symbol, time_frame string) ([]candle, error) {
+	return f.candles, f.call("Candles " + symbol + " " + time_frame)
+}
+func (f *fake_client) OrderBook(symbol string, args gemini.Args) (gemini.Book, error) {
+	return f.book, f.call("OrderBook " + symbol)
+}
+func (f *fake_client) Trades(symbol string, args gemini.Args) ([]gemini.Trade, error) {
+	return nil, f.call("Trades " + symbol)
+}
+func (f *fake_client) CurrentAuction(symbol string) (gemini.CurrentAuction, error) {
+	return gemini.CurrentAuction{}, f.call("CurrentAuction " + symbol)
+}
+func (f *fake_client) AuctionHistory(symbol string, args gemini.Args) ([]gemini.Auction, error) {
+	return nil, f.call("AuctionHistory " + symbol)
+}
+func (f *fake_client) AccountDetail() (gemini.AccountDetail, error) {
+	return gemini.AccountDetail{}, f.call("AccountDetail")
+}
+func (f *fake_client) Balances() ([]gemini.FundBalance, error) {
+	return f.balances, f.call("Balances")
+}
+func (f *fake_client) Transfers(args gemini.Args) ([]gemini.Transfer, error) {
+	return nil, f.call("Transfers")
+}
+func (f *fake_client) TradeVolume() ([][]gemini.TradeVolume, error) {
+	return nil, f.call("TradeVolume")
+}
+func (f *fake_client) DepositAddresses(currency string) ([]gemini.DepositAddresses, error) {
+	return nil, f.call("DepositAddresses " + currency)
+}
+func (f *fake_client) NewDepositAddress(currency, label string) (gemini.NewDepositAddress, error) {
+	return gemini.NewDepositAddress{Label: label}, f.call("NewDepositAddress " + currency)
+}
+func (f *fake_client) ActiveOrders() ([]gemini.Order, error) {
+	return f.orders, f.call("ActiveOrders")
+}
+func (f *fake_client) PastTrades(symbol string, args gemini.Args) ([]gemini.PastTrade, error) {
+	return nil, f.call("PastTrades " + symbol)
+}
+func (f *fake_client) OrderStatus(orderId string) (gemini.Order, error) {
+	return gemini.Order{OrderId: orderId}, f.call("OrderStatus " + orderId)
+}
+func (f *fake_client) OrderStatusTrades(orderId string) (order_trades, error) {
+	return order_trades{Order: gemini.Order{OrderId: orderId}, Trades: []gemini.PastTrade{}}, f.call("OrderStatusTrades " + orderId)
+}
+func (f *fake_client) NewOrderType(o orderRequest) (gemini.Order, error) {
+	f.newOrders = append(f.newOrders, o)
+	return gemini.Order{OrderId: "1", Symbol: o.Symbol, Side: o.Side, Type: o.Type, Options: o.Options}, f.call("NewOrderType " + o.Symbol)
+}
+func (f *fake_client) CancelOrder(orderId string) (gemini.Order, error) {
+	return gemini.Order{OrderId: orderId, IsCancelled: true}, f.call("CancelOrder " + orderId)
+}
+func (f *fake_client) CancelAll() (gemini.CancelResult, error) {
+	return gemini.CancelResult{Result: "ok"}, f.call("CancelAll")
+}
+func (f *fake_client) CancelSession() (gemini.CancelResult, error) {
+	return gemini.CancelResult{Result: "ok"}, f.call("CancelSession")
+}
+func (f *fake_client) Heartbeat() (gemini.GenericResponse, error) {
+	return gemini.GenericResponse{Result: "ok"}, f.call("Heartbeat")
+}
+func (f *fake_client) SignRequest(uri string, params map[string]interface{}) (signed_request, error) {
+	return signed_request{Url: uri}, f.call("SignRequest " + uri)
+}
+func (f *fake_client) SignHeader(uri string, params map[string]interface{}) (http.Header, error) {
+	return http.Header{}, f.call("SignHeader " + uri)
+}
+func (f *fake_client) WithdrawFunds(currency, address string, amount decimal) (gemini.WithdrawFundsResult, error) {
+	return gemini.WithdrawFundsResult{Address: address}, f.call("WithdrawFunds " + currency)
+}
+
+// run_app runs gemini_cli with the fake client and returns what it printed,
+// with a nil fake it uses the real client e.g. against a fake_gemini server
+func run_app(t *testing.T, fake gemini_client, args ...string) (string, error) {
+	t.Helper()
+
+	saved_start_client, saved_stdlog, saved_input := start_client, stdlog, confirm_input
+	defer func() { start_client, stdlog, confirm_input = saved_start_client, saved_stdlog, saved_input }()
+
+	if fake != nil {
+		start_client = func(p *gemini_profile) (gemini_client, error) {
+			return fake, nil
+		}
+	}
+	var out bytes.Buffer
+	stdlog = log.New(&out, "", 0)
+	// the orders placed by the tests are journaled in the test directory
+	if os.Getenv("GEMINI_JOURNAL") == "" {
+		setenv(t, "GEMINI_JOURNAL", filepath.Join(t.TempDir(), "journal.jsonl"))
+	}
+	if os.Getenv("GEMINI_STATE_DIR") == "" {
+		setenv(t, "GEMINI_STATE_DIR", filepath.Join(t.TempDir(), "state"))
+	}
+	// nobody types yes unless the test sets confirm_input
+	if confirm_input == os.Stdin {
+		confirm_input = strings.NewReader("")
+	}
+
+	err := new_app().Run(append([]string{"gemini_cli"}, args...))
+	return out.String(), err
+}
+
+func TestSessionBuildsClientOnce(t *testing.T) {
+	saved := start_client
+	defer func() { start_client = saved }()
+
+	built := 0
+	start_client = func(p *gemini_profile) (gemini_client, error) {
+		built++
+		return &fake_client{}, nil
+	}
+
+	s := new_session(source("", ""))
+	for i := 0; i < 3; i++ {
+		if _, err := s.Client(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if built != 1 {
+		t.Errorf("client built %d times, want 1", built)
+	}
+}
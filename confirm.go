@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v2"
+)
+
+// where the confirmation is asked, tests replace them
+var (
+	confirm_input  io.Reader = os.Stdin
+	confirm_output io.Writer = os.Stderr
+)
+
+// quote currencies of the Gemini symbols, longest first
+var quoteCurrencies = []string{"gusd", "usdt", "usdc", "dai", "usd", "eur", "gbp", "sgd", "btc", "eth"}
+
+type summary_field struct {
+	Name  string
+	Value string
+}
+
+// quote_currency returns the currency the price of symbol is in, e.g. USD for btcusd
+func quote_currency(symbol string) string {
+	symbol = strings.ToLower(symbol)
+	for _, q := range quoteCurrencies {
+		if strings.HasSuffix(symbol, q) && len(symbol) > len(q) {
+			return strings.ToUpper(q)
+		}
+	}
+	return ""
+}
+
+// environment_summary describes where the request goes: Gemini site and account
+func environment_summary(c *cli.Context) []summary_field {
+	s, err := get_session(c)
+	if err != nil {
+		return nil
+	}
+	p, err := s.Profile()
+	if err != nil {
+		return nil
+	}
+
+	environment := "sandbox"
+	if p.Gemini_api_url != "" {
+		environment = p.Gemini_api_url
+	} else if production, _ := strconv.ParseBool(p.Gemini_api_production); production {
+		environment = "PRODUCTION (real money)"
+	}
+	fields := []summary_field{{"environment", environment}}
+	if p.name != "" {
+		fields = append(fields, summary_field{"profile", p.name})
+	}
+	account := p.Account
+	if account == "" {
+		account = "primary"
+	}
+	return append(fields, summary_field{"account", account})
+}
+
+func order_summary(c *cli.Context, o orderRequest) []summary_field {
+	quote := quote_currency(o.Symbol)
+	fields := append(environment_summary(c),
+		summary_field{"symbol", o.Symbol},
+		summary_field{"side", o.Side},
+		summary_field{"type", o.Type},
+		summary_field{"amount", o.Amount.String()},
+		summary_field{"price", strings.TrimSpace(o.Price.String() + " " + quote)},
+	)
+	if o.Type == orderTypeStopLimit {
+		fields = append(fields, summary_field{"stop price", strings.TrimSpace(o.StopPrice.String() + " " + quote)})
+	}
+	if len(o.Options) > 0 {
+		fields = append(fields, summary_field{"options", strings.Join(o.Options, ", ")})
+	}
+	if o.ClientOrderId != "" {
+		fields = append(fields, summary_field{"client order id", o.ClientOrderId})
+	}
+	return append(fields, summary_field{"notional", strings.TrimSpace(o.Amount.mul(o.Price).String() + " " + quote)})
+}
+
+func withdraw_summary(c *cli.Context, currency, address string, amount decimal) []summary_field {
+	return append(environment_summary(c),
+		summary_field{"currency", currency},
+		summary_field{"amount", amount.String() + " " + strings.ToUpper(currency)},
+		summary_field{"destination", address},
+	)
+}
+
+// confirm shows what is going to be done and asks to type yes, --yes skips it
+func confirm(c *cli.Context, action string, summary []summary_field) error {
+	if c.Bool("yes") {
+		return nil
+	}
+
+	w := tabwriter.NewWriter(confirm_output, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "%s\n", action)
+	for _, f := range summary {
+		fmt.Fprintf(w, "  %s:\t%s\n", f.Name, f.Value)
+	}
+	w.Flush()
+	fmt.Fprint(confirm_output, "Type yes to confirm: ")
+
+	answer, err := bufio.NewReader(confirm_input).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if strings.TrimSpace(answer) != "yes" {
+		return fmt.Errorf("Aborted, nothing was sent to Gemini")
+	}
+	return nil
+}
+
+// dry_run prints the signed private request instead of sending it
+func dry_run(c *cli.Context, api gemini_client, uri string, params map[string]interface{}) error {
+	req, err := api.SignRequest(uri, params)
+	if err != nil {
+		return err
+	}
+	return print_output(c, req)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestConfirmOrder(t *testing.T) {
+	server := use_fake_gemini(t)
+	saved_output := confirm_output
+	defer func() { confirm_input, confirm_output = os.Stdin, saved_output }()
+
+	var prompt bytes.Buffer
+	confirm_output = &prompt
+
+	args := []string{"order", "new", "-t", "btcusd", "-s", "buy", "-a", "0.5", "-p", "30000"}
+
+	confirm_input = strings.NewReader("no\n")
+	if _, err := run_app(t, nil, args...); err == nil || !strings.Contains(err.Error(), "Aborted") {
+		t.Errorf("err = %v, want Aborted", err)
+	}
+	if p := server.last_payload(); p != nil {
+		t.Errorf("order sent after no: %v", p)
+	}
+	for _, want := range []string{"environment:", server.URL, "account:", "primary", "symbol:", "btcusd",
+		"side:", "buy", "amount:", "0.5", "price:", "30000 USD", "notional:", "15000 USD", "Type yes to confirm"} {
+		if !strings.Contains(prompt.String(), want) {
+			t.Errorf("summary does not contain %q:\n%s", want, prompt.String())
+		}
+	}
+
+	confirm_input = strings.NewReader("yes\n")
+	if _, err := run_app(t, nil, args...); err != nil {
+		t.Fatal(err)
+	}
+	if p := server.last_payload(); p == nil || p["request"] != new_order_URI {
+		t.Errorf("order not sent after yes: %v", p)
+	}
+}
+
+func TestConfirmWithdraw(t *testing.T) {
+	server := use_fake_gemini(t)
+	saved_output := confirm_output
+	defer func() { confirm_output = saved_output }()
+
+	var prompt bytes.Buffer
+	confirm_output = &prompt
+
+	address := "mpXEeWuc7tSB4BVEoYpStmj4MFrmrLiRKL"
+	if _, err := run_app(t, nil, "withdraw", "-c", "btc", "-a", address, "--amount", "0.25"); err == nil {
+		t.Error("withdraw without confirmation")
+	}
+	if p := server.last_payload(); p != nil {
+		t.Errorf("withdraw sent: %v", p)
+	}
+	if !strings.Contains(prompt.String(), address) || !strings.Contains(prompt.String(), "0.25 BTC") {
+		t.Errorf("summary:\n%s", prompt.String())
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	server := use_fake_gemini(t)
+
+	out, err := run_app(t, nil, "--dry-run", "order", "new", "-t", "btcusd", "-s", "buy", "-a", "0.5", "-p", "100",
+		"--option", "maker-or-cancel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// only the public symbol details are read
+	if len(server.payloads) != 0 {
+		t.Errorf("dry run sent private requests %v", server.paths)
+	}
+
+	var req struct {
+		Url       string                 `json:"url"`
+		Payload   map[string]interface{} `json:"payload"`
+		Signature string                 `json:"x_gemini_signature"`
+		Sent      bool                   `json:"sent"`
+	}
+	if err := json.Unmarshal([]byte(out), &req); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	if req.Url != server.URL+new_order_URI || req.Payload["amount"] != "0.5" || req.Payload["request"] != new_order_URI ||
+		req.Signature == "" || req.Sent {
+		t.Errorf("unexpected dry run output %s", out)
+	}
+
+	// validation still applies
+	if _, err := run_app(t, nil, "--dry-run", "order", "new", "-t", "btcusd", "-s", "hold", "-a", "0.5", "-p", "100"); err == nil {
+		t.Error("dry run of an invalid order")
+	}
+}
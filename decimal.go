@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+// decimals supported by the currencies that can be withdrawn
+var currencyDecimals = map[string]int{
+	"btc": 8,
+	"eth": 18,
+}
+
+// the decimals a decimal keeps when a division does not terminate
+const maxDecimals = 30
+
+// what parse_decimal accepts: no exponent, no thousands separator
+var decimalPattern = regexp.MustCompile(`^[+-]?([0-9]+\.?[0-9]*|\.[0-9]+)$`)
+
+// decimal is an exact decimal number, the amounts and prices are carried
+// as decimals from the flags to the request payloads so that 0.1 stays 0.1
+// and is never sent in exponent form. The zero value is 0
+type decimal struct {
+	r *big.Rat
+}
+
+// parse_decimal parses a decimal number such as 0.00012345 or 30000.5
+func parse_decimal(s string) (decimal, error) {
+	s = strings.TrimSpace(s)
+	if !decimalPattern.MatchString(s) {
+		return decimal{}, fmt.Errorf("Error invalid decimal number: %q", s)
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return decimal{}, fmt.Errorf("Error invalid decimal number: %q", s)
+	}
+	return decimal{r}, nil
+}
+
+// decimal_from_float converts the float64 values of the gemini-api types,
+// through their shortest representation so that 0.1 becomes exactly 0.1
+func decimal_from_float(f float64) decimal {
+	r, _ := new(big.Rat).SetString(strconv.FormatFloat(f, 'f', -1, 64))
+	return decimal{r}
+}
+
+// decimal_int is n as a decimal
+func decimal_int(n int64) decimal {
+	return decimal{new(big.Rat).SetInt64(n)}
+}
+
+// decimal_flag returns the decimal value of flag name, 0 if not set
+func decimal_flag(c *cli.Context, name string) (decimal, error) {
+	if c.String(name) == "" {
+		return decimal{}, nil
+	}
+	d, err := parse_decimal(c.String(name))
+	if err != nil {
+		return decimal{}, fmt.Errorf("Error invalid --%s: %s, it must be a decimal number e.g. 0.0125", name, c.String(name))
+	}
+	return d, nil
+}
+
+func (d decimal) rat() *big.Rat {
+	if d.r == nil {
+		return new(big.Rat)
+	}
+	return d.r
+}
+
+func (d decimal) add(e decimal) decimal {
+	return decimal{new(big.Rat).Add(d.rat(), e.rat())}
+}
+
+func (d decimal) sub(e decimal) decimal {
+	return decimal{new(big.Rat).Sub(d.rat(), e.rat())}
+}
+
+func (d decimal) mul(e decimal) decimal {
+	return decimal{new(big.Rat).Mul(d.rat(), e.rat())}
+}
+
+// quo divides d by e, e must not be 0
+func (d decimal) quo(e decimal) decimal {
+	return decimal{new(big.Rat).Quo(d.rat(), e.rat())}
+}
+
+func (d decimal) cmp(e decimal) int {
+	return d.rat().Cmp(e.rat())
+}
+
+func (d decimal) sign() int {
+	return d.rat().Sign()
+}
+
+func (d decimal) is_zero() bool {
+	return d.sign() == 0
+}
+
+// decimals is the number of decimals of d, e.g. 2 for 0.01 and 0 for 100
+func (d decimal) decimals() int {
+	x := new(big.Rat).Set(d.rat())
+	ten := big.NewRat(10, 1)
+	for n := 0; n < maxDecimals; n++ {
+		if x.IsInt() {
+			return n
+		}
+		x.Mul(x, ten)
+	}
+	return maxDecimals
+}
+
+// is_multiple tells if d is a multiple of increment
+func (d decimal) is_multiple(increment decimal) bool {
+	return d.quo(increment).rat().IsInt()
+}
+
+// round_down and round_up round d to a multiple of increment, towards
+// minus and plus infinity
+func (d decimal) round_down(increment decimal) decimal {
+	q := d.quo(increment).rat()
+	n := new(big.Int).Div(q.Num(), q.Denom())
+	return decimal{new(big.Rat).SetInt(n)}.mul(increment)
+}
+
+func (d decimal) round_up(increment decimal) decimal {
+	rounded := d.round_down(increment)
+	if rounded.cmp(d) < 0 {
+		rounded = rounded.add(increment)
+	}
+	return rounded
+}
+
+// round rounds d to the nearest multiple of increment, halves up
+func (d decimal) round(increment decimal) decimal {
+	down := d.round_down(increment)
+	if d.sub(down).mul(decimal_int(2)).cmp(increment) >= 0 {
+		return down.add(increment)
+	}
+	return down
+}
+
+func (d decimal) float64() float64 {
+	f, _ := d.rat().Float64()
+	return f
+}
+
+// String formats d without exponent and without trailing zeros
+func (d decimal) String() string {
+	s := d.rat().FloatString(d.decimals())
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	return s
+}
+
+// check_decimals returns an error if d has more than decimals decimals
+func (d decimal) check_decimals(name string, decimals int, unit string) error {
+	if d.decimals() > decimals {
+		return fmt.Errorf("Error %s %s has more precision than %s supports, at most %d decimals",
+			name, d, unit, decimals)
+	}
+	return nil
+}
+
+// MarshalJSON writes a decimal as a json string, like Gemini does
+func (d decimal) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON reads a decimal from a json string or number
+func (d *decimal) UnmarshalJSON(data []byte) error {
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return fmt.Errorf("Error invalid decimal number: %s", data)
+	}
+	r, ok := new(big.Rat).SetString(n.String())
+	if !ok {
+		return fmt.Errorf("Error invalid decimal number: %s", data)
+	}
+	d.r = r
+	return nil
+}
+
+// UnmarshalYAML reads a decimal from the configuration file
+func (d *decimal) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	v, err := parse_decimal(s)
+	if err != nil {
+		return err
+	}
+	*d = v
+	return nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// dec is a decimal literal of the tests
+func dec(s string) decimal {
+	d, err := parse_decimal(s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func TestDecimal(t *testing.T) {
+	for _, s := range []string{"1e-8", "0x10", "1,000.5", "", "abc", "NaN", "Inf", "1.2.3"} {
+		if _, err := parse_decimal(s); err == nil {
+			t.Errorf("parse_decimal(%q) accepted", s)
+		}
+	}
+
+	tests := []struct {
+		got, want string
+	}{
+		{dec("0.1").add(dec("0.2")).String(), "0.3"},
+		{dec("0.00000001").String(), "0.00000001"},
+		{dec("100.50").String(), "100.5"},
+		{dec(".5").String(), "0.5"},
+		{dec("12345678.12345678").mul(dec("3")).String(), "37037034.37037034"},
+		{dec("1").sub(dec("0.000000001")).String(), "0.999999999"},
+		{dec("30000.005").round_down(dec("0.01")).String(), "30000"},
+		{dec("30000.005").round_up(dec("0.01")).String(), "30000.01"},
+		{dec("30000.005").round(dec("0.01")).String(), "30000.01"},
+		{dec("30000.004").round(dec("0.01")).String(), "30000"},
+		{dec("7.3").round_down(dec("0.25")).String(), "7.25"},
+		{dec("1").quo(dec("3")).mul(dec("3")).String(), "1"},
+		{decimal_from_float(0.1).String(), "0.1"},
+		{decimal{}.String(), "0"},
+	}
+	for _, test := range tests {
+		if test.got != test.want {
+			t.Errorf("got %s, want %s", test.got, test.want)
+		}
+	}
+
+	if !dec("0.3").is_multiple(dec("0.1")) || dec("0.35").is_multiple(dec("0.1")) {
+		t.Error("is_multiple")
+	}
+	if err := dec("0.123456789").check_decimals("amount", 8, "BTC"); err == nil ||
+		!strings.Contains(err.Error(), "more precision than BTC supports") {
+		t.Errorf("err = %v", err)
+	}
+
+	var v struct {
+		A decimal `json:"a"`
+		B decimal `json:"b"`
+	}
+	if err := json.Unmarshal([]byte(`{"a":1E-8,"b":"0.10"}`), &v); err != nil {
+		t.Fatal(err)
+	}
+	if j, _ := json.Marshal(v); string(j) != `{"a":"0.00000001","b":"0.1"}` {
+		t.Errorf("json %s", j)
+	}
+}
+
+func TestWithdrawPrecision(t *testing.T) {
+	server := use_fake_gemini(t)
+	address := "mpXEeWuc7tSB4BVEoYpStmj4MFrmrLiRKL"
+
+	_, err := run_app(t, nil, "-y", "withdraw", "-c", "btc", "-a", address, "--amount", "0.123456789")
+	if err == nil || !strings.Contains(err.Error(), "more precision than BTC supports") {
+		t.Errorf("err = %v", err)
+	}
+	if _, err := run_app(t, nil, "-y", "withdraw", "-c", "btc", "-a", address, "--amount", "1e-3"); err == nil {
+		t.Error("exponent accepted")
+	}
+	if p := server.last_payload(); p != nil {
+		t.Errorf("withdrawal sent: %v", p)
+	}
+
+	if _, err := run_app(t, nil, "-y", "withdraw", "-c", "eth", "-a", address, "--amount", "0.100000000000000001"); err != nil {
+		t.Fatal(err)
+	}
+	if p := server.last_payload(); p["amount"] != "0.100000000000000001" {
+		t.Errorf("amount sent %v", p["amount"])
+	}
+}
@@ -0,0 +1,663 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/claudiocandio/gemini-api"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	fake_key    = "account-fakekey"
+	fake_secret = "fakesecret"
+)
+
+// fake_gemini is a local Gemini REST server, private requests must be
+// signed with fake_key/fake_secret and use increasing nonces
+type fake_gemini struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	lastNonce int64
+	// payloads of the private requests received, in order
+	payloads []map[string]interface{}
+	// paths of all the requests received, in order
+	paths []string
+
+	orders      []*gemini.Order
+	nextOrderId int
+	balances    []gemini.FundBalance
+	book        gemini.Book
+	ticker      gemini.TickerV2
+	// bids of the next /v2/ticker requests, the last one stays
+	tickerBids []float64
+	trades     []gemini.Trade
+	pastTrades []gemini.PastTrade
+	// raw /v2/candles json of the time frames other than btcusd 1day
+	candles   string
+	transfers []gemini.Transfer
+	// the next rateLimited new orders are refused with 429
+	rateLimited int
+	// new orders are refused with this reason while set
+	orderRefused string
+	// the next dropReplies new orders are placed but their connection is
+	// closed before the reply, as if the request timed out
+	dropReplies int
+	// new orders are refused with InsufficientFunds when the balance does
+	// not cover them and the live orders, as Gemini holds their funds
+	holdBalances bool
+	// amount executed just before the next cancel, as if the order filled
+	// while it was being cancelled
+	fillOnCancel float64
+	// called with the order of every /v1/order/status request before the
+	// reply, e.g. to fill it as the market would
+	onStatus func(o *gemini.Order)
+	// /v1/heartbeat fails while set
+	heartbeatDown bool
+	// raw /v1/symbols/details json by symbol
+	details map[string]string
+	// writes the messages of the nth connection to /v2/marketdata, the
+	// connection is closed when it returns
+	marketdata            func(conn *websocket.Conn, n int)
+	marketdataConnections int
+
+	// connections to the order events WebSocket
+	wmu            sync.Mutex
+	watchers       []*websocket.Conn
+	socketSequence int64
+}
+
+func new_fake_gemini(t *testing.T) *fake_gemini {
+	f := &fake_gemini{
+		nextOrderId: 1000,
+		candles:     "[]",
+		balances: []gemini.FundBalance{
+			{Currency: "BTC", Amount: 1.5, Available: 1.5, AvailableForWithdrawal: 1.5, Type: "exchange"},
+			{Currency: "USD", Amount: 10000, Available: 10000, AvailableForWithdrawal: 10000, Type: "exchange"},
+		},
+		book: gemini.Book{
+			Bids: gemini.BookEntries{{Price: 99, Amount: 1}, {Price: 98, Amount: 2}},
+			Asks: gemini.BookEntries{{Price: 101, Amount: 1}, {Price: 102, Amount: 2}},
+		},
+		ticker: gemini.TickerV2{Symbol: "BTCUSD", Open: 95, High: 105, Low: 90, Close: 100,
+			Changes: []string{"100", "99"}, Bid: 99, Ask: 101},
+		trades: []gemini.Trade{
+			{Timestamp: 1612381252, Timestampms: 1612381252885, TradeId: 1, Price: 100, Amount: 0.5, Exchange: "gemini", Type: "buy"},
+		},
+		pastTrades: []gemini.PastTrade{
+			{Price: 100, Amount: 0.5, Timestamp: 1612381252, Timestampms: 1612381252885, Type: "Buy", FeeCurrency: "USD",
+				FeeAmount: 0.1, TradeId: 1, OrderId: "999", Exchange: "gemini"},
+		},
+		details: map[string]string{
+			"btcusd": `{"symbol":"BTCUSD","base_currency":"BTC","quote_currency":"USD","tick_size":1E-8,"quote_increment":0.01,"min_order_size":"0.00001","status":"open","wrap_enabled":false}`,
+			"ethusd": `{"symbol":"ETHUSD","base_currency":"ETH","quote_currency":"USD","tick_size":1E-6,"quote_increment":0.01,"min_order_size":"0.001","status":"open","wrap_enabled":false}`,
+			"ltcusd": `{"symbol":"LTCUSD","base_currency":"LTC","quote_currency":"USD","tick_size":1E-5,"quote_increment":0.01,"min_order_size":"0.01","status":"open","wrap_enabled":false}`,
+			"zecusd": `{"symbol":"ZECUSD","base_currency":"ZEC","quote_currency":"USD","tick_size":1E-6,"quote_increment":0.01,"min_order_size":"0.001","status":"cancel_only","wrap_enabled":false}`,
+		},
+		transfers: []gemini.Transfer{
+			{Type: "Deposit", Status: "Complete", Timestampms: 1612381252885, Eid: 1, Currency: "BTC", Amount: 1.5},
+		},
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.handle))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fake_gemini) error(w http.ResponseWriter, status int, reason, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"result": "error", "reason": reason, "message": message})
+}
+
+func (f *fake_gemini) reply(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// authenticate checks the key, the payload signature, the request path
+// and the nonce of a private request and returns its payload
+func (f *fake_gemini) authenticate(r *http.Request) (map[string]interface{}, string) {
+	if r.Method != "POST" && r.URL.Path != order_events_URI {
+		return nil, "private API requests must be POST"
+	}
+	if r.Header.Get("X-GEMINI-APIKEY") != fake_key {
+		return nil, "invalid API key"
+	}
+	payload := r.Header.Get("X-GEMINI-PAYLOAD")
+	mac := hmac.New(sha512.New384, []byte(fake_secret))
+	mac.Write([]byte(payload))
+	if !hmac.Equal([]byte(hex.EncodeToString(mac.Sum(nil))), []byte(r.Header.Get("X-GEMINI-SIGNATURE"))) {
+		return nil, "InvalidSignature"
+	}
+
+	j, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, "payload is not base64"
+	}
+	var params map[string]interface{}
+	dec := json.NewDecoder(strings.NewReader(string(j)))
+	dec.UseNumber()
+	if err := dec.Decode(&params); err != nil {
+		return nil, "payload is not json"
+	}
+	if params["request"] != r.URL.Path {
+		return nil, fmt.Sprintf("payload request %v does not match %s", params["request"], r.URL.Path)
+	}
+	n, _ := params["nonce"].(json.Number)
+	nonce, err := n.Int64()
+	if err != nil || nonce <= f.lastNonce {
+		return nil, "InvalidNonce"
+	}
+	f.lastNonce = nonce
+	return params, ""
+}
+
+func (f *fake_gemini) handle(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == order_events_URI {
+		f.order_events(w, r)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, marketdata_v2_URI) && f.marketdata != nil {
+		f.market_data(w, r)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path := r.URL.Path
+	f.paths = append(f.paths, path)
+
+	// public API
+	switch {
+	case path == "/v1/symbols":
+		f.reply(w, []string{"btcusd", "ethusd", "ethbtc"})
+		return
+	case strings.HasPrefix(path, "/v1/symbols/details/"):
+		d, ok := f.details[strings.TrimPrefix(path, "/v1/symbols/details/")]
+		if !ok {
+			f.error(w, 400, "InvalidSymbol", "Supplied value '"+strings.TrimPrefix(path, "/v1/symbols/details/")+"' is not a valid symbol")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, d)
+		return
+	case strings.HasPrefix(path, "/v2/ticker/"):
+		// the ask moves with the bid
+		if len(f.tickerBids) > 0 {
+			f.ticker.Ask += f.tickerBids[0] - f.ticker.Bid
+			f.ticker.Bid, f.tickerBids = f.tickerBids[0], f.tickerBids[1:]
+		}
+		f.reply(w, f.ticker)
+		return
+	case path == "/v2/candles/btcusd/1day":
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `[[1612396800000,101,103,100,102,12.5],[1612310400000,100,102,99,101,10]]`)
+		return
+	case strings.HasPrefix(path, "/v2/candles/"):
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, f.candles)
+		return
+	case strings.HasPrefix(path, "/v1/book/"):
+		f.reply(w, f.book)
+		return
+	case strings.HasPrefix(path, "/v1/trades/"):
+		f.reply(w, f.trades)
+		return
+	case strings.HasPrefix(path, "/v1/auction/") && strings.HasSuffix(path, "/history"):
+		f.reply(w, []gemini.Auction{{Timestampms: 1612381252885, AuctionId: 1, Eid: 2, EventType: "auction",
+			AuctionResult: "success", AuctionPrice: 100, AuctionQuantity: 1}})
+		return
+	case strings.HasPrefix(path, "/v1/auction/"):
+		f.reply(w, gemini.CurrentAuction{LastAuctionPrice: 100, NextAuction: 1612381252885})
+		return
+	}
+
+	params, authErr := f.authenticate(r)
+	if authErr == "InvalidNonce" {
+		f.error(w, 400, "InvalidNonce", "Nonce has not increased since your last call to the Gemini API")
+		return
+	}
+	if authErr != "" {
+		f.error(w, 400, "InvalidSignature", authErr)
+		return
+	}
+	f.payloads = append(f.payloads, params)
+
+	switch {
+	case path == "/v1/account":
+		f.reply(w, gemini.AccountDetail{
+			Account: gemini.Account{AccountName: "Primary", ShortName: "primary", Type: "exchange", Created: 1612381252885},
+			Users:   []gemini.Users{{Name: "Fake User", Status: "Active", CountryCode: "IT", IsVerified: true}},
+		})
+	case path == "/v1/balances":
+		f.reply(w, f.balances)
+	case path == "/v1/transfers":
+		f.reply(w, f.transfers_page(params))
+	case path == "/v1/tradevolume":
+		f.reply(w, [][]gemini.TradeVolume{{{Symbol: "btcusd", BaseCurrency: "BTC", NotionalCurrency: "USD", TotalVolumeBase: 0.5}}})
+	case strings.HasPrefix(path, "/v1/addresses/"):
+		f.reply(w, []gemini.DepositAddresses{{Address: "n2saq73aDTu42bRgEHd8gd4to1gCzHxrdj", Timestamp: 1612381252885}})
+	case strings.HasPrefix(path, "/v1/deposit/") && strings.HasSuffix(path, "/newAddress"):
+		label, _ := params["label"].(string)
+		f.reply(w, gemini.NewDepositAddress{Request: path, Address: "n2saq73aDTu42bRgEHd8gd4to1gCzHxrdj", Label: label})
+	case strings.HasPrefix(path, "/v1/withdraw/"):
+		amount, _ := strconv.ParseFloat(fmt.Sprint(params["amount"]), 64)
+		f.transfers = append([]gemini.Transfer{{Type: "Withdrawal", Status: "Advanced", Timestampms: time.Now().UnixNano() / 1e6,
+			Eid: int64(len(f.transfers) + 1), Currency: strings.ToUpper(strings.TrimPrefix(path, "/v1/withdraw/")), Amount: amount,
+			Destination: fmt.Sprint(params["address"])}}, f.transfers...)
+		f.reply(w, gemini.WithdrawFundsResult{Address: fmt.Sprint(params["address"]), Amount: fmt.Sprint(params["amount"]),
+			TxHash: "faketxhash"})
+	case path == "/v1/mytrades":
+		f.reply(w, f.pastTrades)
+	case path == "/v1/orders":
+		live := []gemini.Order{}
+		for _, o := range f.orders {
+			if o.IsLive {
+				live = append(live, *o)
+			}
+		}
+		f.reply(w, live)
+	case path == "/v1/order/new" && f.rateLimited > 0:
+		f.rateLimited--
+		f.error(w, 429, "RateLimited", "Requests were made too frequently")
+	case path == "/v1/order/new" && f.orderRefused != "":
+		f.error(w, 400, f.orderRefused, "The order was refused")
+	case path == "/v1/order/new" && f.holdBalances && !f.covered(params):
+		f.error(w, 400, "InsufficientFunds", "The balance does not cover the order")
+	case path == "/v1/order/new":
+		o, err := f.new_order(params)
+		if err != "" {
+			f.error(w, 400, "InvalidOrder", err)
+			return
+		}
+		if o.IsLive {
+			f.emit(order_event_of("accepted", o), order_event_of("booked", o))
+		} else {
+			f.emit(order_event_of("accepted", o), order_event_of("cancelled", o))
+		}
+		if f.dropReplies > 0 {
+			f.dropReplies--
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		f.reply(w, o)
+	case path == "/v1/order/status":
+		o := f.find_order(params)
+		if o == nil {
+			f.error(w, 400, "OrderNotFound", "Order not found")
+			return
+		}
+		if f.onStatus != nil {
+			f.onStatus(o)
+		}
+		if params["include_trades"] != true {
+			f.reply(w, o)
+			return
+		}
+		trades := []gemini.PastTrade{}
+		for _, t := range f.pastTrades {
+			if t.OrderId == o.OrderId {
+				trades = append(trades, t)
+			}
+		}
+		f.reply(w, order_trades{Order: *o, Trades: trades})
+	case path == "/v1/order/cancel":
+		o := f.find_order(params)
+		if o == nil {
+			f.error(w, 400, "OrderNotFound", "Order not found")
+			return
+		}
+		if o.IsLive && f.fillOnCancel > 0 {
+			fill_order(o, f.fillOnCancel)
+			f.fillOnCancel = 0
+		}
+		if o.IsLive {
+			o.IsLive = false
+			o.IsCancelled = true
+			f.emit(order_event_of("cancelled", o))
+		}
+		f.reply(w, o)
+	case path == "/v1/heartbeat":
+		if f.heartbeatDown {
+			f.error(w, 503, "ServiceUnavailable", "The service is temporarily unavailable")
+			return
+		}
+		f.reply(w, gemini.GenericResponse{Result: "ok"})
+	case path == "/v1/order/cancel/session" || path == "/v1/order/cancel/all":
+		// the fake has a single session
+		var cancelled []float64
+		for _, o := range f.orders {
+			if o.IsLive {
+				o.IsLive = false
+				o.IsCancelled = true
+				f.emit(order_event_of("cancelled", o))
+				id, _ := strconv.ParseFloat(o.OrderId, 64)
+				cancelled = append(cancelled, id)
+			}
+		}
+		f.reply(w, gemini.CancelResult{Result: "ok", Details: gemini.CancelResultDetails{CancelledOrders: cancelled, CancelRejects: []float64{}}})
+	default:
+		f.error(w, 404, "EndpointNotFound", "API entry point "+path+" not found")
+	}
+}
+
+func (f *fake_gemini) new_order(params map[string]interface{}) (*gemini.Order, string) {
+	amount, err := strconv.ParseFloat(fmt.Sprint(params["amount"]), 64)
+	if err != nil || amount <= 0 {
+		return nil, "invalid amount"
+	}
+	price, err := strconv.ParseFloat(fmt.Sprint(params["price"]), 64)
+	if err != nil || price <= 0 {
+		return nil, "invalid price"
+	}
+	side := fmt.Sprint(params["side"])
+	if side != "buy" && side != "sell" {
+		return nil, "invalid side"
+	}
+	orderType := fmt.Sprint(params["type"])
+	if orderType != orderTypeLimit && orderType != orderTypeStopLimit {
+		return nil, "invalid type"
+	}
+	var options []string
+	if opts, ok := params["options"].([]interface{}); ok {
+		for _, o := range opts {
+			options = append(options, fmt.Sprint(o))
+		}
+	}
+	clientOrderId, _ := params["client_order_id"].(string)
+
+	f.nextOrderId++
+	now := time.Now()
+	o := &gemini.Order{
+		OrderId:         strconv.Itoa(f.nextOrderId),
+		ClientOrderId:   clientOrderId,
+		Symbol:          fmt.Sprint(params["symbol"]),
+		Exchange:        "gemini",
+		Price:           price,
+		Side:            side,
+		Type:            orderType,
+		Options:         options,
+		Timestampms:     now.UnixNano() / 1e6,
+		IsLive:          true,
+		OriginalAmount:  amount,
+		RemainingAmount: amount,
+	}
+	// immediate orders never rest on the book of the fake server, an
+	// immediate-or-cancel order fills against the levels it crosses
+	if contains(options, optionImmediateOrCancel) || contains(options, optionFillOrKill) {
+		if contains(options, optionImmediateOrCancel) {
+			f.cross_book(o)
+		}
+		o.IsLive = false
+		o.IsCancelled = o.RemainingAmount > 0
+	}
+	// a maker-or-cancel order crossing the book is cancelled without fills
+	if contains(options, optionMakerOrCancel) && f.crosses(o) {
+		o.IsLive = false
+		o.IsCancelled = true
+	}
+	f.orders = append(f.orders, o)
+	return o, ""
+}
+
+// covered tells if the available balance covers a new order and what the
+// live orders hold: the amount of a sell, the notional of a buy
+func (f *fake_gemini) covered(params map[string]interface{}) bool {
+	symbol := strings.ToLower(fmt.Sprint(params["symbol"]))
+	amount, _ := strconv.ParseFloat(fmt.Sprint(params["amount"]), 64)
+	price, _ := strconv.ParseFloat(fmt.Sprint(params["price"]), 64)
+	buy := params["side"] == "buy"
+	currency := strings.ToUpper(strings.TrimSuffix(symbol, strings.ToLower(quote_currency(symbol))))
+	if buy {
+		currency = quote_currency(symbol)
+	}
+	orders := append([]*gemini.Order{{Symbol: symbol, Side: fmt.Sprint(params["side"]), Price: price,
+		RemainingAmount: amount, IsLive: true}}, f.orders...)
+	need := 0.0
+	for _, o := range orders {
+		if !o.IsLive || strings.ToLower(o.Symbol) != symbol || (o.Side == "buy") != buy {
+			continue
+		}
+		if buy {
+			need += o.RemainingAmount * o.Price
+		} else {
+			need += o.RemainingAmount
+		}
+	}
+	for _, b := range f.balances {
+		if b.Currency == currency {
+			return need <= b.Available
+		}
+	}
+	return false
+}
+
+// crosses tells if o would take liquidity from the book
+func (f *fake_gemini) crosses(o *gemini.Order) bool {
+	if o.Side == "buy" {
+		return len(f.book.Asks) > 0 && o.Price >= f.book.Asks[0].Price
+	}
+	return len(f.book.Bids) > 0 && o.Price <= f.book.Bids[0].Price
+}
+
+// cross_book fills o against the levels of the book it crosses, one trade
+// with a 0.1% USD fee per level. The book is left as it is
+func (f *fake_gemini) cross_book(o *gemini.Order) {
+	levels := f.book.Asks
+	if o.Side == "sell" {
+		levels = f.book.Bids
+	}
+	notional := 0.0
+	for _, l := range levels {
+		if o.RemainingAmount <= 0 || (o.Side == "buy" && l.Price > o.Price) || (o.Side == "sell" && l.Price < o.Price) {
+			break
+		}
+		amount := math.Min(l.Amount, o.RemainingAmount)
+		o.ExecutedAmount += amount
+		o.RemainingAmount -= amount
+		notional += amount * l.Price
+		f.pastTrades = append(f.pastTrades, gemini.PastTrade{Price: l.Price, Amount: amount, Timestampms: o.Timestampms,
+			Type: strings.Title(o.Side), FeeCurrency: "USD", FeeAmount: amount * l.Price * 0.001, OrderId: o.OrderId,
+			Exchange: "gemini"})
+	}
+	if o.ExecutedAmount > 0 {
+		o.AvgExecutionPrice = notional / o.ExecutedAmount
+	}
+}
+
+// fill_order executes amount of a live order at its price, at most what remains
+func fill_order(o *gemini.Order, amount float64) {
+	if !o.IsLive {
+		return
+	}
+	if amount > o.RemainingAmount {
+		amount = o.RemainingAmount
+	}
+	o.ExecutedAmount += amount
+	o.RemainingAmount -= amount
+	o.AvgExecutionPrice = o.Price
+	o.IsLive = o.RemainingAmount > 0
+}
+
+func (f *fake_gemini) find_order(params map[string]interface{}) *gemini.Order {
+	id := fmt.Sprint(params["order_id"])
+	for _, o := range f.orders {
+		if o.OrderId == id {
+			return o
+		}
+	}
+	return nil
+}
+
+// order_events is the order events WebSocket, authenticated like the
+// private requests but with a GET
+func (f *fake_gemini) order_events(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.paths = append(f.paths, r.URL.Path)
+	params, authErr := f.authenticate(r)
+	if authErr == "" {
+		f.payloads = append(f.payloads, params)
+	}
+	f.mu.Unlock()
+	if authErr != "" {
+		f.error(w, 400, "InvalidSignature", authErr)
+		return
+	}
+
+	conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	f.wmu.Lock()
+	f.watchers = append(f.watchers, conn)
+	conn.WriteJSON(map[string]interface{}{"type": "subscription_ack", "accountId": 1, "subscriptionId": "ws-order-events-1",
+		"symbolFilter": r.URL.Query()["symbolFilter"], "apiSessionFilter": []string{}, "eventTypeFilter": r.URL.Query()["eventTypeFilter"]})
+	f.wmu.Unlock()
+
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+	f.wmu.Lock()
+	defer f.wmu.Unlock()
+	for i, c := range f.watchers {
+		if c == conn {
+			f.watchers = append(f.watchers[:i], f.watchers[i+1:]...)
+			break
+		}
+	}
+}
+
+func order_event_of(event_type string, o *gemini.Order) order_event {
+	return order_event{Type: event_type, OrderId: o.OrderId, ClientOrderId: o.ClientOrderId, Symbol: o.Symbol,
+		Side: o.Side, OrderType: o.Type, Timestampms: o.Timestampms, IsLive: o.IsLive, IsCancelled: o.IsCancelled,
+		ExecutedAmount: formatFloat(o.ExecutedAmount), RemainingAmount: formatFloat(o.RemainingAmount),
+		OriginalAmount: formatFloat(o.OriginalAmount), Price: formatFloat(o.Price)}
+}
+
+// emit sends the events to the order events WebSocket connections
+func (f *fake_gemini) emit(events ...order_event) {
+	f.wmu.Lock()
+	defer f.wmu.Unlock()
+	for i := range events {
+		events[i].SocketSequence = f.socketSequence
+		f.socketSequence++
+	}
+	for _, c := range f.watchers {
+		c.WriteJSON(events)
+	}
+}
+
+// watching returns the number of order events WebSocket connections
+func (f *fake_gemini) watching() int {
+	f.wmu.Lock()
+	defer f.wmu.Unlock()
+	return len(f.watchers)
+}
+
+// drop_watchers closes the order events WebSocket connections
+func (f *fake_gemini) drop_watchers() {
+	f.wmu.Lock()
+	defer f.wmu.Unlock()
+	for _, c := range f.watchers {
+		c.Close()
+	}
+	f.watchers = nil
+}
+
+// last_payload returns the payload of the last private request
+func (f *fake_gemini) last_payload() map[string]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.payloads) == 0 {
+		return nil
+	}
+	return f.payloads[len(f.payloads)-1]
+}
+
+// setenv sets an environment variable for the duration of the test
+func setenv(t *testing.T, key, value string) {
+	t.Helper()
+	saved, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, saved)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+// use_fake_gemini points gemini_cli to a new fake server
+func use_fake_gemini(t *testing.T) *fake_gemini {
+	f := new_fake_gemini(t)
+	setenv(t, "GEMINI_API_KEY", fake_key)
+	setenv(t, "GEMINI_API_SECRET", fake_secret)
+	setenv(t, "GEMINI_API_PRODUCTION", "false")
+	setenv(t, "GEMINI_API_URL", f.URL)
+	setenv(t, "GEMINI_JOURNAL", filepath.Join(t.TempDir(), "journal.jsonl"))
+	setenv(t, "GEMINI_STATE_DIR", filepath.Join(t.TempDir(), "state"))
+	return f
+}
+
+// transfers_page returns, newest first, the oldest limit_transfers transfers
+// on or after timestamp, or the newest ones without timestamp
+func (f *fake_gemini) transfers_page(params map[string]interface{}) []gemini.Transfer {
+	limit := 50
+	if l, ok := params["limit_transfers"].(json.Number); ok {
+		n, _ := l.Int64()
+		limit = int(n)
+	}
+	ts, ok := params["timestamp"].(json.Number)
+	since, err := ts.Int64()
+	if !ok || err != nil {
+		if len(f.transfers) > limit {
+			return f.transfers[:limit]
+		}
+		return f.transfers
+	}
+	page := []gemini.Transfer{}
+	for i := len(f.transfers) - 1; i >= 0 && len(page) < limit; i-- {
+		if f.transfers[i].Timestampms >= since {
+			page = append([]gemini.Transfer{f.transfers[i]}, page...)
+		}
+	}
+	return page
+}
+
+// market_data reads the subscribe message and runs the marketdata script
+func (f *fake_gemini) market_data(w http.ResponseWriter, r *http.Request) {
+	conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	var subscribe map[string]interface{}
+	if err := conn.ReadJSON(&subscribe); err != nil {
+		return
+	}
+	f.mu.Lock()
+	f.marketdataConnections++
+	n := f.marketdataConnections
+	f.mu.Unlock()
+	f.marketdata(conn, n)
+}
@@ -4,7 +4,9 @@ go 1.15
 
 require (
 	github.com/claudiocandio/gemini-api v1.0.1
-	github.com/sirupsen/logrus v1.7.0 // indirect
+	github.com/gorilla/websocket v1.4.2
+	github.com/sirupsen/logrus v1.7.0
 	github.com/urfave/cli/v2 v2.3.0
+	golang.org/x/crypto v0.0.0-20210322153248-0c34fe9e7dc2
 	gopkg.in/yaml.v2 v2.4.0
 )
@@ -0,0 +1,564 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/claudiocandio/gemini-api/logger"
+	"github.com/urfave/cli/v2"
+)
+
+// grid_level is a price of the grid and the order resting at it, if any
+type grid_level struct {
+	Price decimal `json:"price"`
+	// buy or sell, empty when no order rests at this level
+	Side          string `json:"side"`
+	ClientOrderId string `json:"client_order_id"`
+	OrderId       string `json:"order_id"`
+	// pending until it is placed, then live
+	Status string `json:"status"`
+	// fill price of the order one level away this order is the counter of,
+	// zero for the orders of the initial ladder
+	Basis decimal `json:"basis"`
+}
+
+// grid_state is the state file of a grid, it is written after every change
+// so that bot resume can continue after a restart
+type grid_state struct {
+	Kind string `json:"kind"`
+	Id   string `json:"id"`
+	// REST base url and account the orders are placed on
+	Url            string       `json:"url"`
+	Account        string       `json:"account"`
+	Symbol         string       `json:"symbol"`
+	Lower          decimal      `json:"lower"`
+	Upper          decimal      `json:"upper"`
+	AmountPerLevel decimal      `json:"amount_per_level"`
+	Paper          bool         `json:"paper"`
+	Levels         []grid_level `json:"levels"`
+	// client order ids used so far
+	Orders int `json:"orders"`
+	// filled orders, also those cancelled after a partial fill, the amount
+	// bought minus the amount sold and the profit of the counter orders
+	// filled, a round trip between two levels
+	Buys     int       `json:"buys"`
+	Sells    int       `json:"sells"`
+	Position decimal   `json:"position"`
+	Profit   decimal   `json:"realized_profit"`
+	Updated  time.Time `json:"updated"`
+}
+
+// grid_event is what the grid prints for every change
+type grid_event struct {
+	Time    time.Time `json:"time"`
+	Id      string    `json:"id"`
+	Level   int       `json:"level"`
+	Side    string    `json:"side"`
+	OrderId string    `json:"order_id"`
+	// started, resumed, placed, filled, cancelled, out_of_range or error
+	Event  string  `json:"event"`
+	Price  decimal `json:"price"`
+	Amount decimal `json:"amount"`
+	Profit decimal `json:"realized_profit"`
+	Detail string  `json:"detail"`
+}
+
+// grid_flags are the flags of bot grid
+func grid_flags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "ticker",
+			Aliases: []string{"t"},
+			Usage:   "e.g. --ticker btcusd",
+		},
+		&cli.StringFlag{
+			Name:     "lower",
+			Usage:    "e.g. --lower 28000 - Price of the lowest level",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:     "upper",
+			Usage:    "e.g. --upper 32000 - Price of the highest level",
+			Required: true,
+		},
+		&cli.IntFlag{
+			Name:     "levels",
+			Usage:    "e.g. --levels 9 - Number of levels evenly spaced from --lower to --upper",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:     "amount-per-level",
+			Usage:    "e.g. --amount-per-level 0.01 - Amount of the order at every level",
+			Required: true,
+		},
+		&cli.BoolFlag{
+			Name:  "paper",
+			Usage: "Place no order: a buy fills when the ask of the ticker falls to its price, a sell when the bid rises to it",
+		},
+		&cli.StringFlag{
+			Name:    "client-order-id",
+			Aliases: []string{"client_order_id", "i"},
+			Usage:   "e.g. --client-order-id grid-btc - Id of the grid, the orders are grid-btc-1, grid-btc-2...",
+		},
+		&cli.BoolFlag{
+			Name:  "round",
+			Usage: "Round the amount down to the tick size and the prices to the quote increment, as order new --round",
+		},
+		&cli.DurationFlag{
+			Name:  "poll",
+			Usage: "e.g. --poll 5s - How often the orders, or in --paper mode the ticker, are read",
+			Value: 5 * time.Second,
+		},
+		&cli.StringFlag{
+			Name:  "state",
+			Usage: "--state path - State file, by default <id>.json in --state-dir",
+		},
+		&cli.IntFlag{
+			Name:  "count",
+			Usage: "e.g. --count 100 - Exit after printing this many events (default: until Ctrl-C)",
+		},
+	}
+}
+
+// new_grid_state checks the flags of bot grid and lays out the ladder
+// around the mid price: buys below it, sells above it and no order at the
+// level nearest to it, so that every fill has a free level for its counter
+// order
+func new_grid_state(c *cli.Context, api gemini_client) (*grid_state, error) {
+	symbol, err := get_ticker_flag(c)
+	if err != nil {
+		return nil, err
+	}
+	_, url, account, err := journal_scope(c)
+	if err != nil {
+		return nil, err
+	}
+	s := &grid_state{Kind: "grid", Id: c.String("client-order-id"), Url: url, Account: account,
+		Symbol: strings.ToLower(symbol), Paper: c.Bool("paper")}
+	if s.Id == "" {
+		s.Id = fmt.Sprintf("grid-%d", time.Now().UnixNano()/1e6)
+	}
+	if s.Lower, err = decimal_flag(c, "lower"); err != nil {
+		return nil, err
+	}
+	if s.Upper, err = decimal_flag(c, "upper"); err != nil {
+		return nil, err
+	}
+	if s.AmountPerLevel, err = decimal_flag(c, "amount-per-level"); err != nil {
+		return nil, err
+	}
+	levels := c.Int("levels")
+	switch {
+	case s.Lower.sign() <= 0 || s.Lower.cmp(s.Upper) >= 0:
+		return nil, fmt.Errorf("Error invalid range: --lower %s must be greater than 0 and below --upper %s", s.Lower, s.Upper)
+	case levels < 3:
+		return nil, fmt.Errorf("Error invalid --levels: %d, it must be at least 3", levels)
+	case s.AmountPerLevel.sign() <= 0:
+		return nil, fmt.Errorf("Error invalid --amount-per-level: %s, it must be greater than 0", s.AmountPerLevel)
+	}
+
+	// /v1/symbols/details/:symbol
+	d, err := api.SymbolDetails(s.Symbol)
+	if err != nil {
+		return nil, err
+	}
+	step := s.Upper.sub(s.Lower).quo(decimal_int(int64(levels - 1)))
+	if step.cmp(d.QuoteIncrement) < 0 {
+		return nil, fmt.Errorf("Error %d levels from %s to %s are closer than the quote increment %s", levels, s.Lower, s.Upper, d.QuoteIncrement)
+	}
+	// /v2/ticker/:symbol
+	ticker, err := api.TickerV2(s.Symbol)
+	if err != nil {
+		return nil, err
+	}
+	if ticker.Bid <= 0 || ticker.Ask <= 0 {
+		return nil, fmt.Errorf("Error %s has no bid/ask to lay out the grid", s.Symbol)
+	}
+	mid := decimal_from_float(ticker.Bid).add(decimal_from_float(ticker.Ask)).quo(decimal_int(2))
+
+	gap := -1
+	for i := 0; i < levels; i++ {
+		price := s.Lower.add(step.mul(decimal_int(int64(i)))).round(d.QuoteIncrement)
+		side := "buy"
+		if price.cmp(mid) > 0 {
+			side = "sell"
+		}
+		s.Levels = append(s.Levels, grid_level{Price: price, Side: side, Status: "pending"})
+		if mid.cmp(s.Lower) >= 0 && mid.cmp(s.Upper) <= 0 && (gap < 0 || distance_bps(price, mid).cmp(distance_bps(s.Levels[gap].Price, mid)) < 0) {
+			gap = i
+		}
+	}
+	if gap >= 0 {
+		s.Levels[gap].Side, s.Levels[gap].Status = "", ""
+	}
+
+	details := map[string]symbol_details{s.Symbol: d}
+	for i := range s.Levels {
+		l := &s.Levels[i]
+		if l.Side == "" {
+			continue
+		}
+		o := s.request(l)
+		if err := prepare_order(c, api, &o, details); err != nil {
+			return nil, fmt.Errorf("Error level %s: %s", l.Price, strings.TrimPrefix(err.Error(), "Error "))
+		}
+		l.Price = o.Price
+		s.AmountPerLevel = o.Amount
+	}
+	return s, nil
+}
+
+// request is the order of a level, a maker-or-cancel limit order
+func (s *grid_state) request(l *grid_level) orderRequest {
+	return orderRequest{
+		Symbol:        s.Symbol,
+		ClientOrderId: l.ClientOrderId,
+		Side:          l.Side,
+		Type:          orderTypeLimit,
+		Amount:        s.AmountPerLevel,
+		Price:         l.Price,
+		Options:       []string{optionMakerOrCancel},
+	}
+}
+
+func grid_summary(c *cli.Context, s *grid_state, path string) []summary_field {
+	quote := quote_currency(s.Symbol)
+	buys, sells := 0, 0
+	for _, l := range s.Levels {
+		switch l.Side {
+		case "buy":
+			buys++
+		case "sell":
+			sells++
+		}
+	}
+	fields := append(environment_summary(c),
+		summary_field{"symbol", s.Symbol},
+		summary_field{"id", s.Id},
+		summary_field{"range", fmt.Sprintf("%s to %s %s in %d levels", s.Lower, s.Upper, quote, len(s.Levels))},
+		summary_field{"buys", fmt.Sprintf("%d of %s, %s in total", buys, s.AmountPerLevel, s.AmountPerLevel.mul(decimal_int(int64(buys))))},
+		summary_field{"sells", fmt.Sprintf("%d of %s, %s in total", sells, s.AmountPerLevel, s.AmountPerLevel.mul(decimal_int(int64(sells))))},
+	)
+	if s.Paper {
+		fields = append(fields, summary_field{"paper", "no order is placed"})
+	}
+	return append(fields, summary_field{"state", path})
+}
+
+// grid_bot places the orders of the grid and a counter order one level
+// away for every fill
+type grid_bot struct {
+	c       *cli.Context
+	api     gemini_client
+	s       *grid_state
+	path    string
+	w       *stream_writer
+	details map[string]symbol_details
+}
+
+func (g *grid_bot) event(i int, event string, price decimal, detail string) {
+	e := grid_event{Time: time.Now().UTC(), Id: g.s.Id, Level: i + 1, Event: event, Price: price,
+		Amount: g.s.AmountPerLevel, Profit: g.s.Profit, Detail: detail}
+	if i >= 0 {
+		e.Side, e.OrderId = g.s.Levels[i].Side, g.s.Levels[i].OrderId
+	}
+	logger.Debug("func grid_bot: event",
+		fmt.Sprintf("id:%s", e.Id),
+		fmt.Sprintf("level:%d", e.Level),
+		fmt.Sprintf("event:%s", e.Event),
+	)
+	if err := g.w.write(e); err != nil {
+		errlog.Printf("Warning %s: %s", g.s.Id, err)
+	}
+}
+
+func (g *grid_bot) save() error {
+	g.s.Updated = time.Now().UTC()
+	return save_state(g.path, g.s)
+}
+
+// place sends the pending order of level i. A pending order that has a
+// client order id, sent before a restart or without an answer from Gemini,
+// is not placed again if it is found in the active orders or in the
+// journal, otherwise it is sent again with the same id. In paper mode the
+// order is only recorded
+func (g *grid_bot) place(i int) error {
+	l := &g.s.Levels[i]
+	if l.ClientOrderId != "" && !g.s.Paper {
+		ids, err := find_client_order(g.c, g.api, l.ClientOrderId)
+		if err != nil {
+			return err
+		}
+		if len(ids) > 0 {
+			l.OrderId, l.Status = ids[len(ids)-1], "live"
+			g.event(i, "placed", l.Price, "found in the active orders or in the journal")
+			return g.save()
+		}
+	}
+	if l.ClientOrderId == "" {
+		g.s.Orders++
+		l.ClientOrderId = fmt.Sprintf("%s-%d", g.s.Id, g.s.Orders)
+	}
+	l.OrderId = ""
+	o := g.s.request(l)
+	if err := prepare_order(g.c, g.api, &o, g.details); err != nil {
+		return err
+	}
+	// saved before it is sent, to be found after a restart
+	if err := g.save(); err != nil {
+		return err
+	}
+	if g.s.Paper {
+		l.OrderId = "paper-" + l.ClientOrderId
+	} else {
+		// /v1/order/new
+		placed, err := place_order(g.c, g.api, o)
+		if err != nil {
+			return err
+		}
+		l.OrderId = placed.OrderId
+		if !placed.IsLive {
+			// a maker-or-cancel order that would have taken liquidity
+			g.event(i, "cancelled", l.Price, "maker-or-cancel, the price is through the level, placed again at the next poll")
+			l.Status, l.ClientOrderId = "pending", ""
+			return g.save()
+		}
+	}
+	l.Status = "live"
+	g.event(i, "placed", l.Price, "")
+	return g.save()
+}
+
+// record adds amount executed at price by the order of l to the filled
+// orders, the position and, for a counter order, the realized profit
+func (s *grid_state) record(l *grid_level, amount, price decimal) {
+	if l.Side == "buy" {
+		s.Buys++
+		s.Position = s.Position.add(amount)
+	} else {
+		s.Sells++
+		s.Position = s.Position.sub(amount)
+	}
+	if !l.Basis.is_zero() {
+		profit := price.sub(l.Basis)
+		if l.Side == "buy" {
+			profit = l.Basis.sub(price)
+		}
+		s.Profit = s.Profit.add(profit.mul(amount))
+	}
+}
+
+// filled records the fill of level i at price and places its counter order
+// one level away: a sell above a buy, a buy below a sell
+func (g *grid_bot) filled(i int, price decimal) error {
+	s := g.s
+	l := &s.Levels[i]
+	s.record(l, s.AmountPerLevel, price)
+	g.event(i, "filled", price, "")
+	side := l.Side
+	l.Side, l.Status, l.ClientOrderId, l.OrderId, l.Basis = "", "", "", "", decimal{}
+
+	j, counter := i+1, "sell"
+	if side == "sell" {
+		j, counter = i-1, "buy"
+	}
+	if j < 0 || j >= len(s.Levels) {
+		g.event(i, "out_of_range", price, "no level "+map[string]string{"buy": "above", "sell": "below"}[side]+" for the "+counter)
+		return g.save()
+	}
+	if s.Levels[j].Side != "" {
+		g.event(j, "error", s.Levels[j].Price, "the level of the "+counter+" already has an order")
+		return g.save()
+	}
+	s.Levels[j] = grid_level{Price: s.Levels[j].Price, Side: counter, Status: "pending", Basis: price}
+	return g.place(j)
+}
+
+// fills returns the levels filled since the last poll and their fill price:
+// in paper mode the buys at or above the ask and the sells at or below the
+// bid, otherwise the orders no longer active that were not cancelled
+func (g *grid_bot) fills() (map[int]decimal, error) {
+	s := g.s
+	fills := map[int]decimal{}
+	if s.Paper {
+		// /v2/ticker/:symbol
+		ticker, err := g.api.TickerV2(s.Symbol)
+		if err != nil {
+			return nil, err
+		}
+		bid, ask := decimal_from_float(ticker.Bid), decimal_from_float(ticker.Ask)
+		for i, l := range s.Levels {
+			if l.Status == "live" && ((l.Side == "buy" && ask.sign() > 0 && l.Price.cmp(ask) >= 0) ||
+				(l.Side == "sell" && bid.sign() > 0 && l.Price.cmp(bid) <= 0)) {
+				fills[i] = l.Price
+			}
+		}
+		return fills, nil
+	}
+
+	// /v1/orders
+	orders, err := g.api.ActiveOrders()
+	if err != nil {
+		return nil, err
+	}
+	active := map[string]bool{}
+	for _, o := range orders {
+		active[o.OrderId] = true
+	}
+	for i := range s.Levels {
+		l := &s.Levels[i]
+		if l.Status != "live" || active[l.OrderId] {
+			continue
+		}
+		// /v1/order/status
+		o, err := g.api.OrderStatus(l.OrderId)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case o.IsLive:
+		case o.IsCancelled:
+			detail := "cancelled outside of the grid"
+			if o.ExecutedAmount > 0 {
+				// the part executed is in the position like a fill
+				s.record(l, decimal_from_float(o.ExecutedAmount), decimal_from_float(o.AvgExecutionPrice))
+				detail += fmt.Sprintf(", %s was executed", formatFloat(o.ExecutedAmount))
+			}
+			g.event(i, "cancelled", l.Price, detail)
+			l.Side, l.Status, l.ClientOrderId, l.OrderId = "", "", "", ""
+			if err := g.save(); err != nil {
+				return nil, err
+			}
+		default:
+			fills[i] = l.Price
+			if o.AvgExecutionPrice > 0 {
+				fills[i] = decimal_from_float(o.AvgExecutionPrice)
+			}
+		}
+	}
+	return fills, nil
+}
+
+// step places the pending orders and the counter orders of the fills, the
+// buys from the highest and the sells from the lowest so that the counter
+// order of every fill finds its level free
+func (g *grid_bot) step() error {
+	s := g.s
+	for i := range s.Levels {
+		if s.Levels[i].Status == "pending" {
+			if err := g.place(i); err != nil {
+				g.event(i, "error", s.Levels[i].Price, "cannot place the order: "+one_line(err))
+			}
+		}
+	}
+
+	fills, err := g.fills()
+	if err != nil {
+		return err
+	}
+	for i := len(s.Levels) - 1; i >= 0; i-- {
+		if price, ok := fills[i]; ok && s.Levels[i].Side == "buy" {
+			if err := g.filled(i, price); err != nil {
+				return err
+			}
+		}
+	}
+	for i := range s.Levels {
+		if price, ok := fills[i]; ok && s.Levels[i].Side == "sell" {
+			if err := g.filled(i, price); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// run calls step every --poll until Ctrl-C or --count events, a failed
+// step is reported and tried again at the next poll. The orders stay on
+// the book
+func (g *grid_bot) run(event string) error {
+	ctx, cancel := interrupt_context(context.Background())
+	defer cancel()
+	g.w.done = cancel
+
+	poll := g.c.Duration("poll")
+	if poll <= 0 {
+		return fmt.Errorf("Error invalid --poll: %s, it must be greater than 0", poll)
+	}
+	g.event(-1, event, decimal{}, g.path)
+	for {
+		if err := g.step(); err != nil {
+			g.event(-1, "error", decimal{}, one_line(err))
+		}
+		select {
+		case <-ctx.Done():
+			errlog.Printf("grid %s: stopped, resume with: gemini_cli bot resume --state %s", g.s.Id, g.path)
+			if !g.s.Paper {
+				errlog.Printf("grid %s: the orders stay on the book, to cancel them: gemini_cli order cancel --client-order-id-prefix %s-",
+					g.s.Id, g.s.Id)
+			}
+			return g.save()
+		case <-time.After(poll):
+		}
+	}
+}
+
+// run_grid lays out a grid and runs it
+func run_grid(c *cli.Context, api gemini_client) error {
+	s, err := new_grid_state(c, api)
+	if err != nil {
+		return err
+	}
+	path, err := state_path(c, s.Id)
+	if err != nil {
+		return err
+	}
+
+	if c.Bool("dry-run") {
+		var requests []signed_request
+		for i := range s.Levels {
+			l := &s.Levels[i]
+			if l.Side == "" {
+				continue
+			}
+			s.Orders++
+			l.ClientOrderId = fmt.Sprintf("%s-%d", s.Id, s.Orders)
+			req, err := api.SignRequest(new_order_URI, new_order_params(s.request(l)))
+			if err != nil {
+				return err
+			}
+			requests = append(requests, req)
+		}
+		return print_output(c, requests)
+	}
+	if err := new_state_file(path); err != nil {
+		return err
+	}
+	if err := confirm(c, "Start a grid", grid_summary(c, s, path)); err != nil {
+		return err
+	}
+	g := &grid_bot{c: c, api: api, s: s, path: path, w: new_stream_writer(c), details: map[string]symbol_details{}}
+	if err := g.save(); err != nil {
+		return err
+	}
+	return g.run("started")
+}
+
+// run_bot_resume resumes the grid of --state after a restart
+func run_bot_resume(c *cli.Context, api gemini_client) error {
+	path := c.String("state")
+	var s grid_state
+	if err := load_state(path, &s); err != nil {
+		return err
+	}
+	if s.Kind != "grid" {
+		return fmt.Errorf("Error state file %s is not a grid", path)
+	}
+	if err := check_state_scope(c, path, s.Url, s.Account); err != nil {
+		return err
+	}
+	g := &grid_bot{c: c, api: api, s: &s, path: path, w: new_stream_writer(c), details: map[string]symbol_details{}}
+	return g.run("resumed")
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func grid_events(t *testing.T, out string) []string {
+	t.Helper()
+	var e grid_event
+	return jsonl_lines(t, out, &e, func() string {
+		if e.Level == 0 {
+			return fmt.Sprintf("0 %s", e.Event)
+		}
+		return fmt.Sprintf("%d %s %s %s", e.Level, e.Event, e.Side, e.Price)
+	})
+}
+
+// fill_when fills the order with client order id once there are n orders
+func fill_when(t *testing.T, server *fake_gemini, n int, clientOrderId string) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		server.mu.Lock()
+		if len(server.orders) >= n {
+			for _, o := range server.orders {
+				if o.ClientOrderId == clientOrderId {
+					fill_order(o, o.RemainingAmount)
+				}
+			}
+			server.mu.Unlock()
+			return
+		}
+		server.mu.Unlock()
+	}
+	t.Errorf("no order %d to fill", n)
+}
+
+// the grid of the tests: the mid price 100 leaves level 3 free
+var grid_args = []string{"bot", "grid", "-t", "btcusd", "--lower", "96", "--upper", "104", "--levels", "5",
+	"--amount-per-level", "0.5", "--poll", "10ms"}
+
+func TestBotGrid(t *testing.T) {
+	server := use_fake_gemini(t)
+	path := filepath.Join(t.TempDir(), "g1.json")
+	go func() {
+		// the buy at 98 fills, then its counter sell at 100
+		fill_when(t, server, 4, "g1-2")
+		fill_when(t, server, 5, "g1-5")
+	}()
+	out, err := run_app(t, nil, append([]string{"-y", "-o", "jsonl"}, append(grid_args, "-i", "g1", "--state", path, "--count", "9")...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check_events(t, grid_events(t, out), []string{
+		"0 started",
+		"1 placed buy 96",
+		"2 placed buy 98",
+		"4 placed sell 102",
+		"5 placed sell 104",
+		"2 filled buy 98",
+		"3 placed sell 100",
+		"3 filled sell 100",
+		"2 placed buy 98",
+	})
+	var s grid_state
+	read_state(t, path, &s)
+	if s.Buys != 1 || s.Sells != 1 || !s.Position.is_zero() || s.Profit.String() != "1" || s.Orders != 6 {
+		t.Errorf("state %+v", s)
+	}
+	if l := s.Levels[1]; l.Side != "buy" || l.Status != "live" || l.Basis.String() != "100" || l.ClientOrderId != "g1-6" {
+		t.Errorf("level 2 %+v", l)
+	}
+	for _, o := range server.orders {
+		if len(o.Options) != 1 || o.Options[0] != optionMakerOrCancel || o.OriginalAmount != 0.5 {
+			t.Errorf("order %+v", o)
+		}
+	}
+}
+
+func TestBotGridCancelledOutside(t *testing.T) {
+	server := use_fake_gemini(t)
+	path := filepath.Join(t.TempDir(), "g4.json")
+	go func() {
+		// the buy at 96 is cancelled by someone else after a partial fill
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+			server.mu.Lock()
+			if len(server.orders) >= 4 {
+				fill_order(server.orders[0], 0.2)
+				server.orders[0].IsLive, server.orders[0].IsCancelled = false, true
+				server.mu.Unlock()
+				return
+			}
+			server.mu.Unlock()
+		}
+	}()
+	out, err := run_app(t, nil, append([]string{"-y", "-o", "jsonl"}, append(grid_args, "-i", "g4", "--state", path, "--count", "6")...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check_events(t, grid_events(t, out), []string{
+		"0 started",
+		"1 placed buy 96",
+		"2 placed buy 98",
+		"4 placed sell 102",
+		"5 placed sell 104",
+		"1 cancelled buy 96",
+	})
+	var s grid_state
+	read_state(t, path, &s)
+	if s.Buys != 1 || s.Position.String() != "0.2" || s.Levels[0].Status != "" {
+		t.Errorf("state %+v", s)
+	}
+}
+
+func TestBotGridResume(t *testing.T) {
+	server := use_fake_gemini(t)
+	if _, err := run_app(t, nil, "-y", "order", "new", "-t", "btcusd", "-s", "buy", "-a", "0.5", "-p", "96",
+		"--option", "maker-or-cancel", "-i", "g5-1"); err != nil {
+		t.Fatal(err)
+	}
+	// the grid stopped after sending the buy at 96, before saving its order id
+	s := grid_state{Kind: "grid", Id: "g5", Url: server.URL, Symbol: "btcusd", Lower: dec("96"), Upper: dec("104"),
+		AmountPerLevel: dec("0.5"), Orders: 1, Levels: []grid_level{
+			{Price: dec("96"), Side: "buy", ClientOrderId: "g5-1", Status: "pending"},
+			{Price: dec("100")},
+			{Price: dec("104")},
+		}}
+	path := filepath.Join(t.TempDir(), "g5.json")
+	if err := save_state(path, s); err != nil {
+		t.Fatal(err)
+	}
+	out, err := run_app(t, nil, "-o", "jsonl", "bot", "resume", "--state", path, "--poll", "10ms", "--count", "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check_events(t, grid_events(t, out), []string{"0 resumed", "1 placed buy 96"})
+	if len(server.orders) != 1 {
+		t.Errorf("%d orders, the buy was placed again", len(server.orders))
+	}
+	var resumed grid_state
+	read_state(t, path, &resumed)
+	if resumed.Orders != 1 || resumed.Levels[0].Status != "live" || resumed.Levels[0].OrderId != "1001" {
+		t.Errorf("state %+v", resumed)
+	}
+}
+
+func TestBotGridSendTimeout(t *testing.T) {
+	server := use_fake_gemini(t)
+	// the buy at 96 is placed but its reply is lost
+	server.dropReplies = 1
+	path := filepath.Join(t.TempDir(), "g6.json")
+	out, err := run_app(t, nil, append([]string{"-y", "-o", "jsonl"}, append(grid_args, "-i", "g6", "--state", path, "--count", "6")...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check_events(t, grid_events(t, out), []string{
+		"0 started",
+		"1 error buy 96",
+		"2 placed buy 98",
+		"4 placed sell 102",
+		"5 placed sell 104",
+		"1 placed buy 96",
+	})
+	var ids []string
+	for _, o := range server.orders {
+		ids = append(ids, o.ClientOrderId)
+	}
+	if strings.Join(ids, ",") != "g6-1,g6-2,g6-3,g6-4" {
+		t.Errorf("orders %v, the buy at 96 was placed again", ids)
+	}
+	var s grid_state
+	read_state(t, path, &s)
+	if s.Orders != 4 || s.Levels[0].Status != "live" || s.Levels[0].OrderId != "1001" {
+		t.Errorf("state %+v", s)
+	}
+}
+
+func TestBotGridPaper(t *testing.T) {
+	server := use_fake_gemini(t)
+	// the bid falls to 96 and rises to 100
+	server.tickerBids = []float64{99, 96, 100}
+	path := filepath.Join(t.TempDir(), "g2.json")
+	out, err := run_app(t, nil, append([]string{"-y", "-o", "jsonl"}, append(grid_args, "-i", "g2", "--paper", "--state", path, "--count", "9")...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check_events(t, grid_events(t, out), []string{
+		"0 started",
+		"1 placed buy 96",
+		"2 placed buy 98",
+		"4 placed sell 102",
+		"5 placed sell 104",
+		"2 filled buy 98",
+		"3 placed sell 100",
+		"3 filled sell 100",
+		"2 placed buy 98",
+	})
+	if len(server.orders) != 0 {
+		t.Errorf("%d orders placed in paper mode", len(server.orders))
+	}
+	var s grid_state
+	read_state(t, path, &s)
+	if s.Profit.String() != "1" || !s.Paper {
+		t.Errorf("state %+v", s)
+	}
+
+	out, err = run_app(t, nil, "-o", "jsonl", "bot", "resume", "--state", path, "--poll", "10ms", "--count", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check_events(t, grid_events(t, out), []string{"0 resumed"})
+	var resumed grid_state
+	read_state(t, path, &resumed)
+	if resumed.Orders != 6 || resumed.Profit.String() != "1" {
+		t.Errorf("state after resume %+v", resumed)
+	}
+}
+
+func TestBotGridDryRun(t *testing.T) {
+	use_fake_gemini(t)
+	out, err := run_app(t, nil, append([]string{"--dry-run"}, append(grid_args, "-i", "g3")...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var requests []signed_request
+	if err := json.Unmarshal([]byte(out), &requests); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	var orders []string
+	for _, r := range requests {
+		var p map[string]interface{}
+		if err := json.Unmarshal(r.Payload, &p); err != nil {
+			t.Fatal(err)
+		}
+		orders = append(orders, fmt.Sprintf("%v %v %v", p["client_order_id"], p["side"], p["price"]))
+	}
+	if strings.Join(orders, ",") != "g3-1 buy 96,g3-2 buy 98,g3-3 sell 102,g3-4 sell 104" {
+		t.Errorf("orders: %v", orders)
+	}
+	if _, err := run_app(t, nil, "--dry-run", "bot", "grid", "-t", "btcusd", "--lower", "104", "--upper", "96",
+		"--levels", "5", "--amount-per-level", "0.5"); err == nil {
+		t.Error("an inverted range was accepted")
+	}
+}
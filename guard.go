@@ -0,0 +1,679 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/claudiocandio/gemini-api"
+	"github.com/claudiocandio/gemini-api/logger"
+	"github.com/urfave/cli/v2"
+)
+
+// guard_state is the state file of a guard, it is written after every
+// change so that guard resume can continue after a restart
+type guard_state struct {
+	// trailing_stop or stop_loss
+	Kind string `json:"kind"`
+	Id   string `json:"id"`
+	// REST base url and account the orders are placed on
+	Url     string  `json:"url"`
+	Account string  `json:"account"`
+	Symbol  string  `json:"symbol"`
+	Amount  decimal `json:"amount"`
+	// trailing stop: the trigger follows the highest price by Trail, a
+	// percent of it if TrailPercent
+	Trail        decimal `json:"trail"`
+	TrailPercent bool    `json:"trail_percent"`
+	RatchetBps   decimal `json:"ratchet_bps"`
+	High         decimal `json:"high"`
+	Trigger      decimal `json:"trigger"`
+	// stop-limit keeps a stop-limit order on the book at the trigger, ioc
+	// sends an immediate-or-cancel sell when the price reaches it
+	Order          string  `json:"order"`
+	MaxSlippageBps decimal `json:"max_slippage_bps"`
+	// ticker or stream
+	Source string `json:"source"`
+	// the stop-limit order, or the last immediate-or-cancel sell
+	Sell         *oco_leg `json:"sell,omitempty"`
+	SellNotional decimal  `json:"sell_notional"`
+	Sells        int      `json:"sells"`
+	Executed     decimal  `json:"executed_amount"`
+	Notional     decimal  `json:"notional"`
+	// watching, triggered once the ioc sells started, or done
+	Status  string    `json:"status"`
+	Updated time.Time `json:"updated"`
+}
+
+// guard_event is what a guard prints for every change
+type guard_event struct {
+	Time    time.Time `json:"time"`
+	Id      string    `json:"id"`
+	OrderId string    `json:"order_id"`
+	// started, resumed, ratchet, triggered, waiting, placed, fill,
+	// filled, cancelled, error or done
+	Event   string  `json:"event"`
+	Price   decimal `json:"price"`
+	Trigger decimal `json:"trigger"`
+	Amount  decimal `json:"amount"`
+	Detail  string  `json:"detail"`
+}
+
+// guard_flags are the flags of guard trailing-stop and guard stop-loss
+func guard_flags(trailing bool) []cli.Flag {
+	flags := []cli.Flag{
+		&cli.StringFlag{
+			Name:    "ticker",
+			Aliases: []string{"t"},
+			Usage:   "e.g. --ticker ethusd",
+		},
+		&cli.StringFlag{
+			Name:     "amount",
+			Aliases:  []string{"a"},
+			Usage:    "e.g. --amount 3 - Amount of the position to sell when the stop is hit",
+			Required: true,
+		},
+	}
+	if trailing {
+		flags = append(flags,
+			&cli.StringFlag{
+				Name:     "trail",
+				Usage:    "e.g. --trail 4% or --trail 50 - Distance of the stop below the highest price, in percent or in the quote currency",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:  "ratchet-bps",
+				Usage: "e.g. --ratchet-bps 10 - Raise the stop only when it moves up at least 10 bps",
+				Value: "0",
+			})
+	} else {
+		flags = append(flags, &cli.StringFlag{
+			Name:     "stop-price",
+			Usage:    "e.g. --stop-price 1700 - Sell when the price falls to 1700",
+			Required: true,
+		})
+	}
+	return append(flags,
+		&cli.StringFlag{
+			Name:  "order",
+			Usage: "--order ioc|stop-limit - Sell with an immediate-or-cancel order when the stop is hit, or keep a stop-limit order on the book",
+			Value: "ioc",
+		},
+		&cli.StringFlag{
+			Name:  "max-slippage-bps",
+			Usage: "e.g. --max-slippage-bps 50 - Limit price of the sell, at most 50 bps below the stop",
+			Value: "50",
+		},
+		&cli.StringFlag{
+			Name:  "source",
+			Usage: "--source ticker|stream - Read the best bid from the ticker every --poll or from the market data feed",
+			Value: "ticker",
+		},
+		&cli.DurationFlag{
+			Name:  "poll",
+			Usage: "e.g. --poll 2s - How often the price and the orders are checked",
+			Value: 2 * time.Second,
+		},
+		&cli.StringFlag{
+			Name:    "client-order-id",
+			Aliases: []string{"client_order_id", "i"},
+			Usage:   "e.g. --client-order-id guard-eth - Id of the guard, the sells are guard-eth-1, guard-eth-2...",
+		},
+		&cli.StringFlag{
+			Name:  "state",
+			Usage: "--state path - State file, by default <id>.json in --state-dir",
+		},
+		&cli.DurationFlag{
+			Name:  "heartbeat",
+			Usage: "--source stream: reconnect when nothing, not even a heartbeat, is received for this long",
+			Value: 15 * time.Second,
+		},
+		&cli.IntFlag{
+			Name:  "max-retries",
+			Usage: "--source stream: read the ticker after this many failed reconnections in a row (default: retry forever)",
+		},
+	)
+}
+
+// parse_trail reads --trail: 4% is a percent of the highest price, 50 an
+// amount of the quote currency
+func parse_trail(s string) (decimal, bool, error) {
+	percent := strings.HasSuffix(strings.TrimSpace(s), "%")
+	trail, err := parse_decimal(strings.TrimSuffix(strings.TrimSpace(s), "%"))
+	if err != nil {
+		return trail, percent, fmt.Errorf("Error invalid --trail: %s, e.g. 4%% or 50", s)
+	}
+	if trail.sign() <= 0 || (percent && trail.cmp(decimal_int(100)) >= 0) {
+		return trail, percent, fmt.Errorf("Error invalid --trail: %s, it must be greater than 0 and below 100%%", s)
+	}
+	return trail, percent, nil
+}
+
+// new_guard_state checks the flags of guard trailing-stop or guard stop-loss
+func new_guard_state(c *cli.Context, kind string) (*guard_state, error) {
+	symbol, err := get_ticker_flag(c)
+	if err != nil {
+		return nil, err
+	}
+	_, url, account, err := journal_scope(c)
+	if err != nil {
+		return nil, err
+	}
+	s := &guard_state{Kind: kind, Id: c.String("client-order-id"), Url: url, Account: account,
+		Symbol: strings.ToLower(symbol), Order: c.String("order"), Source: c.String("source"), Status: "watching"}
+	if s.Id == "" {
+		s.Id = fmt.Sprintf("%s-%d", strings.Replace(kind, "_", "-", 1), time.Now().UnixNano()/1e6)
+	}
+	if s.Order != "ioc" && s.Order != "stop-limit" {
+		return nil, fmt.Errorf("Error invalid --order: %s\nValid orders: ioc, stop-limit", s.Order)
+	}
+	if s.Source != "ticker" && s.Source != "stream" {
+		return nil, fmt.Errorf("Error invalid --source: %s\nValid sources: ticker, stream", s.Source)
+	}
+	if s.Amount, err = decimal_flag(c, "amount"); err != nil {
+		return nil, err
+	}
+	if s.Amount.sign() <= 0 {
+		return nil, fmt.Errorf("Error invalid amount: %s, it must be greater than 0", s.Amount)
+	}
+	if s.MaxSlippageBps, err = decimal_flag(c, "max-slippage-bps"); err != nil {
+		return nil, err
+	}
+	if s.MaxSlippageBps.sign() < 0 || s.MaxSlippageBps.cmp(decimal_int(10000)) >= 0 {
+		return nil, fmt.Errorf("Error invalid --max-slippage-bps: %s, it must be from 0 to 10000", s.MaxSlippageBps)
+	}
+	if kind == "stop_loss" {
+		if s.Trigger, err = decimal_flag(c, "stop-price"); err != nil {
+			return nil, err
+		}
+		if s.Trigger.sign() <= 0 {
+			return nil, fmt.Errorf("Error invalid --stop-price: %s, it must be greater than 0", s.Trigger)
+		}
+		return s, nil
+	}
+	if s.Trail, s.TrailPercent, err = parse_trail(c.String("trail")); err != nil {
+		return nil, err
+	}
+	if s.RatchetBps, err = decimal_flag(c, "ratchet-bps"); err != nil {
+		return nil, err
+	}
+	if s.RatchetBps.sign() < 0 {
+		return nil, fmt.Errorf("Error invalid --ratchet-bps: %s, it must not be negative", s.RatchetBps)
+	}
+	return s, nil
+}
+
+// trail_trigger is the trigger of a trailing stop below the price high
+func (s *guard_state) trail_trigger(high, increment decimal) decimal {
+	trigger := high.sub(s.Trail)
+	if s.TrailPercent {
+		trigger = high.mul(decimal_int(100).sub(s.Trail)).quo(decimal_int(100))
+	}
+	if increment.sign() > 0 {
+		trigger = trigger.round_down(increment)
+	}
+	return trigger
+}
+
+// sell_request is the sell of what is left at the trigger less the slippage
+func (s *guard_state) sell_request(increment decimal) orderRequest {
+	limit := s.Trigger.mul(decimal_int(10000).sub(s.MaxSlippageBps)).quo(decimal_int(10000))
+	if increment.sign() > 0 {
+		limit = limit.round_up(increment)
+	}
+	o := orderRequest{
+		Symbol:        s.Symbol,
+		ClientOrderId: fmt.Sprintf("%s-%d", s.Id, s.Sells+1),
+		Side:          "sell",
+		Type:          orderTypeLimit,
+		Amount:        s.Amount.sub(s.Executed),
+		Price:         limit,
+		Options:       []string{optionImmediateOrCancel},
+	}
+	if s.Order == "stop-limit" {
+		o.Type, o.StopPrice, o.Options = orderTypeStopLimit, s.Trigger, nil
+	}
+	return o
+}
+
+// guard sells a position when the price falls to a trigger
+type guard struct {
+	c       *cli.Context
+	api     gemini_client
+	s       *guard_state
+	path    string
+	w       *stream_writer
+	details map[string]symbol_details
+	// the local book of --source stream and when the feed last received a
+	// message, zero while it reconnects; the book is not used once it is
+	// older than --heartbeat or feed_done is closed
+	book      *order_book
+	feed_seen time.Time
+	feed_done chan struct{}
+	// why the feed gave up, reported by run
+	feed_err chan error
+	// started by guard resume
+	resumed bool
+	// the price is below the limit of the immediate-or-cancel sell, it is
+	// reported once
+	waiting bool
+}
+
+func (g *guard) event(event string, price, amount decimal, detail string) {
+	e := guard_event{Time: time.Now().UTC(), Id: g.s.Id, Event: event, Price: price, Trigger: g.s.Trigger,
+		Amount: amount, Detail: detail}
+	if g.s.Sell != nil {
+		e.OrderId = g.s.Sell.OrderId
+	}
+	logger.Debug("func guard: event",
+		fmt.Sprintf("id:%s", e.Id),
+		fmt.Sprintf("event:%s", e.Event),
+		fmt.Sprintf("trigger:%s", e.Trigger),
+	)
+	if err := g.w.write(e); err != nil {
+		errlog.Printf("Warning %s: %s", g.s.Id, err)
+	}
+}
+
+func (g *guard) save() error {
+	g.s.Updated = time.Now().UTC()
+	return save_state(g.path, g.s)
+}
+
+func (g *guard) increment() decimal {
+	return g.details[g.s.Symbol].QuoteIncrement
+}
+
+// price is the best bid, from the local book of the market data feed or,
+// while the feed is reconnecting, quiet or gone, from the ticker
+func (g *guard) price() (decimal, error) {
+	if g.book != nil {
+		select {
+		case <-g.feed_done:
+		default:
+			g.book.mu.Lock()
+			bids := g.book.levels("bid")
+			heartbeat := g.c.Duration("heartbeat")
+			fresh := !g.feed_seen.IsZero() && (heartbeat <= 0 || time.Since(g.feed_seen) <= heartbeat)
+			g.book.mu.Unlock()
+			if len(bids) > 0 && fresh {
+				return decimal_from_float(bids[0].Price), nil
+			}
+		}
+	}
+	return ticker_price(g.api, g.s.Symbol, "sell")
+}
+
+// ticker_price reads the price a stop of side is triggered by from the
+// ticker: the best bid for a sell, the best ask for a buy
+func ticker_price(api gemini_client, symbol, side string) (decimal, error) {
+	// /v2/ticker/:symbol
+	ticker, err := api.TickerV2(symbol)
+	if err != nil {
+		return decimal{}, err
+	}
+	price, name := ticker.Bid, "bid"
+	if side == "buy" {
+		price, name = ticker.Ask, "ask"
+	}
+	if price <= 0 {
+		return decimal{}, fmt.Errorf("Error %s has no %s", symbol, name)
+	}
+	return decimal_from_float(price), nil
+}
+
+// stop_reached tells if price reached a stop: a sell stop when the price
+// falls to it, a buy stop when it rises to it
+func stop_reached(side string, price, stop decimal) bool {
+	if side == "buy" {
+		return price.cmp(stop) >= 0
+	}
+	return price.cmp(stop) <= 0
+}
+
+// place sends the sell of what is left. A pending sell, sent before a
+// restart or without an answer from Gemini, found in the active orders or
+// in the journal is not sent again
+func (g *guard) place() error {
+	o := g.s.sell_request(g.increment())
+	if g.s.Sell != nil && g.s.Sell.Status == "pending" {
+		ids, err := find_client_order(g.c, g.api, g.s.Sell.ClientOrderId)
+		if err != nil {
+			return err
+		}
+		if len(ids) > 0 {
+			g.s.Sell.OrderId, g.s.Sell.Status = ids[len(ids)-1], "live"
+			g.event("placed", g.s.Sell.Price, g.s.Sell.Amount, "found in the active orders or in the journal")
+			return g.save()
+		}
+	}
+	if err := prepare_order(g.c, g.api, &o, g.details); err != nil {
+		return err
+	}
+	// saved before it is sent, to be found after a restart
+	g.s.Sell = &oco_leg{Name: "sell", Side: o.Side, Type: o.Type, Amount: o.Amount, Price: o.Price, StopPrice: o.StopPrice,
+		Options: o.Options, ClientOrderId: o.ClientOrderId, Status: "pending"}
+	g.s.SellNotional = decimal{}
+	g.s.Sells++
+	if err := g.save(); err != nil {
+		return err
+	}
+	// /v1/order/new
+	placed, err := place_order(g.c, g.api, o)
+	if err != nil {
+		// a sell refused by Gemini was not placed, any other error, e.g. a
+		// timeout, leaves it pending to be looked up before the next one
+		if _, ok := err.(*api_error); ok {
+			g.s.Sell.Status = "cancelled"
+			if err := g.save(); err != nil {
+				errlog.Printf("Warning %s: %s", g.s.Id, err)
+			}
+		}
+		return err
+	}
+	g.s.Sell.OrderId, g.s.Sell.Status = placed.OrderId, "live"
+	g.event("placed", o.Price, o.Amount, "")
+	return g.update(placed, "immediate-or-cancel, not filled above the slippage limit")
+}
+
+// update records the fills of the sell, detail is why it was cancelled
+func (g *guard) update(o gemini.Order, detail string) error {
+	l := g.s.Sell
+	executed := decimal_from_float(o.ExecutedAmount)
+	if fill := executed.sub(l.Executed); fill.sign() > 0 {
+		// the fill is at the average price of what the sell executed since the last status
+		notional := executed.mul(decimal_from_float(o.AvgExecutionPrice)).round(reportIncrement)
+		fill_notional := notional.sub(g.s.SellNotional)
+		g.s.Executed = g.s.Executed.add(fill)
+		g.s.Notional = g.s.Notional.add(fill_notional)
+		l.Executed, g.s.SellNotional = executed, notional
+		g.event("fill", fill_notional.quo(fill).round(reportIncrement), fill, "")
+	}
+	if !o.IsLive {
+		if o.IsCancelled {
+			l.Status = "cancelled"
+			if detail == "" && l.Type == orderTypeStopLimit {
+				detail = "cancelled outside of the guard"
+			}
+			g.event("cancelled", l.Price, l.Amount.sub(l.Executed), detail)
+		} else {
+			l.Status = "filled"
+			g.event("filled", decimal_from_float(o.AvgExecutionPrice), l.Executed, "")
+		}
+	}
+	if g.s.Executed.cmp(g.s.Amount) >= 0 {
+		g.s.Status = "done"
+	}
+	return g.save()
+}
+
+// ratchet raises the trigger of a trailing stop with a new price high, the
+// stop-limit order is moved with it
+func (g *guard) ratchet(price decimal) error {
+	s := g.s
+	if s.Kind != "trailing_stop" || price.cmp(s.High) <= 0 {
+		return nil
+	}
+	s.High = price
+	trigger := s.trail_trigger(price, g.increment())
+	min := s.Trigger.mul(decimal_int(10000).add(s.RatchetBps)).quo(decimal_int(10000))
+	if !s.Trigger.is_zero() && (trigger.cmp(s.Trigger) <= 0 || trigger.cmp(min) < 0) {
+		return nil
+	}
+	if s.Order == "stop-limit" && s.Sell != nil && s.Sell.Status == "live" {
+		o, err := cancel_confirmed(g.api, s.Sell.OrderId, leg_cancel_timeout)
+		if err != nil {
+			return err
+		}
+		if err := g.update(o, "moved to the new stop "+trigger.String()); err != nil {
+			return err
+		}
+		if s.Sell.Status == "filled" || s.Status == "done" {
+			return nil
+		}
+	}
+	s.Trigger = trigger
+	g.event("ratchet", price, s.Amount.sub(s.Executed), "")
+	return g.save()
+}
+
+// step reads the price, ratchets the trigger and sells when it is hit
+func (g *guard) step() error {
+	s := g.s
+	if s.Sell != nil && s.Sell.Status == "live" {
+		// /v1/order/status
+		o, err := g.api.OrderStatus(s.Sell.OrderId)
+		if err != nil {
+			return err
+		}
+		if err := g.update(o, ""); err != nil {
+			return err
+		}
+		if s.Status == "done" {
+			return nil
+		}
+		if s.Sell.Status == "cancelled" && s.Order == "stop-limit" {
+			s.Status = "done"
+			return fmt.Errorf("Error stop-limit order %s was cancelled outside of the guard", s.Sell.OrderId)
+		}
+	}
+
+	price, err := g.price()
+	if err != nil {
+		return err
+	}
+	if err := g.ratchet(price); err != nil {
+		return err
+	}
+	if s.Status == "done" {
+		return nil
+	}
+	if s.Order == "stop-limit" {
+		// the stop-limit order stays on the book, Gemini triggers it
+		if s.Sell == nil || s.Sell.Status != "live" {
+			return g.place()
+		}
+		return nil
+	}
+	if s.Status == "watching" && stop_reached("sell", price, s.Trigger) {
+		s.Status = "triggered"
+		g.event("triggered", price, s.Amount, "")
+		if err := g.save(); err != nil {
+			return err
+		}
+	}
+	// an immediate-or-cancel sell is sent for what is left while the price
+	// is at or above its limit, below it the guard waits
+	if s.Status == "triggered" {
+		limit := s.sell_request(g.increment()).Price
+		if price.cmp(limit) < 0 {
+			if !g.waiting {
+				g.waiting = true
+				g.event("waiting", price, s.Amount.sub(s.Executed), "the bid is below the limit "+limit.String())
+			}
+			return nil
+		}
+		g.waiting = false
+		return g.place()
+	}
+	return nil
+}
+
+// watch keeps the book of --source stream up to date, the ticker is read
+// while the feed reconnects and again if it gives up
+func (g *guard) watch(ctx context.Context) error {
+	book := new_order_book(g.s.Symbol)
+	feed, handle, err := book_feed(g.c, book)
+	if err != nil {
+		return err
+	}
+	// a dropped connection leaves the last bids in the book, they are not
+	// read until the next connection sends the book again
+	feed.disconnected = func() {
+		book.mu.Lock()
+		book.seed(gemini.Book{})
+		g.feed_seen = time.Time{}
+		book.mu.Unlock()
+	}
+	seen := func(msg []byte) error {
+		if err := handle(msg); err != nil {
+			return err
+		}
+		book.mu.Lock()
+		g.feed_seen = time.Now()
+		book.mu.Unlock()
+		return nil
+	}
+	g.book, g.feed_done, g.feed_err = book, make(chan struct{}), make(chan error, 1)
+	go func() {
+		defer close(g.feed_done)
+		if err := feed.run(ctx, seen); err != nil {
+			g.feed_err <- err
+		}
+	}()
+	return nil
+}
+
+// run calls step every --poll until the position is sold or Ctrl-C, a
+// failed step is reported and tried again at the next poll
+func (g *guard) run() error {
+	ctx, cancel := interrupt_context(context.Background())
+	defer cancel()
+
+	poll := g.c.Duration("poll")
+	if poll <= 0 {
+		return fmt.Errorf("Error invalid --poll: %s, it must be greater than 0", poll)
+	}
+	if _, ok := g.details[g.s.Symbol]; !ok {
+		// /v1/symbols/details/:symbol
+		d, err := g.api.SymbolDetails(g.s.Symbol)
+		if err != nil {
+			return err
+		}
+		g.details[g.s.Symbol] = d
+	}
+	if g.s.Source == "stream" {
+		if err := g.watch(ctx); err != nil {
+			return err
+		}
+	}
+	event := "started"
+	if g.resumed {
+		event = "resumed"
+	}
+	g.event(event, g.s.High, g.s.Amount.sub(g.s.Executed), g.path)
+	for {
+		if err := g.step(); err != nil {
+			g.event("error", decimal{}, decimal{}, one_line(err))
+			if g.s.Status == "done" {
+				g.save()
+				return err
+			}
+		}
+		if g.s.Status == "done" {
+			avg := decimal{}
+			if g.s.Executed.sign() > 0 {
+				avg = g.s.Notional.quo(g.s.Executed).round(reportIncrement)
+			}
+			g.event("done", avg, g.s.Executed, "")
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			errlog.Printf("guard %s: stopped, resume with: gemini_cli guard resume --state %s", g.s.Id, g.path)
+			if g.s.Sell != nil && g.s.Sell.Status == "live" {
+				errlog.Printf("guard %s: stop-limit order %s stays on the book", g.s.Id, g.s.Sell.OrderId)
+			}
+			return nil
+		case err := <-g.feed_err:
+			g.event("error", decimal{}, decimal{}, "reading the ticker, "+one_line(err))
+		case <-time.After(poll):
+		}
+	}
+}
+
+func guard_summary(c *cli.Context, s *guard_state, path string) []summary_field {
+	quote := quote_currency(s.Symbol)
+	fields := append(environment_summary(c),
+		summary_field{"symbol", s.Symbol},
+		summary_field{"id", s.Id},
+		summary_field{"sell", fmt.Sprintf("%s when the bid falls to %s %s", s.Amount, s.Trigger, quote)},
+	)
+	if s.Kind == "trailing_stop" {
+		trail := s.Trail.String() + " " + quote
+		if s.TrailPercent {
+			trail = s.Trail.String() + "%"
+		}
+		fields = append(fields, summary_field{"trail", fmt.Sprintf("%s below the highest bid, now %s", trail, s.High)})
+	}
+	return append(fields,
+		summary_field{"order", fmt.Sprintf("%s, at most %s bps below the stop", s.Order, s.MaxSlippageBps)},
+		summary_field{"source", s.Source},
+		summary_field{"state", path},
+	)
+}
+
+// run_guard starts a trailing stop or a stop loss and watches it
+func run_guard(c *cli.Context, api gemini_client, kind string) error {
+	s, err := new_guard_state(c, kind)
+	if err != nil {
+		return err
+	}
+	path, err := state_path(c, s.Id)
+	if err != nil {
+		return err
+	}
+	g := &guard{c: c, api: api, s: s, path: path, w: new_stream_writer(c), details: map[string]symbol_details{}}
+	// /v1/symbols/details/:symbol
+	d, err := api.SymbolDetails(s.Symbol)
+	if err != nil {
+		return err
+	}
+	g.details[s.Symbol] = d
+	if kind == "trailing_stop" {
+		if s.High, err = g.price(); err != nil {
+			return err
+		}
+		s.Trigger = s.trail_trigger(s.High, d.QuoteIncrement)
+	}
+	// the sell is checked like order new before the guard starts
+	o := s.sell_request(d.QuoteIncrement)
+	if err := prepare_order(c, api, &o, g.details); err != nil {
+		return err
+	}
+
+	if c.Bool("dry-run") {
+		return dry_run(c, api, new_order_URI, new_order_params(o))
+	}
+	if err := new_state_file(path); err != nil {
+		return err
+	}
+	if err := confirm(c, "Guard a position", guard_summary(c, s, path)); err != nil {
+		return err
+	}
+	if err := g.save(); err != nil {
+		return err
+	}
+	return g.run()
+}
+
+// run_guard_resume resumes the guard of --state after a restart
+func run_guard_resume(c *cli.Context, api gemini_client) error {
+	path := c.String("state")
+	var s guard_state
+	if err := load_state(path, &s); err != nil {
+		return err
+	}
+	if s.Kind != "trailing_stop" && s.Kind != "stop_loss" {
+		return fmt.Errorf("Error state file %s is not a guard", path)
+	}
+	if err := check_state_scope(c, path, s.Url, s.Account); err != nil {
+		return err
+	}
+	if s.Status == "done" {
+		return fmt.Errorf("Error guard %s is done, there is nothing to resume", s.Id)
+	}
+	g := &guard{c: c, api: api, s: &s, path: path, w: new_stream_writer(c), details: map[string]symbol_details{}, resumed: true}
+	return g.run()
+}
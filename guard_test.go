@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/claudiocandio/gemini-api"
+	"github.com/gorilla/websocket"
+)
+
+func guard_events(t *testing.T, out string) []string {
+	t.Helper()
+	var e guard_event
+	return jsonl_lines(t, out, &e, func() string {
+		return e.Event + " " + e.Trigger.String() + " " + e.Amount.String()
+	})
+}
+
+func TestGuardTrailingStop(t *testing.T) {
+	server := use_fake_gemini(t)
+	// the bid rises to 120 and falls below the 4% trail
+	server.tickerBids = []float64{100, 110, 120, 115}
+	server.book.Bids = gemini.BookEntries{{Price: 115, Amount: 1}, {Price: 114.8, Amount: 2}, {Price: 114, Amount: 5}}
+	path := filepath.Join(t.TempDir(), "g1.json")
+	out, err := run_app(t, nil, "-y", "-o", "jsonl", "guard", "trailing-stop", "-t", "btcusd", "-a", "3", "--trail", "4%",
+		"-i", "g1", "--state", path, "--poll", "10ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check_events(t, guard_events(t, out), []string{
+		"started 96 3",
+		"ratchet 105.6 3",
+		"ratchet 115.2 3",
+		"triggered 115.2 3",
+		"placed 115.2 3",
+		"fill 115.2 3",
+		"filled 115.2 3",
+		"done 115.2 3",
+	})
+	// an immediate-or-cancel sell at most 50 bps below the stop
+	if len(server.orders) != 1 {
+		t.Fatalf("%d orders", len(server.orders))
+	}
+	if o := server.orders[0]; o.Side != "sell" || o.Price != 114.63 || o.OriginalAmount != 3 ||
+		!contains(o.Options, optionImmediateOrCancel) || o.ClientOrderId != "g1-1" {
+		t.Errorf("sell: %+v", o)
+	}
+	var s guard_state
+	read_state(t, path, &s)
+	if s.Status != "done" || s.High.String() != "120" || s.Executed.String() != "3" || s.Notional.String() != "344.6" {
+		t.Errorf("state %+v", s)
+	}
+}
+
+func TestGuardStopLossResume(t *testing.T) {
+	server := use_fake_gemini(t)
+	if _, err := run_app(t, nil, "-y", "order", "new", "-t", "btcusd", "-s", "sell", "-a", "0.5", "-p", "94",
+		"--type", "stop-limit", "--stop_price", "95", "-i", "g2-1"); err != nil {
+		t.Fatal(err)
+	}
+	// the guard stopped after sending its stop-limit order, before saving its order id
+	s := guard_state{Kind: "stop_loss", Id: "g2", Url: server.URL, Symbol: "btcusd", Amount: dec("0.5"), Trigger: dec("95"),
+		Order: "stop-limit", MaxSlippageBps: dec("100"), Source: "ticker", Sells: 1, Status: "watching",
+		Sell: &oco_leg{Name: "sell", Side: "sell", Type: orderTypeStopLimit, Amount: dec("0.5"), Price: dec("94.05"),
+			StopPrice: dec("95"), ClientOrderId: "g2-1", Status: "pending"}}
+	path := filepath.Join(t.TempDir(), "g2.json")
+	if err := save_state(path, s); err != nil {
+		t.Fatal(err)
+	}
+	server.onStatus = func(o *gemini.Order) {
+		fill_order(o, 0.25)
+	}
+	out, err := run_app(t, nil, "-o", "jsonl", "guard", "resume", "--state", path, "--poll", "10ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check_events(t, guard_events(t, out), []string{
+		"resumed 95 0.5",
+		"placed 95 0.5",
+		"fill 95 0.25",
+		"fill 95 0.25",
+		"filled 95 0.5",
+		"done 95 0.5",
+	})
+	if len(server.orders) != 1 {
+		t.Errorf("%d orders, the stop-limit order was placed again", len(server.orders))
+	}
+	var resumed guard_state
+	read_state(t, path, &resumed)
+	if resumed.Status != "done" || resumed.Sell.OrderId != "1001" || resumed.Executed.String() != "0.5" {
+		t.Errorf("state %+v", resumed)
+	}
+	if _, err := run_app(t, nil, "guard", "resume", "--state", path); err == nil || !strings.Contains(err.Error(), "is done") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestGuardIocWaits(t *testing.T) {
+	server := use_fake_gemini(t)
+	// the bid falls through the stop and the 94.53 limit, then comes back
+	server.tickerBids = []float64{100, 90, 90, 94.6}
+	server.book.Bids = gemini.BookEntries{{Price: 94.6, Amount: 2}}
+	out, err := run_app(t, nil, "-y", "-o", "jsonl", "guard", "stop-loss", "-t", "btcusd", "-a", "1", "--stop-price", "95",
+		"-i", "g6", "--state", filepath.Join(t.TempDir(), "g6.json"), "--poll", "10ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check_events(t, guard_events(t, out), []string{
+		"started 95 1",
+		"triggered 95 1",
+		"waiting 95 1",
+		"placed 95 1",
+		"fill 95 1",
+		"filled 95 1",
+		"done 95 1",
+	})
+	if len(server.orders) != 1 || server.orders[0].Price != 94.53 {
+		t.Errorf("orders %+v", server.orders)
+	}
+}
+
+func TestGuardSendTimeout(t *testing.T) {
+	server := use_fake_gemini(t)
+	// the stop-limit order is placed but its reply is lost
+	server.dropReplies = 1
+	server.onStatus = func(o *gemini.Order) {
+		fill_order(o, 1)
+	}
+	path := filepath.Join(t.TempDir(), "g5.json")
+	out, err := run_app(t, nil, "-y", "-o", "jsonl", "guard", "stop-loss", "-t", "btcusd", "-a", "1", "--stop-price", "95",
+		"--order", "stop-limit", "-i", "g5", "--state", path, "--poll", "10ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check_events(t, guard_events(t, out), []string{
+		"started 95 1",
+		"error 95 0",
+		"placed 95 1",
+		"fill 95 1",
+		"filled 95 1",
+		"done 95 1",
+	})
+	if len(server.orders) != 1 {
+		t.Errorf("%d orders, the stop-limit order was sent again", len(server.orders))
+	}
+	var s guard_state
+	read_state(t, path, &s)
+	if s.Sells != 1 || s.Sell.OrderId != "1001" {
+		t.Errorf("state %+v", s)
+	}
+}
+
+func TestGuardStreamDrop(t *testing.T) {
+	server := use_fake_gemini(t)
+	saved_backoff := ws_backoff
+	ws_backoff = 50 * time.Millisecond
+	t.Cleanup(func() { ws_backoff = saved_backoff })
+	// the feed shows a bid of 100 and drops for good, while the ticker bid
+	// falls through the stop
+	server.marketdata = func(conn *websocket.Conn, n int) {
+		if n == 1 {
+			send(conn, `{"type":"l2_updates","symbol":"BTCUSD","changes":[["buy","100","1"],["sell","101","1"]]}`)
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+	server.tickerBids = []float64{100, 94.8}
+	server.book.Bids = gemini.BookEntries{{Price: 94.8, Amount: 2}}
+	out, err := run_app(t, nil, "-y", "-o", "jsonl", "guard", "stop-loss", "-t", "btcusd", "-a", "1", "--stop-price", "95",
+		"--source", "stream", "-i", "g4", "--state", filepath.Join(t.TempDir(), "g4.json"), "--poll", "10ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check_events(t, guard_events(t, out), []string{
+		"started 95 1",
+		"triggered 95 1",
+		"placed 95 1",
+		"fill 95 1",
+		"filled 95 1",
+		"done 95 1",
+	})
+	if len(server.orders) != 1 || server.orders[0].Price != 94.53 {
+		t.Errorf("orders %+v", server.orders)
+	}
+}
+
+func TestGuardDryRun(t *testing.T) {
+	use_fake_gemini(t)
+	out, err := run_app(t, nil, "--dry-run", "guard", "stop-loss", "-t", "btcusd", "-a", "1", "--stop-price", "90",
+		"--order", "stop-limit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r signed_request
+	if err := json.Unmarshal([]byte(out), &r); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	var payload map[string]interface{}
+	if err := json.Unmarshal(r.Payload, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload["type"] != orderTypeStopLimit || payload["stop_price"] != "90" || payload["price"] != "89.55" {
+		t.Errorf("payload %s", r.Payload)
+	}
+	if _, err := run_app(t, nil, "--dry-run", "guard", "trailing-stop", "-t", "btcusd", "-a", "1", "--trail", "100%"); err == nil {
+		t.Error("a 100% trail was accepted")
+	}
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/claudiocandio/gemini-api/logger"
+	"github.com/urfave/cli/v2"
+)
+
+// iceberg_clip is the visible order of an iceberg
+type iceberg_clip struct {
+	n             int
+	clientOrderId string
+	orderId       string
+	amount        decimal
+	price         decimal
+	executed      decimal
+	notional      decimal
+}
+
+// iceberg_event is a line of the fill log of order iceberg
+type iceberg_event struct {
+	Time    time.Time `json:"time"`
+	Id      string    `json:"id"`
+	Clip    int       `json:"clip"`
+	OrderId string    `json:"order_id"`
+	// placed, rejected, fill, filled, cancelled, error or done
+	Event  string  `json:"event"`
+	Amount decimal `json:"amount"`
+	Price  decimal `json:"price"`
+	// executed by the iceberg so far and its average price
+	Executed decimal `json:"executed_amount"`
+	AvgPrice decimal `json:"avg_price"`
+	Detail   string  `json:"detail"`
+}
+
+// iceberg_flags are the flags of order iceberg
+func iceberg_flags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "ticker",
+			Aliases: []string{"t"},
+			Usage:   "e.g. --ticker btcusd",
+		},
+		&cli.StringFlag{
+			Name:     "side",
+			Aliases:  []string{"s"},
+			Usage:    "--side buy|sell",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:     "total",
+			Usage:    "e.g. --total 10 - Total amount of the iceberg",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:     "display",
+			Usage:    "e.g. --display 0.5 - Amount of the clip visible on the book",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:     "price",
+			Aliases:  []string{"p"},
+			Usage:    "e.g. --price 30000 - Limit price of the clips",
+			Required: true,
+		},
+		&cli.BoolFlag{
+			Name:  "maker-or-cancel",
+			Usage: "Post the clips maker-or-cancel, a clip that would take liquidity is posted again at the next poll",
+		},
+		&cli.StringFlag{
+			Name:  "randomize-bps",
+			Usage: "e.g. --randomize-bps 5 - Move the price of every clip a random 0 to 5 bps away from --price, never through it",
+		},
+		&cli.StringFlag{
+			Name:    "client-order-id",
+			Aliases: []string{"client_order_id", "i"},
+			Usage:   "e.g. --client-order-id mybot-9 - The clips are mybot-9-1, mybot-9-2...",
+		},
+		&cli.BoolFlag{
+			Name:  "round",
+			Usage: "Round the amounts down to the tick size and the prices to the quote increment, as order new --round",
+		},
+		&cli.DurationFlag{
+			Name:  "poll",
+			Usage: "e.g. --poll 2s - How often the status of the visible clip is read",
+			Value: 2 * time.Second,
+		},
+	}
+}
+
+// iceberg shows --display of --total on the book at a time
+type iceberg struct {
+	c       *cli.Context
+	api     gemini_client
+	w       *stream_writer
+	rnd     *rand.Rand
+	details map[string]symbol_details
+
+	id              string
+	symbol          string
+	side            string
+	total           decimal
+	display         decimal
+	price           decimal
+	randomize_bps   decimal
+	maker_or_cancel bool
+
+	clips int
+	clip  *iceberg_clip
+	// a clip sent without an answer from Gemini, it is looked up before
+	// the next one is posted
+	unanswered *orderRequest
+	executed   decimal
+	notional   decimal
+	// why the iceberg stopped before --total was executed
+	stopped string
+}
+
+// new_iceberg checks the flags of order iceberg
+func new_iceberg(c *cli.Context, api gemini_client) (*iceberg, error) {
+	symbol, err := get_ticker_flag(c)
+	if err != nil {
+		return nil, err
+	}
+	ib := &iceberg{c: c, api: api, w: new_stream_writer(c), rnd: rand.New(rand.NewSource(time.Now().UnixNano())),
+		details: map[string]symbol_details{}, id: c.String("client-order-id"), symbol: strings.ToLower(symbol),
+		side: c.String("side"), maker_or_cancel: c.Bool("maker-or-cancel")}
+	if ib.side != "buy" && ib.side != "sell" {
+		return nil, fmt.Errorf("Error invalid side: %s\nValid sides: buy, sell", ib.side)
+	}
+	if ib.total, err = decimal_flag(c, "total"); err != nil {
+		return nil, err
+	}
+	if ib.display, err = decimal_flag(c, "display"); err != nil {
+		return nil, err
+	}
+	if ib.price, err = decimal_flag(c, "price"); err != nil {
+		return nil, err
+	}
+	if ib.randomize_bps, err = decimal_flag(c, "randomize-bps"); err != nil {
+		return nil, err
+	}
+	switch {
+	case ib.total.sign() <= 0:
+		return nil, fmt.Errorf("Error invalid --total: %s, it must be greater than 0", ib.total)
+	case ib.display.sign() <= 0 || ib.display.cmp(ib.total) > 0:
+		return nil, fmt.Errorf("Error invalid --display: %s, it must be greater than 0 and at most --total %s", ib.display, ib.total)
+	case ib.randomize_bps.sign() < 0:
+		return nil, fmt.Errorf("Error invalid --randomize-bps: %s, it must not be negative", ib.randomize_bps)
+	}
+	if c.Duration("poll") <= 0 {
+		return nil, fmt.Errorf("Error invalid --poll: %s, it must be greater than 0", c.Duration("poll"))
+	}
+	if ib.id == "" {
+		ib.id = fmt.Sprintf("iceberg-%d", time.Now().UnixNano()/1e6)
+	}
+	// the first clip is checked like order new, it also checks --price
+	o := ib.request(ib.display, ib.price)
+	if err := prepare_order(c, api, &o, ib.details); err != nil {
+		return nil, err
+	}
+	ib.display, ib.price = o.Amount, o.Price
+	return ib, nil
+}
+
+func (ib *iceberg) request(amount, price decimal) orderRequest {
+	o := orderRequest{
+		Symbol:        ib.symbol,
+		ClientOrderId: fmt.Sprintf("%s-%d", ib.id, ib.clips+1),
+		Side:          ib.side,
+		Type:          orderTypeLimit,
+		Amount:        amount,
+		Price:         price,
+	}
+	if ib.maker_or_cancel {
+		o.Options = []string{optionMakerOrCancel}
+	}
+	return o
+}
+
+// clip_amount is the amount of the next clip: --display, or what is left
+// when the rest would be below the minimum order size
+func (ib *iceberg) clip_amount() decimal {
+	left := ib.total.sub(ib.executed)
+	if left.cmp(ib.display) <= 0 {
+		return left
+	}
+	if d, ok := ib.details[ib.symbol]; ok && left.sub(ib.display).cmp(d.MinOrderSize) < 0 {
+		return left
+	}
+	return ib.display
+}
+
+// clip_price moves --price a random 0 to --randomize-bps away from the
+// book: lower for a buy, higher for a sell
+func (ib *iceberg) clip_price() decimal {
+	if ib.randomize_bps.is_zero() {
+		return ib.price
+	}
+	move := ib.price.mul(ib.randomize_bps).mul(decimal_from_float(ib.rnd.Float64())).quo(decimal_int(10000))
+	inc := ib.details[ib.symbol].QuoteIncrement
+	if ib.side == "buy" {
+		price := ib.price.sub(move)
+		if inc.sign() > 0 {
+			price = price.round_down(inc)
+		}
+		return price
+	}
+	price := ib.price.add(move)
+	if inc.sign() > 0 {
+		price = price.round_up(inc)
+	}
+	return price
+}
+
+func (ib *iceberg) event(event string, amount, price decimal, detail string) {
+	e := iceberg_event{Time: time.Now().UTC(), Id: ib.id, Event: event, Amount: amount, Price: price,
+		Executed: ib.executed, Detail: detail}
+	if ib.clip != nil {
+		e.Clip, e.OrderId = ib.clip.n, ib.clip.orderId
+	}
+	if ib.executed.sign() > 0 {
+		e.AvgPrice = ib.notional.quo(ib.executed).round(reportIncrement)
+	}
+	logger.Debug("func iceberg: event",
+		fmt.Sprintf("id:%s", e.Id),
+		fmt.Sprintf("clip:%d", e.Clip),
+		fmt.Sprintf("event:%s", e.Event),
+	)
+	if err := ib.w.write(e); err != nil {
+		errlog.Printf("Warning %s: %s", ib.id, err)
+	}
+}
+
+// place posts the next clip, a maker-or-cancel clip that would have taken
+// liquidity is cancelled at once and posted again at the next poll
+func (ib *iceberg) place() error {
+	if u := ib.unanswered; u != nil {
+		ids, err := find_client_order(ib.c, ib.api, u.ClientOrderId)
+		if err != nil {
+			return err
+		}
+		ib.unanswered = nil
+		if len(ids) > 0 {
+			ib.clips++
+			ib.clip = &iceberg_clip{n: ib.clips, clientOrderId: u.ClientOrderId, orderId: ids[len(ids)-1], amount: u.Amount, price: u.Price}
+			ib.event("placed", u.Amount, u.Price, "found in the active orders or in the journal")
+			return nil
+		}
+	}
+	o := ib.request(ib.clip_amount(), ib.clip_price())
+	if err := prepare_order(ib.c, ib.api, &o, ib.details); err != nil {
+		ib.stopped = fmt.Sprintf("clip %d was refused: %s", ib.clips+1, one_line(err))
+		return err
+	}
+	// /v1/order/new
+	placed, err := place_order(ib.c, ib.api, o)
+	if err != nil {
+		// a rate limit is tried again and a clip without an answer, e.g.
+		// after a timeout, is looked up first; any other refusal, e.g.
+		// InsufficientFunds or a symbol in cancel_only, stops the iceberg
+		e, ok := err.(*api_error)
+		switch {
+		case !ok:
+			ib.unanswered = &o
+		case !e.retryable():
+			ib.stopped = fmt.Sprintf("clip %d was refused: %s", ib.clips+1, one_line(err))
+		}
+		return err
+	}
+	ib.clips++
+	ib.clip = &iceberg_clip{n: ib.clips, clientOrderId: o.ClientOrderId, orderId: placed.OrderId, amount: o.Amount, price: o.Price}
+	if ib.maker_or_cancel && !placed.IsLive && placed.ExecutedAmount == 0 {
+		ib.event("rejected", o.Amount, o.Price, "maker-or-cancel, the clip would have taken liquidity")
+		ib.clip = nil
+		return nil
+	}
+	ib.event("placed", o.Amount, o.Price, "")
+	ib.update(placed.ExecutedAmount, placed.AvgExecutionPrice, placed.IsLive, placed.IsCancelled, "cancelled by the exchange")
+	return nil
+}
+
+// update records the fills of the visible clip, when it is no longer live
+// the next one can be posted. A clip cancelled by someone else stops the
+// iceberg with detail
+func (ib *iceberg) update(executed, avg_price float64, live, cancelled bool, detail string) {
+	l := ib.clip
+	total := decimal_from_float(executed)
+	notional := total.mul(decimal_from_float(avg_price)).round(reportIncrement)
+	if fill := total.sub(l.executed); fill.sign() > 0 {
+		// the fill is at the average price of what the clip executed since the last status
+		price := notional.sub(l.notional).quo(fill).round(reportIncrement)
+		ib.executed = ib.executed.add(fill)
+		ib.notional = ib.notional.add(notional.sub(l.notional))
+		l.executed, l.notional = total, notional
+		ib.event("fill", fill, price, "")
+	}
+	if live {
+		return
+	}
+	if cancelled {
+		ib.event("cancelled", l.amount.sub(l.executed), l.price, detail)
+		ib.stopped = "a clip was " + detail
+	} else {
+		ib.event("filled", l.executed, decimal_from_float(avg_price), "")
+	}
+	ib.clip = nil
+}
+
+// step posts a clip when none is on the book, or reads the status of the
+// visible one
+func (ib *iceberg) step() error {
+	if ib.clip == nil {
+		return ib.place()
+	}
+	// /v1/order/status
+	o, err := ib.api.OrderStatus(ib.clip.orderId)
+	if err != nil {
+		return err
+	}
+	ib.update(o.ExecutedAmount, o.AvgExecutionPrice, o.IsLive, o.IsCancelled, "cancelled outside of order iceberg")
+	return nil
+}
+
+// run posts the clips every --poll until --total is executed, a clip is
+// cancelled by someone else or refused, or Ctrl-C, which cancels the visible
+// clip. Any other failed step is reported and tried again at the next poll
+func (ib *iceberg) run() error {
+	ctx, cancel := interrupt_context(context.Background())
+	defer cancel()
+
+	for {
+		if err := ib.step(); err != nil {
+			ib.event("error", decimal{}, decimal{}, one_line(err))
+		}
+		if ib.executed.cmp(ib.total) >= 0 || ib.stopped != "" {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			if ib.clip != nil {
+				// /v1/order/cancel
+				o, err := cancel_confirmed(ib.api, ib.clip.orderId, leg_cancel_timeout)
+				if err != nil {
+					ib.event("error", decimal{}, decimal{}, one_line(err))
+					return fmt.Errorf("%s, clip %s may still be on the book", err, ib.clip.clientOrderId)
+				}
+				ib.update(o.ExecutedAmount, o.AvgExecutionPrice, o.IsLive, o.IsCancelled, "Ctrl-C")
+			} else if ib.unanswered != nil {
+				errlog.Printf("Warning %s: clip %s got no answer, it may be on the book", ib.id, ib.unanswered.ClientOrderId)
+			}
+			if ib.executed.cmp(ib.total) < 0 {
+				ib.stopped = "Ctrl-C"
+			}
+		case <-time.After(ib.c.Duration("poll")):
+		}
+		if ib.stopped != "" || ib.executed.cmp(ib.total) >= 0 {
+			break
+		}
+	}
+	ib.event("done", ib.executed, decimal{}, ib.stopped)
+	if ib.stopped != "" && ib.stopped != "Ctrl-C" {
+		return fmt.Errorf("Error iceberg %s stopped, %s: %s of %s executed", ib.id, ib.stopped, ib.executed, ib.total)
+	}
+	return nil
+}
+
+// run_iceberg executes --total in clips of --display, only one clip is on
+// the book at a time and the next is posted when it is filled
+func run_iceberg(c *cli.Context, api gemini_client) error {
+	ib, err := new_iceberg(c, api)
+	if err != nil {
+		return err
+	}
+	if c.Bool("dry-run") {
+		return dry_run(c, api, new_order_URI, new_order_params(ib.request(ib.clip_amount(), ib.price)))
+	}
+	summary := append(environment_summary(c),
+		summary_field{"symbol", ib.symbol},
+		summary_field{"id", ib.id},
+		summary_field{"side", ib.side},
+		summary_field{"total", fmt.Sprintf("%s @ %s %s", ib.total, ib.price, quote_currency(ib.symbol))},
+		summary_field{"display", ib.display.String()},
+	)
+	if ib.maker_or_cancel {
+		summary = append(summary, summary_field{"options", optionMakerOrCancel})
+	}
+	if ib.randomize_bps.sign() > 0 {
+		summary = append(summary, summary_field{"randomize", ib.randomize_bps.String() + " bps"})
+	}
+	if err := confirm(c, "Place an iceberg order", summary); err != nil {
+		return err
+	}
+	errlog.Printf("iceberg %s: Ctrl-C cancels the visible clip and stops", ib.id)
+	return ib.run()
+}
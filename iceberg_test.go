@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/claudiocandio/gemini-api"
+)
+
+func iceberg_events(t *testing.T, out string) []string {
+	t.Helper()
+	var e iceberg_event
+	return jsonl_lines(t, out, &e, func() string {
+		return fmt.Sprintf("%d %s %s", e.Clip, e.Event, e.Amount)
+	})
+}
+
+func TestOrderIceberg(t *testing.T) {
+	server := use_fake_gemini(t)
+	// every clip fills in two halves
+	server.onStatus = func(o *gemini.Order) {
+		fill_order(o, o.OriginalAmount/2)
+	}
+	out, err := run_app(t, nil, "-y", "-o", "jsonl", "order", "iceberg", "-t", "btcusd", "-s", "buy", "--total", "1",
+		"--display", "0.4", "--price", "100", "--maker-or-cancel", "-i", "ice1", "--poll", "10ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check_events(t, iceberg_events(t, out), []string{
+		"1 placed 0.4", "1 fill 0.2", "1 fill 0.2", "1 filled 0.4",
+		"2 placed 0.4", "2 fill 0.2", "2 fill 0.2", "2 filled 0.4",
+		"3 placed 0.2", "3 fill 0.1", "3 fill 0.1", "3 filled 0.2",
+		"0 done 1",
+	})
+	// only one clip was ever on the book
+	var ids []string
+	for _, o := range server.orders {
+		if len(o.Options) != 1 || o.Options[0] != optionMakerOrCancel || o.Price != 100 {
+			t.Errorf("clip: %+v", o)
+		}
+		ids = append(ids, o.ClientOrderId)
+	}
+	if strings.Join(ids, ",") != "ice1-1,ice1-2,ice1-3" {
+		t.Errorf("clips: %v", ids)
+	}
+}
+
+func TestOrderIcebergMakerOrCancel(t *testing.T) {
+	server := use_fake_gemini(t)
+	server.onStatus = func(o *gemini.Order) {
+		fill_order(o, o.OriginalAmount)
+	}
+	// the clips at 101 take the best ask until it moves away
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		server.mu.Lock()
+		server.book.Asks = gemini.BookEntries{{Price: 102, Amount: 2}}
+		server.mu.Unlock()
+	}()
+	out, err := run_app(t, nil, "-y", "-o", "jsonl", "order", "iceberg", "-t", "btcusd", "-s", "buy", "--total", "0.5",
+		"--display", "0.5", "--price", "101", "--maker-or-cancel", "-i", "ice2", "--poll", "10ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := iceberg_events(t, out)
+	if len(events) < 5 || !strings.HasSuffix(events[0], " rejected 0.5") {
+		t.Fatalf("events: %v", events)
+	}
+	check_events(t, events[len(events)-4:], []string{
+		fmt.Sprintf("%d placed 0.5", len(server.orders)), fmt.Sprintf("%d fill 0.5", len(server.orders)),
+		fmt.Sprintf("%d filled 0.5", len(server.orders)), "0 done 0.5",
+	})
+}
+
+func TestOrderIcebergCancelledOutside(t *testing.T) {
+	server := use_fake_gemini(t)
+	server.onStatus = func(o *gemini.Order) {
+		if o.ClientOrderId == "ice3-2" {
+			fill_order(o, 0.1)
+			o.IsLive, o.IsCancelled = false, true
+			return
+		}
+		fill_order(o, o.OriginalAmount)
+	}
+	out, err := run_app(t, nil, "-y", "-o", "jsonl", "order", "iceberg", "-t", "btcusd", "-s", "sell", "--total", "1",
+		"--display", "0.4", "--price", "105", "--randomize-bps", "10", "-i", "ice3", "--poll", "10ms")
+	if err == nil || !strings.Contains(err.Error(), "0.5 of 1 executed") {
+		t.Fatalf("err: %v", err)
+	}
+	check_events(t, iceberg_events(t, out), []string{
+		"1 placed 0.4", "1 fill 0.4", "1 filled 0.4",
+		"2 placed 0.4", "2 fill 0.1", "2 cancelled 0.3",
+		"0 done 0.5",
+	})
+	// a sell clip is randomized above --price, at most 10 bps
+	for _, o := range server.orders {
+		if o.Price < 105 || o.Price > 105.11 {
+			t.Errorf("clip price %v", o.Price)
+		}
+	}
+}
+
+func TestOrderIcebergRefused(t *testing.T) {
+	server := use_fake_gemini(t)
+	// the first clip is rate limited and tried again, the second one is
+	// refused for good once the first is filled
+	server.rateLimited = 1
+	server.onStatus = func(o *gemini.Order) {
+		fill_order(o, o.OriginalAmount)
+		server.orderRefused = "InsufficientFunds"
+	}
+	out, err := run_app(t, nil, "-y", "-o", "jsonl", "order", "iceberg", "-t", "btcusd", "-s", "buy", "--total", "1",
+		"--display", "0.4", "--price", "100", "-i", "ice4", "--poll", "10ms")
+	if err == nil || !strings.Contains(err.Error(), "clip 2 was refused") || !strings.Contains(err.Error(), "0.4 of 1 executed") {
+		t.Fatalf("err: %v", err)
+	}
+	check_events(t, iceberg_events(t, out), []string{
+		"0 error 0",
+		"1 placed 0.4", "1 fill 0.4", "1 filled 0.4",
+		"0 error 0",
+		"0 done 0.4",
+	})
+	if len(server.orders) != 1 {
+		t.Errorf("%d orders", len(server.orders))
+	}
+}
+
+func TestOrderIcebergSendTimeout(t *testing.T) {
+	server := use_fake_gemini(t)
+	// the first clip is placed but its reply is lost
+	server.dropReplies = 1
+	server.onStatus = func(o *gemini.Order) {
+		fill_order(o, o.OriginalAmount)
+	}
+	out, err := run_app(t, nil, "-y", "-o", "jsonl", "order", "iceberg", "-t", "btcusd", "-s", "buy", "--total", "0.8",
+		"--display", "0.4", "--price", "100", "-i", "ice5", "--poll", "10ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check_events(t, iceberg_events(t, out), []string{
+		"0 error 0",
+		"1 placed 0.4", "1 fill 0.4", "1 filled 0.4",
+		"2 placed 0.4", "2 fill 0.4", "2 filled 0.4",
+		"0 done 0.8",
+	})
+	var ids []string
+	for _, o := range server.orders {
+		ids = append(ids, o.ClientOrderId)
+	}
+	if strings.Join(ids, ",") != "ice5-1,ice5-2" {
+		t.Errorf("clips: %v, the first one was posted again", ids)
+	}
+}
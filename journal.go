@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/claudiocandio/gemini-api"
+	"github.com/urfave/cli/v2"
+)
+
+// journal_entry is one order placed by gemini_cli, the journal finds the
+// orders of a client_order_id after they are no longer active
+type journal_entry struct {
+	Time time.Time `json:"time"`
+	// REST base url and account the order was placed on
+	Url           string  `json:"url"`
+	Account       string  `json:"account"`
+	OrderId       string  `json:"order_id"`
+	ClientOrderId string  `json:"client_order_id"`
+	Symbol        string  `json:"symbol"`
+	Side          string  `json:"side"`
+	Type          string  `json:"type"`
+	Amount        decimal `json:"amount"`
+	Price         decimal `json:"price"`
+}
+
+func default_journal_path() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "gemini_cli", "journal.jsonl")
+}
+
+// journal_scope returns the journal file, the url and the account of the profile
+func journal_scope(c *cli.Context) (string, string, string, error) {
+	s, err := get_session(c)
+	if err != nil {
+		return "", "", "", err
+	}
+	p, err := s.Profile()
+	if err != nil {
+		return "", "", "", err
+	}
+	url, err := profile_url(p)
+	if err != nil {
+		return "", "", "", err
+	}
+	return c.String("journal"), url, p.Account, nil
+}
+
+// append_journal writes a placed order to the journal, the order is placed
+// anyway so a failure is only reported
+func append_journal(c *cli.Context, o orderRequest, placed gemini.Order) {
+	path, url, account, err := journal_scope(c)
+	if err == nil && path == "" {
+		return
+	}
+	if err == nil {
+		err = write_journal(path, journal_entry{
+			Time:          time.Now().UTC(),
+			Url:           url,
+			Account:       account,
+			OrderId:       placed.OrderId,
+			ClientOrderId: o.ClientOrderId,
+			Symbol:        strings.ToLower(o.Symbol),
+			Side:          o.Side,
+			Type:          o.Type,
+			Amount:        o.Amount,
+			Price:         o.Price,
+		})
+	}
+	if err != nil {
+		errlog.Printf("Warning order %s placed but not written in the journal: %s", placed.OrderId, err)
+	}
+}
+
+func write_journal(path string, e journal_entry) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	j, err := json.Marshal(e)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(append(j, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// read_journal returns the entries of url and account, none if there is no journal yet
+func read_journal(path, url, account string) ([]journal_entry, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []journal_entry
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var e journal_entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("Error invalid journal %s line %d: %s", path, line, err)
+		}
+		if e.Url == url && e.Account == account {
+			entries = append(entries, e)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// order_id_flags are the flags of the commands working on one order
+func order_id_flags(flags ...cli.Flag) []cli.Flag {
+	return append([]cli.Flag{
+		&cli.StringFlag{
+			Name:    "orderid",
+			Aliases: []string{"o"},
+			Usage:   "e.g. --orderid 121212 (orderid or client-order-id is required)",
+		},
+		&cli.StringFlag{
+			Name:    "client-order-id",
+			Aliases: []string{"client_order_id", "i"},
+			Usage: "e.g. --client-order-id mybot-1 (orderid or client-order-id is required)\n" +
+				"	looked up in the active orders, then in the journal of the orders placed by gemini_cli",
+		},
+	}, flags...)
+}
+
+// resolve_order returns the order id of --orderid or --client-order-id,
+// a client order id is looked up in the active orders and, if none is
+// active, in the journal. It must match exactly one order
+func resolve_order(c *cli.Context, api gemini_client) (string, error) {
+	order_id, client_order_id := c.String("orderid"), c.String("client-order-id")
+	switch {
+	case order_id != "" && client_order_id != "":
+		return "", fmt.Errorf("Error use either --orderid or --client-order-id, not both")
+	case order_id != "":
+		return order_id, nil
+	case client_order_id == "":
+		return "", fmt.Errorf("Error --orderid or --client-order-id is required")
+	}
+
+	ids, err := find_client_order(c, api, client_order_id)
+	if err != nil {
+		return "", err
+	}
+	switch len(ids) {
+	case 0:
+		return "", fmt.Errorf("Error no order with client_order_id %s in the active orders or in the journal", client_order_id)
+	case 1:
+		return ids[0], nil
+	}
+	return "", fmt.Errorf("Error client_order_id %s matches the orders %s, use --orderid", client_order_id, strings.Join(ids, ", "))
+}
+
+// find_client_order returns the ids of the active orders with client_order_id
+// or, if none is active, of the orders of the journal
+func find_client_order(c *cli.Context, api gemini_client, client_order_id string) ([]string, error) {
+	ids := map[string]bool{}
+	// /v1/orders
+	orders, err := api.ActiveOrders()
+	if err != nil {
+		return nil, err
+	}
+	for _, o := range orders {
+		if o.ClientOrderId == client_order_id {
+			ids[o.OrderId] = true
+		}
+	}
+	if len(ids) == 0 {
+		path, url, account, err := journal_scope(c)
+		if err != nil {
+			return nil, err
+		}
+		entries, err := read_journal(path, url, account)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if e.ClientOrderId == client_order_id {
+				ids[e.OrderId] = true
+			}
+		}
+	}
+
+	var list []string
+	for id := range ids {
+		list = append(list, id)
+	}
+	sort.Strings(list)
+	return list, nil
+}
+
+// order_trades is an order with its fills, from order status --include-trades
+type order_trades struct {
+	gemini.Order
+	Trades []gemini.PastTrade `json:"trades"`
+}
+
+// flat gives the order columns and the trades in one json column
+func (o order_trades) flat() ([]string, []string) {
+	var cols, values []string
+	flatten(reflect.ValueOf(o.Order), "", &cols, &values)
+	j, _ := json.Marshal(o.Trades)
+	return append(cols, "trades"), append(values, string(j))
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestOrderStatusAndCancelByClientOrderId(t *testing.T) {
+	server := use_fake_gemini(t)
+
+	for _, id := range []string{"bot-1", "bot-2"} {
+		if _, err := run_app(t, nil, "-y", "order", "new", "-t", "btcusd", "-s", "buy", "-a", "0.5", "-p", "100",
+			"--client_order_id", id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// order orderid sends the --orderid flag
+	if _, err := run_app(t, nil, "order", "orderid", "--orderid", "1001"); err != nil {
+		t.Fatal(err)
+	}
+	if p := server.last_payload(); p["order_id"] != "1001" {
+		t.Errorf("order status payload %v", p)
+	}
+
+	out, err := run_app(t, nil, "order", "status", "--client-order-id", "bot-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, `"order_id": "1002"`) {
+		t.Errorf("status %s", out)
+	}
+
+	if _, err := run_app(t, nil, "order", "cancel", "-i", "bot-1"); err != nil {
+		t.Fatal(err)
+	}
+	if p := server.last_payload(); p["request"] != cancel_order_URI || p["order_id"] != "1001" {
+		t.Errorf("cancel payload %v", p)
+	}
+
+	// bot-1 is no longer active, the journal still knows it
+	server.pastTrades = append(server.pastTrades, server.pastTrades[0])
+	server.pastTrades[1].OrderId = "1001"
+	out, err = run_app(t, nil, "order", "status", "--client-order-id", "bot-1", "--include-trades")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var status order_trades
+	if err := json.Unmarshal([]byte(out), &status); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	if status.OrderId != "1001" || !status.IsCancelled || len(status.Trades) != 1 || status.Trades[0].OrderId != "1001" {
+		t.Errorf("status %s", out)
+	}
+
+	tests := []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"order", "status"}, "--orderid or --client-order-id is required"},
+		{[]string{"order", "status", "-o", "1", "-i", "bot-1"}, "not both"},
+		{[]string{"order", "cancel", "-i", "bot-9"}, "no order with client_order_id bot-9"},
+	}
+	for _, test := range tests {
+		if _, err := run_app(t, nil, test.args...); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%v: err = %v, want %s", test.args, err, test.err)
+		}
+	}
+
+	// client order ids can be reused: the journal has two orders of bot-3
+	for i := 0; i < 2; i++ {
+		if _, err := run_app(t, nil, "-y", "order", "new", "-t", "btcusd", "-s", "buy", "-a", "0.5", "-p", "100",
+			"--client_order_id", "bot-3", "--option", "immediate-or-cancel"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := run_app(t, nil, "order", "status", "-i", "bot-3"); err == nil ||
+		!strings.Contains(err.Error(), "matches the orders 1003, 1004") {
+		t.Errorf("err = %v", err)
+	}
+
+	j, err := ioutil.ReadFile(os.Getenv("GEMINI_JOURNAL"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(j), "\n") != 4 {
+		t.Errorf("journal:\n%s", j)
+	}
+}
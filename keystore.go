@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const keystore_default_profile = "default"
+
+// keystore is the encrypted credentials file written by auth login, every
+// entry is sealed with AES-256-GCM using a key derived from the passphrase
+// with scrypt, entry names are in clear so auth status needs no passphrase
+type keystore struct {
+	Version int                       `json:"version"`
+	Kdf     keystore_kdf              `json:"kdf"`
+	Entries map[string]keystore_entry `json:"entries"`
+
+	path string
+	key  []byte
+}
+
+type keystore_kdf struct {
+	Name string `json:"name"`
+	Salt []byte `json:"salt"`
+	N    int    `json:"n"`
+	R    int    `json:"r"`
+	P    int    `json:"p"`
+}
+
+type keystore_entry struct {
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// keystore_credentials is the plaintext of a keystore entry
+type keystore_credentials struct {
+	Gemini_api_key        string `json:"gemini_api_key"`
+	Gemini_api_secret     string `json:"gemini_api_secret"`
+	Gemini_api_production string `json:"gemini_api_production,omitempty"`
+}
+
+func default_keystore_path() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "gemini_cli", "keystore.json")
+}
+
+// keystore_entry_name is the entry of a profile, "default" without profile
+func keystore_entry_name(profile string) string {
+	if profile == "" {
+		return keystore_default_profile
+	}
+	return profile
+}
+
+// open_keystore reads the keystore file, a missing file is an empty keystore
+func open_keystore(path string) (*keystore, error) {
+	ks := &keystore{Version: 1, Entries: map[string]keystore_entry{}, path: path}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ks, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, ks); err != nil {
+		return nil, fmt.Errorf("Error invalid keystore %s: %s", path, err)
+	}
+	if ks.Version != 1 || ks.Kdf.Name != "scrypt" {
+		return nil, fmt.Errorf("Error unsupported keystore %s version %d", path, ks.Version)
+	}
+	if ks.Entries == nil {
+		ks.Entries = map[string]keystore_entry{}
+	}
+	return ks, nil
+}
+
+func (ks *keystore) exists() bool {
+	return len(ks.Kdf.Salt) > 0
+}
+
+func (ks *keystore) names() []string {
+	var names []string
+	for name := range ks.Entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// unlock derives the encryption key, a new keystore gets a new salt
+func (ks *keystore) unlock(passphrase string) error {
+	if passphrase == "" {
+		return fmt.Errorf("Error empty keystore passphrase")
+	}
+	if !ks.exists() {
+		ks.Kdf = keystore_kdf{Name: "scrypt", N: 1 << 15, R: 8, P: 1, Salt: make([]byte, 16)}
+		if _, err := io.ReadFull(rand.Reader, ks.Kdf.Salt); err != nil {
+			return err
+		}
+	}
+	key, err := scrypt.Key([]byte(passphrase), ks.Kdf.Salt, ks.Kdf.N, ks.Kdf.R, ks.Kdf.P, 32)
+	if err != nil {
+		return err
+	}
+	ks.key = key
+	return nil
+}
+
+func (ks *keystore) aead() (cipher.AEAD, error) {
+	if ks.key == nil {
+		return nil, fmt.Errorf("Error keystore is locked")
+	}
+	block, err := aes.NewCipher(ks.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// get decrypts the credentials of an entry, the entry name is
+// authenticated so entries cannot be swapped
+func (ks *keystore) get(name string) (*keystore_credentials, error) {
+	entry, ok := ks.Entries[name]
+	if !ok {
+		return nil, fmt.Errorf("Error no credentials for %s in keystore %s", name, ks.path)
+	}
+	aead, err := ks.aead()
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, entry.Nonce, entry.Ciphertext, []byte(name))
+	if err != nil {
+		return nil, fmt.Errorf("Error cannot decrypt keystore %s: wrong passphrase?", ks.path)
+	}
+	var cred keystore_credentials
+	if err := json.Unmarshal(plaintext, &cred); err != nil {
+		return nil, err
+	}
+	return &cred, nil
+}
+
+func (ks *keystore) put(name string, cred keystore_credentials) error {
+	aead, err := ks.aead()
+	if err != nil {
+		return err
+	}
+	// a wrong passphrase must not add entries the other ones cannot open
+	for other := range ks.Entries {
+		if _, err := ks.get(other); err != nil {
+			return err
+		}
+	}
+	plaintext, err := json.Marshal(cred)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	ks.Entries[name] = keystore_entry{Nonce: nonce, Ciphertext: aead.Seal(nil, nonce, plaintext, []byte(name))}
+	return nil
+}
+
+// save writes the keystore readable only by the user
+func (ks *keystore) save() error {
+	if err := os.MkdirAll(filepath.Dir(ks.path), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(ks, "", " ")
+	if err != nil {
+		return err
+	}
+	tmp := ks.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, ks.path)
+}
+
+// read_passphrase reads the keystore passphrase from GEMINI_KEYSTORE_PASSPHRASE
+// or from the terminal
+func read_passphrase(prompt string, confirm bool) (string, error) {
+	if passphrase := os.Getenv("GEMINI_KEYSTORE_PASSPHRASE"); passphrase != "" {
+		return passphrase, nil
+	}
+	passphrase, err := read_password(prompt)
+	if err != nil {
+		return "", fmt.Errorf("Error reading keystore passphrase: %s\nSet GEMINI_KEYSTORE_PASSPHRASE when not using a terminal", err)
+	}
+	if confirm {
+		again, err := read_password("Repeat passphrase: ")
+		if err != nil {
+			return "", err
+		}
+		if again != passphrase {
+			return "", fmt.Errorf("Error passphrases do not match")
+		}
+	}
+	return passphrase, nil
+}
+
+// read_password reads a line from the terminal without echo
+func read_password(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return "", fmt.Errorf("stdin is not a terminal")
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	b, err := terminal.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// read_secret_fd reads the first line of file descriptor fd, e.g.
+// gemini_cli --secret-fd 3 ... 3< <(pass show gemini)
+func read_secret_fd(fd int) (string, error) {
+	f := os.NewFile(uintptr(fd), fmt.Sprintf("fd%d", fd))
+	if f == nil {
+		return "", fmt.Errorf("Error invalid --secret-fd %d", fd)
+	}
+	defer f.Close()
+	line, err := bufio.NewReader(f).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("Error reading --secret-fd %d: %s", fd, err)
+	}
+	secret := strings.TrimRight(line, "\r\n")
+	if secret == "" {
+		return "", fmt.Errorf("Error empty secret from --secret-fd %d", fd)
+	}
+	return secret, nil
+}
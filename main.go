@@ -3,9 +3,11 @@ package main
 import (
 	"fmt"
 	"log"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/urfave/cli/v2"
 
@@ -16,14 +18,12 @@ var stdlog, errlog *log.Logger
 
 func init() {
 	stdlog = log.New(os.Stdout, "", 0)
-	errlog = log.New(os.Stderr, "", 0)
+	errlog = log.New(redact_writer{os.Stderr}, "", 0)
 }
 
-func main() {
-
-	var gemini_config_yml string
+func new_app() *cli.App {
 
-	app := &cli.App{
+	return &cli.App{
 		EnableBashCompletion:   true,
 		UseShortOptionHandling: true,
 
@@ -40,11 +40,21 @@ func main() {
 					"		gemini_api_key: \"mygeminikey\"\n" +
 					"		gemini_api_secret: \"mygeminisecret\"\n" +
 					"		gemini_api_production: \"false\" (if false it uses sandbox server)\n" +
+					"		gemini_api_url: \"http://127.0.0.1:8080\" (Optional, overrides the production/sandbox url)\n" +
+					"		secret_command: \"pass show gemini\" (Optional, instead of gemini_api_secret)\n" +
+					"		risk: (Optional, checked before any order or withdrawal is sent)\n" +
+					"			allowed_symbols: [btcusd, ethusd]\n" +
+					"			max_notional: {btcusd: 50000, \"*\": 10000}\n" +
+					"			max_amount: {btcusd: 2}\n" +
+					"			price_band_pct: 5 (max distance of the price from the current bid/ask)\n" +
+					"			daily_withdrawal_limit: {btc: 0.5, eth: 10}\n" +
+					"	The configuration file must not be world-readable, e.g. chmod 600 gemini.yml\n" +
 					"	-\n" +
 					"	Instead of a configuration file you can export the following environment variables:\n" +
 					"		export GEMINI_API_KEY=\"mygeminikey\"\n" +
 					"		export GEMINI_API_SECRET=\"mygeminisecret\"\n" +
 					"		export GEMINI_API_PRODUCTION=\"false\"\n" +
+					"		export GEMINI_API_URL=\"http://127.0.0.1:8080\"\n" +
 					"	Yml configuration file does override the environment variables\n" +
 					"	-\n",
 			},
@@ -55,10 +65,75 @@ func main() {
 			},
 			&cli.BoolFlag{
 				Name:  "trace",
-				Usage: "More debug, this will also show gemini key and secret !",
+				Usage: "More debug, this will also show the requests sent to Gemini",
+			},
+			&cli.BoolFlag{
+				Name:  "unsafe-show-secrets",
+				Usage: "Do not redact keys, secrets, signatures, payloads and addresses in debug and trace logs !",
+			},
+			&cli.StringFlag{
+				Name:    "profile",
+				Aliases: []string{"p"},
+				EnvVars: []string{"GEMINI_PROFILE"},
+				Usage: "--profile sandbox - Use a named profile of the yml configuration file e.g.\n" +
+					"	default_profile: primary\n" +
+					"	profiles:\n" +
+					"		primary:\n" +
+					"			gemini_api_key: \"mygeminikey\"\n" +
+					"			gemini_api_secret: \"mygeminisecret\"\n" +
+					"			gemini_api_production: \"true\"\n" +
+					"			default_symbol: \"btcusd\" (Optional, used when --ticker is not given)\n" +
+					"			account: \"strategy1\" (Optional, sub-account name for master API keys)\n" +
+					"		sandbox:\n" +
+					"			...\n",
+			},
+			&cli.StringFlag{
+				Name:    "keystore",
+				EnvVars: []string{"GEMINI_KEYSTORE"},
+				Usage:   "--keystore path - Encrypted credentials file written by auth login",
+				Value:   default_keystore_path(),
+			},
+			&cli.StringFlag{
+				Name:    "journal",
+				EnvVars: []string{"GEMINI_JOURNAL"},
+				Usage:   "--journal path - Journal of the orders placed by gemini_cli, to find them by client order id",
+				Value:   default_journal_path(),
+			},
+			&cli.StringFlag{
+				Name:    "state-dir",
+				EnvVars: []string{"GEMINI_STATE_DIR"},
+				Usage:   "--state-dir path - Where the supervisors of oco, bracket and the other long running orders keep their state",
+				Value:   default_state_dir(),
+			},
+			&cli.IntFlag{
+				Name:  "secret-fd",
+				Usage: "--secret-fd 3 - Read the Gemini API secret from file descriptor 3 e.g. 3< <(pass show gemini)",
+			},
+			&cli.BoolFlag{
+				Name:    "yes",
+				Aliases: []string{"y"},
+				Usage:   "Do not ask to confirm orders, cancel_all and withdrawals, for scripts",
+			},
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Validate orders, cancel_all and withdrawals and show the signed request without sending it",
+			},
+			&cli.StringFlag{
+				Name:    "output",
+				Aliases: []string{"o"},
+				Usage:   "--output json|jsonl|csv|yaml|table - Output format",
+				Value:   "json",
 			},
 		},
 
+		Before: func(c *cli.Context) error {
+			if err := check_output(c); err != nil {
+				return err
+			}
+			c.App.Metadata["session"] = new_session(parse_params(c))
+			return nil
+		},
+
 		Commands: []*cli.Command{
 			{
 				Name: "get",
@@ -67,28 +142,32 @@ func main() {
 						Name:  "account",
 						Usage: "Get account details (Private)",
 						Action: func(c *cli.Context) error {
-							gemini_config_yml = parse_params(c)
+							api, err := get_client(c)
+							if err != nil {
+								return err
+							}
 							// /v1/account
-							status, err := get_account(gemini_config_yml)
+							status, err := get_account(api)
 							if err != nil {
 								return err
 							}
-							stdlog.Print(status)
-							return nil
+							return print_output(c, status)
 						},
 					},
 					{
 						Name:  "balances",
 						Usage: "This will show the available balances in the supported currencies (Private)",
 						Action: func(c *cli.Context) error {
-							gemini_config_yml = parse_params(c)
+							api, err := get_client(c)
+							if err != nil {
+								return err
+							}
 							// /v1/balances
-							status, err := get_balances(gemini_config_yml)
+							status, err := get_balances(api)
 							if err != nil {
 								return err
 							}
-							stdlog.Print(status)
-							return nil
+							return print_output(c, status)
 						},
 					},
 
@@ -113,7 +192,10 @@ func main() {
 							},
 						},
 						Action: func(c *cli.Context) error {
-							gemini_config_yml = parse_params(c)
+							api, err := get_client(c)
+							if err != nil {
+								return err
+							}
 
 							args := gemini.Args{}
 							if c.IsSet("limit_transfers") {
@@ -131,12 +213,11 @@ func main() {
 								args["show_completed_deposit_advances"] = "true"
 							}
 							// /v1/transfers
-							status, err := get_transfers(gemini_config_yml, args)
+							status, err := get_transfers(api, args)
 							if err != nil {
 								return err
 							}
-							stdlog.Print(status)
-							return nil
+							return print_output(c, status)
 						},
 					},
 					{
@@ -152,18 +233,20 @@ func main() {
 							},
 						},
 						Action: func(c *cli.Context) error {
-							gemini_config_yml = parse_params(c)
+							api, err := get_client(c)
+							if err != nil {
+								return err
+							}
 							validCurrencies := []string{"bitcoin", "ethereum", "bitcoincash", "litecoin", "zcash", "filecoin"}
 							currency := c.String("currency")
 							for _, value := range validCurrencies {
 								if currency == value {
 									// /v1/addresses/:network
-									status, err := get_depositaddresses(gemini_config_yml, currency)
+									status, err := get_depositaddresses(api, currency)
 									if err != nil {
 										return err
 									}
-									stdlog.Print(status)
-									return nil
+									return print_output(c, status)
 								}
 							}
 							return fmt.Errorf("Error invalid currency: %s\nValid currencies: %v", currency, strings.Join(validCurrencies, ", "))
@@ -187,18 +270,20 @@ func main() {
 							},
 						},
 						Action: func(c *cli.Context) error {
-							gemini_config_yml = parse_params(c)
+							api, err := get_client(c)
+							if err != nil {
+								return err
+							}
 							validCurrencies := []string{"bitcoin", "ethereum", "bitcoincash", "litecoin", "zcash", "filecoin"}
 							currency := c.String("currency")
 							for _, value := range validCurrencies {
 								if currency == value {
 									// /v1/deposit/:network/newAddress
-									status, err := new_deposit_address(gemini_config_yml, currency, c.String("label"))
+									status, err := new_deposit_address(api, currency, c.String("label"))
 									if err != nil {
 										return err
 									}
-									stdlog.Print(status)
-									return nil
+									return print_output(c, status)
 								}
 							}
 							return fmt.Errorf("Error invalid currency: %s\nValid currencies: %v", currency, strings.Join(validCurrencies, ", "))
@@ -208,14 +293,43 @@ func main() {
 						Name:  "symbols",
 						Usage: "Retrieves all available symbols for trading (Public)",
 						Action: func(c *cli.Context) error {
-							gemini_config_yml = parse_params(c)
+							api, err := get_client(c)
+							if err != nil {
+								return err
+							}
 							// /v1/symbols
-							status, err := get_symbols(gemini_config_yml)
+							status, err := get_symbols(api)
+							if err != nil {
+								return err
+							}
+							return print_output(c, status)
+						},
+					},
+					{
+						Name:  "symbol-details",
+						Usage: "Retrieves the tick size, quote increment, minimum order size and status of a symbol (Public)",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:    "ticker",
+								Aliases: []string{"t"},
+								Usage:   "e.g. --ticker btcusd (required unless the profile has a default_symbol)",
+							},
+						},
+						Action: func(c *cli.Context) error {
+							api, err := get_client(c)
+							if err != nil {
+								return err
+							}
+							ticker, err := get_ticker_flag(c)
+							if err != nil {
+								return err
+							}
+							// /v1/symbols/details/:symbol
+							status, err := get_symbol_details(api, ticker)
 							if err != nil {
 								return err
 							}
-							stdlog.Print(status)
-							return nil
+							return print_output(c, status)
 						},
 					},
 					{
@@ -223,35 +337,42 @@ func main() {
 						Usage: "This endpoint retrieves information about recent trading activity for the provided symbol (Public)",
 						Flags: []cli.Flag{
 							&cli.StringFlag{
-								Name:     "ticker",
-								Aliases:  []string{"t"},
-								Usage:    "e.g. --ticker btcusd (ticker is required)",
-								Required: true,
+								Name:    "ticker",
+								Aliases: []string{"t"},
+								Usage:   "e.g. --ticker btcusd (required unless the profile has a default_symbol)",
 							},
 						},
 						Action: func(c *cli.Context) error {
-							gemini_config_yml = parse_params(c)
+							api, err := get_client(c)
+							if err != nil {
+								return err
+							}
+							ticker, err := get_ticker_flag(c)
+							if err != nil {
+								return err
+							}
 							// /v2/ticker/:symbol
-							status, err := get_ticker(gemini_config_yml, c.String("ticker"))
+							status, err := get_ticker(api, ticker)
 							if err != nil {
 								return err
 							}
-							stdlog.Print(status)
-							return nil
+							return print_output(c, status)
 						},
 					},
 					{
 						Name:  "tradevolume",
 						Usage: "Get trade volume, up to 30 days of trade volume for each symbol (Private)",
 						Action: func(c *cli.Context) error {
-							gemini_config_yml = parse_params(c)
+							api, err := get_client(c)
+							if err != nil {
+								return err
+							}
 							// /v1/tradevolume
-							status, err := get_tradevolume(gemini_config_yml)
+							status, err := get_tradevolume(api)
 							if err != nil {
 								return err
 							}
-							stdlog.Print(status)
-							return nil
+							return print_output(c, status)
 						},
 					},
 					{
@@ -261,8 +382,7 @@ func main() {
 							&cli.StringFlag{
 								Name: "ticker",
 								//Aliases:  []string{"t"},
-								Usage:    "e.g. --ticker btcusd (ticker is required)",
-								Required: true,
+								Usage: "e.g. --ticker btcusd (required unless the profile has a default_symbol)",
 							},
 							&cli.StringFlag{
 								Name:    "timestamp",
@@ -281,7 +401,14 @@ func main() {
 							},
 						},
 						Action: func(c *cli.Context) error {
-							gemini_config_yml = parse_params(c)
+							api, err := get_client(c)
+							if err != nil {
+								return err
+							}
+							ticker, err := get_ticker_flag(c)
+							if err != nil {
+								return err
+							}
 							args := gemini.Args{}
 							if c.IsSet("timestamp") || c.IsSet("t") {
 								timestamp, err := parseConvertTimestamp(c.String("timestamp"))
@@ -299,12 +426,108 @@ func main() {
 								args["include_breaks"] = "true"
 							}
 							// /v1/trades/:symbol
-							status, err := get_trades(gemini_config_yml, c.String("ticker"), args)
+							status, err := get_trades(api, ticker, args)
+							if err != nil {
+								return err
+							}
+							return print_output(c, status)
+						},
+					},
+					{
+						Name:  "candles",
+						Usage: "OHLCV candles, oldest first, with optional indicators (Public)",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:    "ticker",
+								Aliases: []string{"t"},
+								Usage:   "e.g. --ticker btcusd (required unless the profile has a default_symbol)",
+							},
+							&cli.StringFlag{
+								Name:    "interval",
+								Aliases: []string{"i"},
+								Usage:   "e.g. --interval 15m (1m, 5m, 15m, 30m, 1hr, 6hr or 1day)",
+								Value:   "1hr",
+							},
+							&cli.StringFlag{
+								Name:  "start",
+								Usage: "e.g. --start 2021-02-05T15:04:01 (Optional, only the candles from this time)",
+							},
+							&cli.StringFlag{
+								Name:  "end",
+								Usage: "e.g. --end 2021-02-06T15:04:01 (Optional, only the candles up to this time)",
+							},
+							&cli.IntFlag{
+								Name:    "limit",
+								Aliases: []string{"l"},
+								Usage:   "e.g. --limit 24 (Optional, only the last 24 candles)",
+							},
+							&cli.IntSliceFlag{
+								Name:  "sma",
+								Usage: "e.g. --sma 20 --sma 50 - Simple moving average of the close",
+							},
+							&cli.IntSliceFlag{
+								Name:  "ema",
+								Usage: "e.g. --ema 12 --ema 26 - Exponential moving average of the close",
+							},
+							&cli.IntFlag{
+								Name:  "rsi",
+								Usage: "e.g. --rsi 14 - Relative strength index",
+							},
+							&cli.IntFlag{
+								Name:  "atr",
+								Usage: "e.g. --atr 14 - Average true range",
+							},
+							&cli.IntFlag{
+								Name:  "bollinger",
+								Usage: "e.g. --bollinger 20 - Bollinger bands, the sma and --bollinger-k standard deviations around it",
+							},
+							&cli.Float64Flag{
+								Name:  "bollinger-k",
+								Usage: "e.g. --bollinger-k 2.5",
+								Value: 2,
+							},
+						},
+						Action: func(c *cli.Context) error {
+							api, err := get_client(c)
+							if err != nil {
+								return err
+							}
+							ticker, err := get_ticker_flag(c)
+							if err != nil {
+								return err
+							}
+							interval := c.String("interval")
+							if !contains(candleTimeFrames, interval) {
+								return fmt.Errorf("Error invalid interval: %s\nValid intervals: %s", interval, strings.Join(candleTimeFrames, ", "))
+							}
+							o := candle_options{
+								sma:         c.IntSlice("sma"),
+								ema:         c.IntSlice("ema"),
+								rsi:         c.Int("rsi"),
+								atr:         c.Int("atr"),
+								bollinger:   c.Int("bollinger"),
+								bollinger_k: c.Float64("bollinger-k"),
+								limit:       c.Int("limit"),
+							}
+							if c.IsSet("start") {
+								if o.start, err = parseConvertTimestamp(c.String("start")); err != nil {
+									return err
+								}
+							}
+							if c.IsSet("end") {
+								if o.end, err = parseConvertTimestamp(c.String("end")); err != nil {
+									return err
+								}
+							}
+							if err := o.validate(); err != nil {
+								return err
+							}
+							// /v2/candles/:symbol/:time_frame
+							status, err := get_candles(api, ticker, interval, o)
 							if err != nil {
 								return err
 							}
-							stdlog.Print(status)
-							return nil
+							return print_output(c, status)
 						},
 					},
 					{
@@ -312,10 +535,9 @@ func main() {
 						Usage: "This will return the current order book as two arrays bids/asks (Public)",
 						Flags: []cli.Flag{
 							&cli.StringFlag{
-								Name:     "ticker",
-								Aliases:  []string{"t"},
-								Usage:    "e.g. --ticker btcusd (ticker is required)",
-								Required: true,
+								Name:    "ticker",
+								Aliases: []string{"t"},
+								Usage:   "e.g. --ticker btcusd (required unless the profile has a default_symbol)",
 							},
 							&cli.StringFlag{
 								Name:    "limit_bids",
@@ -329,7 +551,14 @@ func main() {
 							},
 						},
 						Action: func(c *cli.Context) error {
-							gemini_config_yml = parse_params(c)
+							api, err := get_client(c)
+							if err != nil {
+								return err
+							}
+							ticker, err := get_ticker_flag(c)
+							if err != nil {
+								return err
+							}
 
 							args := gemini.Args{}
 							if c.IsSet("limit_bids") {
@@ -341,12 +570,11 @@ func main() {
 								args["limit_asks"] = strconv.Itoa(limitAsks)
 							}
 							// /v1/book/:symbol
-							status, err := get_orderbook(gemini_config_yml, c.String("ticker"), args)
+							status, err := get_orderbook(api, ticker, args)
 							if err != nil {
 								return err
 							}
-							stdlog.Print(status)
-							return nil
+							return print_output(c, status)
 						},
 					},
 					{
@@ -354,21 +582,26 @@ func main() {
 						Usage: "Current auction (Public)",
 						Flags: []cli.Flag{
 							&cli.StringFlag{
-								Name:     "ticker",
-								Aliases:  []string{"t"},
-								Usage:    "e.g. --ticker btcusd (ticker is required)",
-								Required: true,
+								Name:    "ticker",
+								Aliases: []string{"t"},
+								Usage:   "e.g. --ticker btcusd (required unless the profile has a default_symbol)",
 							},
 						},
 						Action: func(c *cli.Context) error {
-							gemini_config_yml = parse_params(c)
+							api, err := get_client(c)
+							if err != nil {
+								return err
+							}
+							ticker, err := get_ticker_flag(c)
+							if err != nil {
+								return err
+							}
 							// /v1/auction/:symbol
-							status, err := get_auction(gemini_config_yml, c.String("ticker"))
+							status, err := get_auction(api, ticker)
 							if err != nil {
 								return err
 							}
-							stdlog.Print(status)
-							return nil
+							return print_output(c, status)
 						},
 					},
 
@@ -378,10 +611,9 @@ func main() {
 						Usage: "This will return the auction events, optionally including publications of indicative prices (Public)",
 						Flags: []cli.Flag{
 							&cli.StringFlag{
-								Name:     "ticker",
-								Aliases:  []string{"t"},
-								Usage:    "e.g. --ticker btcusd (ticker is required)",
-								Required: true,
+								Name:    "ticker",
+								Aliases: []string{"t"},
+								Usage:   "e.g. --ticker btcusd (required unless the profile has a default_symbol)",
 							},
 							&cli.StringFlag{
 								Name:    "since",
@@ -400,7 +632,14 @@ func main() {
 							},
 						},
 						Action: func(c *cli.Context) error {
-							gemini_config_yml = parse_params(c)
+							api, err := get_client(c)
+							if err != nil {
+								return err
+							}
+							ticker, err := get_ticker_flag(c)
+							if err != nil {
+								return err
+							}
 							args := gemini.Args{}
 							if c.IsSet("since") || c.IsSet("s") {
 								since, err := parseConvertTimestamp(c.String("since"))
@@ -418,12 +657,11 @@ func main() {
 								args["include_indicative"] = "true"
 							}
 							// /v1/auction/:symbol/history
-							status, err := get_auction_hystory(gemini_config_yml, c.String("ticker"), args)
+							status, err := get_auction_hystory(api, ticker, args)
 							if err != nil {
 								return err
 							}
-							stdlog.Print(status)
-							return nil
+							return print_output(c, status)
 						},
 					},
 				},
@@ -441,10 +679,9 @@ func main() {
 								Usage:   "e.g. --client_order_id \"20170208_example\" (Oprional but recommended)",
 							},
 							&cli.StringFlag{
-								Name:     "ticker",
-								Aliases:  []string{"t"},
-								Usage:    "e.g. --ticker btcusd (ticker is required)",
-								Required: true,
+								Name:    "ticker",
+								Aliases: []string{"t"},
+								Usage:   "e.g. --ticker btcusd (required unless the profile has a default_symbol)",
 							},
 							&cli.StringFlag{
 								Name:     "side",
@@ -452,247 +689,893 @@ func main() {
 								Usage:    "e.g. --side buy (buy or sell)",
 								Required: true,
 							},
-							&cli.Float64Flag{
+							&cli.StringFlag{
 								Name:     "amount",
 								Aliases:  []string{"a"},
 								Usage:    "e.g. --amount 0.021 (Decimal amount to purchase)",
 								Required: true,
 							},
-							&cli.Float64Flag{
+							&cli.StringFlag{
 								Name:     "price",
 								Aliases:  []string{"p"},
 								Usage:    "e.g. --price 3633.00 (Decimal amount to spend per unit)",
 								Required: true,
 							},
+							&cli.StringFlag{
+								Name:  "type",
+								Usage: "e.g. --type stop-limit (limit or stop-limit)",
+								Value: "limit",
+							},
+							&cli.StringFlag{
+								Name:  "stop_price",
+								Usage: "e.g. --stop_price 3600.00 (required by stop-limit orders)",
+							},
+							&cli.StringSliceFlag{
+								Name: "option",
+								Usage: "e.g. --option maker-or-cancel (Optional, default none: the order rests on the book)\n" +
+									"	Valid options: maker-or-cancel, immediate-or-cancel, fill-or-kill, auction-only, indication-of-interest\n" +
+									"	Gemini accepts only one option per order, stop-limit orders do not accept options",
+							},
+							&cli.BoolFlag{
+								Name: "round",
+								Usage: "Round the amount down to the tick size and the prices to the quote increment of the symbol,\n" +
+									"	buy prices down and sell prices up, instead of refusing the order",
+							},
 						},
 						Action: func(c *cli.Context) error {
-							gemini_config_yml = parse_params(c)
+							api, err := get_client(c)
+							if err != nil {
+								return err
+							}
+							ticker, err := get_ticker_flag(c)
+							if err != nil {
+								return err
+							}
+							orderType, err := parseOrderType(c.String("type"))
+							if err != nil {
+								return err
+							}
+							o := orderRequest{
+								Symbol:        ticker,
+								ClientOrderId: c.String("client_order_id"),
+								Side:          c.String("side"),
+								Type:          orderType,
+								Options:       c.StringSlice("option"),
+							}
+							if o.Amount, err = decimal_flag(c, "amount"); err != nil {
+								return err
+							}
+							if o.Price, err = decimal_flag(c, "price"); err != nil {
+								return err
+							}
+							if o.StopPrice, err = decimal_flag(c, "stop_price"); err != nil {
+								return err
+							}
+							// /v1/symbols/details/:symbol
+							if err := prepare_order(c, api, &o, nil); err != nil {
+								return err
+							}
+							if c.Bool("dry-run") {
+								return dry_run(c, api, new_order_URI, new_order_params(o))
+							}
+							if err := confirm(c, "Place a new order", order_summary(c, o)); err != nil {
+								return err
+							}
 							// /v1/order/new
-							status, err := new_order(gemini_config_yml,
-								c.String("ticker"),
-								c.String("client_order_id"),
-								c.String("side"),
-								c.Float64("amount"),
-								c.Float64("price"),
-							)
+							status, err := place_order(c, api, o)
 							if err != nil {
 								return err
 							}
-							stdlog.Print(status)
-							return nil
+							return print_output(c, status)
 						},
 					},
 					{
-						Name:  "active",
-						Usage: "Get active orders (Private)",
+						Name: "batch",
+						Usage: "Place the orders of a csv, json or yaml file, all the rows are validated before any order is sent (Private)\n" +
+							"	the columns are symbol, side, type, amount, price, stop_price, client_order_id and options",
+						Flags: append([]cli.Flag{
+							&cli.StringFlag{
+								Name:     "file",
+								Aliases:  []string{"f"},
+								Usage:    "e.g. --file orders.csv (.csv, .json, .yaml or .yml)",
+								Required: true,
+							},
+							&cli.BoolFlag{
+								Name:  "round",
+								Usage: "Round the amounts down to the tick size and the prices to the quote increment, as order new --round",
+							},
+							&cli.BoolFlag{
+								Name:  "stop-on-error",
+								Usage: "Do not send the remaining orders after an order fails",
+							},
+						}, parallel_flags("Orders sent")...),
 						Action: func(c *cli.Context) error {
-							gemini_config_yml = parse_params(c)
-							// /v1/orders
-							status, err := get_orders(gemini_config_yml)
+							api, err := get_client(c)
 							if err != nil {
 								return err
 							}
-							stdlog.Print(status)
-							return nil
+							// /v1/order/new for every row
+							return run_batch(c, api)
 						},
 					},
 					{
-						Name:  "past_trades",
-						Usage: "Get past trades (Private)",
-						Flags: []cli.Flag{
+						Name: "replace",
+						Usage: "Replace a resting limit order with a new price and amount: cancel it, then place its unfilled remainder (Private)\n" +
+							"	the replacement has a derived client_order_id e.g. mybot-1-r1 and the execution options of the original",
+						Flags: order_id_flags(
 							&cli.StringFlag{
-								Name:     "ticker",
-								Usage:    "e.g. --ticker btcusd (ticker is required)",
+								Name:     "price",
+								Aliases:  []string{"p"},
+								Usage:    "e.g. --price 30100.25 - Price of the replacement",
 								Required: true,
 							},
 							&cli.StringFlag{
-								Name:    "limit_trades",
-								Aliases: []string{"l"},
-								Usage:   "e.g. --limit_trades 10",
+								Name:    "amount",
+								Aliases: []string{"a"},
+								Usage:   "e.g. --amount 0.5 - New total amount of the order, what was filled is deducted (default: the original amount)",
 							},
-							&cli.StringFlag{
-								Name:    "timestamp",
-								Aliases: []string{"t"},
-								Usage:   "e.g. --timestamp 2021-02-05T15:04:01",
+							&cli.BoolFlag{
+								Name:  "round",
+								Usage: "Round the price to the quote increment, as order new --round",
 							},
-						},
+							&cli.DurationFlag{
+								Name:  "timeout",
+								Usage: "e.g. --timeout 10s - How long to wait for the cancel to be confirmed before giving up",
+								Value: 10 * time.Second,
+							},
+						),
 						Action: func(c *cli.Context) error {
-							gemini_config_yml = parse_params(c)
-
-							args := gemini.Args{}
-							if c.IsSet("limit_trades") {
-								limit_trades := c.Int("limit_trades")
-								args["limit_trades"] = limit_trades
-							}
-							if c.IsSet("timestamp") || c.IsSet("t") {
-								timestamp, err := parseConvertTimestamp(c.String("timestamp"))
-								if err != nil {
-									return err
-								}
-								args["timestamp"] = *timestamp
-							}
-							// /v1/mytrades
-							status, err := get_past_trades(gemini_config_yml, c.String("ticker"), args)
+							api, err := get_client(c)
 							if err != nil {
 								return err
 							}
-							stdlog.Print(status)
-							return nil
+							// /v1/order/cancel then /v1/order/new
+							return run_replace(c, api)
 						},
 					},
 					{
-						Name:  "orderid",
-						Usage: "Get order status (Private)",
-						Flags: []cli.Flag{
-							&cli.StringFlag{
-								Name:     "orderid",
-								Aliases:  []string{"o"},
-								Usage:    "e.g. --orderid 121212 (orderid is required)",
-								Required: true,
-							},
+						Name: "oco",
+						Usage: "Place a take-profit limit exit and trigger a stop-limit exit from the ticker, when one fills the other is cancelled, until Ctrl-C (Private)\n" +
+							"	the stop is placed for what the take-profit left, the state is saved to resume with order supervise",
+						Flags: oco_flags(false),
+						Action: func(c *cli.Context) error {
+							api, err := get_client(c)
+							if err != nil {
+								return err
+							}
+							// /v1/order/new then /v1/order/status every --poll
+							return run_oco(c, api, "oco")
 						},
+					},
+					{
+						Name: "bracket",
+						Usage: "Place a limit entry, then a take-profit and a stop-limit exit for what it filled, as order oco (Private)\n" +
+							"	the exits are placed when the entry is no longer live, filled or cancelled after a partial fill",
+						Flags: oco_flags(true),
 						Action: func(c *cli.Context) error {
-							gemini_config_yml = parse_params(c)
-							// /v1/order/status
-							status, err := get_order(gemini_config_yml, c.String("order"))
+							api, err := get_client(c)
 							if err != nil {
 								return err
 							}
-							stdlog.Print(status)
-							return nil
+							// /v1/order/new then /v1/order/status every --poll
+							return run_oco(c, api, "bracket")
 						},
 					},
 					{
-						Name:  "cancel",
-						Usage: "Cancel an order. If the order is already canceled, the message will succeed but have no effect (Private)",
+						Name:  "supervise",
+						Usage: "Resume the supervisor of an oco or a bracket after a restart (Private)",
 						Flags: []cli.Flag{
 							&cli.StringFlag{
-								Name:     "orderid",
-								Aliases:  []string{"o"},
-								Usage:    "e.g. --orderid 121212 (orderid is required)",
+								Name:     "state",
+								Usage:    "--state path - State file of the oco or bracket",
 								Required: true,
 							},
+							&cli.DurationFlag{
+								Name:  "poll",
+								Usage: "e.g. --poll 2s - How often the status of the orders is read",
+								Value: 2 * time.Second,
+							},
 						},
 						Action: func(c *cli.Context) error {
-							gemini_config_yml = parse_params(c)
-							// /v1/order/cancel
-							status, err := cancel_order(gemini_config_yml, c.String("orderid"))
+							api, err := get_client(c)
 							if err != nil {
 								return err
 							}
-							stdlog.Print(status)
-							return nil
+							// /v1/order/status every --poll
+							return run_supervise(c, api)
 						},
 					},
 					{
-						Name:  "cancel_all",
-						Usage: "Cancel ALL orders includind those placed through the UI !!! (Private)",
+						Name:  "iceberg",
+						Usage: "Show only --display of --total on the book, the next clip is posted when the previous one fills, until Ctrl-C (Private)",
+						Flags: iceberg_flags(),
 						Action: func(c *cli.Context) error {
-							gemini_config_yml = parse_params(c)
-							// /v1/order/cancel/all
-							status, err := cancel_all_order(gemini_config_yml)
+							api, err := get_client(c)
 							if err != nil {
 								return err
 							}
-							stdlog.Print(status)
-							return nil
+							// /v1/order/new and /v1/order/status every --poll
+							return run_iceberg(c, api)
 						},
 					},
-				},
-			},
-
-			{
-				Name:  "withdraw",
-				Usage: "Withdraw Crypto Funds - You must have an approved address list for your account (Private)",
-
-				Flags: []cli.Flag{
-					&cli.StringFlag{
-						Name:     "currency",
-						Aliases:  []string{"c"},
-						Usage:    "--currency btc|eth",
-						Required: true,
-					},
-					&cli.StringFlag{
-						Name:     "address",
-						Aliases:  []string{"a"},
-						Usage:    "Standard string format of cryptocurrency address",
-						Required: true,
-					},
-					&cli.Float64Flag{
+					{
+						Name:  "algo",
+						Usage: "Execute an amount over time in immediate-or-cancel child orders (Private)",
+						Subcommands: []*cli.Command{
+							{
+								Name:  "twap",
+								Usage: "Execute the amount in equal slices over --duration",
+								Flags: algo_flags(),
+								Action: func(c *cli.Context) error {
+									api, err := get_client(c)
+									if err != nil {
+										return err
+									}
+									// /v1/order/new every --duration/--slices
+									return run_algo(c, api, "twap")
+								},
+							},
+							{
+								Name:  "vwap",
+								Usage: "Execute the amount in slices following the volume profile of the candles",
+								Flags: algo_flags(),
+								Action: func(c *cli.Context) error {
+									api, err := get_client(c)
+									if err != nil {
+										return err
+									}
+									// /v1/order/new every --duration/--slices
+									return run_algo(c, api, "vwap")
+								},
+							},
+						},
+					},
+					{
+						Name:  "active",
+						Usage: "Get active orders (Private)",
+						Action: func(c *cli.Context) error {
+							api, err := get_client(c)
+							if err != nil {
+								return err
+							}
+							// /v1/orders
+							status, err := get_orders(api)
+							if err != nil {
+								return err
+							}
+							return print_output(c, status)
+						},
+					},
+					{
+						Name:  "past_trades",
+						Usage: "Get past trades (Private)",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "ticker",
+								Usage: "e.g. --ticker btcusd (required unless the profile has a default_symbol)",
+							},
+							&cli.StringFlag{
+								Name:    "limit_trades",
+								Aliases: []string{"l"},
+								Usage:   "e.g. --limit_trades 10",
+							},
+							&cli.StringFlag{
+								Name:    "timestamp",
+								Aliases: []string{"t"},
+								Usage:   "e.g. --timestamp 2021-02-05T15:04:01",
+							},
+						},
+						Action: func(c *cli.Context) error {
+							api, err := get_client(c)
+							if err != nil {
+								return err
+							}
+							ticker, err := get_ticker_flag(c)
+							if err != nil {
+								return err
+							}
+
+							args := gemini.Args{}
+							if c.IsSet("limit_trades") {
+								limit_trades := c.Int("limit_trades")
+								args["limit_trades"] = limit_trades
+							}
+							if c.IsSet("timestamp") || c.IsSet("t") {
+								timestamp, err := parseConvertTimestamp(c.String("timestamp"))
+								if err != nil {
+									return err
+								}
+								args["timestamp"] = *timestamp
+							}
+							// /v1/mytrades
+							status, err := get_past_trades(api, ticker, args)
+							if err != nil {
+								return err
+							}
+							return print_output(c, status)
+						},
+					},
+					{
+						Name:    "status",
+						Aliases: []string{"orderid"},
+						Usage:   "Get order status by order id or client order id (Private)",
+						Flags: order_id_flags(
+							&cli.BoolFlag{
+								Name:  "include-trades",
+								Usage: "Show the fills of the order",
+							},
+						),
+						Action: func(c *cli.Context) error {
+							api, err := get_client(c)
+							if err != nil {
+								return err
+							}
+							orderId, err := resolve_order(c, api)
+							if err != nil {
+								return err
+							}
+							if c.Bool("include-trades") {
+								// /v1/order/status include_trades
+								status, err := get_order_trades(api, orderId)
+								if err != nil {
+									return err
+								}
+								return print_output(c, status)
+							}
+							// /v1/order/status
+							status, err := get_order(api, orderId)
+							if err != nil {
+								return err
+							}
+							return print_output(c, status)
+						},
+					},
+					{
+						Name: "cancel",
+						Usage: "Cancel an order by order id or client order id. If the order is already canceled, the message will succeed but have no effect (Private)\n" +
+							"	or cancel all the active orders matching the filters e.g. --ticker btcusd --side buy --older-than 1h",
+						Flags: order_id_flags(cancel_filter_flags()...),
+						Action: func(c *cli.Context) error {
+							api, err := get_client(c)
+							if err != nil {
+								return err
+							}
+							filter, err := get_cancel_filter(c)
+							if err != nil {
+								return err
+							}
+							if filter != nil {
+								if c.IsSet("orderid") || c.IsSet("client-order-id") {
+									return fmt.Errorf("Error use either --orderid/--client-order-id or the filters, not both")
+								}
+								// /v1/orders then /v1/order/cancel for every matching order
+								return run_cancel_where(c, api, filter)
+							}
+							if !c.IsSet("orderid") && !c.IsSet("client-order-id") {
+								return fmt.Errorf("Error --orderid, --client-order-id or a filter (--ticker, --side, --type, --min-price, " +
+									"--max-price, --min-distance-bps, --older-than, --client-order-id-prefix) is required")
+							}
+							orderId, err := resolve_order(c, api)
+							if err != nil {
+								return err
+							}
+							if c.Bool("dry-run") {
+								return dry_run(c, api, cancel_order_URI, map[string]interface{}{"order_id": orderId})
+							}
+							// /v1/order/cancel
+							status, err := cancel_order(api, orderId)
+							if err != nil {
+								return err
+							}
+							return print_output(c, status)
+						},
+					},
+					{
+						Name:  "cancel_all",
+						Usage: "Cancel ALL orders includind those placed through the UI !!! (Private)",
+						Action: func(c *cli.Context) error {
+							api, err := get_client(c)
+							if err != nil {
+								return err
+							}
+							if c.Bool("dry-run") {
+								return dry_run(c, api, cancel_all_URI, nil)
+							}
+							if !c.Bool("yes") {
+								orders, err := api.ActiveOrders()
+								if err != nil {
+									return err
+								}
+								summary := append(environment_summary(c),
+									summary_field{"active orders", strconv.Itoa(len(orders))},
+									summary_field{"warning", "this also cancels the orders placed through the UI"})
+								if err := confirm(c, "Cancel ALL orders", summary); err != nil {
+									return err
+								}
+							}
+							// /v1/order/cancel/all
+							status, err := cancel_all_order(api)
+							if err != nil {
+								return err
+							}
+							return print_output(c, status)
+						},
+					},
+					{
+						Name:  "watch",
+						Usage: "Stream the events of your orders: accepted, booked, fill, cancelled, rejected... until Ctrl-C (Private)",
+						Flags: append([]cli.Flag{
+							&cli.StringSliceFlag{
+								Name:    "ticker",
+								Aliases: []string{"t"},
+								Usage:   "e.g. --ticker btcusd (Optional, default all the symbols, can be repeated)",
+							},
+							&cli.StringSliceFlag{
+								Name:    "client_order_id",
+								Aliases: []string{"i"},
+								Usage:   "e.g. --client_order_id mybot-1 (Optional, can be repeated)",
+							},
+							&cli.StringSliceFlag{
+								Name: "event",
+								Usage: "e.g. --event fill (Optional, default all, can be repeated)\n" +
+									"	Valid events: " + strings.Join(orderEventTypes, ", "),
+							},
+						}, feed_flags()...),
+						Action: func(c *cli.Context) error {
+							api, err := get_client(c)
+							if err != nil {
+								return err
+							}
+							// /v1/order/events
+							return watch_orders(c, api)
+						},
+					},
+				},
+			},
+
+			{
+				Name:  "guard",
+				Usage: "Protect a position: sell it when the price falls to a stop (Private)",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "trailing-stop",
+						Usage: "Sell when the bid falls --trail below its highest price since the start, until Ctrl-C",
+						Flags: guard_flags(true),
+						Action: func(c *cli.Context) error {
+							api, err := get_client(c)
+							if err != nil {
+								return err
+							}
+							// /v2/ticker or the market data feed, /v1/order/new when the stop is hit
+							return run_guard(c, api, "trailing_stop")
+						},
+					},
+					{
+						Name:  "stop-loss",
+						Usage: "Sell when the bid falls to --stop-price, until Ctrl-C",
+						Flags: guard_flags(false),
+						Action: func(c *cli.Context) error {
+							api, err := get_client(c)
+							if err != nil {
+								return err
+							}
+							// /v2/ticker or the market data feed, /v1/order/new when the stop is hit
+							return run_guard(c, api, "stop_loss")
+						},
+					},
+					{
+						Name:  "resume",
+						Usage: "Resume a trailing stop or a stop loss after a restart",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "state",
+								Usage:    "--state path - State file of the guard",
+								Required: true,
+							},
+							&cli.DurationFlag{
+								Name:  "poll",
+								Usage: "e.g. --poll 2s - How often the price and the orders are checked",
+								Value: 2 * time.Second,
+							},
+							&cli.DurationFlag{
+								Name:  "heartbeat",
+								Usage: "--source stream: reconnect when nothing, not even a heartbeat, is received for this long",
+								Value: 15 * time.Second,
+							},
+							&cli.IntFlag{
+								Name:  "max-retries",
+								Usage: "--source stream: read the ticker after this many failed reconnections in a row (default: retry forever)",
+							},
+						},
+						Action: func(c *cli.Context) error {
+							api, err := get_client(c)
+							if err != nil {
+								return err
+							}
+							return run_guard_resume(c, api)
+						},
+					},
+				},
+			},
+			{
+				Name:  "bot",
+				Usage: "Run a trading strategy until Ctrl-C (Private)",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "grid",
+						Usage: "Place a ladder of buys and sells from --lower to --upper, every fill is followed by the opposite order one level away",
+						Flags: grid_flags(),
+						Action: func(c *cli.Context) error {
+							api, err := get_client(c)
+							if err != nil {
+								return err
+							}
+							// /v1/order/new, /v1/orders every --poll
+							return run_grid(c, api)
+						},
+					},
+					{
+						Name:  "resume",
+						Usage: "Resume a grid after a restart",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "state",
+								Usage:    "--state path - State file of the grid",
+								Required: true,
+							},
+							&cli.DurationFlag{
+								Name:  "poll",
+								Usage: "e.g. --poll 5s - How often the orders, or in --paper mode the ticker, are read",
+								Value: 5 * time.Second,
+							},
+							&cli.IntFlag{
+								Name:  "count",
+								Usage: "e.g. --count 100 - Exit after printing this many events (default: until Ctrl-C)",
+							},
+						},
+						Action: func(c *cli.Context) error {
+							api, err := get_client(c)
+							if err != nil {
+								return err
+							}
+							return run_bot_resume(c, api)
+						},
+					},
+				},
+			},
+			{
+				Name:  "session",
+				Usage: "Manage the orders and the heartbeat of this API key session",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "cancel",
+						Usage: "Cancel the orders placed by this API session, not those of the other sessions and of the UI (Private)",
+						Action: func(c *cli.Context) error {
+							api, err := get_client(c)
+							if err != nil {
+								return err
+							}
+							if c.Bool("dry-run") {
+								return dry_run(c, api, cancel_session_URI, nil)
+							}
+							summary := append(environment_summary(c),
+								summary_field{"scope", "the orders placed by this API session"})
+							if err := confirm(c, "Cancel the session orders", summary); err != nil {
+								return err
+							}
+							// /v1/order/cancel/session
+							status, err := cancel_session(api)
+							if err != nil {
+								return err
+							}
+							return print_output(c, status)
+						},
+					},
+					{
+						Name: "heartbeat",
+						Usage: "Keep a \"Require Heartbeat\" session alive until Ctrl-C, exits with an error when the heartbeats fail (Private)\n" +
+							"	Gemini cancels the session orders after 30s without heartbeats or other private requests",
+						Flags: []cli.Flag{
+							&cli.DurationFlag{
+								Name:  "interval",
+								Usage: "e.g. --interval 15s - Time between heartbeats, less than 30s",
+								Value: 15 * time.Second,
+							},
+							&cli.IntFlag{
+								Name:  "max-failures",
+								Usage: "e.g. --max-failures 2 - Consecutive failed heartbeats before exiting with an error",
+								Value: 2,
+							},
+							&cli.IntFlag{
+								Name:  "count",
+								Usage: "e.g. --count 10 - Stop after count heartbeats (Optional, default until Ctrl-C)",
+							},
+						},
+						Action: func(c *cli.Context) error {
+							api, err := get_client(c)
+							if err != nil {
+								return err
+							}
+							// /v1/heartbeat
+							return run_heartbeat(c, api)
+						},
+					},
+				},
+			},
+			{
+				Name:  "stream",
+				Usage: "Stream live market data from the Gemini WebSocket API until Ctrl-C (Public)",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "l2",
+						Usage: "Stream level 2 order book changes, trades and auction events of one or more symbols (market data v2)",
+						Flags: stream_flags(),
+						Action: func(c *cli.Context) error {
+							// /v2/marketdata l2
+							return stream_market_v2(c, "l2")
+						},
+					},
+					{
+						Name:  "trades",
+						Usage: "Stream the trades of one or more symbols (market data v1)",
+						Flags: stream_flags(),
+						Action: func(c *cli.Context) error {
+							// /v1/marketdata/:symbol
+							return stream_market_v1(c, url.Values{
+								"trades": {"true"}, "bids": {"false"}, "offers": {"false"}, "auctions": {"false"}})
+						},
+					},
+					{
+						Name:  "candles",
+						Usage: "Stream the candles of one or more symbols (market data v2)",
+						Flags: append(stream_flags(),
+							&cli.StringFlag{
+								Name:  "interval",
+								Usage: "e.g. --interval 5m (1m, 5m, 15m, 30m, 1h, 6h or 1d)",
+								Value: "1m",
+							},
+						),
+						Action: func(c *cli.Context) error {
+							interval := c.String("interval")
+							if !contains(candleIntervals, interval) {
+								return fmt.Errorf("Error invalid interval: %s\nValid intervals: %s", interval, strings.Join(candleIntervals, ", "))
+							}
+							// /v2/marketdata candles_<interval>
+							return stream_market_v2(c, "candles_"+interval)
+						},
+					},
+					{
+						Name:  "auction",
+						Usage: "Stream the auction events of one or more symbols (market data v1)",
+						Flags: stream_flags(),
+						Action: func(c *cli.Context) error {
+							// /v1/marketdata/:symbol
+							return stream_market_v1(c, url.Values{
+								"auctions": {"true"}, "bids": {"false"}, "offers": {"false"}, "trades": {"false"}})
+						},
+					},
+				},
+			},
+
+			{
+				Name:  "book",
+				Usage: "Order book analytics from a local order book, seeded from get orderbook and with --live updated by the market data feed (Public)",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "stats",
+						Usage: "Best bid/ask, spread in bps, top of book imbalance and cumulative depth within --depth-bps of the mid price",
+						Flags: book_flags(
+							&cli.Float64SliceFlag{
+								Name:  "depth-bps",
+								Usage: "e.g. --depth-bps 5 --depth-bps 20 - Distances from the mid price to measure the depth at",
+								Value: cli.NewFloat64Slice(10, 25, 50, 100),
+							},
+						),
+						Action: func(c *cli.Context) error {
+							api, err := get_client(c)
+							if err != nil {
+								return err
+							}
+							depth := c.Float64Slice("depth-bps")
+							for _, bps := range depth {
+								if bps <= 0 {
+									return fmt.Errorf("Error invalid --depth-bps: %s, it must be greater than 0", formatFloat(bps))
+								}
+							}
+							// /v1/book/:symbol, /v2/marketdata l2
+							return run_book(c, api, func(b *order_book) (interface{}, error) {
+								return b.stats(depth)
+							})
+						},
+					},
+					{
+						Name:  "impact",
+						Usage: "VWAP fill price and slippage of a market order of --amount, walking the book",
+						Flags: book_flags(
+							&cli.StringFlag{
+								Name:     "side",
+								Aliases:  []string{"s"},
+								Usage:    "--side buy|sell",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:     "amount",
+								Aliases:  []string{"a"},
+								Usage:    "e.g. --amount 2.5",
+								Required: true,
+							},
+						),
+						Action: func(c *cli.Context) error {
+							api, err := get_client(c)
+							if err != nil {
+								return err
+							}
+							side := c.String("side")
+							if side != "buy" && side != "sell" {
+								return fmt.Errorf("Error invalid side: %s\nValid sides: buy, sell", side)
+							}
+							amount, err := decimal_flag(c, "amount")
+							if err != nil {
+								return err
+							}
+							if amount.sign() <= 0 {
+								return fmt.Errorf("Error invalid amount: %s, it must be greater than 0", amount)
+							}
+							// /v1/book/:symbol, /v2/marketdata l2
+							return run_book(c, api, func(b *order_book) (interface{}, error) {
+								return b.impact(side, amount)
+							})
+						},
+					},
+				},
+			},
+
+			{
+				Name:  "withdraw",
+				Usage: "Withdraw Crypto Funds - You must have an approved address list for your account (Private)",
+
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "currency",
+						Aliases:  []string{"c"},
+						Usage:    "--currency btc|eth",
+						Required: true,
+					},
+					&cli.StringFlag{
+						Name:     "address",
+						Aliases:  []string{"a"},
+						Usage:    "Standard string format of cryptocurrency address",
+						Required: true,
+					},
+					&cli.StringFlag{
 						Name:     "amount",
 						Usage:    "e.g. --amount 0.021 (Decimal amount to purchase)",
 						Required: true,
 					},
 				},
 				Action: func(c *cli.Context) error {
-					gemini_config_yml = parse_params(c)
+					api, err := get_client(c)
+					if err != nil {
+						return err
+					}
 					validCurrencies := []string{"btc", "eth"}
 					currency := c.String("currency")
 					for _, value := range validCurrencies {
 						if currency == value {
+							address := c.String("address")
+							amount, err := decimal_flag(c, "amount")
+							if err != nil {
+								return err
+							}
+							if amount.sign() <= 0 {
+								return fmt.Errorf("Error invalid amount: %s, it must be greater than 0", amount)
+							}
+							if err := amount.check_decimals("amount", currencyDecimals[currency], strings.ToUpper(currency)); err != nil {
+								return err
+							}
+							risk, err := get_risk(c)
+							if err != nil {
+								return err
+							}
+							if err := risk.check_withdrawal(api, currency, amount); err != nil {
+								return err
+							}
+							if c.Bool("dry-run") {
+								return dry_run(c, api, withdraw_funds_URI+currency, withdraw_params(address, amount))
+							}
+							if err := confirm(c, "Withdraw funds", withdraw_summary(c, currency, address, amount)); err != nil {
+								return err
+							}
 							// /v1/withdraw/:currency
-							status, err := withdraw_funds(gemini_config_yml, currency, c.String("address"), c.Float64("amount"))
+							status, err := withdraw_funds(api, currency, address, amount)
 							if err != nil {
 								return err
 							}
-							stdlog.Print(status)
-							return nil
+							return print_output(c, status)
 						}
 					}
 					return fmt.Errorf("Error invalid currency: %s\nValid currencies: %v", currency, strings.Join(validCurrencies, ", "))
 				},
 			},
+			{
+				Name:  "auth",
+				Usage: "Manage the Gemini API credentials in the encrypted keystore",
+				Subcommands: []*cli.Command{
+					{
+						Name: "login",
+						Usage: "Store the API key and secret of --profile in the keystore, the secret is read from the terminal or --secret-fd\n" +
+							"	the keystore passphrase is read from the terminal or GEMINI_KEYSTORE_PASSPHRASE",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "api_key",
+								Aliases:  []string{"k"},
+								Usage:    "e.g. --api_key account-XXXXXXXXXXXX (api_key is required)",
+								Required: true,
+							},
+							&cli.StringFlag{
+								Name:  "production",
+								Usage: "e.g. --production false (Optional, otherwise from the configuration file or GEMINI_API_PRODUCTION)",
+							},
+						},
+						Action: func(c *cli.Context) error {
+							s, err := get_session(c)
+							if err != nil {
+								return err
+							}
+							status, err := auth_login(s.source, c.String("api_key"), c.String("production"))
+							if err != nil {
+								return err
+							}
+							return print_output(c, status)
+						},
+					},
+					{
+						Name:  "logout",
+						Usage: "Remove the credentials of --profile from the keystore",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "all",
+								Usage: "e.g. --all (remove the keystore file)",
+							},
+						},
+						Action: func(c *cli.Context) error {
+							s, err := get_session(c)
+							if err != nil {
+								return err
+							}
+							status, err := auth_logout(s.source, c.Bool("all"))
+							if err != nil {
+								return err
+							}
+							return print_output(c, status)
+						},
+					},
+					{
+						Name:  "status",
+						Usage: "Show the keystore entries and where the credentials of --profile are read from",
+						Action: func(c *cli.Context) error {
+							s, err := get_session(c)
+							if err != nil {
+								return err
+							}
+							status, err := auth_status_of(s)
+							if err != nil {
+								return err
+							}
+							return print_output(c, status)
+						},
+					},
+				},
+			},
 		},
 	}
+}
+
+func main() {
 
-	err := app.Run(os.Args)
+	err := new_app().Run(os.Args)
 	if err != nil {
 		errlog.Println(err)
+		os.Exit(1)
 	}
-
-	/*
-		TO BE DONE
-
-		// currency can be btc or eth
-		func withdraw_funds(gemini_config_yml, currency, address string, amount float64) (string, error) {
-
-			// Withdraw Funds
-			withdrawFunds, err := api.WithdrawFunds("btc", "mpXEeWuc7tSB4BVEoYpStmj4MFrmrLiRKL", 0.01)
-			if err != nil {
-				log.Fatalf("err = %v\n", err)
-			}
-			j, err = json.MarshalIndent(&withdrawFunds, "", " ")
-			if err != nil {
-				log.Fatalf("json.MarshalIndent failed with '%s'\n", err)
-			}
-			fmt.Printf("withdrawFunds = %s\n\n", j)
-
-			//This will cancel all orders opened by this session.
-			//This will have the same effect as heartbeat expiration if "Require Heartbeat" is selected for the session.
-			cancelSession, err := api.CancelSession()
-			if err != nil {
-				log.Fatalf("err = %v\n", err)
-			}
-			j, err = json.MarshalIndent(&cancelSession, "", " ")
-			if err != nil {
-				log.Fatalf("json.MarshalIndent failed with '%s'\n", err)
-			}
-			fmt.Printf("cancelSession = %s\n\n", j)
-
-			// Heartbeat
-			// This will prevent a session from timing out and canceling orders if the
-			// require heartbeat flag has been set. Note that this is only required if
-			// no other private API requests have been made. The arrival of any message
-			// resets the heartbeat timer.
-			heartbeat, err := api.Heartbeat()
-			if err != nil {
-				log.Fatalf("err = %v\n", err)
-			}
-			j, err = json.MarshalIndent(&heartbeat, "", " ")
-			if err != nil {
-				log.Fatalf("json.MarshalIndent failed with '%s'\n", err)
-			}
-			fmt.Printf("heartbeat = %s\n\n", j)
-
-	*/
 }
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/claudiocandio/gemini-api"
+)
+
+func TestGetBalancesOutput(t *testing.T) {
+	fake := &fake_client{balances: []gemini.FundBalance{
+		{Currency: "BTC", Amount: 0.5, Available: 0.25, AvailableForWithdrawal: 0.25, Type: "exchange"},
+	}}
+
+	tests := []struct {
+		output string
+		want   string
+	}{
+		{"json", "[\n {\n  \"currency\": \"BTC\",\n  \"amount\": \"0.5\","},
+		{"jsonl", `{"currency":"BTC","amount":"0.5","available":"0.25","availableForWithdrawal":"0.25","type":"exchange"}`},
+		{"csv", "currency,amount,available,availableForWithdrawal,type\nBTC,0.5,0.25,0.25,exchange\n"},
+		{"yaml", "- currency: BTC\n  amount: \"0.5\"\n"},
+		{"table", "CURRENCY  AMOUNT  AVAILABLE  AVAILABLEFORWITHDRAWAL  TYPE\nBTC       0.5     0.25       0.25                    exchange\n"},
+	}
+	for _, tt := range tests {
+		out, err := run_app(t, fake, "--output", tt.output, "get", "balances")
+		if err != nil {
+			t.Fatalf("%s: %v", tt.output, err)
+		}
+		if !strings.HasPrefix(out, tt.want) {
+			t.Errorf("%s output:\n%s\nwant prefix:\n%s", tt.output, out, tt.want)
+		}
+	}
+}
+
+func TestOrderbookCsv(t *testing.T) {
+	fake := &fake_client{book: gemini.Book{
+		Bids: gemini.BookEntries{{Price: 99.5, Amount: 1}},
+		Asks: gemini.BookEntries{{Price: 100.5, Amount: 2}},
+	}}
+
+	out, err := run_app(t, fake, "-o", "csv", "get", "orderbook", "-t", "btcusd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "side,price,amount\nbid,99.5,1\nask,100.5,2\n"
+	if out != want {
+		t.Errorf("output:\n%s\nwant:\n%s", out, want)
+	}
+}
+
+func TestInvalidOutput(t *testing.T) {
+	fake := &fake_client{}
+	if _, err := run_app(t, fake, "-o", "xml", "get", "symbols"); err == nil {
+		t.Error("expected an error for --output xml")
+	}
+	if len(fake.calls) != 0 {
+		t.Errorf("unexpected calls %v", fake.calls)
+	}
+}
+
+func TestOrderNew(t *testing.T) {
+	fake := &fake_client{}
+	_, err := run_app(t, fake, "--yes", "order", "new", "-t", "btcusd", "-s", "buy", "-a", "0.5", "-p", "100",
+		"--option", "maker-or-cancel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.newOrders) != 1 {
+		t.Fatalf("placed %d orders, want 1", len(fake.newOrders))
+	}
+	o := fake.newOrders[0]
+	if o.Type != orderTypeLimit || o.Side != "buy" || o.Amount.String() != "0.5" || o.Price.String() != "100" ||
+		len(o.Options) != 1 || o.Options[0] != optionMakerOrCancel {
+		t.Errorf("unexpected order %+v", o)
+	}
+}
+
+func TestOrderNewInvalid(t *testing.T) {
+	tests := [][]string{
+		{"--option", "fill-or-kill", "--option", "maker-or-cancel"},
+		{"--option", "good-till-cancel"},
+		{"--type", "stop-limit"},
+		{"--type", "stop-limit", "--stop_price", "90", "--option", "immediate-or-cancel"},
+		{"--type", "stop-limit", "--stop_price", "110"},
+		{"--stop_price", "90"},
+		{"--type", "market"},
+	}
+	for _, args := range tests {
+		fake := &fake_client{}
+		args = append([]string{"-y", "order", "new", "-t", "btcusd", "-s", "buy", "-a", "1", "-p", "100"}, args...)
+		if _, err := run_app(t, fake, args...); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+		if len(fake.newOrders) != 0 {
+			t.Errorf("%v: order sent", args)
+		}
+	}
+}
+
+func TestEndToEnd(t *testing.T) {
+	server := use_fake_gemini(t)
+
+	tests := []struct {
+		args    []string
+		request string // private request path, "" for public commands
+		want    string
+	}{
+		{[]string{"get", "account"}, "/v1/account", `"accountname": "Primary"`},
+		{[]string{"get", "balances"}, "/v1/balances", `"currency": "BTC"`},
+		{[]string{"get", "transfers", "-l", "10", "-t", "2021-02-01T15:04:01"}, "/v1/transfers", `"type": "Deposit"`},
+		{[]string{"get", "depositaddresses", "-c", "bitcoin"}, "/v1/addresses/bitcoin", `"address": "n2saq73aDTu42bRgEHd8gd4to1gCzHxrdj"`},
+		{[]string{"get", "new_depositaddresses", "-c", "bitcoin", "-l", "cold"}, "/v1/deposit/bitcoin/newAddress", `"label": "cold"`},
+		{[]string{"get", "symbols"}, "", `"ethbtc"`},
+		{[]string{"get", "ticker", "-t", "btcusd"}, "", `"symbol": "BTCUSD"`},
+		{[]string{"get", "tradevolume"}, "/v1/tradevolume", `"symbol": "btcusd"`},
+		{[]string{"get", "trades", "--ticker", "btcusd", "-t", "2021-02-05T15:04:01", "-l", "5", "-b"}, "", `"tid": 1`},
+		{[]string{"get", "orderbook", "-t", "btcusd", "-b", "1", "-a", "1"}, "", `"bids"`},
+		{[]string{"get", "candles", "-t", "btcusd", "-i", "1day", "--sma", "2"}, "", `"sma_2": 101.5`},
+		{[]string{"get", "auction", "-t", "btcusd"}, "", `"last_auction_price": "100"`},
+		{[]string{"get", "auction-hystory", "-t", "btcusd", "-s", "2021-02-05T15:04:01", "-l", "1", "-i"}, "", `"auction_result": "success"`},
+		{[]string{"-y", "order", "new", "-t", "btcusd", "-s", "buy", "-a", "0.5", "-p", "100", "-i", "e2e"}, "/v1/order/new", `"client_order_id": "e2e"`},
+		{[]string{"order", "active"}, "/v1/orders", `"is_live": true`},
+		{[]string{"order", "past_trades", "--ticker", "btcusd", "-l", "10"}, "/v1/mytrades", `"fee_currency": "USD"`},
+		{[]string{"order", "cancel", "-o", "1001"}, "/v1/order/cancel", `"is_cancelled": true`},
+		{[]string{"-y", "order", "cancel_all"}, "/v1/order/cancel/all", `"result": "ok"`},
+		{[]string{"-y", "withdraw", "-c", "btc", "-a", "mpXEeWuc7tSB4BVEoYpStmj4MFrmrLiRKL", "--amount", "0.00000001"}, "/v1/withdraw/btc", `"amount": "0.00000001"`},
+	}
+
+	for _, tt := range tests {
+		out, err := run_app(t, nil, tt.args...)
+		if err != nil {
+			t.Errorf("%v: %v", tt.args, err)
+			continue
+		}
+		if !strings.Contains(out, tt.want) {
+			t.Errorf("%v output:\n%s\nwant %s", tt.args, out, tt.want)
+		}
+		if tt.request != "" {
+			if p := server.last_payload(); p == nil || p["request"] != tt.request {
+				t.Errorf("%v: last private request %v, want %s", tt.args, p, tt.request)
+			}
+		}
+	}
+}
+
+func TestEndToEndBadSecret(t *testing.T) {
+	use_fake_gemini(t)
+	setenv(t, "GEMINI_API_SECRET", "wrongsecret")
+
+	_, err := run_app(t, nil, "get", "balances")
+	if err == nil || !strings.Contains(err.Error(), "InvalidSignature") {
+		t.Errorf("err = %v, want InvalidSignature", err)
+	}
+}
+
+func TestEndToEndOrderOptions(t *testing.T) {
+	server := use_fake_gemini(t)
+
+	_, err := run_app(t, nil, "-y", "order", "new", "-t", "btcusd", "-s", "sell", "-a", "1", "-p", "90",
+		"--type", "stop-limit", "--stop_price", "95")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := server.last_payload()
+	if p["type"] != orderTypeStopLimit || p["stop_price"] != "95" || p["price"] != "90" {
+		t.Errorf("unexpected payload %v", p)
+	}
+	if _, ok := p["options"]; ok {
+		t.Errorf("stop-limit payload has options %v", p["options"])
+	}
+}
@@ -0,0 +1,577 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/claudiocandio/gemini-api/logger"
+	"github.com/urfave/cli/v2"
+)
+
+// how long the supervisor waits for the cancel of a leg to be confirmed
+var leg_cancel_timeout = 10 * time.Second
+
+// oco_leg is one order of an oco or a bracket
+type oco_leg struct {
+	// entry, take_profit or stop_loss
+	Name          string   `json:"name"`
+	Side          string   `json:"side"`
+	Type          string   `json:"type"`
+	Amount        decimal  `json:"amount"`
+	Price         decimal  `json:"price"`
+	StopPrice     decimal  `json:"stop_price"`
+	Options       []string `json:"options"`
+	ClientOrderId string   `json:"client_order_id"`
+	OrderId       string   `json:"order_id"`
+	// pending until it is placed, then live, filled or cancelled; the
+	// stop_loss is watching until the price reaches its stop price
+	Status   string  `json:"status"`
+	Executed decimal `json:"executed_amount"`
+}
+
+func (l *oco_leg) request(symbol string) orderRequest {
+	o := orderRequest{
+		Symbol:        symbol,
+		ClientOrderId: l.ClientOrderId,
+		Side:          l.Side,
+		Type:          l.Type,
+		Amount:        l.Amount,
+		Price:         l.Price,
+		Options:       l.Options,
+	}
+	// a triggered stop_loss is a limit order, its stop price is a record
+	if l.Type == orderTypeStopLimit {
+		o.StopPrice = l.StopPrice
+	}
+	return o
+}
+
+// oco_state is the state file of an oco or a bracket, it is written after
+// every change so that order supervise can resume after a restart
+type oco_state struct {
+	// oco or bracket
+	Kind string `json:"kind"`
+	Id   string `json:"id"`
+	// REST base url and account the orders are placed on
+	Url        string   `json:"url"`
+	Account    string   `json:"account"`
+	Symbol     string   `json:"symbol"`
+	Entry      *oco_leg `json:"entry,omitempty"`
+	TakeProfit oco_leg  `json:"take_profit"`
+	StopLoss   oco_leg  `json:"stop_loss"`
+	// waiting_entry, active or done
+	Status  string    `json:"status"`
+	Updated time.Time `json:"updated"`
+}
+
+// oco_event is what the supervisor prints for every change
+type oco_event struct {
+	Time    time.Time `json:"time"`
+	Id      string    `json:"id"`
+	Leg     string    `json:"leg"`
+	OrderId string    `json:"order_id"`
+	// triggered, placed, fill, filled, cancelled, not_placed, error or done
+	Event  string  `json:"event"`
+	Amount decimal `json:"amount"`
+	Price  decimal `json:"price"`
+	Detail string  `json:"detail"`
+}
+
+// oco_flags are the flags of order oco and order bracket
+func oco_flags(bracket bool) []cli.Flag {
+	side_usage := "--side buy|sell - Side of the exits, sell to protect a long position"
+	if bracket {
+		side_usage = "--side buy|sell - Side of the entry, the exits are on the other side"
+	}
+	flags := []cli.Flag{
+		&cli.StringFlag{
+			Name:    "ticker",
+			Aliases: []string{"t"},
+			Usage:   "e.g. --ticker btcusd",
+		},
+		&cli.StringFlag{
+			Name:     "side",
+			Aliases:  []string{"s"},
+			Usage:    side_usage,
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:     "amount",
+			Aliases:  []string{"a"},
+			Usage:    "e.g. --amount 0.5",
+			Required: true,
+		},
+	}
+	if bracket {
+		flags = append(flags, &cli.StringFlag{
+			Name:     "price",
+			Aliases:  []string{"p"},
+			Usage:    "e.g. --price 30000 - Limit price of the entry",
+			Required: true,
+		})
+	}
+	return append(flags,
+		&cli.StringFlag{
+			Name:     "take-profit",
+			Usage:    "e.g. --take-profit 33000 - Limit price of the take-profit exit",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:     "stop-price",
+			Usage:    "e.g. --stop-price 28500 - Stop price of the stop-limit exit",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:     "stop-limit",
+			Usage:    "e.g. --stop-limit 28400 - Limit price of the stop-limit exit once triggered",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:    "client-order-id",
+			Aliases: []string{"client_order_id", "i"},
+			Usage:   "e.g. --client-order-id mybot-7 - Id of the orders, the legs are mybot-7-tp, mybot-7-sl and mybot-7-entry",
+		},
+		&cli.BoolFlag{
+			Name:  "round",
+			Usage: "Round the amount down to the tick size and the prices to the quote increment, as order new --round",
+		},
+		&cli.DurationFlag{
+			Name:  "poll",
+			Usage: "e.g. --poll 2s - How often the status of the orders is read",
+			Value: 2 * time.Second,
+		},
+		&cli.StringFlag{
+			Name:  "state",
+			Usage: "--state path - State file, by default <id>.json in --state-dir",
+		},
+	)
+}
+
+// new_oco_state checks the flags of order oco or order bracket and prepares
+// every leg like order new does
+func new_oco_state(c *cli.Context, api gemini_client, kind string) (*oco_state, error) {
+	symbol, err := get_ticker_flag(c)
+	if err != nil {
+		return nil, err
+	}
+	symbol = strings.ToLower(symbol)
+	side := c.String("side")
+	if side != "buy" && side != "sell" {
+		return nil, fmt.Errorf("Error invalid side: %s\nValid sides: buy, sell", side)
+	}
+	values := map[string]decimal{}
+	for _, name := range []string{"amount", "price", "take-profit", "stop-price", "stop-limit"} {
+		if values[name], err = decimal_flag(c, name); err != nil {
+			return nil, err
+		}
+	}
+	_, url, account, err := journal_scope(c)
+	if err != nil {
+		return nil, err
+	}
+	id := c.String("client-order-id")
+	if id == "" {
+		id = fmt.Sprintf("%s-%d", kind, time.Now().UnixNano()/1e6)
+	}
+
+	s := &oco_state{Kind: kind, Id: id, Url: url, Account: account, Symbol: symbol, Status: "active"}
+	exit_side := side
+	if kind == "bracket" {
+		exit_side = map[string]string{"buy": "sell", "sell": "buy"}[side]
+		s.Entry = &oco_leg{Name: "entry", Side: side, Type: orderTypeLimit, Amount: values["amount"],
+			Price: values["price"], ClientOrderId: id + "-entry", Status: "pending"}
+		s.Status = "waiting_entry"
+	}
+	s.TakeProfit = oco_leg{Name: "take_profit", Side: exit_side, Type: orderTypeLimit, Amount: values["amount"],
+		Price: values["take-profit"], ClientOrderId: id + "-tp", Status: "pending"}
+	s.StopLoss = oco_leg{Name: "stop_loss", Side: exit_side, Type: orderTypeStopLimit, Amount: values["amount"],
+		Price: values["stop-limit"], StopPrice: values["stop-price"], ClientOrderId: id + "-sl", Status: "watching"}
+
+	// a sell take-profit is above the stop, a buy take-profit below it
+	tp, stop := s.TakeProfit.Price, s.StopLoss.StopPrice
+	if exit_side == "buy" {
+		tp, stop = stop, tp
+	}
+	if tp.cmp(stop) <= 0 {
+		return nil, fmt.Errorf("Error the %s take-profit %s must be %s the stop price %s", exit_side,
+			s.TakeProfit.Price, map[string]string{"sell": "above", "buy": "below"}[exit_side], s.StopLoss.StopPrice)
+	}
+	if s.Entry != nil && (s.Entry.Price.cmp(tp) >= 0 || s.Entry.Price.cmp(stop) <= 0) {
+		return nil, fmt.Errorf("Error the entry price %s must be between the stop price %s and the take-profit %s",
+			s.Entry.Price, s.StopLoss.StopPrice, s.TakeProfit.Price)
+	}
+
+	details := map[string]symbol_details{}
+	for _, l := range s.legs() {
+		o := l.request(symbol)
+		if err := prepare_order(c, api, &o, details); err != nil {
+			return nil, fmt.Errorf("Error %s: %s", strings.Replace(l.Name, "_", "-", 1), strings.TrimPrefix(err.Error(), "Error "))
+		}
+		l.Amount, l.Price, l.StopPrice = o.Amount, o.Price, o.StopPrice
+	}
+	return s, nil
+}
+
+// legs returns the entry, if any, and the exits
+func (s *oco_state) legs() []*oco_leg {
+	if s.Entry != nil {
+		return []*oco_leg{s.Entry, &s.TakeProfit, &s.StopLoss}
+	}
+	return []*oco_leg{&s.TakeProfit, &s.StopLoss}
+}
+
+func oco_summary(c *cli.Context, s *oco_state, path string) []summary_field {
+	quote := quote_currency(s.Symbol)
+	fields := append(environment_summary(c),
+		summary_field{"symbol", s.Symbol},
+		summary_field{"id", s.Id},
+	)
+	for _, l := range s.legs() {
+		price := l.Price.String()
+		if l.Type == orderTypeStopLimit {
+			price = fmt.Sprintf("%s placed when the %s reaches %s", l.Price,
+				map[string]string{"sell": "bid", "buy": "ask"}[l.Side], l.StopPrice)
+		}
+		fields = append(fields, summary_field{strings.Replace(l.Name, "_", "-", 1),
+			strings.TrimSpace(fmt.Sprintf("%s %s @ %s %s", l.Side, l.Amount, price, quote))})
+	}
+	return append(fields, summary_field{"state", path})
+}
+
+// oco_supervisor places the legs and watches their fills
+type oco_supervisor struct {
+	c    *cli.Context
+	api  gemini_client
+	s    *oco_state
+	path string
+	w    *stream_writer
+	// a pending leg may have been placed before a restart
+	resumed bool
+}
+
+func (sv *oco_supervisor) event(l *oco_leg, event string, amount, price decimal, detail string) {
+	e := oco_event{Time: time.Now().UTC(), Id: sv.s.Id, Event: event, Amount: amount, Price: price, Detail: detail}
+	if l != nil {
+		e.Leg, e.OrderId = l.Name, l.OrderId
+	}
+	logger.Debug("func oco_supervisor: event",
+		fmt.Sprintf("id:%s", e.Id),
+		fmt.Sprintf("leg:%s", e.Leg),
+		fmt.Sprintf("event:%s", e.Event),
+	)
+	if err := sv.w.write(e); err != nil {
+		errlog.Printf("Warning %s: %s", sv.s.Id, err)
+	}
+}
+
+func (sv *oco_supervisor) save() error {
+	sv.s.Updated = time.Now().UTC()
+	return save_state(sv.path, sv.s)
+}
+
+// place sends a pending leg, after a restart a leg found in the active
+// orders or in the journal is not placed again
+func (sv *oco_supervisor) place(l *oco_leg) error {
+	if sv.resumed {
+		ids, err := find_client_order(sv.c, sv.api, l.ClientOrderId)
+		if err != nil {
+			return err
+		}
+		if len(ids) > 0 {
+			l.OrderId, l.Status = ids[len(ids)-1], "live"
+			sv.event(l, "placed", l.Amount, l.Price, "found after the restart")
+			return sv.save()
+		}
+	}
+	o := l.request(sv.s.Symbol)
+	if err := prepare_order(sv.c, sv.api, &o, nil); err != nil {
+		return err
+	}
+	// /v1/order/new
+	placed, err := place_order(sv.c, sv.api, o)
+	if err != nil {
+		return err
+	}
+	l.OrderId, l.Status, l.Executed = placed.OrderId, "live", decimal{}
+	sv.event(l, "placed", l.Amount, l.Price, "")
+	return sv.save()
+}
+
+// poll reads the status of a live leg and returns the amount executed
+// since the last poll
+func (sv *oco_supervisor) poll(l *oco_leg) (decimal, error) {
+	// /v1/order/status
+	o, err := sv.api.OrderStatus(l.OrderId)
+	if err != nil {
+		return decimal{}, err
+	}
+	executed := decimal_from_float(o.ExecutedAmount)
+	fill := executed.sub(l.Executed)
+	l.Executed = executed
+	if fill.sign() > 0 {
+		sv.event(l, "fill", fill, decimal_from_float(o.AvgExecutionPrice), "")
+	}
+	if !o.IsLive {
+		if o.IsCancelled {
+			l.Status = "cancelled"
+			sv.event(l, "cancelled", l.Amount.sub(l.Executed), l.Price, "cancelled outside the supervisor")
+		} else {
+			l.Status = "filled"
+			sv.event(l, "filled", l.Executed, decimal_from_float(o.AvgExecutionPrice), "")
+		}
+	}
+	return fill, sv.save()
+}
+
+// cancel cancels a live leg and records what it executed until the cancel
+func (sv *oco_supervisor) cancel(l *oco_leg, detail string) error {
+	o, err := cancel_confirmed(sv.api, l.OrderId, leg_cancel_timeout)
+	if err != nil {
+		return err
+	}
+	if executed := decimal_from_float(o.ExecutedAmount); executed.cmp(l.Executed) > 0 {
+		sv.event(l, "fill", executed.sub(l.Executed), decimal_from_float(o.AvgExecutionPrice), "")
+		l.Executed = executed
+	}
+	if o.IsCancelled {
+		l.Status = "cancelled"
+		sv.event(l, "cancelled", l.Amount.sub(l.Executed), l.Price, detail)
+	} else {
+		l.Status = "filled"
+		sv.event(l, "filled", l.Executed, decimal_from_float(o.AvgExecutionPrice), "filled before the cancel")
+	}
+	return sv.save()
+}
+
+// filled adjusts the sibling of an exit that was filled: it is cancelled if
+// the exit is done, filled or cancelled, otherwise a stop_loss that is not
+// placed yet is resized to what the exit has left
+func (sv *oco_supervisor) filled(l, sibling *oco_leg) error {
+	if l.Status == "live" {
+		if sibling.Status == "pending" || sibling.Status == "watching" {
+			sibling.Amount = l.Amount.sub(l.Executed)
+			return sv.save()
+		}
+		return nil
+	}
+	switch sibling.Status {
+	case "live":
+		return sv.cancel(sibling, l.Name+" "+l.Status)
+	case "pending", "watching":
+		sibling.Status = "cancelled"
+		sv.event(sibling, "not_placed", sibling.Amount, sibling.Price, l.Name+" "+l.Status)
+		return sv.save()
+	}
+	return nil
+}
+
+// trigger cancels the take-profit once the price reaches the stop price and
+// places the stop_loss, as a limit order, for what the take-profit has left
+func (sv *oco_supervisor) trigger(price decimal) error {
+	tp, sl := &sv.s.TakeProfit, &sv.s.StopLoss
+	sv.event(sl, "triggered", sl.Amount, price, "")
+	switch tp.Status {
+	case "live":
+		if err := sv.cancel(tp, "the stop price was reached"); err != nil {
+			return err
+		}
+	case "pending":
+		tp.Status = "cancelled"
+		sv.event(tp, "not_placed", tp.Amount, tp.Price, "the stop price was reached")
+	}
+	if tp.Status == "filled" {
+		return sv.filled(tp, sl)
+	}
+	sl.Amount, sl.Type, sl.Status = tp.Amount.sub(tp.Executed), orderTypeLimit, "pending"
+	if err := sv.save(); err != nil {
+		return err
+	}
+	if err := sv.place(sl); err != nil {
+		sv.event(sl, "error", sl.Amount, sl.Price, "cannot place the exit: "+one_line(err))
+	}
+	return nil
+}
+
+// step places what is pending, reads the status of the live legs and
+// triggers the stop_loss
+func (sv *oco_supervisor) step() error {
+	s := sv.s
+	if s.Status == "waiting_entry" {
+		if s.Entry.Status == "pending" {
+			if err := sv.place(s.Entry); err != nil {
+				return err
+			}
+		}
+		if s.Entry.Status == "live" {
+			if _, err := sv.poll(s.Entry); err != nil {
+				return err
+			}
+		}
+		if s.Entry.Status == "live" {
+			return nil
+		}
+		// the exits are for what the entry executed
+		if s.Entry.Executed.sign() == 0 {
+			for _, l := range []*oco_leg{&s.TakeProfit, &s.StopLoss} {
+				l.Status = "cancelled"
+				sv.event(l, "not_placed", l.Amount, l.Price, "the entry was cancelled without fills")
+			}
+		}
+		s.TakeProfit.Amount, s.StopLoss.Amount = s.Entry.Executed, s.Entry.Executed
+		s.Status = "active"
+		if err := sv.save(); err != nil {
+			return err
+		}
+	}
+
+	// an exit that cannot be placed does not hold back the other one
+	placed := true
+	for _, l := range []*oco_leg{&s.TakeProfit, &s.StopLoss} {
+		if l.Status == "pending" {
+			if err := sv.place(l); err != nil {
+				placed = false
+				sv.event(l, "error", l.Amount, l.Price, "cannot place the exit: "+one_line(err))
+			}
+		}
+	}
+	sv.resumed = sv.resumed && !placed
+	for _, pair := range [][2]*oco_leg{{&s.TakeProfit, &s.StopLoss}, {&s.StopLoss, &s.TakeProfit}} {
+		l, sibling := pair[0], pair[1]
+		if l.Status != "live" {
+			continue
+		}
+		fill, err := sv.poll(l)
+		if err != nil {
+			return err
+		}
+		if fill.sign() > 0 || l.Status != "live" {
+			if err := sv.filled(l, sibling); err != nil {
+				return err
+			}
+		}
+	}
+
+	// the stop_loss is not on the book, where Gemini would hold the
+	// amount of both exits, it is triggered from the ticker
+	if s.StopLoss.Status == "watching" {
+		price, err := ticker_price(sv.api, s.Symbol, s.StopLoss.Side)
+		if err != nil {
+			return err
+		}
+		if stop_reached(s.StopLoss.Side, price, s.StopLoss.StopPrice) {
+			if err := sv.trigger(price); err != nil {
+				return err
+			}
+		}
+	}
+
+	if s.TakeProfit.Status != "live" && s.TakeProfit.Status != "pending" &&
+		s.StopLoss.Status != "live" && s.StopLoss.Status != "pending" && s.StopLoss.Status != "watching" {
+		s.Status = "done"
+		sv.event(nil, "done", decimal{}, decimal{}, "")
+		return sv.save()
+	}
+	return nil
+}
+
+// run calls step every --poll until the oco or bracket is done or Ctrl-C,
+// a failed step is reported and tried again at the next poll
+func (sv *oco_supervisor) run() error {
+	ctx, cancel := interrupt_context(context.Background())
+	defer cancel()
+
+	poll := sv.c.Duration("poll")
+	if poll <= 0 {
+		return fmt.Errorf("Error invalid --poll: %s, it must be greater than 0", poll)
+	}
+	errlog.Printf("%s %s: supervising, state %s", sv.s.Kind, sv.s.Id, sv.path)
+	for {
+		if err := sv.step(); err != nil {
+			sv.event(nil, "error", decimal{}, decimal{}, one_line(err))
+		}
+		if sv.s.Status == "done" {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			errlog.Printf("%s %s: stopped, the orders stay on the book, resume with: gemini_cli order supervise --state %s",
+				sv.s.Kind, sv.s.Id, sv.path)
+			if sv.s.StopLoss.Status == "watching" {
+				errlog.Printf("%s %s: the stop at %s is not triggered until then", sv.s.Kind, sv.s.Id, sv.s.StopLoss.StopPrice)
+			}
+			return nil
+		case <-time.After(poll):
+		}
+	}
+}
+
+// run_oco places an oco or a bracket and supervises it
+func run_oco(c *cli.Context, api gemini_client, kind string) error {
+	s, err := new_oco_state(c, api, kind)
+	if err != nil {
+		return err
+	}
+	path, err := state_path(c, s.Id)
+	if err != nil {
+		return err
+	}
+
+	if c.Bool("dry-run") {
+		var requests []signed_request
+		for _, l := range s.legs() {
+			if l.Status != "pending" {
+				continue
+			}
+			req, err := api.SignRequest(new_order_URI, new_order_params(l.request(s.Symbol)))
+			if err != nil {
+				return err
+			}
+			requests = append(requests, req)
+		}
+		return print_output(c, requests)
+	}
+	if err := new_state_file(path); err != nil {
+		return err
+	}
+	if err := confirm(c, fmt.Sprintf("Place an %s", strings.ToUpper(kind)), oco_summary(c, s, path)); err != nil {
+		return err
+	}
+
+	sv := &oco_supervisor{c: c, api: api, s: s, path: path, w: new_stream_writer(c)}
+	if err := sv.save(); err != nil {
+		return err
+	}
+	// the take-profit of an oco is placed at once, the oco stops if it is refused
+	if kind == "oco" {
+		if err := sv.place(&s.TakeProfit); err != nil {
+			s.Status = "done"
+			if err := sv.save(); err != nil {
+				errlog.Printf("Warning %s: %s", s.Id, err)
+			}
+			return fmt.Errorf("Error %s: %s", s.TakeProfit.Name, one_line(err))
+		}
+	}
+	return sv.run()
+}
+
+// run_supervise resumes the oco or bracket of --state after a restart
+func run_supervise(c *cli.Context, api gemini_client) error {
+	path := c.String("state")
+	var s oco_state
+	if err := load_state(path, &s); err != nil {
+		return err
+	}
+	if s.Kind != "oco" && s.Kind != "bracket" {
+		return fmt.Errorf("Error state file %s is not an oco or a bracket", path)
+	}
+	if err := check_state_scope(c, path, s.Url, s.Account); err != nil {
+		return err
+	}
+	if s.Status == "done" {
+		return fmt.Errorf("Error %s %s is done, there is nothing to supervise", s.Kind, s.Id)
+	}
+	sv := &oco_supervisor{c: c, api: api, s: &s, path: path, w: new_stream_writer(c), resumed: true}
+	return sv.run()
+}
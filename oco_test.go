@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/claudiocandio/gemini-api"
+)
+
+// jsonl_lines unmarshals every line of out into v, a pointer to an event,
+// and returns what format makes of each one
+func jsonl_lines(t *testing.T, out string, v interface{}, format func() string) []string {
+	t.Helper()
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		e := reflect.ValueOf(v).Elem()
+		e.Set(reflect.Zero(e.Type()))
+		if err := json.Unmarshal([]byte(line), v); err != nil {
+			t.Fatalf("%v\n%s", err, out)
+		}
+		lines = append(lines, format())
+	}
+	return lines
+}
+
+func check_events(t *testing.T, got, want []string) {
+	t.Helper()
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("events:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// read_state loads the state file path into v
+func read_state(t *testing.T, path string, v interface{}) {
+	t.Helper()
+	if err := load_state(path, v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func oco_events(t *testing.T, out string) []string {
+	t.Helper()
+	var e oco_event
+	return jsonl_lines(t, out, &e, func() string {
+		return strings.TrimSpace(e.Leg + " " + e.Event + " " + e.Amount.String())
+	})
+}
+
+func TestOrderOco(t *testing.T) {
+	server := use_fake_gemini(t)
+	// 1.5 BTC cover one exit of 1, not both
+	server.holdBalances = true
+	// the take-profit fills half, then the bid falls to the stop
+	server.tickerBids = []float64{99, 95}
+	server.onStatus = func(o *gemini.Order) {
+		switch o.ClientOrderId {
+		case "oco1-tp":
+			if o.ExecutedAmount == 0 {
+				fill_order(o, 0.5)
+			}
+		case "oco1-sl":
+			fill_order(o, 0.5)
+		}
+	}
+	out, err := run_app(t, nil, "-y", "-o", "jsonl", "order", "oco", "-t", "btcusd", "-s", "sell", "-a", "1",
+		"--take-profit", "110", "--stop-price", "95", "--stop-limit", "94", "-i", "oco1", "--poll", "10ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check_events(t, oco_events(t, out), []string{
+		"take_profit placed 1",
+		"take_profit fill 0.5",
+		"stop_loss triggered 0.5",
+		"take_profit cancelled 0.5",
+		"stop_loss placed 0.5",
+		"stop_loss fill 0.5",
+		"stop_loss filled 0.5",
+		"done 0",
+	})
+	if len(server.orders) != 2 || server.orders[1].ClientOrderId != "oco1-sl" || server.orders[1].Type != orderTypeLimit ||
+		server.orders[1].Price != 94 || server.orders[1].OriginalAmount != 0.5 {
+		t.Errorf("orders %+v", server.orders)
+	}
+	for _, o := range server.orders {
+		if o.IsLive {
+			t.Errorf("order %s is live", o.OrderId)
+		}
+	}
+	path := filepath.Join(os.Getenv("GEMINI_STATE_DIR"), "oco1.json")
+	var s oco_state
+	read_state(t, path, &s)
+	if s.Status != "done" || s.TakeProfit.Status != "cancelled" || s.StopLoss.Status != "filled" {
+		t.Errorf("state %+v", s)
+	}
+
+	// a take-profit the balance does not cover is refused
+	if _, err := run_app(t, nil, "-y", "order", "oco", "-t", "btcusd", "-s", "sell", "-a", "2",
+		"--take-profit", "110", "--stop-price", "95", "--stop-limit", "94", "-i", "oco2"); err == nil || !strings.Contains(err.Error(), "InsufficientFunds") {
+		t.Errorf("err = %v", err)
+	}
+
+	// the id is taken
+	if _, err := run_app(t, nil, "-y", "order", "oco", "-t", "btcusd", "-s", "sell", "-a", "1",
+		"--take-profit", "110", "--stop-price", "95", "--stop-limit", "94", "-i", "oco1"); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("err = %v", err)
+	}
+	if _, err := run_app(t, nil, "-y", "order", "oco", "-t", "btcusd", "-s", "sell", "-a", "1",
+		"--take-profit", "90", "--stop-price", "95", "--stop-limit", "94"); err == nil || !strings.Contains(err.Error(), "must be above the stop price") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestOrderBracket(t *testing.T) {
+	server := use_fake_gemini(t)
+	// the entry fills, then the bid falls to the stop and the stop fills
+	server.tickerBids = []float64{95}
+	server.onStatus = func(o *gemini.Order) {
+		if strings.HasSuffix(o.ClientOrderId, "-entry") || strings.HasSuffix(o.ClientOrderId, "-sl") {
+			fill_order(o, 2)
+		}
+	}
+	out, err := run_app(t, nil, "-y", "-o", "jsonl", "order", "bracket", "-t", "btcusd", "-s", "buy", "-a", "2", "-p", "100",
+		"--take-profit", "110", "--stop-price", "95", "--stop-limit", "94", "-i", "b1", "--poll", "10ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check_events(t, oco_events(t, out), []string{
+		"entry placed 2",
+		"entry fill 2",
+		"entry filled 2",
+		"take_profit placed 2",
+		"stop_loss triggered 2",
+		"take_profit cancelled 2",
+		"stop_loss placed 2",
+		"stop_loss fill 2",
+		"stop_loss filled 2",
+		"done 0",
+	})
+	if len(server.orders) != 3 || server.orders[1].Side != "sell" || server.orders[2].Side != "sell" {
+		t.Errorf("orders %+v", server.orders)
+	}
+
+	if _, err := run_app(t, nil, "-y", "order", "bracket", "-t", "btcusd", "-s", "buy", "-a", "2", "-p", "120",
+		"--take-profit", "110", "--stop-price", "95", "--stop-limit", "94"); err == nil || !strings.Contains(err.Error(), "must be between") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestOrderSupervise(t *testing.T) {
+	server := use_fake_gemini(t)
+	for _, args := range [][]string{
+		{"-s", "sell", "-p", "110", "-i", "r1-tp"},
+		{"-s", "sell", "-p", "94", "-i", "r1-sl"},
+	} {
+		if _, err := run_app(t, nil, append([]string{"-y", "order", "new", "-t", "btcusd", "-a", "1"}, args...)...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server.orders[0].IsLive, server.orders[0].IsCancelled = false, true
+	// the supervisor stopped after the stop was triggered and placed, before
+	// saving its order id
+	url, account := server.URL, ""
+	s := oco_state{Kind: "oco", Id: "r1", Url: url, Account: account, Symbol: "btcusd", Status: "active",
+		TakeProfit: oco_leg{Name: "take_profit", Side: "sell", Type: orderTypeLimit, Amount: dec("1"), Price: dec("110"),
+			ClientOrderId: "r1-tp", OrderId: "1001", Status: "cancelled"},
+		StopLoss: oco_leg{Name: "stop_loss", Side: "sell", Type: orderTypeLimit, Amount: dec("1"), Price: dec("94"),
+			StopPrice: dec("95"), ClientOrderId: "r1-sl", Status: "pending"},
+	}
+	path := filepath.Join(t.TempDir(), "r1.json")
+	if err := save_state(path, s); err != nil {
+		t.Fatal(err)
+	}
+
+	server.onStatus = func(o *gemini.Order) {
+		if o.ClientOrderId == "r1-sl" {
+			fill_order(o, 1)
+		}
+	}
+	out, err := run_app(t, nil, "-o", "jsonl", "order", "supervise", "--state", path, "--poll", "10ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check_events(t, oco_events(t, out), []string{
+		"stop_loss placed 1",
+		"stop_loss fill 1",
+		"stop_loss filled 1",
+		"done 0",
+	})
+	if len(server.orders) != 2 {
+		t.Errorf("%d orders, the stop was placed again", len(server.orders))
+	}
+	var resumed oco_state
+	read_state(t, path, &resumed)
+	if resumed.Status != "done" || resumed.StopLoss.OrderId != "1002" {
+		t.Errorf("state %+v", resumed)
+	}
+
+	if _, err := run_app(t, nil, "order", "supervise", "--state", path); err == nil || !strings.Contains(err.Error(), "is done") {
+		t.Errorf("err = %v", err)
+	}
+	s.Account = "other"
+	save_state(path, s)
+	if _, err := run_app(t, nil, "order", "supervise", "--state", path); err == nil || !strings.Contains(err.Error(), `not for`) {
+		t.Errorf("err = %v", err)
+	}
+}
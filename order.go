@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Gemini order types
+const (
+	orderTypeLimit     = "exchange limit"
+	orderTypeStopLimit = "exchange stop limit"
+)
+
+// Gemini order execution options
+const (
+	optionMakerOrCancel        = "maker-or-cancel"
+	optionImmediateOrCancel    = "immediate-or-cancel"
+	optionFillOrKill           = "fill-or-kill"
+	optionAuctionOnly          = "auction-only"
+	optionIndicationOfInterest = "indication-of-interest"
+)
+
+// order types accepted by --type
+var orderTypes = map[string]string{
+	"limit":      orderTypeLimit,
+	"stop-limit": orderTypeStopLimit,
+}
+
+var orderOptions = []string{
+	optionMakerOrCancel,
+	optionImmediateOrCancel,
+	optionFillOrKill,
+	optionAuctionOnly,
+	optionIndicationOfInterest,
+}
+
+type orderRequest struct {
+	Symbol        string
+	ClientOrderId string
+	Side          string
+	Type          string
+	Amount        decimal
+	Price         decimal
+	StopPrice     decimal
+	Options       []string
+}
+
+// parseOrderType converts the --type value in the Gemini order type
+func parseOrderType(orderType string) (string, error) {
+	if t, ok := orderTypes[orderType]; ok {
+		return t, nil
+	}
+	return "", fmt.Errorf("Error invalid order type: %s\nValid order types: limit, stop-limit", orderType)
+}
+
+// validate checks the order before it is sent, Gemini accepts at most one
+// execution option and none at all for stop-limit orders
+func (o *orderRequest) validate() error {
+
+	if o.Side != "buy" && o.Side != "sell" {
+		return fmt.Errorf("Error invalid side: %s\nValid sides: buy, sell", o.Side)
+	}
+	if o.Amount.sign() <= 0 {
+		return fmt.Errorf("Error invalid amount: %s, it must be greater than 0", o.Amount)
+	}
+	if o.Price.sign() <= 0 {
+		return fmt.Errorf("Error invalid price: %s, it must be greater than 0", o.Price)
+	}
+
+	for _, option := range o.Options {
+		if !contains(orderOptions, option) {
+			return fmt.Errorf("Error invalid option: %s\nValid options: %s", option, strings.Join(orderOptions, ", "))
+		}
+	}
+	for i, option := range o.Options {
+		if contains(o.Options[i+1:], option) {
+			return fmt.Errorf("Error option %s given more than once", option)
+		}
+	}
+	if len(o.Options) > 1 {
+		return fmt.Errorf("Error options %s cannot be combined, Gemini accepts only one execution option per order",
+			strings.Join(o.Options, ", "))
+	}
+
+	switch o.Type {
+	case orderTypeLimit:
+		if !o.StopPrice.is_zero() {
+			return fmt.Errorf("Error --stop_price is only valid for stop-limit orders")
+		}
+	case orderTypeStopLimit:
+		if o.StopPrice.sign() <= 0 {
+			return fmt.Errorf("Error stop-limit orders require --stop_price")
+		}
+		if len(o.Options) > 0 {
+			return fmt.Errorf("Error option %s is not supported by stop-limit orders", o.Options[0])
+		}
+		// a buy stop triggers when the price rises to the stop price,
+		// a sell stop when it falls to it
+		if o.Side == "buy" && o.StopPrice.cmp(o.Price) > 0 {
+			return fmt.Errorf("Error buy stop-limit: stop price %s must not be above the limit price %s",
+				o.StopPrice, o.Price)
+		}
+		if o.Side == "sell" && o.StopPrice.cmp(o.Price) < 0 {
+			return fmt.Errorf("Error sell stop-limit: stop price %s must not be below the limit price %s",
+				o.StopPrice, o.Price)
+		}
+	default:
+		return fmt.Errorf("Error invalid order type: %s", o.Type)
+	}
+
+	return nil
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
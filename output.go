@@ -0,0 +1,433 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/claudiocandio/gemini-api"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v2"
+)
+
+var outputFormats = []string{"json", "jsonl", "csv", "yaml", "table"}
+
+// bookRow is one bid or ask of an order book, the book is rendered
+// as a single list of rows for jsonl, csv and table output
+type bookRow struct {
+	Side   string  `json:"side"`
+	Price  float64 `json:"price,string"`
+	Amount float64 `json:"amount,string"`
+}
+
+func check_output(c *cli.Context) error {
+	output := c.String("output")
+	if !contains(outputFormats, output) {
+		return fmt.Errorf("Error invalid output format: %s\nValid output formats: %s", output, strings.Join(outputFormats, ", "))
+	}
+	return nil
+}
+
+// print_output prints the result of a command in the --output format
+func print_output(c *cli.Context, v interface{}) error {
+	out, err := format_output(c.String("output"), v)
+	if err != nil {
+		return err
+	}
+	stdlog.Print(out)
+	return nil
+}
+
+func format_output(output string, v interface{}) (string, error) {
+	switch output {
+	case "", "json":
+		j, err := json.MarshalIndent(v, "", " ")
+		if err != nil {
+			return "", err
+		}
+		return string(j), nil
+	case "jsonl":
+		return format_jsonl(v)
+	case "csv":
+		return format_csv(v)
+	case "yaml":
+		return format_yaml(v)
+	case "table":
+		return format_table(v)
+	}
+	return "", fmt.Errorf("Error invalid output format: %s", output)
+}
+
+// records converts a command result in a list of records, one for each
+// output line/row
+func records(v interface{}) []interface{} {
+
+	switch b := v.(type) {
+	case gemini.Book:
+		var rows []interface{}
+		for _, e := range b.Bids {
+			rows = append(rows, bookRow{Side: "bid", Price: e.Price, Amount: e.Amount})
+		}
+		for _, e := range b.Asks {
+			rows = append(rows, bookRow{Side: "ask", Price: e.Price, Amount: e.Amount})
+		}
+		return rows
+	case *gemini.Book:
+		return records(*b)
+	case book_stats:
+		var rows []interface{}
+		for _, d := range b.Depth {
+			rows = append(rows, book_depth_row{Time: b.Time, Symbol: b.Symbol, BestBid: b.BestBid, BestAsk: b.BestAsk,
+				SpreadBps: b.SpreadBps, Bps: d.Bps, BidAmount: d.BidAmount, AskAmount: d.AskAmount,
+				BidNotional: d.BidNotional, AskNotional: d.AskNotional, Imbalance: d.Imbalance})
+		}
+		return rows
+	}
+
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return []interface{}{rv.Interface()}
+	}
+
+	var rows []interface{}
+	for i := 0; i < rv.Len(); i++ {
+		e := rv.Index(i)
+		// e.g. TradeVolume is [][]TradeVolume
+		if e.Kind() == reflect.Slice {
+			rows = append(rows, records(e.Interface())...)
+		} else {
+			rows = append(rows, e.Interface())
+		}
+	}
+	return rows
+}
+
+func format_jsonl(v interface{}) (string, error) {
+	var buf bytes.Buffer
+	for _, r := range records(v) {
+		j, err := json.Marshal(r)
+		if err != nil {
+			return "", err
+		}
+		buf.Write(j)
+		buf.WriteByte('\n')
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+func format_yaml(v interface{}) (string, error) {
+	// going through json keeps the json field names and order
+	j, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	dec := json.NewDecoder(bytes.NewReader(j))
+	dec.UseNumber()
+	ms, err := yaml_value(dec)
+	if err != nil {
+		return "", err
+	}
+	y, err := yaml.Marshal(ms)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(y), "\n"), nil
+}
+
+// yaml_value reads the next json value, objects become a yaml.MapSlice
+// so the fields keep their order
+func yaml_value(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := tok.(type) {
+	case json.Delim:
+		if t == '{' {
+			ms := yaml.MapSlice{}
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				value, err := yaml_value(dec)
+				if err != nil {
+					return nil, err
+				}
+				ms = append(ms, yaml.MapItem{Key: key, Value: value})
+			}
+			_, err := dec.Token()
+			return ms, err
+		}
+		list := []interface{}{}
+		for dec.More() {
+			value, err := yaml_value(dec)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		_, err := dec.Token()
+		return list, err
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i, nil
+		}
+		return t.Float64()
+	}
+	return tok, nil
+}
+
+func format_csv(v interface{}) (string, error) {
+	header, rows := flatten_records(records(v))
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.Write(header); err != nil {
+		return "", err
+	}
+	if err := w.WriteAll(rows); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+func format_table(v interface{}) (string, error) {
+	recs := records(v)
+	header, rows := flatten_records(recs)
+
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+
+	// a single object is easier to read as a list of fields
+	rv := reflect.ValueOf(v)
+	if len(rows) == 1 && rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array && len(header) > 1 {
+		fmt.Fprintln(w, "FIELD\tVALUE")
+		for i, h := range header {
+			fmt.Fprintf(w, "%s\t%s\n", h, rows[0][i])
+		}
+	} else {
+		fmt.Fprintln(w, strings.ToUpper(strings.Join(header, "\t")))
+		for _, row := range rows {
+			fmt.Fprintln(w, strings.Join(row, "\t"))
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// flatten_records returns the column names and the rows of the records,
+// nested structs become "parent.field" columns
+func flatten_records(recs []interface{}) ([]string, [][]string) {
+	var header []string
+	var rows [][]string
+	for i, r := range recs {
+		var cols, values []string
+		flatten(reflect.ValueOf(r), "", &cols, &values)
+		if i == 0 {
+			header = cols
+		}
+		rows = append(rows, values)
+	}
+	if header == nil {
+		header = []string{"value"}
+	}
+	return header, rows
+}
+
+// flat_record is a record with its own csv and table columns, e.g. the
+// candles with the indicators asked on the command line
+type flat_record interface {
+	flat() ([]string, []string)
+}
+
+func flatten(v reflect.Value, prefix string, cols, values *[]string) {
+
+	if v.IsValid() && v.CanInterface() {
+		if r, ok := v.Interface().(flat_record); ok {
+			c, vs := r.flat()
+			for i := range c {
+				*cols = append(*cols, column_name(prefix, c[i]))
+			}
+			*values = append(*values, vs...)
+			return
+		}
+	}
+
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			*cols = append(*cols, column_name(prefix, "value"))
+			*values = append(*values, "")
+			return
+		}
+		v = v.Elem()
+	}
+
+	if v.Kind() == reflect.Struct && v.Type() != reflect.TypeOf(time.Time{}) && v.Type() != reflect.TypeOf(decimal{}) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			name := strings.Split(f.Tag.Get("json"), ",")[0]
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			flatten(v.Field(i), column_name(prefix, name), cols, values)
+		}
+		return
+	}
+
+	if prefix == "" {
+		prefix = "value"
+	}
+	*cols = append(*cols, prefix)
+	*values = append(*values, format_value(v))
+}
+
+func column_name(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+func format_value(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64)
+	case reflect.Struct:
+		if t, ok := v.Interface().(time.Time); ok {
+			if t.IsZero() {
+				return ""
+			}
+			return t.Format(time.RFC3339)
+		}
+		if d, ok := v.Interface().(decimal); ok {
+			return d.String()
+		}
+	case reflect.Slice, reflect.Array:
+		// a list of strings or numbers fits in one cell
+		if v.Type().Elem().Kind() != reflect.Struct {
+			var s []string
+			for i := 0; i < v.Len(); i++ {
+				s = append(s, format_value(v.Index(i)))
+			}
+			return strings.Join(s, " ")
+		}
+	}
+	j, err := json.Marshal(v.Interface())
+	if err != nil {
+		return fmt.Sprintf("%v", v.Interface())
+	}
+	return string(j)
+}
+
+// stream_writer prints the records of a stream as they arrive: json, jsonl
+// and yaml one record at a time, csv and table a header and then one row
+// per record
+type stream_writer struct {
+	mu     sync.Mutex
+	output string
+	// stop after limit records, 0 never, done is called when it is reached
+	limit int
+	count int
+	done  func()
+
+	header []string
+	widths []int
+}
+
+func new_stream_writer(c *cli.Context) *stream_writer {
+	return &stream_writer{output: c.String("output"), limit: c.Int("count")}
+}
+
+func (w *stream_writer) write(v interface{}) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.limit > 0 && w.count >= w.limit {
+		return nil
+	}
+	w.count++
+	if w.limit > 0 && w.count == w.limit && w.done != nil {
+		defer w.done()
+	}
+
+	switch w.output {
+	case "csv":
+		header, rows := flatten_records([]interface{}{v})
+		var buf bytes.Buffer
+		cw := csv.NewWriter(&buf)
+		if w.header == nil {
+			w.header = header
+			cw.Write(header)
+		}
+		cw.WriteAll(rows)
+		stdlog.Print(strings.TrimSuffix(buf.String(), "\n"))
+	case "table":
+		header, rows := flatten_records([]interface{}{v})
+		if w.header == nil {
+			w.header = header
+			for _, h := range header {
+				w.widths = append(w.widths, len(h))
+			}
+			stdlog.Print(w.table_row(strings.Split(strings.ToUpper(strings.Join(header, "\t")), "\t")))
+		}
+		stdlog.Print(w.table_row(rows[0]))
+	case "yaml":
+		out, err := format_yaml(v)
+		if err != nil {
+			return err
+		}
+		stdlog.Print("---\n" + out)
+	default:
+		out, err := format_output(w.output, v)
+		if err != nil {
+			return err
+		}
+		stdlog.Print(out)
+	}
+	return nil
+}
+
+// table_row pads the columns to the widest value seen so far
+func (w *stream_writer) table_row(row []string) string {
+	var cells []string
+	for i, cell := range row {
+		if i < len(w.widths) {
+			if len(cell) > w.widths[i] {
+				w.widths[i] = len(cell)
+			}
+			if i < len(row)-1 {
+				cell += strings.Repeat(" ", w.widths[i]-len(cell))
+			}
+		}
+		cells = append(cells, cell)
+	}
+	return strings.Join(cells, "  ")
+}
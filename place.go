@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/claudiocandio/gemini-api"
+	"github.com/urfave/cli/v2"
+)
+
+// prepare_order runs the checks of every new order before it is sent: the
+// order itself, the symbol details, rounding with --round, and the risk
+// limits of the profile. details caches the symbol details, it can be nil
+func prepare_order(c *cli.Context, api gemini_client, o *orderRequest, details map[string]symbol_details) error {
+	if err := o.validate(); err != nil {
+		return err
+	}
+
+	symbol := strings.ToLower(o.Symbol)
+	d, ok := details[symbol]
+	if !ok {
+		var err error
+		// /v1/symbols/details/:symbol
+		if d, err = api.SymbolDetails(symbol); err != nil {
+			return err
+		}
+		if details != nil {
+			details[symbol] = d
+		}
+	}
+	if err := d.check_order(o, c.Bool("round")); err != nil {
+		return err
+	}
+
+	risk, err := get_risk(c)
+	if err != nil {
+		return err
+	}
+	return risk.check_order(api, *o)
+}
+
+// place_order sends a prepared order and writes it in the journal
+func place_order(c *cli.Context, api gemini_client, o orderRequest) (gemini.Order, error) {
+	// /v1/order/new
+	placed, err := api.NewOrderType(o)
+	if err != nil {
+		return placed, err
+	}
+	append_journal(c, o, placed)
+	return placed, nil
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/claudiocandio/gemini-api"
+	"github.com/claudiocandio/gemini-api/logger"
+)
+
+// Past Trades
+// Args{"limit_trades": 50, "timestamp": time.Time}
+// limit_trades": 0 -> retrieves all trades
+func (api *gemini_api) PastTrades(symbol string, args gemini.Args) ([]gemini.PastTrade, error) {
+	const max_limit_tradesAPI int = 500
+
+	var maxTrades, limit_trades int = 0, max_limit_tradesAPI
+	if arg, ok := args["limit_trades"].(int); ok {
+		limit_trades = arg
+		maxTrades = limit_trades
+		if limit_trades > max_limit_tradesAPI || limit_trades == 0 {
+			limit_trades = max_limit_tradesAPI
+		}
+	}
+
+	var timestamp int64 = 0 // default
+	if arg, ok := args["timestamp"].(time.Time); ok {
+		// timestamp ms
+		timestamp = arg.UnixNano() / 1e6
+	}
+
+	var pastTrade []gemini.PastTrade
+
+	for {
+		params := map[string]interface{}{
+			"symbol":       symbol,
+			"limit_trades": limit_trades,
+			"timestamp":    timestamp,
+		}
+
+		var ptrade []gemini.PastTrade
+		if err := api.private_request(past_trades_URI, params, &ptrade); err != nil {
+			return nil, err
+		}
+
+		pastTrade = append(pastTrade, ptrade...)
+
+		if len(ptrade) > 0 &&
+			(len(pastTrade) < maxTrades || maxTrades == 0) &&
+			len(ptrade) == limit_trades {
+
+			// trades are newest first, next page starts after the newest one
+			timestamp = ptrade[0].Timestamp + 1
+			logger.Debug("func PastTrades: next limit_trades page")
+		} else {
+			logger.Debug("func PastTrades: end limit_trades page")
+			break
+		}
+	}
+
+	if maxTrades > 0 && len(pastTrade) > maxTrades {
+		pastTrade = pastTrade[:maxTrades]
+	}
+
+	// adding TimestampmsT
+	for i, t := range pastTrade {
+		pastTrade[i].TimestampmsT = msToTime(t.Timestampms)
+	}
+
+	logger.Debug("func PastTrades: unmarshal",
+		fmt.Sprintf("pastTrade:%+v", pastTrade),
+	)
+
+	return pastTrade, nil
+}
+
+// Trade Volume
+func (api *gemini_api) TradeVolume() ([][]gemini.TradeVolume, error) {
+
+	var tradeVolume [][]gemini.TradeVolume
+	if err := api.private_request(trade_volume_URI, nil, &tradeVolume); err != nil {
+		return nil, err
+	}
+
+	logger.Debug("func TradeVolume: unmarshal",
+		fmt.Sprintf("tradeVolume:%+v", tradeVolume),
+	)
+
+	return tradeVolume, nil
+}
+
+// Active Orders
+func (api *gemini_api) ActiveOrders() ([]gemini.Order, error) {
+
+	var order []gemini.Order
+	if err := api.private_request(active_orders_URI, nil, &order); err != nil {
+		return nil, err
+	}
+
+	// adding TimestampmsT
+	for i, o := range order {
+		order[i].TimestampmsT = msToTime(o.Timestampms)
+	}
+
+	logger.Debug("func ActiveOrders: unmarshal",
+		fmt.Sprintf("orders:%+v", order),
+	)
+
+	return order, nil
+}
+
+// Order Status
+func (api *gemini_api) OrderStatus(orderId string) (gemini.Order, error) {
+
+	params := map[string]interface{}{
+		"order_id": orderId,
+	}
+
+	var order gemini.Order
+	if err := api.private_request(order_status_URI, params, &order); err != nil {
+		return order, err
+	}
+	order.TimestampmsT = msToTime(order.Timestampms)
+
+	logger.Debug("func OrderStatus: unmarshal",
+		fmt.Sprintf("order:%+v", order),
+	)
+
+	return order, nil
+}
+
+// Order Status with the trades of the order
+func (api *gemini_api) OrderStatusTrades(orderId string) (order_trades, error) {
+
+	params := map[string]interface{}{
+		"order_id":       orderId,
+		"include_trades": true,
+	}
+
+	var order order_trades
+	if err := api.private_request(order_status_URI, params, &order); err != nil {
+		return order, err
+	}
+	order.TimestampmsT = msToTime(order.Timestampms)
+	for i, t := range order.Trades {
+		order.Trades[i].TimestampmsT = msToTime(t.Timestampms)
+	}
+
+	logger.Debug("func OrderStatusTrades: unmarshal",
+		fmt.Sprintf("order:%+v", order),
+	)
+
+	return order, nil
+}
+
+// new_order_params are the /v1/order/new parameters of an order
+func new_order_params(o orderRequest) map[string]interface{} {
+	params := map[string]interface{}{
+		"client_order_id": o.ClientOrderId,
+		"symbol":          o.Symbol,
+		"amount":          o.Amount.String(),
+		"price":           o.Price.String(),
+		"side":            o.Side,
+		"type":            o.Type,
+	}
+	if o.Type == orderTypeStopLimit {
+		params["stop_price"] = o.StopPrice.String()
+	}
+	if len(o.Options) > 0 {
+		params["options"] = o.Options
+	}
+	return params
+}
+
+// New Order
+// the gemini-api wrapper always sends "exchange limit", this supports all
+// order types and the stop_price of stop-limit orders
+func (api *gemini_api) NewOrderType(o orderRequest) (gemini.Order, error) {
+
+	var order gemini.Order
+	if err := api.private_request(new_order_URI, new_order_params(o), &order); err != nil {
+		return order, err
+	}
+	order.TimestampmsT = msToTime(order.Timestampms)
+
+	logger.Debug("func NewOrderType: unmarshal",
+		fmt.Sprintf("order:%+v", order),
+	)
+
+	return order, nil
+}
+
+// Cancel Order
+func (api *gemini_api) CancelOrder(orderId string) (gemini.Order, error) {
+
+	params := map[string]interface{}{
+		"order_id": orderId,
+	}
+
+	var order gemini.Order
+	if err := api.private_request(cancel_order_URI, params, &order); err != nil {
+		return order, err
+	}
+	order.TimestampmsT = msToTime(order.Timestampms)
+
+	logger.Debug("func CancelOrder: unmarshal",
+		fmt.Sprintf("order:%+v", order),
+	)
+
+	return order, nil
+}
+
+// Cancel All
+// This will cancel all outstanding orders created by all sessions owned
+// by this account, including interactive orders placed through the UI.
+// Note that this cancels orders that were not placed using this API key.
+func (api *gemini_api) CancelAll() (gemini.CancelResult, error) {
+
+	var cancelResult gemini.CancelResult
+	if err := api.private_request(cancel_all_URI, nil, &cancelResult); err != nil {
+		return cancelResult, err
+	}
+
+	logger.Debug("func CancelAll: unmarshal",
+		fmt.Sprintf("cancelResult:%+v", cancelResult),
+	)
+
+	return cancelResult, nil
+}
+
+// Cancel Session
+// This will cancel all orders opened by this session, the orders of the
+// other sessions and of the UI are not touched. This has the same effect
+// as the heartbeat expiration if "Require Heartbeat" is selected for the session.
+func (api *gemini_api) CancelSession() (gemini.CancelResult, error) {
+
+	var cancelResult gemini.CancelResult
+	if err := api.private_request(cancel_session_URI, nil, &cancelResult); err != nil {
+		return cancelResult, err
+	}
+
+	logger.Debug("func CancelSession: unmarshal",
+		fmt.Sprintf("cancelResult:%+v", cancelResult),
+	)
+
+	return cancelResult, nil
+}
+
+// Heartbeat
+// This will prevent a session from timing out and canceling orders if the
+// require heartbeat flag has been set. Note that this is only required if
+// no other private API requests have been made. The arrival of any message
+// resets the heartbeat timer.
+func (api *gemini_api) Heartbeat() (gemini.GenericResponse, error) {
+
+	var genericResponse gemini.GenericResponse
+	if err := api.private_request(heartbeat_URI, nil, &genericResponse); err != nil {
+		return genericResponse, err
+	}
+
+	logger.Debug("func Heartbeat: unmarshal",
+		fmt.Sprintf("genericResponse:%+v", genericResponse),
+	)
+
+	return genericResponse, nil
+}
+
+// Balances
+func (api *gemini_api) Balances() ([]gemini.FundBalance, error) {
+
+	var fundBalance []gemini.FundBalance
+	if err := api.private_request(balances_URI, nil, &fundBalance); err != nil {
+		return nil, err
+	}
+
+	logger.Debug("func Balances: unmarshal",
+		fmt.Sprintf("fundBalance:%+v", fundBalance),
+	)
+
+	return fundBalance, nil
+}
+
+// Account
+func (api *gemini_api) AccountDetail() (gemini.AccountDetail, error) {
+
+	var accountDetail gemini.AccountDetail
+	if err := api.private_request(account_URI, nil, &accountDetail); err != nil {
+		return accountDetail, err
+	}
+
+	accountDetail.Account.CreatedT = msToTime(accountDetail.Account.Created)
+
+	logger.Debug("func AccountDetail: unmarshal",
+		fmt.Sprintf("accountDetail:%+v", accountDetail),
+	)
+
+	return accountDetail, nil
+}
+
+// New Deposit Address
+// currency can be bitcoin, ethereum, bitcoincash, litecoin, zcash, or filecoin
+func (api *gemini_api) NewDepositAddress(currency, label string) (gemini.NewDepositAddress, error) {
+
+	params := map[string]interface{}{
+		"label": label,
+	}
+
+	var newDepositAddress gemini.NewDepositAddress
+	if err := api.private_request(new_deposit_address_URI+currency+"/newAddress", params, &newDepositAddress); err != nil {
+		return newDepositAddress, err
+	}
+
+	logger.Debug("func NewDepositAddress: unmarshal",
+		fmt.Sprintf("newDepositAddress:%+v", newDepositAddress),
+	)
+
+	return newDepositAddress, nil
+}
+
+// Get Deposit Addresses
+// currency can be bitcoin, ethereum, bitcoincash, litecoin, zcash, filecoin
+func (api *gemini_api) DepositAddresses(currency string) ([]gemini.DepositAddresses, error) {
+
+	var depositAddresses []gemini.DepositAddresses
+	if err := api.private_request(deposit_addresses_URI+currency, nil, &depositAddresses); err != nil {
+		return nil, err
+	}
+
+	// adding TimestampmsT
+	for i, address := range depositAddresses {
+		depositAddresses[i].TimestampmsT = msToTime(address.Timestamp)
+	}
+
+	logger.Debug("func DepositAddresses: unmarshal",
+		fmt.Sprintf("depositAddresses:%+v", depositAddresses),
+	)
+
+	return depositAddresses, nil
+}
+
+// withdraw_params are the /v1/withdraw/:currency parameters
+func withdraw_params(address string, amount decimal) map[string]interface{} {
+	return map[string]interface{}{
+		"address": address,
+		"amount":  amount.String(),
+	}
+}
+
+// Withdraw Crypto Funds
+// currency can be btc or eth
+func (api *gemini_api) WithdrawFunds(currency, address string, amount decimal) (gemini.WithdrawFundsResult, error) {
+
+	var withdrawFundsResult gemini.WithdrawFundsResult
+	if err := api.private_request(withdraw_funds_URI+currency, withdraw_params(address, amount), &withdrawFundsResult); err != nil {
+		return withdrawFundsResult, err
+	}
+
+	logger.Debug("func WithdrawFunds: unmarshal",
+		fmt.Sprintf("withdrawFundsResult:%+v", withdrawFundsResult),
+	)
+
+	return withdrawFundsResult, nil
+}
+
+// Transfers
+// Args{"timestamp": 1612381252885, "limit_transfers": 20, "show_completed_deposit_advances": "true"}
+func (api *gemini_api) Transfers(args gemini.Args) ([]gemini.Transfer, error) {
+
+	params := map[string]interface{}{}
+	for k, v := range args {
+		params[k] = v
+	}
+
+	var transfer []gemini.Transfer
+	if err := api.private_request(transfers_URI, params, &transfer); err != nil {
+		return nil, err
+	}
+
+	// adding TimestampmsT
+	for i, tr := range transfer {
+		transfer[i].TimestampmsT = msToTime(tr.Timestampms)
+	}
+
+	logger.Debug("func Transfers: unmarshal",
+		fmt.Sprintf("transfer:%+v", transfer),
+	)
+
+	return transfer, nil
+}
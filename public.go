@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/claudiocandio/gemini-api"
+	"github.com/claudiocandio/gemini-api/logger"
+)
+
+// Symbols
+func (api *gemini_api) Symbols() ([]string, error) {
+
+	var symbols []string
+	if err := api.public_request(symbols_URI, nil, &symbols); err != nil {
+		return nil, err
+	}
+
+	logger.Debug("func Symbols: unmarshal",
+		fmt.Sprintf("symbols:%+v", symbols),
+	)
+
+	return symbols, nil
+}
+
+// TickerV2
+func (api *gemini_api) TickerV2(symbol string) (gemini.TickerV2, error) {
+
+	var tickerV2 gemini.TickerV2
+	if err := api.public_request(ticker_v2_URI+symbol, nil, &tickerV2); err != nil {
+		return tickerV2, err
+	}
+
+	logger.Debug("func TickerV2: unmarshal",
+		fmt.Sprintf("tickerV2:%+v", tickerV2),
+	)
+
+	return tickerV2, nil
+}
+
+// Symbol Details, the increments and the status of a symbol
+func (api *gemini_api) SymbolDetails(symbol string) (symbol_details, error) {
+
+	var details symbol_details
+	if err := api.public_request(symbol_details_URI+symbol, nil, &details); err != nil {
+		return details, err
+	}
+
+	logger.Debug("func SymbolDetails: unmarshal",
+		fmt.Sprintf("details:%+v", details),
+	)
+
+	return details, nil
+}
+
+// Candles of time_frame e.g. 1hr, newest first
+func (api *gemini_api) Candles(symbol, time_frame string) ([]candle, error) {
+
+	var values [][]json.Number
+	if err := api.public_request(candles_URI+symbol+"/"+time_frame, nil, &values); err != nil {
+		return nil, err
+	}
+
+	candles := make([]candle, 0, len(values))
+	for _, v := range values {
+		c, err := parse_candle(symbol, v)
+		if err != nil {
+			return nil, err
+		}
+		candles = append(candles, c)
+	}
+
+	logger.Debug("func Candles: unmarshal",
+		fmt.Sprintf("candles:%d", len(candles)),
+	)
+
+	return candles, nil
+}
+
+// Order Book
+// Args{"limit_bids": 1, "limit_asks": 1}
+func (api *gemini_api) OrderBook(symbol string, args gemini.Args) (gemini.Book, error) {
+
+	var book gemini.Book
+	if err := api.public_request(book_URI+symbol, args, &book); err != nil {
+		return book, err
+	}
+
+	logger.Debug("func OrderBook: unmarshal",
+		fmt.Sprintf("book:%+v", book),
+	)
+
+	return book, nil
+}
+
+// Trades
+// Args{"timestamp": time.Time, "limit_trades": 30, "include_breaks": "true"}
+func (api *gemini_api) Trades(symbol string, args gemini.Args) ([]gemini.Trade, error) {
+
+	var trade []gemini.Trade
+	if err := api.public_request(trades_URI+symbol, args, &trade); err != nil {
+		return nil, err
+	}
+
+	// adding TimestampmsT
+	for i, r := range trade {
+		trade[i].TimestampmsT = msToTime(r.Timestampms)
+	}
+
+	logger.Debug("func Trades: unmarshal",
+		fmt.Sprintf("trade:%+v", trade),
+	)
+
+	return trade, nil
+}
+
+// Current Auction
+func (api *gemini_api) CurrentAuction(symbol string) (gemini.CurrentAuction, error) {
+
+	var currentAuction gemini.CurrentAuction
+	if err := api.public_request(auction_URI+symbol, nil, &currentAuction); err != nil {
+		return currentAuction, err
+	}
+
+	// adding TimestampmsT
+	if currentAuction.NextAuction > 0 {
+		currentAuction.NextAuctionT = msToTime(currentAuction.NextAuction)
+	}
+	if currentAuction.NextUpdate > 0 {
+		currentAuction.NextUpdateT = msToTime(currentAuction.NextUpdate)
+	}
+
+	logger.Debug("func CurrentAuction: unmarshal",
+		fmt.Sprintf("currentAuction:%+v", currentAuction),
+	)
+
+	return currentAuction, nil
+}
+
+// Auction History
+// Args{"since": time.Time, "limit_auction_results": 50, "include_indicative": "true"}
+func (api *gemini_api) AuctionHistory(symbol string, args gemini.Args) ([]gemini.Auction, error) {
+
+	var auction []gemini.Auction
+	if err := api.public_request(auction_URI+symbol+"/history", args, &auction); err != nil {
+		return nil, err
+	}
+
+	// adding TimestampmsT
+	for i, au := range auction {
+		auction[i].TimestampmsT = msToTime(au.Timestampms)
+	}
+
+	logger.Debug("func AuctionHistory: unmarshal",
+		fmt.Sprintf("auction:%+v", auction),
+	)
+
+	return auction, nil
+}
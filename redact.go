@@ -0,0 +1,121 @@
+package main
+
+import (
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+const redacted = "[REDACTED]"
+
+// sensitive names: API keys, secrets, passphrases, request signatures and
+// payloads, deposit addresses and withdrawal destinations
+const sensitive_names = `[a-z_]*key|[a-z_]*secret|passphrase|signature|payload|address|destination`
+
+var (
+	// X-Gemini-Payload:[eyJ...] in the %v of an http.Request
+	redact_header = regexp.MustCompile(`(?i)(X-Gemini-(?:Apikey|Payload|Signature):\[)[^\]]*`)
+	// "address":"..." in json
+	redact_json = regexp.MustCompile(`(?i)("(?:` + sensitive_names + `)"\s*:\s*)"[^"]*"`)
+	// address:... in the %v of maps and the %+v of structs
+	redact_field = regexp.MustCompile(`(?i)\b((?:` + sensitive_names + `):)[^\s\[\]\}"]+`)
+	// a log field named after a sensitive value e.g. "address:"
+	redact_name = regexp.MustCompile(`(?i)^(?:` + sensitive_names + `):?$`)
+)
+
+// redactor masks secrets in everything gemini_cli logs, the values it
+// knows, e.g. the API key and secret, and the sensitive fields it finds
+type redactor struct {
+	mu       sync.RWMutex
+	values   []string
+	disabled bool
+}
+
+var redact = &redactor{}
+
+func init() {
+	logrus.AddHook(redact_hook{redact})
+}
+
+// add registers values to mask wherever they appear
+func (r *redactor) add(values ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, v := range values {
+		// too short values would mask unrelated text
+		if len(v) >= 6 && !contains(r.values, v) {
+			r.values = append(r.values, v)
+		}
+	}
+	// longest first so a value containing another one is masked whole
+	sort.Slice(r.values, func(i, j int) bool { return len(r.values[i]) > len(r.values[j]) })
+}
+
+// show_secrets disables the redaction, --unsafe-show-secrets
+func (r *redactor) show_secrets(show bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.disabled = show
+}
+
+func (r *redactor) String(s string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.disabled {
+		return s
+	}
+	s = redact_header.ReplaceAllString(s, "${1}"+redacted)
+	s = redact_json.ReplaceAllString(s, `${1}"`+redacted+`"`)
+	s = redact_field.ReplaceAllString(s, "${1}"+redacted)
+	for _, v := range r.values {
+		s = strings.Replace(s, v, redacted, -1)
+	}
+	return s
+}
+
+// field masks the value of a log field
+func (r *redactor) field(name, value string) string {
+	r.mu.RLock()
+	disabled := r.disabled
+	r.mu.RUnlock()
+	if !disabled && redact_name.MatchString(strings.TrimSpace(name)) {
+		return redacted
+	}
+	return r.String(value)
+}
+
+// redact_hook masks the message and the fields of every logrus entry,
+// it covers the logs of the gemini-api logger package too
+type redact_hook struct {
+	r *redactor
+}
+
+func (h redact_hook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h redact_hook) Fire(entry *logrus.Entry) error {
+	entry.Message = h.r.String(entry.Message)
+	for k, v := range entry.Data {
+		if s, ok := v.(string); ok {
+			entry.Data[k] = h.r.field(k, s)
+		}
+	}
+	return nil
+}
+
+// redact_writer masks what is written to w, used for errlog
+type redact_writer struct {
+	w io.Writer
+}
+
+func (rw redact_writer) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(rw.w, redact.String(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
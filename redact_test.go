@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/claudiocandio/gemini-api/logger"
+	"github.com/sirupsen/logrus"
+)
+
+func TestRedactString(t *testing.T) {
+	r := &redactor{}
+	r.add("account-fakekey", "fakesecret", "abc")
+
+	tests := []struct {
+		in, want string
+	}{
+		{"api:&{url:http://x key:account-fakekey secret:fakesecret}", "api:&{url:http://x key:[REDACTED] secret:[REDACTED]}"},
+		{"req:map[X-Gemini-Apikey:[k1] X-Gemini-Payload:[eyJub25jZSI6MX0=] X-Gemini-Signature:[abcdef]]",
+			"req:map[X-Gemini-Apikey:[[REDACTED]] X-Gemini-Payload:[[REDACTED]] X-Gemini-Signature:[[REDACTED]]]"},
+		{`body:[{"address":"n2saq73aDTu42bRgEHd8gd4to1gCzHxrdj","timestamp":1}]`, `body:[{"address":"[REDACTED]","timestamp":1}]`},
+		{"params:map[address:mpXEeWuc7tSB4BVEoYpStmj4MFrmrLiRKL amount:0.01]", "params:map[address:[REDACTED] amount:0.01]"},
+		{"transfer:[{Type:Withdrawal Destination:0xabc Amount:1}]", "transfer:[{Type:Withdrawal Destination:[REDACTED] Amount:1}]"},
+		// too short values are not registered
+		{"abc", "abc"},
+	}
+	for _, tt := range tests {
+		if got := r.String(tt.in); got != tt.want {
+			t.Errorf("String(%q)\n got %q\nwant %q", tt.in, got, tt.want)
+		}
+	}
+
+	r.show_secrets(true)
+	if got := r.String("secret:fakesecret"); got != "secret:fakesecret" {
+		t.Errorf("with show_secrets got %q", got)
+	}
+}
+
+// run_app_logs runs gemini_cli and returns what it logged
+func run_app_logs(t *testing.T, args ...string) string {
+	t.Helper()
+	var logs bytes.Buffer
+	logrus.SetOutput(&logs)
+	defer func() {
+		logrus.SetOutput(os.Stdout)
+		logger.SetLevel(logger.InfoLevel)
+		redact.show_secrets(false)
+	}()
+	if _, err := run_app(t, nil, args...); err != nil {
+		t.Fatal(err)
+	}
+	return logs.String()
+}
+
+func TestTraceRedacted(t *testing.T) {
+	use_fake_gemini(t)
+	address := "mpXEeWuc7tSB4BVEoYpStmj4MFrmrLiRKL"
+
+	logs := run_app_logs(t, "--trace", "-y", "withdraw", "-c", "btc", "-a", address, "--amount", "0.01")
+	if logs == "" {
+		t.Fatal("nothing logged")
+	}
+	for _, secret := range []string{fake_key, fake_secret, address, "X-Gemini-Payload:[eyJ"} {
+		if strings.Contains(logs, secret) {
+			t.Errorf("logs contain %s:\n%s", secret, logs)
+		}
+	}
+
+	logs = run_app_logs(t, "--trace", "--unsafe-show-secrets", "-y", "withdraw", "-c", "btc", "-a", address, "--amount", "0.01")
+	if !strings.Contains(logs, address) || !strings.Contains(logs, fake_key) {
+		t.Errorf("--unsafe-show-secrets logs do not show the secrets:\n%s", logs)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/claudiocandio/gemini-api"
+	"github.com/claudiocandio/gemini-api/logger"
+	"github.com/urfave/cli/v2"
+)
+
+// how often order replace reads the status of the cancelled order until
+// it is no longer live
+var replace_poll = 250 * time.Millisecond
+
+// client order ids of the replacements end with -r and the replace count
+var replacedClientOrderId = regexp.MustCompile(`^(.*)-r([0-9]+)$`)
+
+// replace_result is what order replace prints
+type replace_result struct {
+	OrderId string `json:"order_id"`
+	// filled before the cancel, it is not placed again
+	ExecutedAmount     decimal `json:"executed_amount"`
+	ReplacementOrderId string  `json:"replacement_order_id"`
+	ClientOrderId      string  `json:"client_order_id"`
+	Symbol             string  `json:"symbol"`
+	Side               string  `json:"side"`
+	Amount             decimal `json:"amount"`
+	Price              decimal `json:"price"`
+	// replaced, or filled when nothing was left to place
+	Status string `json:"status"`
+}
+
+// replacement_client_order_id derives the client order id of a replacement:
+// mybot-1 becomes mybot-1-r1, then mybot-1-r2, an order without one gets
+// replace-<order id>-r1
+func replacement_client_order_id(o gemini.Order) string {
+	base, n := o.ClientOrderId, 0
+	if m := replacedClientOrderId.FindStringSubmatch(base); m != nil {
+		base = m[1]
+		n, _ = strconv.Atoi(m[2])
+	}
+	if base == "" {
+		base = "replace-" + o.OrderId
+	}
+	return fmt.Sprintf("%s-r%d", base, n+1)
+}
+
+// unfilled is what is left to place of an order of total amount
+func unfilled(o gemini.Order, amount decimal) decimal {
+	if amount.is_zero() {
+		amount = decimal_from_float(o.OriginalAmount)
+	}
+	return amount.sub(decimal_from_float(o.ExecutedAmount))
+}
+
+// wait_cancelled reads the order status until the order is no longer live
+func wait_cancelled(api gemini_client, orderId string, timeout time.Duration) (gemini.Order, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		// /v1/order/status
+		o, err := api.OrderStatus(orderId)
+		if err == nil && !o.IsLive {
+			return o, nil
+		}
+		if time.Now().After(deadline) {
+			if err != nil {
+				return o, fmt.Errorf("Error cannot confirm the cancel of order %s: %s", orderId, err)
+			}
+			return o, fmt.Errorf("Error order %s is still live %s after the cancel", orderId, timeout)
+		}
+		time.Sleep(replace_poll)
+	}
+}
+
+// cancel_confirmed cancels an order and reads its status until it is no
+// longer live. A failed cancel is checked once: the order may have been
+// filled meanwhile, otherwise it is still live and the cancel error returned
+func cancel_confirmed(api gemini_client, orderId string, timeout time.Duration) (gemini.Order, error) {
+	// /v1/order/cancel
+	_, cancel_err := api.CancelOrder(orderId)
+	if cancel_err != nil {
+		timeout = 0
+	}
+	o, err := wait_cancelled(api, orderId, timeout)
+	if err != nil && cancel_err != nil {
+		return o, fmt.Errorf("Error cannot cancel order %s: %s", orderId, one_line(cancel_err))
+	}
+	return o, err
+}
+
+// run_replace cancels a limit order, reads what was filled until the cancel
+// and places the unfilled remainder at --price. --amount is the new total
+// amount of the order, what was filled is deducted from it
+func run_replace(c *cli.Context, api gemini_client) error {
+	orderId, err := resolve_order(c, api)
+	if err != nil {
+		return err
+	}
+	price, err := decimal_flag(c, "price")
+	if err != nil {
+		return err
+	}
+	amount, err := decimal_flag(c, "amount")
+	if err != nil {
+		return err
+	}
+	if c.IsSet("amount") && amount.sign() <= 0 {
+		return fmt.Errorf("Error invalid --amount: %s, it must be greater than 0", amount)
+	}
+
+	// /v1/order/status
+	original, err := api.OrderStatus(orderId)
+	if err != nil {
+		return err
+	}
+	if !original.IsLive {
+		return fmt.Errorf("Error order %s is not live, there is nothing to replace", orderId)
+	}
+	if original.Type != orderTypeLimit {
+		return fmt.Errorf("Error order %s is a %s order, only limit orders can be replaced", orderId, original.Type)
+	}
+	o := orderRequest{
+		Symbol:        strings.ToLower(original.Symbol),
+		ClientOrderId: replacement_client_order_id(original),
+		Side:          original.Side,
+		Type:          orderTypeLimit,
+		Amount:        unfilled(original, amount),
+		Price:         price,
+		Options:       original.Options,
+	}
+	if o.Amount.sign() <= 0 {
+		return fmt.Errorf("Error order %s has already executed %s, more than --amount %s", orderId, formatFloat(original.ExecutedAmount), amount)
+	}
+	// the replacement is checked before the original is cancelled
+	if err := prepare_order(c, api, &o, nil); err != nil {
+		return err
+	}
+
+	if c.Bool("dry-run") {
+		cancel, err := api.SignRequest(cancel_order_URI, map[string]interface{}{"order_id": orderId})
+		if err != nil {
+			return err
+		}
+		replacement, err := api.SignRequest(new_order_URI, new_order_params(o))
+		if err != nil {
+			return err
+		}
+		return print_output(c, []signed_request{cancel, replacement})
+	}
+	summary := append(order_summary(c, o),
+		summary_field{"replaces order", orderId},
+		summary_field{"old price", formatFloat(original.Price)})
+	if err := confirm(c, "Replace an order", summary); err != nil {
+		return err
+	}
+
+	// /v1/order/cancel
+	cancelled, err := cancel_confirmed(api, orderId, c.Duration("timeout"))
+	if err != nil {
+		return fmt.Errorf("%s, no replacement was placed", err)
+	}
+	logger.Debug("func run_replace: cancelled",
+		fmt.Sprintf("orderId:%s", orderId),
+		fmt.Sprintf("is_cancelled:%v", cancelled.IsCancelled),
+		fmt.Sprintf("executed_amount:%v", cancelled.ExecutedAmount),
+	)
+
+	result := replace_result{
+		OrderId:        orderId,
+		ExecutedAmount: decimal_from_float(cancelled.ExecutedAmount),
+		ClientOrderId:  o.ClientOrderId,
+		Symbol:         o.Symbol,
+		Side:           o.Side,
+		Price:          o.Price,
+	}
+	// the order may have been filled, entirely or in part, during the replace
+	o.Amount = unfilled(cancelled, amount)
+	if !cancelled.IsCancelled || o.Amount.sign() <= 0 {
+		errlog.Printf("order replace: order %s was filled before the cancel, nothing left to place", orderId)
+		result.Status = "filled"
+		return print_output(c, result)
+	}
+	if err := prepare_order(c, api, &o, nil); err != nil {
+		return fmt.Errorf("Error order %s was cancelled but the replacement of the remaining %s was not placed: %s",
+			orderId, o.Amount, one_line(err))
+	}
+	// /v1/order/new
+	placed, err := place_order(c, api, o)
+	if err != nil {
+		return fmt.Errorf("Error order %s was cancelled but the replacement of the remaining %s was not placed: %s",
+			orderId, o.Amount, one_line(err))
+	}
+	result.ReplacementOrderId, result.Amount, result.Status = placed.OrderId, o.Amount, "replaced"
+	return print_output(c, result)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/claudiocandio/gemini-api"
+)
+
+func TestReplacementClientOrderId(t *testing.T) {
+	for _, test := range []struct{ client_order_id, want string }{
+		{"mybot-1", "mybot-1-r1"},
+		{"mybot-1-r1", "mybot-1-r2"},
+		{"mybot-1-r9", "mybot-1-r10"},
+		{"", "replace-42-r1"},
+		{"-r3", "replace-42-r4"},
+	} {
+		if got := replacement_client_order_id(gemini.Order{OrderId: "42", ClientOrderId: test.client_order_id}); got != test.want {
+			t.Errorf("%q: got %s, want %s", test.client_order_id, got, test.want)
+		}
+	}
+}
+
+func TestOrderReplace(t *testing.T) {
+	server := use_fake_gemini(t)
+
+	if _, err := run_app(t, nil, "-y", "order", "new", "-t", "btcusd", "-s", "buy", "-a", "1", "-p", "95",
+		"--client_order_id", "mybot-1", "--option", optionMakerOrCancel); err != nil {
+		t.Fatal(err)
+	}
+	replace := func(args ...string) (replace_result, error) {
+		out, err := run_app(t, nil, append([]string{"-y", "order", "replace"}, args...)...)
+		var r replace_result
+		if err == nil {
+			if err := json.Unmarshal([]byte(out), &r); err != nil {
+				t.Fatalf("%v\n%s", err, out)
+			}
+		}
+		return r, err
+	}
+
+	// 0.25 fills while the order is cancelled, the remaining 0.75 is placed
+	server.fillOnCancel = 0.25
+	r, err := replace("--client-order-id", "mybot-1", "-p", "96")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Status != "replaced" || r.OrderId != "1001" || r.ReplacementOrderId != "1002" || r.ClientOrderId != "mybot-1-r1" ||
+		r.Amount.String() != "0.75" || r.ExecutedAmount.String() != "0.25" || r.Price.String() != "96" {
+		t.Errorf("result %+v", r)
+	}
+	o := server.orders[1]
+	if !o.IsLive || o.Price != 96 || o.OriginalAmount != 0.75 || len(o.Options) != 1 || o.Options[0] != optionMakerOrCancel {
+		t.Errorf("replacement %+v", o)
+	}
+
+	// --amount is the new total amount, the replacement has not filled yet
+	r, err = replace("--client-order-id", "mybot-1-r1", "-p", "97", "-a", "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Status != "replaced" || r.ClientOrderId != "mybot-1-r2" || r.Amount.String() != "2" {
+		t.Errorf("result %+v", r)
+	}
+
+	// the order fills entirely during the replace
+	server.fillOnCancel = 5
+	r, err = replace("--orderid", r.ReplacementOrderId, "-p", "98")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Status != "filled" || r.ReplacementOrderId != "" || r.ExecutedAmount.String() != "2" {
+		t.Errorf("result %+v", r)
+	}
+	if len(server.orders) != 3 {
+		t.Errorf("%d orders", len(server.orders))
+	}
+
+	for _, test := range []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"--orderid", "1001", "-p", "99"}, "order 1001 is not live"},
+		{[]string{"--orderid", "404", "-p", "99"}, "OrderNotFound"},
+		{[]string{"-p", "99"}, "--orderid or --client-order-id is required"},
+	} {
+		if _, err := replace(test.args...); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%v: err = %v, want %s", test.args, err, test.err)
+		}
+	}
+
+	// a replacement that is not valid leaves the original untouched
+	if _, err := run_app(t, nil, "-y", "order", "new", "-t", "btcusd", "-s", "sell", "-a", "1", "-p", "105"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := replace("--orderid", "1004", "-p", "105.001"); err == nil || !strings.Contains(err.Error(), "use --round") {
+		t.Errorf("err = %v", err)
+	}
+	out, err := run_app(t, nil, "--dry-run", "order", "replace", "--orderid", "1004", "-p", "106")
+	if err != nil || !strings.Contains(out, cancel_order_URI) || !strings.Contains(out, "replace-1004-r1") {
+		t.Errorf("dry run err = %v\n%s", err, out)
+	}
+	if !server.orders[3].IsLive {
+		t.Errorf("order 1004 cancelled")
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/claudiocandio/gemini-api/logger"
+)
+
+const (
+	base_URL    = "https://api.gemini.com"
+	sandbox_URL = "https://api.sandbox.gemini.com"
+
+	// public
+	symbols_URI        = "/v1/symbols"
+	ticker_v2_URI      = "/v2/ticker/"
+	book_URI           = "/v1/book/"
+	trades_URI         = "/v1/trades/"
+	auction_URI        = "/v1/auction/"
+	candles_URI        = "/v2/candles/"
+	symbol_details_URI = "/v1/symbols/details/"
+
+	// authenticated
+	past_trades_URI    = "/v1/mytrades"
+	trade_volume_URI   = "/v1/tradevolume"
+	active_orders_URI  = "/v1/orders"
+	order_status_URI   = "/v1/order/status"
+	new_order_URI      = "/v1/order/new"
+	cancel_order_URI   = "/v1/order/cancel"
+	cancel_all_URI     = "/v1/order/cancel/all"
+	cancel_session_URI = "/v1/order/cancel/session"
+	heartbeat_URI      = "/v1/heartbeat"
+	order_events_URI   = "/v1/order/events"
+	account_URI        = "/v1/account"
+	transfers_URI      = "/v1/transfers"
+
+	// fund mgmt
+	balances_URI            = "/v1/balances"
+	new_deposit_address_URI = "/v1/deposit/"
+	deposit_addresses_URI   = "/v1/addresses/"
+	withdraw_funds_URI      = "/v1/withdraw/"
+)
+
+// gemini_api talks to the Gemini REST API, it follows the gemini-api
+// wrapper and returns its types, but the base url can be changed and
+// it supports the parameters the wrapper does not
+type gemini_api struct {
+	url    string
+	key    string
+	secret string
+	client *http.Client
+	// sub-account of a master API key, sent with every private request
+	account string
+}
+
+// new_gemini_api connects to the production or sandbox site, or to
+// base_url if it is not empty
+func new_gemini_api(production bool, key, secret, base_url string) *gemini_api {
+	var url string
+	if url = sandbox_URL; production {
+		url = base_URL
+	}
+	if base_url != "" {
+		url = strings.TrimSuffix(base_url, "/")
+	}
+
+	return &gemini_api{
+		url:    url,
+		key:    key,
+		secret: secret,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// last_nonce is the last nonce used, requests can be signed concurrently
+var last_nonce int64
+
+// nonce must increase on every private request of the same key
+func nonce() int64 {
+	for {
+		last := atomic.LoadInt64(&last_nonce)
+		n := time.Now().UnixNano()
+		if n <= last {
+			n = last + 1
+		}
+		if atomic.CompareAndSwapInt64(&last_nonce, last, n) {
+			return n
+		}
+	}
+}
+
+func msToTime(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+// buildHeader builds the Gemini private API headers: the API key,
+// the base64 payload and its HMAC-SHA384 signature.
+func (api *gemini_api) buildHeader(params map[string]interface{}) (http.Header, error) {
+
+	reqStr, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	payload := base64.StdEncoding.EncodeToString(reqStr)
+
+	mac := hmac.New(sha512.New384, []byte(api.secret))
+	if _, err := mac.Write([]byte(payload)); err != nil {
+		return nil, err
+	}
+	signature := hex.EncodeToString(mac.Sum(nil))
+
+	header := http.Header{}
+	header.Set("Content-Type", "text/plain")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("X-GEMINI-APIKEY", api.key)
+	header.Set("X-GEMINI-PAYLOAD", payload)
+	header.Set("X-GEMINI-SIGNATURE", signature)
+
+	return header, nil
+}
+
+// private_request sends a signed POST to uri and decodes the json response into out
+func (api *gemini_api) private_request(uri string, params map[string]interface{}, out interface{}) error {
+
+	url, header, err := api.sign(uri, params)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer([]byte{}))
+	if err != nil {
+		return err
+	}
+	req.Header = header
+
+	return api.do(req, out)
+}
+
+// sign adds request, nonce and account to params and returns the url and
+// the signed headers of the private request
+func (api *gemini_api) sign(uri string, params map[string]interface{}) (string, http.Header, error) {
+
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+	params["request"] = uri
+	params["nonce"] = nonce()
+	if api.account != "" {
+		params["account"] = api.account
+	}
+
+	url := api.url + uri
+
+	logger.Debug("func private_request",
+		fmt.Sprintf("url:%s", url),
+		fmt.Sprintf("params:%v", params),
+	)
+
+	header, err := api.buildHeader(params)
+	return url, header, err
+}
+
+// SignHeader returns the signed headers of a private request, e.g. to
+// connect to the order events WebSocket
+func (api *gemini_api) SignHeader(uri string, params map[string]interface{}) (http.Header, error) {
+
+	_, header, err := api.sign(uri, params)
+	return header, err
+}
+
+// signed_request is a private request as it would be sent, for --dry-run
+type signed_request struct {
+	Url       string          `json:"url"`
+	Payload   json.RawMessage `json:"payload"`
+	Base64    string          `json:"x_gemini_payload"`
+	Signature string          `json:"x_gemini_signature"`
+	Api_key   string          `json:"x_gemini_apikey"`
+	Sent      bool            `json:"sent"`
+}
+
+// SignRequest signs a private request without sending it
+func (api *gemini_api) SignRequest(uri string, params map[string]interface{}) (signed_request, error) {
+
+	url, header, err := api.sign(uri, params)
+	if err != nil {
+		return signed_request{}, err
+	}
+	payload, err := base64.StdEncoding.DecodeString(header.Get("X-GEMINI-PAYLOAD"))
+	if err != nil {
+		return signed_request{}, err
+	}
+
+	return signed_request{
+		Url:       url,
+		Payload:   payload,
+		Base64:    header.Get("X-GEMINI-PAYLOAD"),
+		Signature: header.Get("X-GEMINI-SIGNATURE"),
+		Api_key:   mask_key(api.key),
+	}, nil
+}
+
+// public_request sends a GET to uri with args as query string and decodes
+// the json response into out
+func (api *gemini_api) public_request(uri string, args map[string]interface{}, out interface{}) error {
+
+	url := api.url + uri
+
+	logger.Debug("func public_request",
+		fmt.Sprintf("url:%s", url),
+		fmt.Sprintf("args:%v", args),
+	)
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		q := req.URL.Query()
+		for key, val := range args {
+			q.Add(key, query_value(val))
+		}
+		req.URL.RawQuery = q.Encode()
+	}
+
+	return api.do(req, out)
+}
+
+func (api *gemini_api) do(req *http.Request, out interface{}) error {
+
+	// gemini key, payload and signature are redacted unless --unsafe-show-secrets
+	logger.Trace("func do: request",
+		fmt.Sprintf("req:%v", req),
+	)
+
+	resp, err := api.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	logger.Debug("func do: Http Client body",
+		fmt.Sprintf("status:%d", resp.StatusCode),
+		fmt.Sprintf("body:%s", body),
+	)
+
+	if resp.StatusCode != 200 {
+		return response_error(resp.StatusCode, body)
+	}
+
+	return json.Unmarshal(body, out)
+}
+
+func query_value(val interface{}) string {
+	switch v := val.(type) {
+	case string:
+		return v
+	case time.Time:
+		return strconv.FormatInt(v.UnixNano()/1e6, 10)
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprintf("%v", val)
+}
+
+var status_errors = map[int]string{
+	400: "Auction not open or paused, ineligible timing, market not open, or the request was malformed; in the case of a private API request, missing or malformed Gemini private API authentication headers",
+	403: "The API key is missing the role necessary to access this private API endpoint",
+	404: "Unknown API entry point or Order not found",
+	406: "Insufficient Funds",
+	429: "Rate Limiting was applied",
+	500: "The server encountered an error",
+	502: "Technical issues are preventing the request from being satisfied",
+	503: "The exchange is down for maintenance",
+}
+
+// api_error is an error response of Gemini, the request was not executed
+type api_error struct {
+	status int
+	// Gemini reason e.g. InvalidNonce, empty if the body has none
+	reason  string
+	message string
+}
+
+func (e *api_error) Error() string {
+	if e.reason != "" {
+		return fmt.Sprintf("HTTP Status Code: %d\n%s: %s", e.status, e.reason, e.message)
+	}
+	return fmt.Sprintf("HTTP Status Code: %d\n%s", e.status, e.message)
+}
+
+// retryable tells if the request can be sent again: it was refused because
+// of the rate limit or of a nonce received out of order, so it did nothing
+func (e *api_error) retryable() bool {
+	return e.status == 429 || e.reason == "InvalidNonce" || e.reason == "RateLimit" || e.reason == "RateLimited"
+}
+
+// response_error returns the Gemini error reason and message if the body
+// has them, otherwise the meaning of the status code
+func response_error(statusCode int, body []byte) error {
+
+	var geminiErr struct {
+		Result  string `json:"result"`
+		Reason  string `json:"reason"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(body, &geminiErr); err == nil && geminiErr.Result == "error" {
+		return &api_error{statusCode, geminiErr.Reason, geminiErr.Message}
+	}
+	if statusCode >= 300 && statusCode < 400 {
+		return &api_error{status: statusCode, message: "API entry point has moved, see Location: header. Most likely an http: to https: redirect."}
+	}
+	if msg, ok := status_errors[statusCode]; ok {
+		return &api_error{status: statusCode, message: msg}
+	}
+	return &api_error{status: statusCode, message: string(body)}
+}
+
+// base_url_valid checks a gemini_api_url/GEMINI_API_URL value
+func base_url_valid(base_url string) error {
+	u, err := url.Parse(base_url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("Error invalid Gemini API url: %s", base_url)
+	}
+	return nil
+}
@@ -1,361 +1,213 @@
 package main
 
 import (
-	"encoding/json"
-	"fmt"
-
 	"github.com/claudiocandio/gemini-api"
 )
 
-func get_account(gemini_config_yml string) (string, error) {
-	api, err := start_api(gemini_config_yml)
-	if err != nil {
-		return "", err
-	}
-
+func get_account(api gemini_client) (interface{}, error) {
 	accountDetail, err := api.AccountDetail()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	j, err := json.MarshalIndent(&accountDetail, "", " ")
-	if err != nil {
-		return "", err
-	}
-
-	return fmt.Sprintf("%s", j), nil
+	return accountDetail, nil
 }
 
-func get_tradevolume(gemini_config_yml string) (string, error) {
-	api, err := start_api(gemini_config_yml)
-	if err != nil {
-		return "", err
-	}
-
+func get_tradevolume(api gemini_client) (interface{}, error) {
 	tradeVolume, err := api.TradeVolume()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	j, err := json.MarshalIndent(&tradeVolume, "", " ")
-	if err != nil {
-		return "", err
-	}
-
-	return fmt.Sprintf("%s", j), nil
+	return tradeVolume, nil
 }
 
-func get_depositaddresses(gemini_config_yml string, currency string) (string, error) {
-	api, err := start_api(gemini_config_yml)
-	if err != nil {
-		return "", err
-	}
-
+func get_depositaddresses(api gemini_client, currency string) (interface{}, error) {
 	depositAddresses, err := api.DepositAddresses(currency)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	j, err := json.MarshalIndent(&depositAddresses, "", " ")
-	if err != nil {
-		return "", err
-	}
-	return fmt.Sprintf("%s", j), nil
+	return depositAddresses, nil
 }
 
-func get_symbols(gemini_config_yml string) (string, error) {
-	api, err := start_api(gemini_config_yml)
-	if err != nil {
-		return "", err
-	}
-
+func get_symbols(api gemini_client) (interface{}, error) {
 	// get Symbols
 	symbols, err := api.Symbols()
 	if err != nil {
-		return "", err
-	}
-	j, err := json.MarshalIndent(&symbols, "", " ")
-	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return fmt.Sprintf("%s", j), nil
+	return symbols, nil
 }
 
-func get_balances(gemini_config_yml string) (string, error) {
-	api, err := start_api(gemini_config_yml)
-	if err != nil {
-		return "", err
-	}
-
+func get_balances(api gemini_client) (interface{}, error) {
 	// get Balances
 	balances, err := api.Balances()
 	if err != nil {
-		return "", err
-	}
-	j, err := json.MarshalIndent(&balances, "", " ")
-	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return fmt.Sprintf("%s", j), nil
+	return balances, nil
 }
 
-func get_ticker(gemini_config_yml string, ticker string) (string, error) {
-	api, err := start_api(gemini_config_yml)
-	if err != nil {
-		return "", err
-	}
-
+func get_ticker(api gemini_client, ticker string) (interface{}, error) {
 	// get TickerV2
 	tickerV2, err := api.TickerV2(ticker)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	j, err := json.MarshalIndent(&tickerV2, "", " ")
-	if err != nil {
-		return "", err
-	}
-	return fmt.Sprintf("%s", j), nil
+	return tickerV2, nil
 }
 
-func get_auction(gemini_config_yml string, ticker string) (string, error) {
-	api, err := start_api(gemini_config_yml)
+func get_symbol_details(api gemini_client, ticker string) (interface{}, error) {
+	// get Symbol Details
+	details, err := api.SymbolDetails(ticker)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	return details, nil
+}
 
+func get_auction(api gemini_client, ticker string) (interface{}, error) {
 	// get Current Auction
 	currentAuction, err := api.CurrentAuction(ticker)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	j, err := json.MarshalIndent(&currentAuction, "", " ")
-	if err != nil {
-		return "", err
-	}
-	return fmt.Sprintf("%s", j), nil
+	return currentAuction, nil
 }
 
 // gemini.Args{"timestampms": 0, "limitTrades": 30, "includeBreaks": false}
-func get_trades(gemini_config_yml string, ticker string, args gemini.Args) (string, error) {
-
-	api, err := start_api(gemini_config_yml)
-	if err != nil {
-		return "", err
-	}
+func get_trades(api gemini_client, ticker string, args gemini.Args) (interface{}, error) {
 
 	trades, err := api.Trades(ticker, args)
 	if err != nil {
-		return "", err
-	}
-	j, err := json.MarshalIndent(&trades, "", " ")
-	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return fmt.Sprintf("%s", j), nil
+	return trades, nil
 
 }
 
-// Args{"limit_bids": 1, "limit_asks": 1}
-func get_orderbook(gemini_config_yml string, ticker string, args gemini.Args) (string, error) {
-	api, err := start_api(gemini_config_yml)
-	if err != nil {
-		return "", err
-	}
+func get_candles(api gemini_client, symbol, time_frame string, o candle_options) (interface{}, error) {
 
-	orderBook, err := api.OrderBook(ticker, args)
-	if err != nil {
-		return "", err
-	}
-	j, err := json.MarshalIndent(&orderBook, "", " ")
+	candles, err := api.Candles(symbol, time_frame)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return fmt.Sprintf("%s", j), nil
+	return candle_rows(candles, o)
 
 }
 
-func get_orders(gemini_config_yml string) (string, error) {
-	api, err := start_api(gemini_config_yml)
+// Args{"limit_bids": 1, "limit_asks": 1}
+func get_orderbook(api gemini_client, ticker string, args gemini.Args) (interface{}, error) {
+	orderBook, err := api.OrderBook(ticker, args)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	return orderBook, nil
 
+}
+
+func get_orders(api gemini_client) (interface{}, error) {
 	// Get your active orders
 	activeOrders, err := api.ActiveOrders()
 	if err != nil {
-		return "", err
-	}
-	j, err := json.MarshalIndent(&activeOrders, "", " ")
-	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return fmt.Sprintf("%s", j), nil
+	return activeOrders, nil
 }
 
 // Get list of my past trades
 // Args{"limit_trades": 0, "timestamp": "2021-12-01T15:04:01"}
-func get_past_trades(gemini_config_yml string, ticker string, args gemini.Args) (string, error) {
-	api, err := start_api(gemini_config_yml)
-	if err != nil {
-		return "", err
-	}
-
+func get_past_trades(api gemini_client, ticker string, args gemini.Args) (interface{}, error) {
 	pastTrades, err := api.PastTrades(ticker, args)
 	if err != nil {
-		return "", err
-	}
-	j, err := json.MarshalIndent(&pastTrades, "", " ")
-	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return fmt.Sprintf("%s", j), nil
+	return pastTrades, nil
 
 }
 
-func get_order(gemini_config_yml string, orderId string) (string, error) {
-	api, err := start_api(gemini_config_yml)
-	if err != nil {
-		return "", err
-	}
-
+func get_order(api gemini_client, orderId string) (interface{}, error) {
 	// get order
 	order, err := api.OrderStatus(orderId)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	j, err := json.MarshalIndent(&order, "", " ")
-	if err != nil {
-		return "", err
-	}
-	return fmt.Sprintf("%s", j), nil
+	return order, nil
 }
 
 // gemini.Args{"since": 0, "limit": 100, "includeIndicative": true}
-func get_auction_hystory(gemini_config_yml string, ticker string, args gemini.Args) (string, error) {
-
-	api, err := start_api(gemini_config_yml)
-	if err != nil {
-		return "", err
-	}
+func get_auction_hystory(api gemini_client, ticker string, args gemini.Args) (interface{}, error) {
 
 	auctionHistory, err := api.AuctionHistory(ticker, args)
 	if err != nil {
-		return "", err
-	}
-	j, err := json.MarshalIndent(&auctionHistory, "", " ")
-	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return fmt.Sprintf("%s", j), nil
+	return auctionHistory, nil
 
 }
 
-func cancel_order(gemini_config_yml string, orderId string) (string, error) {
-	api, err := start_api(gemini_config_yml)
+func get_order_trades(api gemini_client, orderId string) (interface{}, error) {
+	// get order with its trades
+	order, err := api.OrderStatusTrades(orderId)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	return order, nil
+}
 
+func cancel_order(api gemini_client, orderId string) (interface{}, error) {
 	// cancel order
 	cancelOrder, err := api.CancelOrder(orderId)
 	if err != nil {
-		return "", err
-	}
-	j, err := json.MarshalIndent(&cancelOrder, "", " ")
-	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return fmt.Sprintf("%s", j), nil
+	return cancelOrder, nil
 }
 
-func cancel_all_order(gemini_config_yml string) (string, error) {
-	api, err := start_api(gemini_config_yml)
-	if err != nil {
-		return "", err
-	}
-
+func cancel_all_order(api gemini_client) (interface{}, error) {
 	// This will cancel ALL orders includind those placed through the UI
 	cancelAll, err := api.CancelAll()
 	if err != nil {
-		return "", err
-	}
-	j, err := json.MarshalIndent(&cancelAll, "", " ")
-	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return fmt.Sprintf("%s", j), nil
+	return cancelAll, nil
 }
 
-func new_order(gemini_config_yml, ticker, clientOrderId, side string, amount, price float64) (string, error) {
-	api, err := start_api(gemini_config_yml)
-	if err != nil {
-		return "", err
-	}
-
-	newOrder, err := api.NewOrder(ticker, clientOrderId, amount, price, side, []string{"immediate-or-cancel"})
+// This will cancel the orders placed by this API session only
+func cancel_session(api gemini_client) (interface{}, error) {
+	cancelSession, err := api.CancelSession()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	j, err := json.MarshalIndent(&newOrder, "", " ")
-	if err != nil {
-		return "", err
-	}
-	return fmt.Sprintf("%s", j), nil
-
+	return cancelSession, nil
 }
 
 // currency can be bitcoin, ethereum, bitcoincash, litecoin, zcash, or filecoin
-func new_deposit_address(gemini_config_yml, currency, label string) (string, error) {
-	api, err := start_api(gemini_config_yml)
-	if err != nil {
-		return "", err
-	}
-
+func new_deposit_address(api gemini_client, currency, label string) (interface{}, error) {
 	newDepositAddress, err := api.NewDepositAddress(currency, label)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	j, err := json.MarshalIndent(&newDepositAddress, "", " ")
-	if err != nil {
-		return "", err
-	}
-	return fmt.Sprintf("%s", j), nil
+	return newDepositAddress, nil
 
 }
 
 // Withdraw Crypto Funds
 // currency can be btc or eth
-func withdraw_funds(gemini_config_yml, currency, address string, amount float64) (string, error) {
-	api, err := start_api(gemini_config_yml)
-	if err != nil {
-		return "", err
-	}
-
+func withdraw_funds(api gemini_client, currency, address string, amount decimal) (interface{}, error) {
 	withdrawFunds, err := api.WithdrawFunds(currency, address, amount)
 	if err != nil {
-		return "", err
-	}
-	j, err := json.MarshalIndent(&withdrawFunds, "", " ")
-	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return fmt.Sprintf("%s", j), nil
+	return withdrawFunds, nil
 
 }
 
 // Args{"timestamp": "2021-12-01T15:04:01", "limit_transfers": 20,"show_completed_deposit_advances": false}
-func get_transfers(gemini_config_yml string, args gemini.Args) (string, error) {
-
-	api, err := start_api(gemini_config_yml)
-	if err != nil {
-		return "", err
-	}
+func get_transfers(api gemini_client, args gemini.Args) (interface{}, error) {
 
 	transfers, err := api.Transfers(args)
 	if err != nil {
-		return "", err
-	}
-	j, err := json.MarshalIndent(&transfers, "", " ")
-	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return fmt.Sprintf("%s", j), nil
+	return transfers, nil
 
 }
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/claudiocandio/gemini-api"
+	"github.com/claudiocandio/gemini-api/logger"
+	"github.com/urfave/cli/v2"
+)
+
+// risk_limits are the pre-trade checks of a profile, e.g.
+//
+//	risk:
+//	  allowed_symbols: [btcusd, ethusd]
+//	  max_notional: {btcusd: 50000, "*": 10000}
+//	  max_amount: {btcusd: 2}
+//	  price_band_pct: 5
+//	  daily_withdrawal_limit: {btc: 0.5, eth: 10}
+//
+// they are enforced before any order or withdrawal is sent, --yes and
+// --dry-run do not skip them
+type risk_limits struct {
+	// symbols orders can be placed on, empty for any
+	Allowed_symbols []string `yaml:"allowed_symbols"`
+	// per order, in the quote currency, by symbol or "*" for any symbol
+	Max_notional map[string]decimal `yaml:"max_notional"`
+	// per order, in the base currency, by symbol or "*" for any symbol
+	Max_amount map[string]decimal `yaml:"max_amount"`
+	// how far the order price can be from the current bid/ask, in percent
+	Price_band_pct decimal `yaml:"price_band_pct"`
+	// per UTC day, by currency or "*" for any currency
+	Daily_withdrawal_limit map[string]decimal `yaml:"daily_withdrawal_limit"`
+}
+
+type risk_error struct {
+	rule   string
+	reason string
+}
+
+func (e *risk_error) Error() string {
+	return fmt.Sprintf("Error blocked by risk rule %s: %s", e.rule, e.reason)
+}
+
+// risk_limit returns the limit of name (a symbol or a currency), or the "*" one
+func risk_limit(limits map[string]decimal, name string) (decimal, bool) {
+	if limit, ok := limits[strings.ToLower(name)]; ok {
+		return limit, true
+	}
+	limit, ok := limits["*"]
+	return limit, ok
+}
+
+// get_risk returns the risk limits of the profile in use, nil if there are none
+func get_risk(c *cli.Context) (*risk_limits, error) {
+	s, err := get_session(c)
+	if err != nil {
+		return nil, err
+	}
+	p, err := s.Profile()
+	if err != nil {
+		return nil, err
+	}
+	return p.Risk, nil
+}
+
+// check_order returns a risk_error if o breaks one of the limits
+func (r *risk_limits) check_order(api gemini_client, o orderRequest) error {
+	if r == nil {
+		return nil
+	}
+	symbol := strings.ToLower(o.Symbol)
+	quote := quote_currency(symbol)
+
+	allowed := len(r.Allowed_symbols) == 0
+	for _, s := range r.Allowed_symbols {
+		allowed = allowed || strings.EqualFold(s, symbol)
+	}
+	if !allowed {
+		return &risk_error{"allowed_symbols",
+			fmt.Sprintf("%s is not one of %s", symbol, strings.Join(r.Allowed_symbols, ", "))}
+	}
+	if limit, ok := risk_limit(r.Max_amount, symbol); ok && o.Amount.cmp(limit) > 0 {
+		return &risk_error{"max_amount",
+			fmt.Sprintf("amount %s of %s exceeds %s", o.Amount, symbol, limit)}
+	}
+	if limit, ok := risk_limit(r.Max_notional, symbol); ok && o.Amount.mul(o.Price).cmp(limit) > 0 {
+		return &risk_error{"max_notional",
+			strings.TrimSpace(fmt.Sprintf("notional %s of %s exceeds %s %s",
+				o.Amount.mul(o.Price), symbol, limit, quote))}
+	}
+
+	if r.Price_band_pct.sign() > 0 {
+		ticker, err := api.TickerV2(symbol)
+		if err != nil {
+			return fmt.Errorf("Error cannot check risk rule price_band_pct: %s", err)
+		}
+		if ticker.Bid <= 0 || ticker.Ask <= 0 {
+			return &risk_error{"price_band_pct", fmt.Sprintf("%s has no bid/ask to check the price against", symbol)}
+		}
+		bid, ask := decimal_from_float(ticker.Bid), decimal_from_float(ticker.Ask)
+		band := r.Price_band_pct.quo(decimal_int(100))
+		low := bid.mul(decimal_int(1).sub(band))
+		high := ask.mul(decimal_int(1).add(band))
+		logger.Debug("func check_order: price band",
+			fmt.Sprintf("symbol:%s", symbol),
+			fmt.Sprintf("bid:%v", ticker.Bid),
+			fmt.Sprintf("ask:%v", ticker.Ask),
+			fmt.Sprintf("low:%v", low),
+			fmt.Sprintf("high:%v", high),
+		)
+		for _, price := range []decimal{o.Price, o.StopPrice} {
+			if price.is_zero() {
+				continue
+			}
+			if price.cmp(low) < 0 || price.cmp(high) > 0 {
+				return &risk_error{"price_band_pct",
+					fmt.Sprintf("price %s is more than %s%% away from bid %s / ask %s, allowed %s - %s",
+						price, r.Price_band_pct, bid, ask, round_price(low), round_price(high))}
+			}
+		}
+	}
+	return nil
+}
+
+// check_withdrawal returns a risk_error if withdrawing amount of currency
+// today, UTC, exceeds the daily_withdrawal_limit, see withdrawn_since for
+// the withdrawals counted
+func (r *risk_limits) check_withdrawal(api gemini_client, currency string, amount decimal) error {
+	if r == nil {
+		return nil
+	}
+	limit, ok := risk_limit(r.Daily_withdrawal_limit, currency)
+	if !ok {
+		return nil
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	withdrawn, err := withdrawn_since(api, currency, today)
+	if err != nil {
+		return fmt.Errorf("Error cannot check risk rule daily_withdrawal_limit: %s", err)
+	}
+	if withdrawn.add(amount).cmp(limit) > 0 {
+		return &risk_error{"daily_withdrawal_limit",
+			fmt.Sprintf("%s %s already withdrawn today (UTC), %s more exceeds %s %s",
+				withdrawn, strings.ToUpper(currency), amount, limit, strings.ToUpper(currency))}
+	}
+	return nil
+}
+
+// transfers_page is the most transfers /v1/transfers returns at once
+const transfers_page = 50
+
+// withdrawn_since sums the withdrawals of currency since start, paging
+// forward by timestamp through all the transfers of a busy account. Every
+// withdrawal counts whatever its status, also a cancelled or failed one:
+// the daily_withdrawal_limit errs on the side of blocking
+func withdrawn_since(api gemini_client, currency string, start time.Time) (decimal, error) {
+	var withdrawn decimal
+	seen := map[int64]bool{}
+	from := get_timestampms(start)
+	for since := from; ; {
+		transfers, err := api.Transfers(gemini.Args{
+			"timestamp":       since,
+			"limit_transfers": transfers_page,
+		})
+		if err != nil {
+			return withdrawn, err
+		}
+		// the next page starts at the newest transfer of this one, those
+		// with the same timestamp are returned again and skipped by eid
+		next := since
+		for _, tr := range transfers {
+			if tr.Timestampms < from || seen[tr.Eid] {
+				continue
+			}
+			seen[tr.Eid] = true
+			if tr.Timestampms > next {
+				next = tr.Timestampms
+			}
+			if tr.Type == "Withdrawal" && strings.EqualFold(tr.Currency, currency) {
+				withdrawn = withdrawn.add(decimal_from_float(tr.Amount))
+			}
+		}
+		logger.Debug("func withdrawn_since: page",
+			fmt.Sprintf("since:%d", since),
+			fmt.Sprintf("transfers:%d", len(transfers)),
+			fmt.Sprintf("withdrawn:%s", withdrawn),
+		)
+		if len(transfers) < transfers_page {
+			return withdrawn, nil
+		}
+		if next == since {
+			return withdrawn, fmt.Errorf("more than %d transfers at timestampms %d, cannot page through them", transfers_page, since)
+		}
+		since = next
+	}
+}
+
+// round_price keeps the band limits readable in the error messages
+func round_price(price decimal) decimal {
+	return price.round(decimal{big.NewRat(1, 100)})
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/claudiocandio/gemini-api"
+)
+
+const risk_yml = `
+gemini_api_credentials:
+  risk:
+    allowed_symbols: [btcusd, ETHUSD]
+    max_notional: {btcusd: 5000, "*": 1000}
+    max_amount: {btcusd: 2}
+    price_band_pct: 5
+    daily_withdrawal_limit: {btc: 0.5}
+`
+
+func TestRiskOrder(t *testing.T) {
+	server := use_fake_gemini(t)
+	config := write_config(t, risk_yml)
+
+	// the fake ticker has bid 99 and ask 101
+	tests := []struct {
+		args []string
+		rule string
+	}{
+		{[]string{"-t", "btcusd", "-s", "buy", "-a", "1", "-p", "100"}, ""},
+		{[]string{"-t", "ethusd", "-s", "buy", "-a", "1", "-p", "100"}, ""},
+		{[]string{"-t", "ltcusd", "-s", "buy", "-a", "1", "-p", "100"}, "allowed_symbols"},
+		{[]string{"-t", "btcusd", "-s", "buy", "-a", "3", "-p", "100"}, "max_amount"},
+		{[]string{"-t", "ethusd", "-s", "buy", "-a", "11", "-p", "100"}, "max_notional"},
+		{[]string{"-t", "btcusd", "-s", "buy", "-a", "1", "-p", "106.1"}, "price_band_pct"},
+		{[]string{"-t", "btcusd", "-s", "sell", "-a", "1", "-p", "94"}, "price_band_pct"},
+		{[]string{"-t", "btcusd", "-s", "sell", "-a", "1", "-p", "100", "--type", "stop-limit", "--stop_price", "120"}, "price_band_pct"},
+	}
+	for _, test := range tests {
+		before := len(server.payloads)
+		args := append([]string{"-c", config, "order", "new"}, test.args...)
+		// --dry-run does not skip the risk checks
+		for _, mode := range []string{"--yes", "--dry-run"} {
+			_, err := run_app(t, nil, append([]string{mode}, args...)...)
+			if test.rule == "" {
+				if err != nil {
+					t.Errorf("%v %v: %v", mode, test.args, err)
+				}
+				continue
+			}
+			if err == nil || !strings.Contains(err.Error(), "risk rule "+test.rule) {
+				t.Errorf("%v %v: err = %v, want rule %s", mode, test.args, err, test.rule)
+			}
+			if len(server.payloads) != before {
+				t.Errorf("%v %v: request sent", mode, test.args)
+			}
+		}
+	}
+}
+
+func TestRiskWithdrawal(t *testing.T) {
+	server := use_fake_gemini(t)
+	config := write_config(t, risk_yml)
+	address := "mpXEeWuc7tSB4BVEoYpStmj4MFrmrLiRKL"
+
+	withdraw := func(amount string) error {
+		_, err := run_app(t, nil, "-c", config, "-y", "withdraw", "-c", "btc", "-a", address, "--amount", amount)
+		return err
+	}
+	if err := withdraw("0.3"); err != nil {
+		t.Fatal(err)
+	}
+	err := withdraw("0.3")
+	if err == nil || !strings.Contains(err.Error(), "risk rule daily_withdrawal_limit") ||
+		!strings.Contains(err.Error(), "0.3 BTC already withdrawn") {
+		t.Errorf("err = %v", err)
+	}
+	if p := server.last_payload(); p["request"] != transfers_URI {
+		t.Errorf("second withdrawal sent: %v", p)
+	}
+	if err := withdraw("0.2"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRiskWithdrawalPages(t *testing.T) {
+	server := use_fake_gemini(t)
+	config := write_config(t, risk_yml)
+	address := "mpXEeWuc7tSB4BVEoYpStmj4MFrmrLiRKL"
+
+	// a withdrawal yesterday, then today 50 deposits and two withdrawals on
+	// the second page, transfers 50 and 51 have the same timestamp
+	today := get_timestampms(time.Now().UTC().Truncate(24 * time.Hour))
+	transfers := []gemini.Transfer{{Type: "Withdrawal", Status: "Complete", Timestampms: today - 1000, Eid: 1, Currency: "BTC", Amount: 1}}
+	for i := 1; i <= 52; i++ {
+		tr := gemini.Transfer{Type: "Deposit", Status: "Complete", Timestampms: today + int64(i/2), Eid: int64(i + 1), Currency: "ETH", Amount: 1}
+		if i > 50 {
+			tr.Type, tr.Currency, tr.Amount = "Withdrawal", "BTC", 0.2
+		}
+		if i == 52 {
+			// a cancelled withdrawal counts too
+			tr.Status = "Cancelled"
+		}
+		transfers = append([]gemini.Transfer{tr}, transfers...)
+	}
+	server.transfers = transfers
+
+	_, err := run_app(t, nil, "-c", config, "-y", "withdraw", "-c", "btc", "-a", address, "--amount", "0.2")
+	if err == nil || !strings.Contains(err.Error(), "0.4 BTC already withdrawn") {
+		t.Errorf("err = %v", err)
+	}
+	if _, err := run_app(t, nil, "-c", config, "-y", "withdraw", "-c", "btc", "-a", address, "--amount", "0.1"); err != nil {
+		t.Error(err)
+	}
+}
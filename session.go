@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/claudiocandio/gemini-api/logger"
+	"github.com/urfave/cli/v2"
+)
+
+// Gemini cancels the orders of a "Require Heartbeat" session after 30s
+// without private requests
+const heartbeat_timeout = 30 * time.Second
+
+// heartbeat_row is what session heartbeat prints for every heartbeat
+type heartbeat_row struct {
+	Time      time.Time `json:"time"`
+	Result    string    `json:"result"`
+	LatencyMs int64     `json:"latency_ms"`
+	// consecutive failures, 0 after a successful heartbeat
+	Failures int    `json:"failures"`
+	Error    string `json:"error"`
+}
+
+// run_heartbeat sends a heartbeat every --interval until Ctrl-C or --count,
+// it fails after --max-failures consecutive failed heartbeats, when the
+// session is likely expired and its orders cancelled
+func run_heartbeat(c *cli.Context, api gemini_client) error {
+	interval, max_failures := c.Duration("interval"), c.Int("max-failures")
+	if interval <= 0 || interval >= heartbeat_timeout {
+		return fmt.Errorf("Error invalid --interval: %s, it must be greater than 0 and less than %s, "+
+			"the session expires after %s without heartbeats", interval, heartbeat_timeout, heartbeat_timeout)
+	}
+	if max_failures <= 0 {
+		return fmt.Errorf("Error invalid --max-failures: %d, it must be greater than 0", max_failures)
+	}
+
+	ctx, cancel := interrupt_context(context.Background())
+	defer cancel()
+	w := new_stream_writer(c)
+	w.done = cancel
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	failures := 0
+	var last_ok time.Time
+	for {
+		start := time.Now()
+		// /v1/heartbeat
+		res, err := api.Heartbeat()
+		row := heartbeat_row{Time: start.UTC(), Result: res.Result, LatencyMs: time.Since(start).Milliseconds()}
+		if err != nil {
+			failures++
+			row.Result, row.Error = "error", err.Error()
+			errlog.Printf("session heartbeat: failure %d of %d: %s", failures, max_failures, err)
+		} else {
+			failures, last_ok = 0, start
+		}
+		row.Failures = failures
+		logger.Debug("func run_heartbeat",
+			fmt.Sprintf("result:%s", row.Result),
+			fmt.Sprintf("failures:%d", failures),
+		)
+		if err := w.write(row); err != nil {
+			return err
+		}
+		if failures >= max_failures {
+			since := "no heartbeat succeeded"
+			if !last_ok.IsZero() {
+				since = fmt.Sprintf("last successful heartbeat %s ago", time.Since(last_ok).Round(time.Second))
+			}
+			return fmt.Errorf("Error session heartbeat: %d consecutive heartbeats failed, %s: "+
+				"the session orders may have been cancelled", failures, since)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSessionCancel(t *testing.T) {
+	server := use_fake_gemini(t)
+	saved_output := confirm_output
+	defer func() { confirm_output = saved_output }()
+	var prompt bytes.Buffer
+	confirm_output = &prompt
+
+	if _, err := run_app(t, nil, "-y", "order", "new", "-t", "btcusd", "-s", "buy", "-a", "0.5", "-p", "100"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := run_app(t, nil, "session", "cancel"); err == nil || !strings.Contains(err.Error(), "Aborted") {
+		t.Errorf("err = %v", err)
+	}
+	if !strings.Contains(prompt.String(), "the orders placed by this API session") {
+		t.Errorf("summary:\n%s", prompt.String())
+	}
+	out, err := run_app(t, nil, "-y", "session", "cancel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := server.last_payload(); p["request"] != cancel_session_URI {
+		t.Errorf("last request %v", p)
+	}
+	if !strings.Contains(out, `"cancelledOrders": [`) || !strings.Contains(out, "1001") {
+		t.Errorf("output %s", out)
+	}
+}
+
+func TestSessionHeartbeat(t *testing.T) {
+	server := use_fake_gemini(t)
+
+	out, err := run_app(t, nil, "-o", "jsonl", "session", "heartbeat", "--interval", "10ms", "--count", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(out, `"result":"ok"`); n != 3 {
+		t.Errorf("%d heartbeats, want 3:\n%s", n, out)
+	}
+
+	server.heartbeatDown = true
+	out, err = run_app(t, nil, "-o", "jsonl", "session", "heartbeat", "--interval", "10ms", "--max-failures", "2")
+	if err == nil || !strings.Contains(err.Error(), "2 consecutive heartbeats failed") {
+		t.Errorf("err = %v", err)
+	}
+	if !strings.Contains(out, `"failures":2`) || !strings.Contains(out, "ServiceUnavailable") {
+		t.Errorf("output %s", out)
+	}
+
+	if _, err := run_app(t, nil, "session", "heartbeat", "--interval", "30s"); err == nil {
+		t.Error("interval of 30s accepted")
+	}
+}
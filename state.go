@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/urfave/cli/v2"
+)
+
+func default_state_dir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "gemini_cli", "state")
+}
+
+// state_path returns --state, or the state file named id in --state-dir
+func state_path(c *cli.Context, id string) (string, error) {
+	if path := c.String("state"); path != "" {
+		return path, nil
+	}
+	if c.String("state-dir") == "" {
+		return "", fmt.Errorf("Error --state or --state-dir is required")
+	}
+	return filepath.Join(c.String("state-dir"), id+".json"), nil
+}
+
+// save_state writes v as json, through a temporary file renamed over path so
+// that a crash never leaves a truncated state
+func save_state(path string, v interface{}) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	j, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, append(j, '\n'), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func load_state(path string, v interface{}) error {
+	j, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(j, v); err != nil {
+		return fmt.Errorf("Error invalid state file %s: %s", path, err)
+	}
+	return nil
+}
+
+// new_state_file fails if path exists, a new supervisor never takes over the
+// state of another one
+func new_state_file(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("Error state file %s already exists, use another --client-order-id or --state", path)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// check_state_scope fails if the state was written for another site or account
+func check_state_scope(c *cli.Context, path, url, account string) error {
+	_, u, a, err := journal_scope(c)
+	if err != nil {
+		return err
+	}
+	if u != url || a != account {
+		return fmt.Errorf("Error state file %s is for %s account %q, not for %s account %q of this profile",
+			path, url, account, u, a)
+	}
+	return nil
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/claudiocandio/gemini-api/logger"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	marketdata_v1_URI = "/v1/marketdata/"
+	marketdata_v2_URI = "/v2/marketdata"
+)
+
+var candleIntervals = []string{"1m", "5m", "15m", "30m", "1h", "6h", "1d"}
+
+// market_event is one book change, trade or auction event of the market data feeds
+type market_event struct {
+	Time   time.Time `json:"time"`
+	Symbol string    `json:"symbol"`
+	// change, trade, auction_open, auction_indicative or auction_result
+	Type string `json:"type"`
+	// bid/ask for changes, buy/sell (the taker side) for trades
+	Side string `json:"side"`
+	// changes: the new amount at the price level, 0 when it is removed
+	Price  string `json:"price"`
+	Amount string `json:"amount"`
+	// changes: place, trade, cancel or initial, auctions: result or auction time
+	Detail string `json:"detail"`
+}
+
+// candle is one OHLCV candle, the values are kept as sent by Gemini
+type candle struct {
+	Time   time.Time   `json:"time"`
+	Symbol string      `json:"symbol,omitempty"`
+	Open   json.Number `json:"open"`
+	High   json.Number `json:"high"`
+	Low    json.Number `json:"low"`
+	Close  json.Number `json:"close"`
+	Volume json.Number `json:"volume"`
+}
+
+// v1_message is a message of the v1 market data feed of one symbol
+type v1_message struct {
+	Type        string     `json:"type"`
+	Sequence    int64      `json:"socket_sequence"`
+	Timestampms int64      `json:"timestampms"`
+	Events      []v1_event `json:"events"`
+}
+
+type v1_event struct {
+	Type      string `json:"type"`
+	Side      string `json:"side"`
+	Price     string `json:"price"`
+	Remaining string `json:"remaining"`
+	Reason    string `json:"reason"`
+	Amount    string `json:"amount"`
+	MakerSide string `json:"makerSide"`
+	auction_fields
+}
+
+// auction_fields are the fields of the auction events, the same in v1 and v2
+type auction_fields struct {
+	Result             string `json:"result"`
+	TimeMs             int64  `json:"time_ms"`
+	AuctionTimeMs      int64  `json:"auction_time_ms"`
+	IndicativePrice    string `json:"indicative_price"`
+	IndicativeQuantity string `json:"indicative_quantity"`
+	AuctionPrice       string `json:"auction_price"`
+	AuctionQuantity    string `json:"auction_quantity"`
+}
+
+// v2_message is a message of the v2 market data feed, l2_updates carry
+// the initial trades and auction events as nested messages
+type v2_message struct {
+	Type          string            `json:"type"`
+	Symbol        string            `json:"symbol"`
+	Changes       []json.RawMessage `json:"changes"`
+	Trades        []v2_message      `json:"trades"`
+	AuctionEvents []v2_message      `json:"auction_events"`
+	Timestamp     int64             `json:"timestamp"`
+	Price         string            `json:"price"`
+	Quantity      string            `json:"quantity"`
+	Side          string            `json:"side"`
+	Reason        string            `json:"reason"`
+	auction_fields
+}
+
+// auction_event converts the auction events, nil for the other types
+func auction_event(t time.Time, symbol, event_type string, a auction_fields) *market_event {
+	e := &market_event{Time: t, Symbol: symbol, Type: event_type, Detail: a.Result}
+	if a.TimeMs > 0 {
+		e.Time = msToTime(a.TimeMs).UTC()
+	}
+	switch event_type {
+	case "auction_open":
+		e.Detail = "auction at " + msToTime(a.AuctionTimeMs).UTC().Format(time.RFC3339)
+	case "auction_indicative":
+		e.Price, e.Amount = a.IndicativePrice, a.IndicativeQuantity
+	case "auction_result":
+		e.Price, e.Amount = a.AuctionPrice, a.AuctionQuantity
+	default:
+		return nil
+	}
+	return e
+}
+
+// book_side converts the v2 buy/sell and the v1 bid/ask to bid/ask
+func book_side(side string) string {
+	switch side {
+	case "buy":
+		return "bid"
+	case "sell":
+		return "ask"
+	}
+	return side
+}
+
+// v1_events converts a v1 message, the maker side of the trades becomes the taker side
+func v1_events(symbol string, m v1_message) []market_event {
+	t := time.Now().UTC()
+	if m.Timestampms > 0 {
+		t = msToTime(m.Timestampms).UTC()
+	}
+	var events []market_event
+	for _, e := range m.Events {
+		switch e.Type {
+		case "change":
+			events = append(events, market_event{Time: t, Symbol: symbol, Type: e.Type, Side: book_side(e.Side),
+				Price: e.Price, Amount: e.Remaining, Detail: e.Reason})
+		case "trade":
+			side := "buy"
+			if e.MakerSide == "bid" {
+				side = "sell"
+			}
+			events = append(events, market_event{Time: t, Symbol: symbol, Type: e.Type, Side: side,
+				Price: e.Price, Amount: e.Amount})
+		default:
+			if a := auction_event(t, symbol, e.Type, e.auction_fields); a != nil {
+				events = append(events, *a)
+			}
+		}
+	}
+	return events
+}
+
+// v2_events converts a v2 l2_updates, trade or auction message
+func v2_events(m v2_message) ([]market_event, error) {
+	t := time.Now().UTC()
+	symbol := strings.ToLower(m.Symbol)
+	var events []market_event
+	switch m.Type {
+	case "l2_updates":
+		for _, raw := range m.Changes {
+			var change []string
+			if err := json.Unmarshal(raw, &change); err != nil || len(change) != 3 {
+				return nil, fmt.Errorf("Error invalid l2 change: %s", raw)
+			}
+			events = append(events, market_event{Time: t, Symbol: symbol, Type: "change", Side: book_side(change[0]),
+				Price: change[1], Amount: change[2], Detail: m.Reason})
+		}
+		for _, nested := range append(m.Trades, m.AuctionEvents...) {
+			e, err := v2_events(nested)
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, e...)
+		}
+	case "trade":
+		if m.Timestamp > 0 {
+			t = msToTime(m.Timestamp).UTC()
+		}
+		events = append(events, market_event{Time: t, Symbol: symbol, Type: m.Type, Side: m.Side,
+			Price: m.Price, Amount: m.Quantity})
+	default:
+		if a := auction_event(t, symbol, m.Type, m.auction_fields); a != nil {
+			events = append(events, *a)
+		}
+	}
+	return events, nil
+}
+
+// parse_candle converts [time ms, open, high, low, close, volume], the
+// format of both /v2/candles and the candles feed
+func parse_candle(symbol string, values []json.Number) (candle, error) {
+	if len(values) != 6 {
+		return candle{}, fmt.Errorf("Error invalid candle: %v", values)
+	}
+	ms, err := values[0].Int64()
+	if err != nil {
+		return candle{}, fmt.Errorf("Error invalid candle time: %s", values[0])
+	}
+	return candle{Time: msToTime(ms).UTC(), Symbol: strings.ToLower(symbol),
+		Open: values[1], High: values[2], Low: values[3], Close: values[4], Volume: values[5]}, nil
+}
+
+// v2_candles converts a candles_<interval>_updates message
+func v2_candles(m v2_message) ([]candle, error) {
+	var candles []candle
+	for _, raw := range m.Changes {
+		var values []json.Number
+		if err := json.Unmarshal(raw, &values); err != nil {
+			return nil, fmt.Errorf("Error invalid candle: %s", raw)
+		}
+		c, err := parse_candle(m.Symbol, values)
+		if err != nil {
+			return nil, err
+		}
+		candles = append(candles, c)
+	}
+	return candles, nil
+}
+
+// market_feed_v1 is the v1 market data feed of symbol, with the socket
+// sequence checked on every message: a gap reconnects to get a new snapshot
+func market_feed_v1(c *cli.Context, symbol string, query url.Values, w *stream_writer) (*ws_feed, func([]byte) error, error) {
+	u, err := ws_url(c, marketdata_v1_URI+symbol)
+	if err != nil {
+		return nil, nil, err
+	}
+	query.Set("heartbeat", "true")
+
+	var next int64
+	feed := &ws_feed{
+		name:        "marketdata " + symbol,
+		url:         u + "?" + query.Encode(),
+		heartbeat:   c.Duration("heartbeat"),
+		max_retries: c.Int("max-retries"),
+		connected: func(bool) error {
+			next = 0
+			return nil
+		},
+	}
+	handle := func(msg []byte) error {
+		var m v1_message
+		if err := json.Unmarshal(msg, &m); err != nil {
+			return fmt.Errorf("Error %s: invalid message: %s", feed.name, err)
+		}
+		if m.Sequence != next {
+			return &ws_reconnect{fmt.Sprintf("sequence gap, expected %d received %d", next, m.Sequence)}
+		}
+		next++
+		for _, e := range v1_events(symbol, m) {
+			if err := w.write(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return feed, handle, nil
+}
+
+// market_feed_v2 is the v2 market data feed of the symbols, subscribed to
+// the l2 or candles_<interval> channel
+func market_feed_v2(c *cli.Context, name string, symbols []string, w *stream_writer) (*ws_feed, func([]byte) error, error) {
+	u, err := ws_url(c, marketdata_v2_URI)
+	if err != nil {
+		return nil, nil, err
+	}
+	var upper []string
+	for _, s := range symbols {
+		upper = append(upper, strings.ToUpper(s))
+	}
+
+	feed := &ws_feed{
+		name: "marketdata " + name,
+		url:  u,
+		subscribe: map[string]interface{}{
+			"type":          "subscribe",
+			"subscriptions": []map[string]interface{}{{"name": name, "symbols": upper}},
+		},
+		heartbeat:   c.Duration("heartbeat"),
+		max_retries: c.Int("max-retries"),
+	}
+	handle := func(msg []byte) error {
+		var m v2_message
+		if err := json.Unmarshal(msg, &m); err != nil {
+			return fmt.Errorf("Error %s: invalid message: %s", feed.name, err)
+		}
+		switch {
+		case m.Type == "heartbeat":
+			return nil
+		case strings.HasPrefix(m.Type, "candles_"):
+			candles, err := v2_candles(m)
+			if err != nil {
+				return err
+			}
+			for _, e := range candles {
+				if err := w.write(e); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		events, err := v2_events(m)
+		if err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			logger.Debug("func market_feed_v2: message skipped", fmt.Sprintf("message:%s", msg))
+		}
+		for _, e := range events {
+			if err := w.write(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return feed, handle, nil
+}
+
+// interrupt_context is cancelled by Ctrl-C or SIGTERM, for the commands
+// that run until interrupted
+func interrupt_context(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		defer signal.Stop(signals)
+		select {
+		case <-signals:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// stream_feeds runs the feeds until one fails, --count records are
+// printed, or the user presses Ctrl-C
+func stream_feeds(ctx context.Context, w *stream_writer, feeds []*ws_feed, handlers []func([]byte) error) error {
+	ctx, cancel := interrupt_context(ctx)
+	defer cancel()
+	w.done = cancel
+
+	errs := make(chan error, len(feeds))
+	for i := range feeds {
+		go func(f *ws_feed, handle func([]byte) error) {
+			err := f.run(ctx, handle)
+			if err != nil {
+				cancel()
+			}
+			errs <- err
+		}(feeds[i], handlers[i])
+	}
+	var first error
+	for range feeds {
+		if err := <-errs; err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// stream_market_v1 runs the v1 feed of every symbol with query
+func stream_market_v1(c *cli.Context, query url.Values) error {
+	symbols, err := get_tickers_flag(c)
+	if err != nil {
+		return err
+	}
+	w := new_stream_writer(c)
+	var feeds []*ws_feed
+	var handlers []func([]byte) error
+	for _, symbol := range symbols {
+		q := url.Values{}
+		for k, v := range query {
+			q[k] = v
+		}
+		feed, handle, err := market_feed_v1(c, strings.ToLower(symbol), q, w)
+		if err != nil {
+			return err
+		}
+		feeds, handlers = append(feeds, feed), append(handlers, handle)
+	}
+	return stream_feeds(context.Background(), w, feeds, handlers)
+}
+
+// stream_market_v2 runs one v2 feed subscribed to name for all the symbols
+func stream_market_v2(c *cli.Context, name string) error {
+	symbols, err := get_tickers_flag(c)
+	if err != nil {
+		return err
+	}
+	w := new_stream_writer(c)
+	feed, handle, err := market_feed_v2(c, name, symbols, w)
+	if err != nil {
+		return err
+	}
+	return stream_feeds(context.Background(), w, []*ws_feed{feed}, []func([]byte) error{handle})
+}
+
+// stream_flags are the flags of all the stream commands
+func stream_flags() []cli.Flag {
+	return append([]cli.Flag{
+		&cli.StringSliceFlag{
+			Name:    "ticker",
+			Aliases: []string{"t"},
+			Usage:   "e.g. --ticker btcusd --ticker ethusd (required unless the profile has a default_symbol)",
+		},
+	}, feed_flags()...)
+}
+
+// feed_flags are the flags of all the commands reading a WebSocket feed
+func feed_flags() []cli.Flag {
+	return []cli.Flag{
+		&cli.IntFlag{
+			Name:  "count",
+			Usage: "e.g. --count 100 - Exit after printing this many records (default: until Ctrl-C)",
+		},
+		&cli.DurationFlag{
+			Name:  "heartbeat",
+			Usage: "Reconnect when nothing, not even a heartbeat, is received for this long",
+			Value: 15 * time.Second,
+		},
+		&cli.IntFlag{
+			Name:  "max-retries",
+			Usage: "Exit after this many failed reconnections in a row (default: retry forever)",
+		},
+	}
+}
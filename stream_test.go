@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// fake_ws is a local WebSocket server, script writes the messages of
+// every connection, n counts the connections from 1
+type fake_ws struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	connections int
+	urls        []string
+	// first message received on every connection, e.g. the v2 subscribe
+	received []map[string]interface{}
+}
+
+func new_fake_ws(t *testing.T, subscribe bool, script func(conn *websocket.Conn, n int)) *fake_ws {
+	f := &fake_ws{}
+	upgrader := websocket.Upgrader{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+
+		f.mu.Lock()
+		f.connections++
+		n := f.connections
+		f.urls = append(f.urls, r.URL.String())
+		f.mu.Unlock()
+
+		if subscribe {
+			var m map[string]interface{}
+			if err := conn.ReadJSON(&m); err != nil {
+				return
+			}
+			f.mu.Lock()
+			f.received = append(f.received, m)
+			f.mu.Unlock()
+		}
+		script(conn, n)
+		// wait for the client to go away
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(f.Close)
+
+	saved_backoff := ws_backoff
+	ws_backoff = 10 * time.Millisecond
+	t.Cleanup(func() { ws_backoff = saved_backoff })
+
+	setenv(t, "GEMINI_API_URL", f.URL)
+	setenv(t, "GEMINI_API_PRODUCTION", "false")
+	return f
+}
+
+func send(conn *websocket.Conn, messages ...string) {
+	for _, m := range messages {
+		conn.WriteMessage(websocket.TextMessage, []byte(m))
+	}
+}
+
+func TestStreamTradesSequenceGap(t *testing.T) {
+	server := new_fake_ws(t, false, func(conn *websocket.Conn, n int) {
+		send(conn,
+			`{"type":"update","eventId":1,"socket_sequence":0,"events":[]}`,
+			`{"type":"update","eventId":2,"socket_sequence":1,"timestampms":1612381252885,"events":[{"type":"trade","tid":2,"price":"100.5","amount":"0.25","makerSide":"bid"}]}`,
+		)
+		if n == 1 {
+			// sequence 2 is lost
+			send(conn, `{"type":"update","eventId":4,"socket_sequence":3,"events":[{"type":"trade","tid":4,"price":"101","amount":"1","makerSide":"ask"}]}`)
+			return
+		}
+		send(conn,
+			`{"type":"heartbeat","socket_sequence":2}`,
+			`{"type":"update","eventId":5,"socket_sequence":3,"timestampms":1612381253885,"events":[{"type":"trade","tid":5,"price":"102","amount":"2","makerSide":"ask"}]}`,
+		)
+	})
+
+	out, err := run_app(t, nil, "-o", "jsonl", "stream", "trades", "-t", "btcusd", "--count", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"time":"2021-02-03T19:40:52.885Z","symbol":"btcusd","type":"trade","side":"sell","price":"100.5","amount":"0.25","detail":""}
+{"time":"2021-02-03T19:40:52.885Z","symbol":"btcusd","type":"trade","side":"sell","price":"100.5","amount":"0.25","detail":""}
+{"time":"2021-02-03T19:40:53.885Z","symbol":"btcusd","type":"trade","side":"buy","price":"102","amount":"2","detail":""}
+`
+	if out != want {
+		t.Errorf("output:\n%s\nwant:\n%s", out, want)
+	}
+	if server.connections != 2 {
+		t.Errorf("%d connections, want a reconnection after the gap", server.connections)
+	}
+	if u := server.urls[0]; !strings.HasPrefix(u, "/v1/marketdata/btcusd?") ||
+		!strings.Contains(u, "trades=true") || !strings.Contains(u, "bids=false") || !strings.Contains(u, "heartbeat=true") {
+		t.Errorf("url %s", u)
+	}
+}
+
+func TestStreamHeartbeatTimeout(t *testing.T) {
+	server := new_fake_ws(t, false, func(conn *websocket.Conn, n int) {
+		if n == 1 {
+			// a connection that went quiet
+			return
+		}
+		send(conn, `{"type":"update","socket_sequence":0,"events":[{"type":"auction_result","eid":1,"result":"success","time_ms":1612381252885,"auction_price":"100","auction_quantity":"5"}]}`)
+	})
+
+	out, err := run_app(t, nil, "-o", "csv", "stream", "auction", "-t", "btcusd", "--count", "1", "--heartbeat", "100ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "time,symbol,type,side,price,amount,detail\n2021-02-03T19:40:52Z,btcusd,auction_result,,100,5,success\n"
+	if out != want {
+		t.Errorf("output:\n%s\nwant:\n%s", out, want)
+	}
+	if server.connections != 2 {
+		t.Errorf("%d connections, want a reconnection after the missed heartbeat", server.connections)
+	}
+}
+
+func TestStreamMaxRetries(t *testing.T) {
+	new_fake_ws(t, false, func(conn *websocket.Conn, n int) {})
+
+	_, err := run_app(t, nil, "stream", "trades", "-t", "btcusd", "--heartbeat", "20ms", "--max-retries", "2")
+	if err == nil || !strings.Contains(err.Error(), "giving up after 2 reconnections") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestStreamL2AndCandles(t *testing.T) {
+	server := new_fake_ws(t, true, func(conn *websocket.Conn, n int) {
+		if n == 2 {
+			send(conn, `{"type":"candles_1m_updates","symbol":"ETHUSD","changes":[[1612381200000,1500.5,1510,1495,1505.25,12.5]]}`)
+			return
+		}
+		send(conn,
+			`{"type":"l2_updates","symbol":"BTCUSD","changes":[["buy","99","1.5"],["sell","101","2"]],"trades":[{"type":"trade","symbol":"BTCUSD","event_id":1,"timestamp":1612381252885,"price":"100","quantity":"0.5","side":"buy"}]}`,
+			`{"type":"heartbeat","timestamp":1612381252885}`,
+		)
+	})
+
+	out, err := run_app(t, nil, "-o", "table", "stream", "l2", "-t", "btcusd", "-t", "ethusd", "--count", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], "TIME") ||
+		!strings.Contains(lines[1], "btcusd  change  bid   99     1.5") ||
+		!strings.Contains(lines[3], "btcusd  trade   buy   100    0.5") {
+		t.Errorf("output:\n%s", out)
+	}
+	subscribe, _ := json.Marshal(server.received[0])
+	if string(subscribe) != `{"subscriptions":[{"name":"l2","symbols":["BTCUSD","ETHUSD"]}],"type":"subscribe"}` {
+		t.Errorf("subscribe %s", subscribe)
+	}
+
+	out, err = run_app(t, nil, "-o", "jsonl", "stream", "candles", "-t", "ethusd", "--interval", "1m", "--count", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"time":"2021-02-03T19:40:00Z","symbol":"ethusd","open":1500.5,"high":1510,"low":1495,"close":1505.25,"volume":12.5}` + "\n"
+	if out != want {
+		t.Errorf("output:\n%s\nwant:\n%s", out, want)
+	}
+	if name := server.received[1]["subscriptions"].([]interface{})[0].(map[string]interface{})["name"]; name != "candles_1m" {
+		t.Errorf("subscribed to %v", name)
+	}
+
+	if _, err := run_app(t, nil, "stream", "candles", "-t", "ethusd", "--interval", "2m"); err == nil {
+		t.Error("invalid interval accepted")
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// statuses of a symbol that do not accept new orders
+var closedSymbolStatuses = []string{"closed", "cancel_only"}
+
+// symbol_details is the /v1/symbols/details of a symbol: amounts are
+// multiples of tick_size, prices multiples of quote_increment
+type symbol_details struct {
+	Symbol         string  `json:"symbol"`
+	BaseCurrency   string  `json:"base_currency"`
+	QuoteCurrency  string  `json:"quote_currency"`
+	TickSize       decimal `json:"tick_size"`
+	QuoteIncrement decimal `json:"quote_increment"`
+	MinOrderSize   decimal `json:"min_order_size"`
+	Status         string  `json:"status"`
+	WrapEnabled    bool    `json:"wrap_enabled"`
+}
+
+// increment_error explains why value does not fit increment, more
+// decimals than the increment is a precision error
+func increment_error(name string, value decimal, symbol, increment_name string, increment, rounded decimal) error {
+	if value.decimals() > increment.decimals() {
+		return fmt.Errorf("Error %s %s has more precision than %s supports, the %s is %s, use --round to round it to %s",
+			name, value, symbol, increment_name, increment, rounded)
+	}
+	return fmt.Errorf("Error %s %s is not a multiple of the %s %s %s, use --round to round it to %s",
+		name, value, symbol, increment_name, increment, rounded)
+}
+
+// check_order rejects the orders the exchange would reject because of the
+// symbol status, the minimum order size or the increments. With round the
+// amount is rounded down to the tick size and the prices to the quote
+// increment, away from the other side of the book so that rounding never
+// makes an order more aggressive
+func (d symbol_details) check_order(o *orderRequest, round bool) error {
+	symbol := strings.ToLower(o.Symbol)
+	if contains(closedSymbolStatuses, d.Status) {
+		return fmt.Errorf("Error %s is %s, it does not accept new orders", symbol, d.Status)
+	}
+
+	if tick := d.TickSize; tick.sign() > 0 && !o.Amount.is_multiple(tick) {
+		if !round {
+			return increment_error("amount", o.Amount, symbol, "tick size", tick, o.Amount.round_down(tick))
+		}
+		o.Amount = o.Amount.round_down(tick)
+	}
+	if min := d.MinOrderSize; min.sign() > 0 && o.Amount.cmp(min) < 0 {
+		return fmt.Errorf("Error amount %s is less than the %s minimum order size %s", o.Amount, symbol, min)
+	}
+
+	if inc := d.QuoteIncrement; inc.sign() > 0 {
+		if !o.Price.is_multiple(inc) {
+			rounded := o.Price.round_down(inc)
+			if o.Side == "sell" {
+				rounded = o.Price.round_up(inc)
+			}
+			if !round {
+				return increment_error("price", o.Price, symbol, "quote increment", inc, rounded)
+			}
+			o.Price = rounded
+		}
+		if !o.StopPrice.is_zero() && !o.StopPrice.is_multiple(inc) {
+			rounded := o.StopPrice.round(inc)
+			if !round {
+				return increment_error("stop_price", o.StopPrice, symbol, "quote increment", inc, rounded)
+			}
+			o.StopPrice = rounded
+		}
+	}
+	if o.Amount.sign() <= 0 || o.Price.sign() <= 0 {
+		return fmt.Errorf("Error the rounded amount %s or price %s is 0", o.Amount, o.Price)
+	}
+	// rounding can move the price past the stop price
+	return o.validate()
+}
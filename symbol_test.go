@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSymbolDetails(t *testing.T) {
+	use_fake_gemini(t)
+
+	out, err := run_app(t, nil, "get", "symbol-details", "-t", "btcusd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"tick_size": "0.00000001"`, `"quote_increment": "0.01"`, `"min_order_size": "0.00001"`, `"status": "open"`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %s in\n%s", want, out)
+		}
+	}
+
+	if _, err := run_app(t, nil, "get", "symbol-details", "-t", "foousd"); err == nil || !strings.Contains(err.Error(), "InvalidSymbol") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestOrderSymbolChecks(t *testing.T) {
+	server := use_fake_gemini(t)
+
+	tests := []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"-t", "btcusd", "-s", "buy", "-a", "0.5", "-p", "100.005"}, "use --round to round it to 100"},
+		{[]string{"-t", "btcusd", "-s", "sell", "-a", "0.5", "-p", "100.005"}, "use --round to round it to 100.01"},
+		{[]string{"-t", "btcusd", "-s", "buy", "-a", "0.123456789", "-p", "100"}, "more precision than btcusd supports, the tick size is 0.00000001"},
+		{[]string{"-t", "ethusd", "-s", "buy", "-a", "0.0005", "-p", "100"}, "minimum order size 0.001"},
+		{[]string{"-t", "zecusd", "-s", "buy", "-a", "1", "-p", "100"}, "zecusd is cancel_only"},
+		{[]string{"-t", "zecusd", "-s", "buy", "-a", "1", "-p", "100", "--round"}, "zecusd is cancel_only"},
+		{[]string{"-t", "btcusd", "-s", "sell", "-a", "0.5", "-p", "100", "--type", "stop-limit", "--stop_price", "100.004"},
+			"use --round to round it to 100"},
+		{[]string{"-t", "btcusd", "-s", "buy", "-a", "1e-5", "-p", "100"}, "invalid --amount: 1e-5"},
+	}
+	for _, test := range tests {
+		_, err := run_app(t, nil, append([]string{"-y", "order", "new"}, test.args...)...)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%v: err = %v, want %s", test.args, err, test.err)
+		}
+	}
+	if p := server.last_payload(); p != nil {
+		t.Errorf("order sent: %v", p)
+	}
+
+	if _, err := run_app(t, nil, "-y", "order", "new", "-t", "btcusd", "-s", "sell", "-a", "0.123456789", "-p", "100.005", "--round"); err != nil {
+		t.Fatal(err)
+	}
+	p := server.last_payload()
+	if p["amount"] != "0.12345678" || p["price"] != "100.01" {
+		t.Errorf("rounded order %v", p)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// a request refused because of the rate limit or of a nonce received out
+// of order is sent again up to send_retries times, after send_backoff,
+// doubled at every retry
+var (
+	send_retries = 3
+	send_backoff = time.Second
+)
+
+// parallel_flags are the flags of the commands sending many requests,
+// what is e.g. "Orders sent"
+func parallel_flags(what string) []cli.Flag {
+	return []cli.Flag{
+		&cli.IntFlag{
+			Name:  "concurrency",
+			Usage: "e.g. --concurrency 4 - " + what + " at the same time, from 1 to 10",
+			Value: 4,
+		},
+		&cli.Float64Flag{
+			Name:  "rate",
+			Usage: "e.g. --rate 5 - Maximum " + strings.ToLower(what) + " per second, Gemini rate limits the private API",
+			Value: 5,
+		},
+	}
+}
+
+func check_parallel_flags(c *cli.Context) error {
+	if n := c.Int("concurrency"); n < 1 || n > 10 {
+		return fmt.Errorf("Error invalid --concurrency: %d, it must be from 1 to 10", n)
+	}
+	if c.Float64("rate") <= 0 {
+		return fmt.Errorf("Error invalid --rate: %s, it must be greater than 0", formatFloat(c.Float64("rate")))
+	}
+	return nil
+}
+
+// throttle spaces the requests of parallel commands at most rate per second
+type throttle struct {
+	ctx    context.Context
+	tokens chan struct{}
+}
+
+// new_throttle returns a throttle and the function that stops it, the
+// first request does not wait
+func new_throttle(ctx context.Context, rate float64) (*throttle, func()) {
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+	t := &throttle{ctx: ctx, tokens: make(chan struct{}, 1)}
+	t.tokens <- struct{}{}
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				select {
+				case t.tokens <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return t, ticker.Stop
+}
+
+// wait waits d and then the next request slot, false if the context is done
+func (t *throttle) wait(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-t.ctx.Done():
+		return false
+	case <-timer.C:
+	}
+	select {
+	case <-t.ctx.Done():
+		return false
+	case <-t.tokens:
+		return true
+	}
+}
+
+// send calls request in the next slot and calls it again while Gemini
+// refuses it with a retryable error. It returns false, without calling
+// request, if the context is done before the first slot
+func (t *throttle) send(name string, request func() error) (bool, error) {
+	if !t.wait(0) {
+		return false, nil
+	}
+	for attempt := 0; ; attempt++ {
+		err := request()
+		if e, ok := err.(*api_error); ok && e.retryable() && attempt < send_retries {
+			backoff := send_backoff << uint(attempt)
+			errlog.Printf("%s: %s, retrying in %s", name, one_line(err), backoff)
+			if t.wait(backoff) {
+				continue
+			}
+		}
+		return true, err
+	}
+}
+
+// run_parallel calls work for 0 to n-1 with concurrency goroutines, once
+// the context is done skip is called for the indexes not yet started
+func run_parallel(ctx context.Context, concurrency, n int, work, skip func(i int)) {
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				work(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		if ctx.Err() != nil {
+			skip(i)
+			continue
+		}
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			skip(i)
+		}
+	}
+	close(jobs)
+	wg.Wait()
+}
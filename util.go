@@ -29,7 +29,12 @@ func parseConvertTimestamp(timestamp_str string) (*time.Time, error) {
 
 }
 
-func parse_params(c *cli.Context) string {
+func parse_params(c *cli.Context) profile_source {
+
+	redact.show_secrets(c.Bool("unsafe-show-secrets"))
+	if c.Bool("unsafe-show-secrets") {
+		logger.Warn("Secrets are not redacted in the logs")
+	}
 
 	if c.Bool("trace") {
 		logger.SetLevel(logger.Level(logger.TraceLevel))
@@ -39,10 +44,16 @@ func parse_params(c *cli.Context) string {
 		logger.Debug("Debug enabled")
 	}
 
-	if c.IsSet("config") {
-		return c.String("config")
+	src := profile_source{
+		config:    c.String("config"),
+		profile:   c.String("profile"),
+		keystore:  c.String("keystore"),
+		secret_fd: -1,
+	}
+	if c.IsSet("secret-fd") {
+		src.secret_fd = c.Int("secret-fd")
 	}
-	return ""
+	return src
 }
 
 func get_timestampms(ts time.Time) int64 {
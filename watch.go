@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/claudiocandio/gemini-api"
+	"github.com/claudiocandio/gemini-api/logger"
+	"github.com/urfave/cli/v2"
+)
+
+var orderEventTypes = []string{"initial", "accepted", "rejected", "booked", "fill", "cancelled", "cancel_rejected", "closed"}
+
+// order_event is one event of the order events WebSocket
+type order_event struct {
+	Type              string      `json:"type"`
+	OrderId           string      `json:"order_id"`
+	ClientOrderId     string      `json:"client_order_id,omitempty"`
+	ApiSession        string      `json:"api_session,omitempty"`
+	Symbol            string      `json:"symbol"`
+	Side              string      `json:"side"`
+	OrderType         string      `json:"order_type"`
+	Timestampms       int64       `json:"timestampms"`
+	IsLive            bool        `json:"is_live"`
+	IsCancelled       bool        `json:"is_cancelled"`
+	AvgExecutionPrice string      `json:"avg_execution_price,omitempty"`
+	ExecutedAmount    string      `json:"executed_amount,omitempty"`
+	RemainingAmount   string      `json:"remaining_amount,omitempty"`
+	OriginalAmount    string      `json:"original_amount,omitempty"`
+	Price             string      `json:"price,omitempty"`
+	Reason            string      `json:"reason,omitempty"`
+	Fill              *order_fill `json:"fill,omitempty"`
+	SocketSequence    int64       `json:"socket_sequence"`
+}
+
+type order_fill struct {
+	TradeId     string `json:"trade_id"`
+	Liquidity   string `json:"liquidity"`
+	Price       string `json:"price"`
+	Amount      string `json:"amount"`
+	Fee         string `json:"fee"`
+	FeeCurrency string `json:"fee_currency"`
+}
+
+// watch_row is what order watch prints for every event, the same columns
+// for all the events so that csv and table stay aligned
+type watch_row struct {
+	Time            time.Time `json:"time"`
+	Event           string    `json:"event"`
+	OrderId         string    `json:"order_id"`
+	ClientOrderId   string    `json:"client_order_id"`
+	Symbol          string    `json:"symbol"`
+	Side            string    `json:"side"`
+	Price           string    `json:"price"`
+	OriginalAmount  string    `json:"original_amount"`
+	ExecutedAmount  string    `json:"executed_amount"`
+	RemainingAmount string    `json:"remaining_amount"`
+	FillPrice       string    `json:"fill_price"`
+	FillAmount      string    `json:"fill_amount"`
+	Fee             string    `json:"fee"`
+	Reason          string    `json:"reason"`
+}
+
+// order_filter keeps the events of some symbols and client order ids, all if empty
+type order_filter struct {
+	symbols          []string
+	client_order_ids []string
+}
+
+func (f order_filter) match(symbol, client_order_id string) bool {
+	if len(f.symbols) > 0 && !contains(f.symbols, strings.ToLower(symbol)) {
+		return false
+	}
+	if len(f.client_order_ids) > 0 && !contains(f.client_order_ids, client_order_id) {
+		return false
+	}
+	return true
+}
+
+func event_row(e order_event) watch_row {
+	row := watch_row{
+		Time:            msToTime(e.Timestampms).UTC(),
+		Event:           e.Type,
+		OrderId:         e.OrderId,
+		ClientOrderId:   e.ClientOrderId,
+		Symbol:          e.Symbol,
+		Side:            e.Side,
+		Price:           e.Price,
+		OriginalAmount:  e.OriginalAmount,
+		ExecutedAmount:  e.ExecutedAmount,
+		RemainingAmount: e.RemainingAmount,
+		Reason:          e.Reason,
+	}
+	if e.Fill != nil {
+		row.FillPrice, row.FillAmount = e.Fill.Price, e.Fill.Amount
+		row.Fee = strings.TrimSpace(e.Fill.Fee + " " + e.Fill.FeeCurrency)
+	}
+	return row
+}
+
+// active_row is an active order read with the catch-up REST call after a reconnection
+func active_row(o gemini.Order) watch_row {
+	return watch_row{
+		Time:            time.Now().UTC(),
+		Event:           "active",
+		OrderId:         o.OrderId,
+		ClientOrderId:   o.ClientOrderId,
+		Symbol:          o.Symbol,
+		Side:            o.Side,
+		Price:           formatFloat(o.Price),
+		OriginalAmount:  formatFloat(o.OriginalAmount),
+		ExecutedAmount:  formatFloat(o.ExecutedAmount),
+		RemainingAmount: formatFloat(o.RemainingAmount),
+	}
+}
+
+// order_events_feed is the order events WebSocket of the account, signed
+// again at every connection, after a reconnection the active orders are
+// read with ActiveOrders to catch up with what was missed
+func order_events_feed(c *cli.Context, api gemini_client, filter order_filter, events []string, w *stream_writer) (*ws_feed, func([]byte) error, error) {
+	u, err := ws_url(c, order_events_URI)
+	if err != nil {
+		return nil, nil, err
+	}
+	query := url.Values{"heartbeat": {"true"}}
+	for _, s := range filter.symbols {
+		query.Add("symbolFilter", s)
+	}
+	for _, e := range events {
+		query.Add("eventTypeFilter", e)
+	}
+
+	feed := &ws_feed{
+		name: "order events",
+		url:  u + "?" + query.Encode(),
+		header: func() (http.Header, error) {
+			return api.SignHeader(order_events_URI, nil)
+		},
+		heartbeat:   c.Duration("heartbeat"),
+		max_retries: c.Int("max-retries"),
+		connected: func(reconnected bool) error {
+			if !reconnected {
+				return nil
+			}
+			orders, err := api.ActiveOrders()
+			if err != nil {
+				errlog.Printf("order events: catch-up of the active orders failed: %s", err)
+				return nil
+			}
+			for _, o := range orders {
+				if filter.match(o.Symbol, o.ClientOrderId) {
+					if err := w.write(active_row(o)); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+	}
+
+	handle := func(msg []byte) error {
+		// the order events are sent as arrays, acks and heartbeats as objects
+		if !strings.HasPrefix(strings.TrimSpace(string(msg)), "[") {
+			var m struct {
+				Type string `json:"type"`
+			}
+			if err := json.Unmarshal(msg, &m); err != nil {
+				return fmt.Errorf("Error %s: invalid message: %s", feed.name, err)
+			}
+			if m.Type != "heartbeat" {
+				logger.Debug("func order_events_feed: message", fmt.Sprintf("message:%s", msg))
+			}
+			return nil
+		}
+		var order_events []order_event
+		if err := json.Unmarshal(msg, &order_events); err != nil {
+			return fmt.Errorf("Error %s: invalid message: %s", feed.name, err)
+		}
+		for _, e := range order_events {
+			if filter.match(e.Symbol, e.ClientOrderId) && (len(events) == 0 || contains(events, e.Type)) {
+				if err := w.write(event_row(e)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return feed, handle, nil
+}
+
+// watch_orders prints the order events until Ctrl-C or --count
+func watch_orders(c *cli.Context, api gemini_client) error {
+	filter := order_filter{client_order_ids: c.StringSlice("client_order_id")}
+	for _, s := range c.StringSlice("ticker") {
+		filter.symbols = append(filter.symbols, strings.ToLower(s))
+	}
+	events := c.StringSlice("event")
+	for _, e := range events {
+		if !contains(orderEventTypes, e) {
+			return fmt.Errorf("Error invalid event: %s\nValid events: %s", e, strings.Join(orderEventTypes, ", "))
+		}
+	}
+
+	w := new_stream_writer(c)
+	feed, handle, err := order_events_feed(c, api, filter, events, w)
+	if err != nil {
+		return err
+	}
+	return stream_feeds(context.Background(), w, []*ws_feed{feed}, []func([]byte) error{handle})
+}
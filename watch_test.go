@@ -0,0 +1,94 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// wait_for polls cond until it is true
+func wait_for(t *testing.T, what string, cond func() bool) {
+	for start := time.Now(); !cond(); time.Sleep(5 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Errorf("timeout waiting for %s", what)
+			return
+		}
+	}
+}
+
+func TestOrderWatch(t *testing.T) {
+	server := use_fake_gemini(t)
+	saved_backoff := ws_backoff
+	ws_backoff = 10 * time.Millisecond
+	defer func() { ws_backoff = saved_backoff }()
+
+	// a second client places the orders while order watch runs
+	api := new_gemini_api(false, fake_key, fake_secret, server.URL)
+	catch_ups := func() int {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		n := 0
+		for _, p := range server.paths {
+			if p == active_orders_URI {
+				n++
+			}
+		}
+		return n
+	}
+	go func() {
+		wait_for(t, "order watch", func() bool { return server.watching() == 1 })
+		btc, err := api.NewOrderType(orderRequest{Symbol: "btcusd", ClientOrderId: "bot-1", Side: "buy",
+			Type: orderTypeLimit, Amount: dec("2"), Price: dec("100")})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		// filtered out by --ticker
+		if _, err := api.NewOrderType(orderRequest{Symbol: "ethusd", Side: "buy", Type: orderTypeLimit, Amount: dec("1"), Price: dec("10")}); err != nil {
+			t.Error(err)
+		}
+		server.emit(order_event{Type: "fill", OrderId: btc.OrderId, ClientOrderId: "bot-1", Symbol: "btcusd", Side: "buy",
+			Timestampms: btc.Timestampms, IsLive: true, ExecutedAmount: "0.5", RemainingAmount: "1.5", OriginalAmount: "2",
+			Price: "100", Fill: &order_fill{TradeId: "7", Liquidity: "Maker", Price: "100", Amount: "0.5", Fee: "0.05", FeeCurrency: "USD"}})
+
+		server.drop_watchers()
+		wait_for(t, "catch-up", func() bool { return catch_ups() == 1 })
+		wait_for(t, "reconnection", func() bool { return server.watching() == 1 })
+		if _, err := api.CancelOrder(btc.OrderId); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	out, err := run_app(t, nil, "-o", "csv", "order", "watch", "-t", "btcusd", "--count", "5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 6 || lines[0] != "time,event,order_id,client_order_id,symbol,side,price,original_amount,executed_amount,remaining_amount,fill_price,fill_amount,fee,reason" {
+		t.Fatalf("output:\n%s", out)
+	}
+	for i, want := range []string{
+		",accepted,1001,bot-1,btcusd,buy,100,2,0,2,,,,",
+		",booked,1001,bot-1,btcusd,buy,100,2,0,2,,,,",
+		",fill,1001,bot-1,btcusd,buy,100,2,0.5,1.5,100,0.5,0.05 USD,",
+		",active,1001,bot-1,btcusd,buy,100,2,0,2,,,,",
+		",cancelled,1001,bot-1,btcusd,buy,100,2,0,2,,,,",
+	} {
+		if !strings.HasSuffix(lines[i+1], want) {
+			t.Errorf("line %d: %s, want %s", i+1, lines[i+1], want)
+		}
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.payloads[0]["request"] != order_events_URI {
+		t.Errorf("order events payload %v", server.payloads[0])
+	}
+}
+
+func TestOrderWatchInvalidEvent(t *testing.T) {
+	use_fake_gemini(t)
+	if _, err := run_app(t, nil, "order", "watch", "--event", "filled"); err == nil {
+		t.Error("invalid event accepted")
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/claudiocandio/gemini-api/logger"
+	"github.com/gorilla/websocket"
+	"github.com/urfave/cli/v2"
+)
+
+// wait between reconnections, doubled after every failed attempt,
+// tests make them shorter
+var (
+	ws_backoff     = time.Second
+	ws_backoff_max = 30 * time.Second
+)
+
+// ws_reconnect makes ws_feed drop the connection and connect again,
+// e.g. after a read error, a missed heartbeat or a sequence gap
+type ws_reconnect struct {
+	reason string
+}
+
+func (e *ws_reconnect) Error() string {
+	return e.reason
+}
+
+// ws_feed is a Gemini WebSocket feed that reconnects with exponential
+// backoff when the connection drops or goes quiet
+type ws_feed struct {
+	// for the logs, e.g. "marketdata btcusd"
+	name string
+	url  string
+	// called before every connection, private feeds sign it with a new nonce
+	header func() (http.Header, error)
+	// sent after every connection, if not nil
+	subscribe interface{}
+	// reconnect when nothing is received for this long, 0 never
+	heartbeat time.Duration
+	// give up after this many failed reconnections in a row, 0 never
+	max_retries int
+	// called after every connection, reconnected is false the first time
+	connected func(reconnected bool) error
+	// called when a connection drops, before waiting to reconnect
+	disconnected func()
+}
+
+// run reads the feed and passes every message to handle until ctx is done,
+// handle errors other than ws_reconnect stop the feed
+func (f *ws_feed) run(ctx context.Context, handle func(msg []byte) error) error {
+	backoff := ws_backoff
+	failures := 0
+	for attempt := 0; ; attempt++ {
+		received, err := f.connect(ctx, attempt > 0, handle)
+		if ctx.Err() != nil {
+			return nil
+		}
+		var reconnect *ws_reconnect
+		if !errors.As(err, &reconnect) {
+			return err
+		}
+		if f.disconnected != nil {
+			f.disconnected()
+		}
+
+		if received {
+			backoff, failures = ws_backoff, 0
+		}
+		failures++
+		if f.max_retries > 0 && failures > f.max_retries {
+			return fmt.Errorf("Error %s: giving up after %d reconnections: %s", f.name, f.max_retries, err)
+		}
+		errlog.Printf("%s: %s, reconnecting in %s", f.name, err, backoff)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > ws_backoff_max {
+			backoff = ws_backoff_max
+		}
+	}
+}
+
+// connect reads one connection of the feed, received tells if any message arrived
+func (f *ws_feed) connect(ctx context.Context, reconnected bool, handle func(msg []byte) error) (bool, error) {
+	var header http.Header
+	if f.header != nil {
+		var err error
+		if header, err = f.header(); err != nil {
+			return false, err
+		}
+	}
+
+	logger.Debug("func ws_feed: connecting",
+		fmt.Sprintf("name:%s", f.name),
+		fmt.Sprintf("url:%s", f.url),
+	)
+	conn, resp, err := websocket.DefaultDialer.DialContext(ctx, f.url, header)
+	if err != nil {
+		// e.g. a wrong symbol or key, connecting again does not help
+		if resp != nil && resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			body, _ := ioutil.ReadAll(resp.Body)
+			return false, fmt.Errorf("Error %s: %s", f.name, response_error(resp.StatusCode, body))
+		}
+		return false, &ws_reconnect{err.Error()}
+	}
+	defer conn.Close()
+
+	// closing the connection stops ReadMessage when ctx is done
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	if f.subscribe != nil {
+		logger.Trace("func ws_feed: subscribe", fmt.Sprintf("subscribe:%+v", f.subscribe))
+		if err := conn.WriteJSON(f.subscribe); err != nil {
+			return false, &ws_reconnect{err.Error()}
+		}
+	}
+	if f.connected != nil {
+		if err := f.connected(reconnected); err != nil {
+			return false, err
+		}
+	}
+
+	received := false
+	for {
+		if f.heartbeat > 0 {
+			conn.SetReadDeadline(time.Now().Add(f.heartbeat))
+		}
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return received, &ws_reconnect{fmt.Sprintf("no message or heartbeat for %s", f.heartbeat)}
+			}
+			return received, &ws_reconnect{err.Error()}
+		}
+		received = true
+		logger.Trace("func ws_feed: received",
+			fmt.Sprintf("name:%s", f.name),
+			fmt.Sprintf("message:%s", msg),
+		)
+		if err := handle(msg); err != nil {
+			return received, err
+		}
+	}
+}
+
+// ws_url returns the WebSocket url of uri on the Gemini site of the profile
+func ws_url(c *cli.Context, uri string) (string, error) {
+	s, err := get_session(c)
+	if err != nil {
+		return "", err
+	}
+	p, err := s.Profile()
+	if err != nil {
+		return "", err
+	}
+
+	url, err := profile_url(p)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(url, "https://") {
+		return "wss://" + strings.TrimPrefix(url, "https://") + uri, nil
+	}
+	return "ws://" + strings.TrimPrefix(url, "http://") + uri, nil
+}